@@ -0,0 +1,90 @@
+package catzip
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Options.Annotate columns
+const (
+	AnnotateArchive = "archive"   // Input file
+	AnnotateEntry   = "entry"     // Name of the entry in it
+	AnnotateLine    = "lineno"    // Line in the entry, from 1
+	AnnotateCatLine = "catlineno" // Line in the cat file, from 1
+)
+
+// AnnotateColumns lists the values of Options.Annotate
+func AnnotateColumns() []string {
+	return []string{AnnotateArchive, AnnotateEntry, AnnotateLine, AnnotateCatLine}
+}
+
+// Tabs and newlines in the names would shift the columns
+var annotateEscaper = strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// annotator writes the content of an entry to w, prefixing each line with
+// the columns of Options.Annotate and a tab
+type annotator struct {
+	w        io.Writer
+	columns  []string
+	archive  string
+	entry    string
+	catLine  int64 // Lines before the entry in the cat file
+	line     int64 // Lines started so far
+	midLine  bool
+	buf      []byte
+	written  int64
+	newlines int64
+}
+
+func newAnnotator(w io.Writer, columns []string, entry *EntryInfo, catLine int64) *annotator {
+	return &annotator{
+		w:       w,
+		columns: columns,
+		archive: annotateEscaper.Replace(entry.Archive),
+		entry:   annotateEscaper.Replace(entry.Name),
+		catLine: catLine,
+	}
+}
+
+func (a *annotator) Write(p []byte) (int, error) {
+	a.buf = a.buf[:0]
+	for rest := p; len(rest) > 0; {
+		if !a.midLine {
+			a.line++
+			a.prefix()
+			a.midLine = true
+		}
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+			a.midLine = false
+			a.newlines++
+		}
+		a.buf = append(a.buf, line...)
+		rest = rest[len(line):]
+	}
+	n, err := a.w.Write(a.buf)
+	a.written += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (a *annotator) prefix() {
+	for _, column := range a.columns {
+		switch column {
+		case AnnotateArchive:
+			a.buf = append(a.buf, a.archive...)
+		case AnnotateEntry:
+			a.buf = append(a.buf, a.entry...)
+		case AnnotateLine:
+			a.buf = strconv.AppendInt(a.buf, a.line, 10)
+		case AnnotateCatLine:
+			a.buf = strconv.AppendInt(a.buf, a.catLine+a.line, 10)
+		}
+		a.buf = append(a.buf, '\t')
+	}
+}
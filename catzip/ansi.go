@@ -0,0 +1,73 @@
+package catzip
+
+import "io"
+
+// ansiStripper states, for the escape sequences split across writes
+const (
+	ansiText    = iota
+	ansiEscape  // After ESC
+	ansiCSI     // ESC [, up to a final byte
+	ansiOSC     // ESC ], up to BEL or ESC \
+	ansiOSCEsc  // ESC inside an OSC
+	ansiCharset // ESC ( and the like, up to the charset byte
+)
+
+// ansiStripper writes the content of an entry to w without its ANSI escape
+// sequences, like the colors of console logs, with Options.StripANSI. An
+// unfinished sequence at the end of the entry is dropped.
+type ansiStripper struct {
+	w       io.Writer
+	state   int
+	buf     []byte
+	written int64
+}
+
+func (a *ansiStripper) Write(p []byte) (int, error) {
+	a.buf = a.buf[:0]
+	for _, b := range p {
+		switch a.state {
+		case ansiText:
+			if b == 0x1b {
+				a.state = ansiEscape
+			} else {
+				a.buf = append(a.buf, b)
+			}
+		case ansiEscape:
+			switch b {
+			case '[':
+				a.state = ansiCSI
+			case ']':
+				a.state = ansiOSC
+			case '(', ')', '*', '+', '#', '%':
+				a.state = ansiCharset
+			default:
+				// Two bytes ones, like ESC c or ESC =
+				a.state = ansiText
+			}
+		case ansiCSI:
+			if b >= 0x40 && b <= 0x7e {
+				a.state = ansiText
+			}
+		case ansiOSC:
+			switch b {
+			case 0x07:
+				a.state = ansiText
+			case 0x1b:
+				a.state = ansiOSCEsc
+			}
+		case ansiOSCEsc:
+			a.state = ansiText
+			if b != '\\' {
+				a.state = ansiOSC
+			}
+		case ansiCharset:
+			a.state = ansiText
+		}
+	}
+	n, err := a.w.Write(a.buf)
+	a.written += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
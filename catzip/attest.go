@@ -0,0 +1,297 @@
+package catzip
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Attestation binds the manifest, the cat file and the inputs together under
+// a signature, for chain of custody
+type Attestation struct {
+	Statement AttestationStatement `json:"statement"`
+	Algorithm string               `json:"algorithm"`
+	PublicKey []byte               `json:"public_key"` // PKIX, DER
+	Signature []byte               `json:"signature"`  // Over the JSON encoding of Statement
+}
+
+type AttestationStatement struct {
+	ManifestSHA256 string `json:"manifest_sha256"`
+	CatSHA256      string `json:"cat_sha256"`
+	// Root of a hash tree over every written entry, see entriesRoot
+	EntriesRoot string             `json:"entries_root"`
+	Entries     int                `json:"entries"`
+	Inputs      []AttestationInput `json:"inputs"`
+	CreatedAt   time.Time          `json:"created_at"`
+}
+
+type AttestationInput struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// entriesRoot builds an RFC 6962 style hash tree, each leaf binds an entry
+// to its archive and to the hash of its content
+func entriesRoot(entries []*EntryInfo) ([]byte, error) {
+	var level [][]byte
+	for _, e := range entries {
+		contentHash, err := hex.DecodeString(e.Hash)
+		if err != nil || e.Hash == "" {
+			return nil, fmt.Errorf("%s in %s has no hash", e.Name, e.Archive)
+		}
+		h := sha256.New()
+		h.Write([]byte{0})
+		fmt.Fprintf(h, "%s\x00%s\x00", e.Archive, e.Name)
+		if e.Nested != "" {
+			fmt.Fprintf(h, "%s\x00", e.Nested)
+		}
+		h.Write(contentHash)
+		level = append(level, h.Sum(nil))
+	}
+	if len(level) == 0 {
+		empty := sha256.Sum256(nil)
+		return empty[:], nil
+	}
+
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write([]byte{1})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+	return level[0], nil
+}
+
+func (r *run) writeAttestation(catSum []byte) error {
+	manifestData, err := os.ReadFile(r.opts.ManifestPath)
+	if err != nil {
+		return err
+	}
+	manifestSum := sha256.Sum256(manifestData)
+
+	statement := AttestationStatement{
+		ManifestSHA256: hex.EncodeToString(manifestSum[:]),
+		CatSHA256:      hex.EncodeToString(catSum),
+		Inputs:         []AttestationInput{},
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	var written []*EntryInfo
+	for _, archive := range r.manifest.Archives {
+		for _, e := range archive.Entries {
+			if e.Status == EntryWritten {
+				written = append(written, e)
+			}
+		}
+
+		if archive.Nested != "" {
+			// Its input is hashed with the archive it is in
+			continue
+		}
+		inputSum, err := hashInput(r.in, archive.Path)
+		if err != nil {
+			return err
+		}
+		statement.Inputs = append(statement.Inputs, AttestationInput{Path: archive.Path, SHA256: inputSum})
+	}
+	root, err := entriesRoot(written)
+	if err != nil {
+		return err
+	}
+	statement.EntriesRoot = hex.EncodeToString(root)
+	statement.Entries = len(written)
+
+	attestation, err := signStatement(statement, r.opts.SigningKey)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(attestation, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.opts.AttestationPath, data, 0644)
+}
+
+func hashInput(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Check compares the statement with a manifest and a cat file, the
+// signature is checked by VerifyAttestation
+func (a *Attestation) Check(manifestData []byte, cat io.Reader) error {
+	manifestSum := sha256.Sum256(manifestData)
+	if hex.EncodeToString(manifestSum[:]) != a.Statement.ManifestSHA256 {
+		return errors.New("the manifest doesn't match the attestation")
+	}
+
+	m := &manifest{}
+	if err := json.Unmarshal(manifestData, m); err != nil {
+		return err
+	}
+	var written []*EntryInfo
+	for _, archive := range m.Archives {
+		for _, e := range archive.Entries {
+			if e.Status == EntryWritten {
+				written = append(written, e)
+			}
+		}
+	}
+	root, err := entriesRoot(written)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(root) != a.Statement.EntriesRoot {
+		return errors.New("the manifest entries don't match the attestation")
+	}
+
+	if cat != nil {
+		h := sha256.New()
+		if _, err := io.Copy(h, cat); err != nil {
+			return err
+		}
+		if hex.EncodeToString(h.Sum(nil)) != a.Statement.CatSHA256 {
+			return errors.New("the cat file doesn't match the attestation")
+		}
+	}
+	return nil
+}
+
+// signatureAlgorithm names how a key signs, RSA and ECDSA sign the SHA-256 of
+// the statement and Ed25519 the statement itself
+func signatureAlgorithm(key crypto.PublicKey) (string, crypto.Hash, error) {
+	switch key.(type) {
+	case ed25519.PublicKey:
+		return "ed25519", crypto.Hash(0), nil
+	case *ecdsa.PublicKey:
+		return "ecdsa-sha256", crypto.SHA256, nil
+	case *rsa.PublicKey:
+		return "rsa-pkcs1v15-sha256", crypto.SHA256, nil
+	}
+	return "", 0, fmt.Errorf("unsupported key type %T", key)
+}
+
+func signStatement(statement AttestationStatement, key crypto.Signer) (*Attestation, error) {
+	algorithm, hash, err := signatureAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	message, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := message
+	if hash != 0 {
+		sum := sha256.Sum256(message)
+		digest = sum[:]
+	}
+	signature, err := key.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &Attestation{Statement: statement, Algorithm: algorithm, PublicKey: publicKey, Signature: signature}, nil
+}
+
+// VerifyAttestation checks the signature of an attestation file against its
+// embedded public key, which the caller still has to trust, and returns the
+// statement
+func VerifyAttestation(data []byte) (*Attestation, error) {
+	attestation := &Attestation{}
+	if err := json.Unmarshal(data, attestation); err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(attestation.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, _, err := signatureAlgorithm(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if algorithm != attestation.Algorithm {
+		return nil, fmt.Errorf("the key is %s but the attestation says %s", algorithm, attestation.Algorithm)
+	}
+
+	message, err := json.Marshal(attestation.Statement)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(message)
+	valid := false
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, message, attestation.Signature)
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], attestation.Signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], attestation.Signature) == nil
+	}
+	if !valid {
+		return nil, errors.New("invalid attestation signature")
+	}
+	return attestation, nil
+}
+
+// SignedBy checks the attestation was signed with the PEM or DER encoded
+// PKIX public key
+func (a *Attestation) SignedBy(publicKey []byte) error {
+	if block, _ := pem.Decode(publicKey); block != nil {
+		publicKey = block.Bytes
+	}
+	if !bytes.Equal(publicKey, a.PublicKey) {
+		return errors.New("the attestation is signed with another key")
+	}
+	return nil
+}
+
+// ParseSigningKey parses a PKCS #8 private key, DER or PEM encoded
+func ParseSigningKey(data []byte) (crypto.Signer, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	key, err := x509.ParsePKCS8PrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,80 @@
+package catzip
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audited operations
+const (
+	AuditCreate = "create"
+	AuditMkdir  = "mkdir"
+	AuditRename = "rename"
+	AuditDelete = "delete"
+)
+
+// AuditRecord is one line of the audit log
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Op      string    `json:"op"`
+	Path    string    `json:"path"`
+	NewPath string    `json:"new_path,omitempty"` // Of a rename
+	Archive string    `json:"archive,omitempty"`  // Input that triggered it
+	Entry   string    `json:"entry,omitempty"`
+}
+
+// auditLog records every filesystem change of a run as JSON lines. The file
+// is only ever appended to and each record is written as soon as the change
+// is done, so a crash loses at most the change in progress.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	dirs map[string]bool // Directories already recorded
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file, dirs: map[string]bool{}}, nil
+}
+
+// audit records a change, entry is nil when it isn't caused by an entry
+func (r *run) audit(op string, path string, newPath string, entry *EntryInfo) error {
+	a := r.auditLog
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if op == AuditMkdir {
+		if a.dirs[path] {
+			return nil
+		}
+		a.dirs[path] = true
+	}
+
+	record := AuditRecord{Time: time.Now().UTC(), Op: op, Path: path, NewPath: newPath}
+	if entry != nil {
+		record.Archive = entry.Archive
+		record.Entry = entry.Name
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	// A single write per record so concurrent runs appending don't interleave
+	_, err = a.file.Write(append(line, '\n'))
+	return err
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
@@ -0,0 +1,105 @@
+package catzip
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Of the Blob service, the first taking bearer tokens is 2017-11-09
+const azureVersion = "2021-08-06"
+
+// azureClient builds the requests of the az:// inputs to the Blob service
+// of a storage account, with the bearer token of Options.Credentials
+type azureClient struct {
+	creds    CredentialProvider
+	endpoint string // "" without an account
+}
+
+func newAzureClient(opts Options) *azureClient {
+	c := &azureClient{creds: opts.Credentials, endpoint: opts.AzureEndpoint}
+	if account := firstNonEmpty(opts.AzureAccount, os.Getenv("AZURE_STORAGE_ACCOUNT")); c.endpoint == "" && account != "" {
+		c.endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+	if c.creds == nil {
+		c.creds = DefaultCredentials(opts.HTTPClient)
+	}
+	return c
+}
+
+// request builds the GET of the az://container/blob URL u, of the content
+// of the blob or, without one, of the listing of container with the query
+// of u
+func (c *azureClient) request(u *url.URL) (*http.Request, error) {
+	if c.endpoint == "" {
+		return nil, errors.New("no Azure storage account, AzureAccount and AZURE_STORAGE_ACCOUNT are empty")
+	}
+	creds, err := c.creds.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds.Token == "" {
+		return nil, fmt.Errorf("the credentials from %s have no token for Azure requests", creds.Source)
+	}
+	target, err := url.Parse(c.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + u.Host
+	target.RawQuery = u.RawQuery
+	if blob := strings.TrimPrefix(u.Path, "/"); blob != "" {
+		target.Path += "/" + blob
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+creds.Token)
+	req.Header.Set("X-Ms-Version", azureVersion)
+	return req, nil
+}
+
+// listAzure lists the blobs of container with names starting with prefix,
+// in name order
+func (u *urlInputs) listAzure(container, prefix string) ([]bucketObject, error) {
+	var objects []bucketObject
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := u.get((&url.URL{Scheme: "az", Host: container, Path: "/", RawQuery: query.Encode()}).String(), "")
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Blobs []struct {
+				Name       string
+				Properties struct {
+					LastModified  string `xml:"Last-Modified"`
+					ContentLength int64  `xml:"Content-Length"`
+				}
+			} `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the listing of az://%s/%s: %w", container, prefix, err)
+		}
+		for _, blob := range page.Blobs {
+			modTime, _ := http.ParseTime(blob.Properties.LastModified)
+			objects = append(objects, bucketObject{blob.Name, blob.Properties.ContentLength, modTime})
+		}
+		if page.NextMarker == "" {
+			return objects, nil
+		}
+		marker = page.NextMarker
+	}
+}
@@ -0,0 +1,123 @@
+package catzip
+
+import "io"
+
+// bcjReader reverses the x86 BCJ filter, which makes the targets of the
+// relative CALL and JMP instructions absolute so executables compress
+// better. It follows the filter of the LZMA SDK.
+type bcjReader struct {
+	r   io.Reader
+	buf []byte
+	// buf[start:done] is decoded, buf[done:end] waits for more bytes
+	start, done, end int
+
+	pos      uint32 // Position of buf[0] in the stream
+	prevMask uint32
+	prevPos  uint32
+	eof      bool
+	err      error
+}
+
+func newBCJReader(r io.Reader) *bcjReader {
+	return &bcjReader{r: r, buf: make([]byte, 64<<10), prevPos: 0xFFFFFFFB}
+}
+
+func (b *bcjReader) Read(p []byte) (int, error) {
+	for b.start == b.done {
+		if b.eof {
+			// The last 4 bytes can't hold an instruction
+			if b.done == b.end {
+				if b.err != nil {
+					return 0, b.err
+				}
+				return 0, io.EOF
+			}
+			b.done = b.end
+			break
+		}
+
+		b.pos += uint32(b.start)
+		b.end = copy(b.buf, b.buf[b.start:b.end])
+		b.start, b.done = 0, 0
+		n, err := b.r.Read(b.buf[b.end:])
+		b.end += n
+		if err != nil {
+			b.eof = true
+			if err != io.EOF {
+				b.err = err
+			}
+		}
+		b.done = b.decode(b.buf[:b.end])
+	}
+
+	n := copy(p, b.buf[b.start:b.done])
+	b.start += n
+	return n, nil
+}
+
+var (
+	bcjAllowedMask = [8]bool{true, true, true, false, true, false, false, false}
+	bcjMaskBit     = [8]uint32{0, 1, 2, 2, 3, 3, 3, 3}
+)
+
+func bcjTest(b byte) bool { return b == 0 || b == 0xFF }
+
+// decode converts the instructions of buf, it returns how many bytes are
+// decoded
+func (b *bcjReader) decode(buf []byte) int {
+	if len(buf) < 5 {
+		return 0
+	}
+	if b.pos-b.prevPos > 5 {
+		b.prevPos = b.pos - 5
+	}
+
+	i := 0
+	for i <= len(buf)-5 {
+		if buf[i] != 0xE8 && buf[i] != 0xE9 {
+			i++
+			continue
+		}
+		offset := b.pos + uint32(i) - b.prevPos
+		b.prevPos = b.pos + uint32(i)
+		if offset > 5 {
+			b.prevMask = 0
+		} else {
+			for j := uint32(0); j < offset; j++ {
+				b.prevMask &= 0x77
+				b.prevMask <<= 1
+			}
+		}
+
+		high := buf[i+4]
+		if !bcjTest(high) || !bcjAllowedMask[(b.prevMask>>1)&0x7] || b.prevMask>>1 >= 0x10 {
+			i++
+			b.prevMask |= 1
+			if bcjTest(high) {
+				b.prevMask |= 0x10
+			}
+			continue
+		}
+
+		src := uint32(high)<<24 | uint32(buf[i+3])<<16 | uint32(buf[i+2])<<8 | uint32(buf[i+1])
+		var dest uint32
+		for {
+			dest = src - (b.pos + uint32(i) + 5)
+			if b.prevMask == 0 {
+				break
+			}
+			bit := bcjMaskBit[b.prevMask>>1]
+			if !bcjTest(byte(dest >> (24 - bit*8))) {
+				break
+			}
+			src = dest ^ (1<<(32-bit*8) - 1)
+		}
+		buf[i+4] = ^byte((dest>>24)&1 - 1)
+		buf[i+3] = byte(dest >> 16)
+		buf[i+2] = byte(dest >> 8)
+		buf[i+1] = byte(dest)
+		i += 5
+		b.prevMask = 0
+	}
+	return i
+}
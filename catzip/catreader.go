@@ -0,0 +1,163 @@
+package catzip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// CatReader runs opts in the background and returns the concatenated
+// stream as it is written, the run going as far as it is read. sources is
+// where the inputs are read from like Options.InputFS, nil keeps the one of
+// opts. With a nil Options.FS the extracted files are kept in memory only
+// until they are appended, so nothing is written to disk.
+//
+// The reader returns the error of the run at the end of the stream, and
+// the one of ctx once it is done. Close stops the run and waits for it.
+//
+// The run starts with the first Read. When io.Copy starts it with WriteTo
+// instead, the run writes to the writer itself, without the pipe between
+// them, so a file or a socket gets the files of the outputs through its
+// ReadFrom.
+func CatReader(ctx context.Context, sources fs.FS, opts Options) io.ReadCloser {
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(ctx)
+	c := &catReader{PipeReader: pr, pw: pw, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	if sources != nil {
+		opts.InputFS = sources
+	}
+	opts.CatFileName = ""
+	if opts.FS == nil {
+		if len(opts.FormatRoutes) > 0 {
+			c.err = errors.New("FormatRoutes need an FS for the files they write, the stream only has the cat file")
+		}
+		mem := NewMemFS()
+		opts.FS = mem
+		onEntry := opts.OnEntry
+		opts.OnEntry = func(entry EntryInfo) {
+			// Appended, the stream has it now
+			for _, name := range outputFiles(&entry) {
+				mem.Remove(name)
+			}
+			if onEntry != nil {
+				onEntry(entry)
+			}
+		}
+	}
+	c.opts = opts
+	return c
+}
+
+type catReader struct {
+	*io.PipeReader
+	pw     *io.PipeWriter
+	opts   Options
+	err    error // Returned instead of running
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	start     sync.Once
+	closeOnce sync.Once
+	done      chan struct{} // Closed once the run returns
+}
+
+// run runs the options with w as the cat writer
+func (c *catReader) run(w io.Writer) error {
+	defer close(c.done)
+	if c.err != nil {
+		return c.err
+	}
+	opts := c.opts
+	opts.CatWriter = w
+	_, err := Run(opts)
+	return err
+}
+
+func (c *catReader) Read(p []byte) (int, error) {
+	c.start.Do(func() {
+		go func() {
+			c.pw.CloseWithError(c.run(c.pw))
+		}()
+		go func() {
+			select {
+			case <-c.ctx.Done():
+				// Fails the writes of the run too
+				c.pw.CloseWithError(c.ctx.Err())
+			case <-c.done:
+			}
+		}()
+	})
+	return c.PipeReader.Read(p)
+}
+
+// WriteTo runs the options with w as the cat writer when nothing was read
+// yet, and copies the rest of the pipe otherwise
+func (c *catReader) WriteTo(w io.Writer) (int64, error) {
+	started := false
+	c.start.Do(func() { started = true })
+	if !started {
+		return io.Copy(w, c.PipeReader)
+	}
+
+	out := &ctxWriter{ctx: c.ctx, w: w}
+	err := c.run(out)
+	if err == nil {
+		// The Reads after it get io.EOF
+		c.pw.Close()
+	} else {
+		c.pw.CloseWithError(err)
+	}
+	return out.n, err
+}
+
+func (c *catReader) Close() error {
+	c.closeOnce.Do(func() {
+		c.start.Do(func() { close(c.done) })
+		c.cancel()
+		c.PipeReader.Close()
+		<-c.done
+	})
+	return nil
+}
+
+// ctxWriter counts the bytes written to w, and fails once ctx is done
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+	n   int64
+}
+
+func (c *ctxWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Bytes ctxWriter.ReadFrom copies between the checks of ctx
+const ctxCopyChunk = 1 << 20
+
+// ReadFrom copies r in chunks, checking ctx before each so that a large
+// entry stops soon after it is done. The chunks are io.LimitedReaders, which
+// a file or a socket still copies in the kernel.
+func (c *ctxWriter) ReadFrom(r io.Reader) (int64, error) {
+	var written int64
+	for {
+		if err := c.ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := io.CopyN(c.w, r, ctxCopyChunk)
+		written += n
+		c.n += n
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
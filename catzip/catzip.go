@@ -0,0 +1,948 @@
+// Package catzip extracts zip and gzip files and concatenates everything they
+// contain into a single file.
+//
+// The package doesn't need an OS filesystem, with Options.InputFS and
+// Options.FS set it runs under GOOS=js and GOOS=wasip1. The cat-zip command
+// itself is native only.
+package catzip
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options configure a run, DefaultOptions has the values used by the CLI
+type Options struct {
+	// Directory where the input files are placed, or s3://bucket/prefix,
+	// gs://bucket/prefix or az://container/prefix for the objects of an S3
+	// or GCS bucket or of an Azure container with keys starting with prefix,
+	// downloaded like URLs and named inside OutDir after their key past the
+	// last slash of prefix. sftp://user@host/dir lists the files under a
+	// remote directory the same way, named after their path inside it, and
+	// sftp://user@host/~/dir one of the home directory. So do
+	// webdavs://host/dir and webdav://host/dir for the ones of a WebDAV
+	// share over https or http.
+	Dir string
+	// Also read these http, https, s3://bucket/key, gs://bucket/key,
+	// az://container/blob, sftp://user@host/path or webdavs://host/path
+	// URLs, after the files of Dir, which can be "" for none. They are downloaded as they are read, tried again
+	// URLRetries times and resumed where they broke when the server takes
+	// ranges. Each is named after the end of its path inside OutDir, which
+	// tells its format like the files of Dir.
+	URLs       []string
+	URLRetries int
+	// Directory where the extracted files are placed, gz files are
+	// extracted next to them
+	OutDir string
+	// Input files extension, .gz, .zst, .bz2, .xz, .lz4, .br, .sz, .zip,
+	// .7z, .rar, .iso, .tar, .tar.gz, .tgz, .tar.xz, .txz, .tar.zst, .tzst,
+	// .tar.bz2, .tbz2, .tar.lz4, .cpio, .cpio.gz, .deb or .rpm, or several
+	// of them separated by commas, each input read as the longest it has,
+	// e.g. .gz,.zip. Only the installed files of deb and rpm packages are
+	// extracted, and split zip files are read from their .z01, .z02 and on
+	// parts next to them. The tarballs a single compressed extension
+	// selects, like the .tar.gz files of .gz, are untarred. With Detect it
+	// only selects the input files, "" selects them all.
+	// zst files are decompressed with the zstd command, br ones with the
+	// brotli command, and the compressed files of rar archives with the
+	// unrar command. zip files that can't be seeked, like named pipes, are
+	// read in order from their local headers.
+	Ext string
+	// Read each input file as the format its magic bytes tell, the one of
+	// Ext when they match none. Single compressed files without the
+	// extension of their format are extracted to name.out.
+	Detect bool
+	// The .tar inputs are docker save or OCI layout tarballs, the root
+	// filesystem of each of their images is extracted instead of their
+	// members, the layers applied in order with their whiteouts
+	Image bool
+	// Extract the .zip, .gz, .tar.gz and .tgz entries too, up to this many
+	// archives deep, into a directory named after them, a gz one next to
+	// it. 0 writes them as they are.
+	MaxDepth int
+	// Settings of the zip, gz and tar handlers, by format. The tar ones
+	// apply to the compressed tar files and deb packages too.
+	Handlers map[string]HandlerOptions
+	// Concatenated file name, inside OutDir
+	CatFileName string
+	// Write the concatenated file here instead, like stdout, it isn't
+	// closed. DirectIO, PreallocateCat, CheckpointPath, SortKey and
+	// PostCommand need CatFileName. Without Sinks and AttestationPath, its
+	// ReadFrom is given the extracted files, for sendfile to a socket.
+	CatWriter io.Writer
+	// Plain files with these extensions are copied and concatenated as they are
+	PassthroughExt []string
+	// Rewrite the entry names, in order, before computing the output paths.
+	// gz and plain inputs only have their base name rewritten.
+	Rename []RenameRule
+	// Merge the trees of the zip files into one, keeping a single entry for
+	// each output path instead of numbering the repeated ones. One of
+	// MergeNewest, MergeOldest, MergeFirst and MergeLast, "" is off.
+	MergeTrees string
+	// Write the paths with different content in several zip files as JSON here
+	ConflictReportPath string
+	// Entry of the zip files listing the paths that archive deletes, one per
+	// line. With the MergeLast MergeTrees the zip files are applied in input
+	// order as a full backup and its increments, deleted paths are left out
+	// and removed from OutDir when a previous run extracted them.
+	Tombstones string
+
+	// Input files decompressed concurrently, 0 adjusts it during the run
+	Workers int
+	// Upper limit for Workers, 0 is twice the number of CPUs
+	MaxWorkers int
+	// Extracted files written concurrently, more than 1 doesn't keep the
+	// cat file in input order
+	WriteWorkers int
+	// Queue depths between the stages, see queueDepths
+	FilesQueue   int
+	EntriesQueue int
+	ChunksQueue  int
+	// Size of the buffers carrying decoded data to the write stage, 0 is 256KiB
+	ChunkSize int
+	// Process the largest input files first
+	LargestFirst bool
+	// Process the input files as the walk of Dir finds them instead of once
+	// it is done, so the first ones are out before a huge tree is walked.
+	// MergeTrees, LargestFirst, OnProgress, PreallocateCat and
+	// CheckpointPath need the inputs upfront.
+	Stream bool
+	// Directories of Dir read concurrently, in no particular order, implies
+	// Stream. 0 is a single one, with Stream, or walks Dir upfront.
+	WalkWorkers int
+
+	// Split the extracted files larger than this into numbered parts,
+	// Output.part000 and on, 0 is off
+	SplitEntrySize int64
+	// Gzip the extracted files larger than this to Output.gz once they are
+	// appended to cat, 0 is off
+	RecompressAbove int64
+
+	// Hash of each extracted file, one of HashAlgorithms or HashOff
+	Hash string
+	// Record the format of each extracted file, one of Formats
+	Classify bool
+	// Concatenate the files of these formats to other files in OutDir instead
+	// of the cat file, format to file name. It requires Classify.
+	FormatRoutes map[string]string
+	// Similarity signature of each extracted file, SignatureSimHash,
+	// SignatureMinHash or "" for none
+	Signature string
+	// Record the line terminators and the encoding of each extracted file,
+	// see EntryInfo.LineEndings and EntryInfo.Encoding
+	DetectText bool
+	// Prefix each line of the cat file with these AnnotateColumns and a tab,
+	// to trace it back to its entry. CatLength includes the prefixes.
+	Annotate []string
+	// Remove the ANSI escape sequences, like the colors of console logs,
+	// from what is appended to the cat file. The extracted files keep them.
+	StripANSI bool
+	// Append the binary entries to the cat file as a single line escaped
+	// this way, one of EscapeModes, see EntryInfo.Escaped. "" appends them
+	// as they are.
+	Escape string
+	// Longest line appended to the cat file, the newline aside, the longer
+	// ones are cut as LongLines tells. 0 is no limit.
+	MaxLineBytes int64
+	// LongLinesTruncate, the default, or LongLinesWrap
+	LongLines string
+
+	// Skip input files modified less than this long ago
+	StableFor time.Duration
+	// Only process input files with a companion file with this suffix
+	RequireMarker string
+	// Name of the ignore files of the directories of Dir, which leave out
+	// the files and directories under them matching their glob patterns,
+	// one per line like the ones of .gitignore: # comments, ! negations,
+	// a trailing / for directories only, a slash elsewhere anchoring it to
+	// the directory of the file and ** for any number of directories. ""
+	// reads none, the buckets are never looked into.
+	IgnoreFile string
+	// Name of the config files of the directories of Dir, which override
+	// these options for the inputs under them, on top of the ones of their
+	// parents. They are YAML with these keys, all optional:
+	//
+	//	# File in OutDir the entries are concatenated to instead of the cat
+	//	# file, CatFileName sends them back to it
+	//	route: team-a.txt
+	//	# Like FormatRoutes, before route
+	//	format_routes:
+	//	  json: team-a.json
+	//	# Replaces FilterCommand, split on spaces or a [list], "" for none
+	//	filter_command: grep -v DEBUG
+	//	# Some of the extensions of Ext, or any with Detect and no Ext
+	//	ext: .gz
+	//
+	// A directory with a bad one is logged and left out. "" reads none, the
+	// buckets are never looked into.
+	DirConfigFile string
+	// Create a file with this suffix next to each processed input file
+	WriteMarker string
+	// State file remembering the processed input files
+	StatePath string
+	// Keep StatePath as a compact index with a bloom filter instead of
+	// JSON, for millions of inputs. A JSON state file is converted, an index
+	// stays one either way.
+	StateIndex bool
+	// Skip the input files modified before the newest input in the state
+	// file, the ones of the same time are compared with the state. The
+	// directories are still walked, adding a file deep in a tree doesn't
+	// change the time of the directories above it.
+	OnlyNewerThanState bool
+	// Write a JSON manifest of the extracted files here
+	ManifestPath string
+	// Save the progress of the run here, so a run that crashed or was
+	// stopped resumes after the entries it already appended. When it exists
+	// the cat file and the routes are truncated back to it and appended to,
+	// so they have each entry once, the sinks only get the rest. It is
+	// removed once the run completes.
+	CheckpointPath string
+	// Time between the saves of the checkpoint, 0 saves it after every entry
+	CheckpointInterval time.Duration
+
+	// Memory-map zip files
+	Mmap bool
+	// Reserve disk space for the extracted files and the cat file
+	Preallocate    bool
+	PreallocateCat bool
+	// Write the cat file with O_DIRECT
+	DirectIO bool
+
+	// Where the inputs are read from, nil is the OS with Dir an OS path.
+	// WriteMarker, Mmap and the sameFile check only apply to OS inputs.
+	InputFS fs.FS
+	// When set, the OS inputs are only read when their real path, with the
+	// links resolved, is under this directory, e.g. the input root of a
+	// tenant. A link to a file elsewhere fails like a file that can't be
+	// read.
+	InputRoot string
+	// Where the outputs are written, nil is OS
+	FS WriteFS
+	// Credentials of the remote inputs, nil is DefaultCredentials
+	Credentials CredentialProvider
+	// Client of the remote inputs, see HTTPConfig. nil is
+	// http.DefaultClient.
+	HTTPClient *http.Client
+	// Endpoint of the s3:// inputs, e.g. http://localhost:9000 for MinIO,
+	// reached with path style requests. "" is AWS_ENDPOINT_URL_S3,
+	// AWS_ENDPOINT_URL or AWS.
+	S3Endpoint string
+	// Region the s3:// requests are signed for, "" is the one each bucket
+	// tells, AWS_REGION or us-east-1
+	S3Region string
+	// Endpoint of the gs:// inputs, e.g. the one of fake-gcs-server. "" is
+	// STORAGE_EMULATOR_HOST or GCS.
+	GCSEndpoint string
+	// Storage account of the az:// inputs, "" is AZURE_STORAGE_ACCOUNT
+	AzureAccount string
+	// Endpoint of the az:// inputs, e.g.
+	// http://127.0.0.1:10000/devstoreaccount1 for Azurite. "" is the one of
+	// AzureAccount.
+	AzureEndpoint string
+	// Command the sftp:// inputs are reached with, given the -p port, -l
+	// user, -s host sftp arguments of the ssh command. nil is ssh -o
+	// BatchMode=yes, with the keys, agent and known hosts of the user.
+	SSHCommand []string
+	// File with the logins of the webdav:// and webdavs:// inputs, in the
+	// format of .netrc, "" is NETRC or ~/.netrc. CATZIP_WEBDAV_USER and
+	// CATZIP_WEBDAV_PASSWORD come first.
+	NetrcFile string
+
+	// Sign the manifest, the cat file and the inputs with SigningKey into an
+	// Attestation written here, it requires ManifestPath and the sha256 Hash
+	AttestationPath string
+	SigningKey      crypto.Signer
+
+	// Append a record of every file created, renamed or removed here
+	AuditPath string
+
+	// Command each extracted entry is piped through, from its stdin to its
+	// stdout, before it is written, scanned and hashed
+	FilterCommand []string
+
+	// Starlark script deciding what to do with each entry, the source of a
+	// file defining decide(entry). entry has the attributes archive, name,
+	// nested, layer, size, compressed_size, mode, is_dir, mtime (seconds
+	// since 1970), crc32, comment, format, xattrs, entry and entries. decide
+	// returns None to extract it as usual, or a dict with the fields of
+	// PolicyDecision:
+	//
+	//	def decide(entry):
+	//	    if entry.size == 0:
+	//	        return {"skip": True, "reason": "empty"}
+	//	    if entry.name.endswith(".csv"):
+	//	        return {"route": "csv_blob", "transform": ["sort -u"]}
+	//
+	// The language is a subset of Starlark, see starlark.go.
+	PolicyScript string
+	// Command deciding what to do with each entry instead, see
+	// commandPolicy
+	PolicyCommand []string
+
+	// Command each extracted entry is streamed to, see scanner. Flagged
+	// entries are left out of cat and moved to QuarantineDir, or removed
+	ScanCommand   []string
+	QuarantineDir string
+
+	// Also send the cat file and the entries here, see ParseSink. A failing
+	// sink fails the run or is dropped depending on its policy.
+	Sinks []AttachedSink
+	// Sort the CSV records of the complete cat file by this column, from 1,
+	// 0 is off. Blank lines are dropped and the cat offsets of the manifest
+	// are the ones before sorting. The routes aren't sorted.
+	SortKey int
+	// Compare the SortKey column as numbers, after the records where it
+	// isn't one like the header rows
+	SortNumeric bool
+	// Memory for the records sorted at once, the rest is spilled to sorted
+	// runs in SortTempDir and merged. 0 is 64MiB.
+	SortMemory int64
+	// Where the sorted runs are written, "" is the OS temporary directory
+	SortTempDir string
+	// Run once the cat file is complete, {} is replaced with its path
+	PostCommand []string
+
+	// Called once each entry is written to the cat file or skipped, one
+	// call at a time
+	OnEntry func(EntryInfo)
+	// Called after OnEntry with the counts of the run, one call at a time.
+	// Setting it reads the zip central directories upfront, and the tar
+	// files through, to know the total.
+	OnProgress func(Progress)
+	// Where the progress of the run is logged, one line per entry and the
+	// problems worked around, nil is the standard logger
+	Logger *log.Logger
+}
+
+// DefaultOptions returns the options used when no flags are given
+func DefaultOptions() Options {
+	return Options{
+		Dir:           ".",
+		OutDir:        ".",
+		Ext:           ".gz",
+		CatFileName:   "unknown_blob",
+		Workers:       1,
+		WriteWorkers:  1,
+		FilesQueue:    0,
+		EntriesQueue:  1,
+		ChunksQueue:   16,
+		Hash:          HashOff,
+		URLRetries:    3,
+		IgnoreFile:    ".catzipignore",
+		DirConfigFile: ".catzip.yaml",
+	}
+}
+
+// Summary describes a finished run
+type Summary struct {
+	Files       int
+	Bytes       int64
+	Duration    time.Duration
+	Workers     int // Final number of workers
+	PeakWorkers int
+	Skipped     []EntryInfo
+	Entries     int // Written or skipped, directories aside
+	// Output paths with different content in several zip files, with MergeTrees
+	Conflicts []MergeConflict
+	// Errors of the best-effort sinks dropped during the run, by name
+	SinkErrors map[string]error
+	// Lines truncated or wrapped, with MaxLineBytes
+	LongLines int
+	// The Entries by size, in the order of their bounds, and by type, the
+	// most common first
+	Sizes []SizeBucket
+	Types []TypeCount
+}
+
+// run holds the state of a single Run
+type run struct {
+	opts    Options
+	logger  *log.Logger
+	in      fs.FS
+	fs      WriteFS
+	depths  queueDepths
+	newHash func() hash.Hash
+
+	catFile io.WriteCloser
+	// catFile, also hashing it when there is an attestation
+	catOut  io.Writer
+	catHash hash.Hash
+	// Guards catFile so entries from different write workers don't interleave
+	catFileMu sync.Mutex
+
+	unzipedFiles   map[string]uint
+	unzipedFilesMu sync.Mutex
+
+	writeTasks chan *writeTask
+	chunkPool  sync.Pool
+	// Bytes decoded so far
+	copiedBytes atomic.Int64
+	// Names the nested zip files spooled to OutDir
+	nestedSpools atomic.Int64
+
+	skipped   []EntryInfo
+	skippedMu sync.Mutex
+	manifest  *manifest
+	auditLog  *auditLog
+	sinks     *sinks
+	merge     *mergePlan
+	policy    policy
+	routes    map[string]*catTarget // By file name, guarded by catFileMu
+	configs   *inputConfigs
+	onEntryMu sync.Mutex
+	// Entries done so far and upfront, guarded by progressMu
+	progressMu   sync.Mutex
+	entriesDone  int
+	totalEntries int
+	composition  composition
+	// Next offset in the cat file, guarded by catFileMu
+	catOffset int64
+	// Guarded by catFileMu, with Options.MaxLineBytes
+	longLines int
+	// Lines in the cat file, with Options.Annotate
+	catLines int64
+	// Guarded by catFileMu, nil without Options.CheckpointPath
+	checkpoint *checkpoint
+	inputInfos map[string]fs.FileInfo
+	// Input files sent by streamInputs, with Options.Stream
+	streamed []string
+
+	errMu sync.Mutex
+	err   error
+}
+
+// fileKind is how the input file name is read, see Options.fileKind
+func (r *run) fileKind(name string) inputKind {
+	return r.opts.fileKind(r.in, name)
+}
+
+// fail records the first error of the run, the remaining work is dropped
+func (r *run) fail(err error) {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *run) failed() bool {
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	return r.err != nil
+}
+
+// Run extracts and concatenates the input files selected by opts
+func Run(opts Options) (*Summary, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := &run{
+		opts:         opts,
+		logger:       opts.logger(),
+		in:           inputFS(opts),
+		fs:           opts.FS,
+		depths:       queueDepths{files: opts.FilesQueue, entries: opts.EntriesQueue, chunks: opts.ChunksQueue},
+		unzipedFiles: map[string]uint{},
+	}
+	defer closeInputs(r.in)
+	if r.fs == nil {
+		r.fs = OS
+	}
+
+	chunkSize := opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+	}
+	r.chunkPool.New = func() any { return make([]byte, chunkSize) }
+
+	var err error
+	if r.newHash, err = parseHash(opts.Hash); err != nil {
+		return nil, err
+	}
+
+	// Walked while they are processed with Stream
+	var filesInDir []string
+	var fileInfos map[string]fs.FileInfo
+	var walkOpts walkOptions
+	var state inputState
+	if walkOpts, state, err = inputWalk(opts); err != nil {
+		return nil, err
+	}
+	r.configs = walkOpts.configs
+	if opts.streams() {
+		fileInfos = map[string]fs.FileInfo{}
+	} else {
+		filesInDir, fileInfos, err = walkSelected(opts, r.in, walkOpts, state)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.inputInfos = fileInfos
+
+	if opts.MergeTrees != "" && opts.readsZip() {
+		if r.merge, err = r.planMerge(filesInDir, opts.MergeTrees); err != nil {
+			return nil, fmt.Errorf("unable to plan the merge: %w", err)
+		}
+	}
+
+	if opts.CheckpointPath != "" {
+		if r.checkpoint, err = loadCheckpoint(opts.CheckpointPath, filepath.Join(opts.OutDir, opts.CatFileName), fileInfos); err != nil {
+			return nil, fmt.Errorf("unable to read checkpoint %s: %w", opts.CheckpointPath, err)
+		}
+	}
+
+	if opts.ManifestPath != "" {
+		r.manifest = &manifest{}
+		if opts.CatWriter == nil {
+			r.manifest.Cat = filepath.Join(opts.OutDir, opts.CatFileName)
+		}
+		if r.newHash != nil {
+			r.manifest.Hash = opts.Hash
+		}
+	}
+
+	if opts.AuditPath != "" {
+		if r.auditLog, err = openAuditLog(opts.AuditPath); err != nil {
+			return nil, fmt.Errorf("unable to open audit log %s: %w", opts.AuditPath, err)
+		}
+		defer r.auditLog.Close()
+	}
+
+	r.sinks = newSinks(opts.Sinks, r.logger)
+	// A no-op once the sinks are closed, this only matters when the run fails
+	defer r.sinks.abort()
+
+	if err = r.openCatFile(filesInDir); err != nil {
+		return nil, err
+	}
+	defer r.catFile.Close()
+	catWriters := []io.Writer{r.catFile}
+	if opts.AttestationPath != "" {
+		r.catHash = sha256.New()
+		catWriters = append(catWriters, r.catHash)
+	}
+	if r.sinks != nil {
+		catWriters = append(catWriters, r.sinks)
+	}
+	// Alone, the cat file gets the outputs through its ReadFrom
+	r.catOut = r.catFile
+	if len(catWriters) > 1 {
+		r.catOut = io.MultiWriter(catWriters...)
+	}
+
+	defer r.closeRoutes()
+	if err = r.openRoutes(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case opts.PolicyScript != "":
+		script, err := compilePolicyScript(opts.PolicyScript)
+		if err != nil {
+			return nil, err
+		}
+		r.policy = script
+	case len(opts.PolicyCommand) > 0:
+		command, err := startPolicy(opts.PolicyCommand)
+		if err != nil {
+			return nil, err
+		}
+		r.policy = command
+		defer r.policy.Close()
+	}
+
+	passthrough := parseExtList(opts.PassthroughExt)
+	if opts.OnProgress != nil {
+		if r.totalEntries, err = countEntries(r.in, filesInDir, r.fileKind, passthrough); err != nil {
+			return nil, fmt.Errorf("unable to count the entries: %w", err)
+		}
+	}
+	start := time.Now()
+	waitWriters := r.startWriters()
+	inputs := sendFiles(filesInDir)
+	if opts.streams() {
+		inputs = r.streamInputs(walkOpts, state)
+	}
+	finalWorkers, peakWorkers := runWorkers(inputs, opts.Workers, opts.MaxWorkers, r.depths.files, &r.copiedBytes, func(f string) {
+		if r.failed() {
+			return
+		}
+
+		var err error
+		kind := opts.fileKind(r.in, f)
+		switch {
+		case passthrough[filepath.Ext(f)] || kind == kindPlain:
+			err = r.handlePlain(f)
+		case kind == kindGz:
+			err = r.handleGz(f)
+		case kind.single():
+			err = r.handleCompressed(f, kind)
+		case kind.tar():
+			err = r.handleTar(f, kind)
+		case kind.cpio():
+			err = r.handleCpio(f, kind)
+		case kind == kind7z:
+			err = r.handle7z(f)
+		case kind == kindRar:
+			err = r.handleRar(f)
+		case kind == kindIso:
+			err = r.handleIso(f)
+		case kind == kindImage:
+			err = r.handleImage(f)
+		default:
+			err = r.handleZip(f)
+		}
+		if err != nil {
+			r.fail(fmt.Errorf("%s: %w", f, err))
+		}
+	})
+	waitWriters()
+	if opts.streams() {
+		filesInDir = r.streamed
+	}
+	if r.err != nil {
+		// So the next run resumes from the last entry appended
+		if r.checkpoint != nil {
+			if err = r.saveCheckpoint(); err != nil {
+				r.logger.Print(err)
+			}
+			r.checkpoint.close()
+		}
+		return nil, r.err
+	}
+	if r.merge != nil && len(r.merge.deleted) > 0 {
+		destination, err := filepath.Abs(opts.OutDir)
+		if err != nil {
+			return nil, err
+		}
+		if err = r.removeDeleted(destination); err != nil {
+			return nil, fmt.Errorf("unable to remove deleted files: %w", err)
+		}
+	}
+	if err = r.catFile.Close(); err != nil {
+		return nil, err
+	}
+	if opts.SortKey > 0 {
+		if err = r.sortCat(); err != nil {
+			return nil, fmt.Errorf("unable to sort the cat file: %w", err)
+		}
+	}
+	if err = r.closeRoutes(); err != nil {
+		return nil, err
+	}
+	if r.policy != nil {
+		if err = r.policy.Close(); err != nil {
+			return nil, fmt.Errorf("policy command: %w", err)
+		}
+	}
+	if err = r.sinks.close(); err != nil {
+		return nil, err
+	}
+	if len(opts.PostCommand) > 0 {
+		if err = runPostCommand(opts.PostCommand, filepath.Join(opts.OutDir, opts.CatFileName)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only once everything is written, so a crash doesn't mark unfinished files
+	if state != nil {
+		for _, f := range filesInDir {
+			state.record(f, fileInfos[f])
+		}
+		if err = state.save(opts.StatePath); err != nil {
+			return nil, fmt.Errorf("unable to write state file %s: %w", opts.StatePath, err)
+		}
+		if err = r.audit(AuditCreate, opts.StatePath, "", nil); err != nil {
+			return nil, err
+		}
+	}
+	if r.checkpoint != nil {
+		if err = r.removeCheckpoint(); err != nil {
+			return nil, fmt.Errorf("unable to remove checkpoint %s: %w", opts.CheckpointPath, err)
+		}
+	}
+	if opts.WriteMarker != "" {
+		for _, f := range filesInDir {
+			if err = os.WriteFile(f+opts.WriteMarker, nil, 0644); err != nil {
+				return nil, fmt.Errorf("unable to write marker for %s: %w", f, err)
+			}
+			if err = r.audit(AuditCreate, f+opts.WriteMarker, "", nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if r.manifest != nil {
+		if err = r.manifest.write(opts.ManifestPath); err != nil {
+			return nil, fmt.Errorf("unable to write manifest %s: %w", opts.ManifestPath, err)
+		}
+		if err = r.audit(AuditCreate, opts.ManifestPath, "", nil); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ConflictReportPath != "" {
+		var conflicts []MergeConflict
+		if r.merge != nil {
+			conflicts = r.merge.conflicts
+		}
+		if err = writeConflictReport(opts.ConflictReportPath, conflicts); err != nil {
+			return nil, fmt.Errorf("unable to write conflict report %s: %w", opts.ConflictReportPath, err)
+		}
+		if err = r.audit(AuditCreate, opts.ConflictReportPath, "", nil); err != nil {
+			return nil, err
+		}
+	}
+	if opts.AttestationPath != "" {
+		if err = r.writeAttestation(r.catHash.Sum(nil)); err != nil {
+			return nil, fmt.Errorf("unable to write attestation %s: %w", opts.AttestationPath, err)
+		}
+		if err = r.audit(AuditCreate, opts.AttestationPath, "", nil); err != nil {
+			return nil, err
+		}
+	}
+
+	summary := &Summary{
+		Files:       len(filesInDir),
+		Bytes:       r.copiedBytes.Load(),
+		Duration:    time.Since(start),
+		Workers:     finalWorkers,
+		PeakWorkers: peakWorkers,
+		Skipped:     r.skipped,
+		Entries:     r.entriesDone,
+		LongLines:   r.longLines,
+	}
+	summary.Sizes, summary.Types = r.composition.counts()
+	if r.merge != nil {
+		summary.Conflicts = r.merge.conflicts
+	}
+	if r.sinks != nil {
+		summary.SinkErrors = r.sinks.errors
+	}
+	return summary, nil
+}
+
+// selectInputs walks opts.Dir in fsys, from inputFS, and returns the input
+// files to process, in the order they should be processed, along with the
+// state file if there is one
+func selectInputs(opts Options, fsys fs.FS) ([]string, map[string]fs.FileInfo, inputState, error) {
+	walkOpts, state, err := inputWalk(opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	filesInDir, fileInfos, err := walkSelected(opts, fsys, walkOpts, state)
+	return filesInDir, fileInfos, state, err
+}
+
+// walkSelected is selectInputs with the walk and the state of inputWalk
+func walkSelected(opts Options, fsys fs.FS, walkOpts walkOptions, state inputState) ([]string, map[string]fs.FileInfo, error) {
+	var err error
+	filesInDir, fileInfos := walkInputs(fsys, opts.Dir, walkOpts)
+	if state != nil {
+		if filesInDir, err = changedFiles(state, filesInDir, fileInfos); err != nil {
+			return nil, nil, fmt.Errorf("unable to read state file %s: %w", opts.StatePath, err)
+		}
+	}
+
+	if opts.LargestFirst {
+		sort.SliceStable(filesInDir, func(i, j int) bool {
+			return fileInfos[filesInDir[i]].Size() > fileInfos[filesInDir[j]].Size()
+		})
+	}
+	return filesInDir, fileInfos, nil
+}
+
+// inputWalk returns what the walk of the input directory selects, and the
+// state file when there is one
+func inputWalk(opts Options) (walkOptions, inputState, error) {
+	walkOpts := walkOptions{
+		exts:          splitExts(opts.Ext),
+		passthrough:   parseExtList(opts.PassthroughExt),
+		requireMarker: opts.RequireMarker,
+		stableFor:     opts.StableFor,
+		ignoreFile:    opts.IgnoreFile,
+		dirConfigFile: opts.DirConfigFile,
+		checkConfig:   opts.checkDirConfig,
+		configs:       &inputConfigs{},
+		logger:        opts.logger(),
+	}
+
+	var state inputState
+	if opts.StatePath != "" {
+		var err error
+		state, err = openState(opts.StatePath, opts.StateIndex)
+		if err != nil {
+			return walkOpts, nil, fmt.Errorf("unable to read state file %s: %w", opts.StatePath, err)
+		}
+		if opts.OnlyNewerThanState {
+			walkOpts.onlyNewerThan = state.newest()
+		}
+	}
+	return walkOpts, state, nil
+}
+
+// readsZip tells whether some extensions of Ext are zip files
+func (o *Options) readsZip() bool {
+	for _, kind := range o.kinds() {
+		if kind == kindZip {
+			return true
+		}
+	}
+	return false
+}
+
+// streamBatch is the most inputs found by the walk compared with the state
+// at once
+const streamBatch = 1024
+
+// streams tells whether the input files are processed as the walk finds them
+func (o *Options) streams() bool {
+	return o.Stream || o.WalkWorkers > 0
+}
+
+// streamInputs walks the input directory with Options.WalkWorkers readers,
+// or a single one, and returns the input files as they are found, less the
+// ones state has unchanged. The ones returned are also kept in r.streamed,
+// and their info in r.inputInfos, for once the run is done.
+func (r *run) streamInputs(walkOpts walkOptions, state inputState) <-chan string {
+	found := make(chan foundInput, streamBatch)
+	stop := make(chan struct{})
+	workers := r.opts.WalkWorkers
+	if workers == 0 {
+		workers = 1
+	}
+	go walkConcurrently(r.in, r.opts.Dir, walkOpts, workers, found, stop)
+
+	files := make(chan string, r.depths.files)
+	go func() {
+		defer close(files)
+		stopped := false
+		for input := range found {
+			if r.failed() {
+				// Left to the end of the walk
+				if !stopped {
+					close(stop)
+					stopped = true
+				}
+				continue
+			}
+
+			// What was found meanwhile is compared with the state at once
+			batch := []string{input.path}
+			infos := map[string]fs.FileInfo{input.path: input.info}
+			for more := true; more && len(batch) < streamBatch; {
+				select {
+				case input, ok := <-found:
+					if more = ok; ok {
+						batch = append(batch, input.path)
+						infos[input.path] = input.info
+					}
+				default:
+					more = false
+				}
+			}
+			if state != nil {
+				var err error
+				if batch, err = changedFiles(state, batch, infos); err != nil {
+					r.fail(fmt.Errorf("unable to read state file %s: %w", r.opts.StatePath, err))
+					continue
+				}
+			}
+			for _, f := range batch {
+				r.streamed = append(r.streamed, f)
+				r.inputInfos[f] = infos[f]
+				files <- f
+			}
+		}
+	}()
+	return files
+}
+
+func (r *run) openCatFile(filesInDir []string) error {
+	if r.opts.CatWriter != nil {
+		r.catFile = nopWriteCloser{r.opts.CatWriter}
+		return nil
+	}
+
+	catFilePath := filepath.Join(r.opts.OutDir, r.opts.CatFileName)
+	catFlags := os.O_APPEND | os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+
+	var rawCatFile *os.File
+	if r.opts.DirectIO {
+		var err error
+		rawCatFile, err = openDirect(catFilePath, catFlags, 0644)
+		if err != nil {
+			return fmt.Errorf("unable to open %s for direct I/O: %w", catFilePath, err)
+		}
+		r.catFile = newDirectWriter(rawCatFile, 4*1024*1024)
+	} else if r.checkpoint.resuming() {
+		catFile, err := r.resumeFile(catFilePath, r.checkpoint.CatOffset)
+		if err != nil {
+			return err
+		}
+		r.catFile = catFile
+		r.catOffset = r.checkpoint.CatOffset
+		rawCatFile, _ = catFile.(*os.File)
+	} else {
+		catFile, err := r.fs.OpenFile(catFilePath, catFlags, 0644)
+		if err != nil {
+			return err
+		}
+		r.catFile = catFile
+		rawCatFile, _ = catFile.(*os.File)
+	}
+
+	if r.opts.PreallocateCat && rawCatFile != nil {
+		if err := preallocateFile(rawCatFile, estimateCatSize(r.in, filesInDir, r.fileKind)); err != nil {
+			r.catFile.Close()
+			return fmt.Errorf("unable to preallocate %s: %w", catFilePath, err)
+		}
+	}
+	return r.audit(AuditCreate, catFilePath, "", nil)
+}
+
+// nopWriteCloser leaves Options.CatWriter open once the run is done
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ReadFrom gives the appends to the ReadFrom of the writer, like the one of
+// a socket sending the files
+func (w nopWriteCloser) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(w.Writer, r)
+}
+
+func (o *Options) logger() *log.Logger {
+	if o.Logger == nil {
+		return log.Default()
+	}
+	return o.Logger
+}
+
+// parseExtList normalizes a list of extensions into a set
+func parseExtList(list []string) map[string]bool {
+	exts := map[string]bool{}
+	for _, e := range list {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		exts[e] = true
+	}
+	return exts
+}
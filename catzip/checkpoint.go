@@ -0,0 +1,300 @@
+package catzip
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// checkpoint records the entries a run appended so far. It is saved while the
+// run goes, so a run that crashed or was stopped resumes after the entries of
+// the last save instead of extracting and appending everything again, and it
+// is removed once the run completes. The resumed run truncates the cat file
+// and the routes back to the offsets of the checkpoint, so whatever was
+// appended after it is appended again exactly once.
+//
+// Each save appends a line with the entries since the previous one, so saves
+// don't get longer as the run goes. Loading merges the lines, dropping the
+// last one when a crash cut it, and writes them back as a single line.
+type checkpoint struct {
+	Cat       string
+	CatOffset int64
+	Routes    map[string]int64
+	State     runState
+
+	// Entries of the run being resumed, by checkpointKey and index
+	resumed map[string]map[int]EntryInfo
+	// Outputs of the resumed entries, the new entries aren't given them
+	// whatever order the workers reach the entries in this time
+	outputs map[string]bool
+	pending checkpointRecord // Inputs and entries of the next save
+	file    *os.File         // Appended to, opened by the first save
+	saved   time.Time
+	dirty   bool
+}
+
+// checkpointRecord is a line of the checkpoint file, what a save adds to the
+// lines before it
+type checkpointRecord struct {
+	Cat       string `json:"cat"`
+	CatOffset int64  `json:"cat_offset"` // Size of the cat file after the entries
+	// Size of the files of the routes after the entries, by name
+	Routes map[string]int64 `json:"routes,omitempty"`
+	// Input files the entries are from, as in the state file, the run can't
+	// be resumed once one of them changed
+	Inputs map[string]stateInput `json:"inputs,omitempty"`
+	// Appended entries
+	Entries []checkpointEntry `json:"entries,omitempty"`
+}
+
+type checkpointEntry struct {
+	Index int `json:"index"` // In the input file
+	EntryInfo
+}
+
+// loadCheckpoint reads the checkpoint of the cat file at cat, a missing file
+// is a new run. infos are the input files of the run.
+func loadCheckpoint(path string, cat string, infos map[string]fs.FileInfo) (*checkpoint, error) {
+	c := &checkpoint{
+		Cat:     cat,
+		Routes:  map[string]int64{},
+		State:   runState{Inputs: map[string]stateInput{}},
+		pending: checkpointRecord{Inputs: map[string]stateInput{}},
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []checkpointEntry
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var record checkpointRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			if i == len(lines)-1 {
+				// Cut by a crash while it was appended
+				break
+			}
+			return nil, err
+		}
+		if record.Cat != cat {
+			return nil, fmt.Errorf("it was saved by a run writing %s, not %s", record.Cat, cat)
+		}
+		c.CatOffset = record.CatOffset
+		for name, offset := range record.Routes {
+			c.Routes[name] = offset
+		}
+		for input, info := range record.Inputs {
+			c.State.Inputs[input] = info
+		}
+		entries = append(entries, record.Entries...)
+	}
+	for input := range c.State.Inputs {
+		if info, ok := infos[input]; ok && !c.State.unchanged(input, info) {
+			return nil, fmt.Errorf("%s changed since, remove it to start over", input)
+		}
+	}
+
+	compacted, err := json.Marshal(checkpointRecord{Cat: cat, CatOffset: c.CatOffset, Routes: c.Routes, Inputs: c.State.Inputs, Entries: entries})
+	if err != nil {
+		return nil, err
+	}
+	if err = writeFileAtomic(path, append(compacted, '\n')); err != nil {
+		return nil, err
+	}
+
+	c.resumed = map[string]map[int]EntryInfo{}
+	c.outputs = map[string]bool{}
+	for _, e := range entries {
+		key := checkpointKey(&e.EntryInfo)
+		if c.resumed[key] == nil {
+			c.resumed[key] = map[int]EntryInfo{}
+		}
+		c.resumed[key][e.Index] = e.EntryInfo
+		if e.Output != "" {
+			c.outputs[e.Output] = true
+		}
+	}
+	return c, nil
+}
+
+// checkpointKey is the input file of entry, with the nested archive it is in
+// as their indexes are in it
+func checkpointKey(entry *EntryInfo) string {
+	if entry.Nested == "" {
+		return entry.Archive
+	}
+	return entry.Archive + "\x00" + entry.Nested
+}
+
+func (c *checkpoint) resuming() bool {
+	return c != nil && c.resumed != nil
+}
+
+// resumed restores entry from the checkpoint when the run being resumed
+// appended it, the entry is then neither written nor reported again. Its output
+// path has to be reserved already so the next entries are numbered the same.
+func (r *run) resumed(entry *EntryInfo) bool {
+	if !r.checkpoint.resuming() {
+		return false
+	}
+	previous, ok := r.checkpoint.resumed[checkpointKey(entry)][entry.index]
+	if !ok {
+		return false
+	}
+	*entry = previous
+	r.reportProgress(entry)
+	return true
+}
+
+// resumeFile opens the file at path the run being resumed wrote saved bytes
+// to, to append to it. The bytes after saved are from entries appended after
+// the last save of the checkpoint, they are dropped as the entries are
+// appended again.
+func (r *run) resumeFile(path string, saved int64) (WriteFile, error) {
+	size, err := r.fileSize(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case size < saved:
+		return nil, fmt.Errorf("%s has %d bytes but its checkpoint has %d, it was changed since", path, size, saved)
+	case size > saved:
+		r.logger.Print(LogFields("event", "checkpoint_dropped", "path", path, "bytes", size-saved))
+		if err = r.fs.Truncate(path, saved); err != nil {
+			return nil, err
+		}
+	}
+	return r.fs.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+}
+
+func (r *run) fileSize(path string) (int64, error) {
+	f, err := r.fs.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// recordCheckpoint records entry, just appended to the cat file or a route,
+// and saves the checkpoint when it is time to. It is called with catFileMu
+// held so the offsets match the entries.
+func (r *run) recordCheckpoint(entry *EntryInfo) error {
+	c := r.checkpoint
+	if _, ok := c.State.Inputs[entry.Archive]; !ok {
+		c.State.record(entry.Archive, r.inputInfos[entry.Archive])
+		c.pending.Inputs[entry.Archive] = c.State.Inputs[entry.Archive]
+	}
+	c.pending.Entries = append(c.pending.Entries, checkpointEntry{Index: entry.index, EntryInfo: *entry})
+	c.CatOffset = r.catOffset
+	for name, route := range r.routes {
+		c.Routes[name] = route.offset
+	}
+	c.dirty = true
+	if time.Since(c.saved) < r.opts.CheckpointInterval {
+		return nil
+	}
+	return r.saveCheckpoint()
+}
+
+// saveCheckpoint writes the checkpoint if it changed, with catFileMu held
+func (r *run) saveCheckpoint() error {
+	c := r.checkpoint
+	if !c.dirty {
+		return nil
+	}
+	// The checkpoint can't be ahead of what is on the disk
+	files := []io.Writer{r.catFile}
+	for _, route := range r.routes {
+		files = append(files, route.file)
+	}
+	for _, f := range files {
+		if syncer, ok := f.(interface{ Sync() error }); ok {
+			if err := syncer.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+
+	record := c.pending
+	record.Cat, record.CatOffset, record.Routes = c.Cat, c.CatOffset, c.Routes
+	if err := c.append(r.opts.CheckpointPath, record); err != nil {
+		return fmt.Errorf("unable to write checkpoint %s: %w", r.opts.CheckpointPath, err)
+	}
+	c.pending = checkpointRecord{Inputs: map[string]stateInput{}}
+	if c.saved.IsZero() && !c.resuming() {
+		if err := r.audit(AuditCreate, r.opts.CheckpointPath, "", nil); err != nil {
+			return err
+		}
+	}
+	c.saved = time.Now()
+	c.dirty = false
+	return nil
+}
+
+// append writes record as a line at the end of the file at path
+func (c *checkpoint) append(path string, record checkpointRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if c.file == nil {
+		if c.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+	}
+	if _, err = c.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return c.file.Sync()
+}
+
+// close closes the file of the checkpoint, once the run stops saving it
+func (c *checkpoint) close() error {
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// removeCheckpoint removes the checkpoint of a completed run
+func (r *run) removeCheckpoint() error {
+	if err := r.checkpoint.close(); err != nil {
+		return err
+	}
+	err := os.Remove(r.opts.CheckpointPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.audit(AuditDelete, r.opts.CheckpointPath, "", nil)
+}
+
+// routeOffset returns the size the run being resumed wrote to the route name
+func (c *checkpoint) routeOffset(name string) (int64, bool) {
+	if !c.resuming() {
+		return 0, false
+	}
+	saved, ok := c.Routes[name]
+	return saved, ok
+}
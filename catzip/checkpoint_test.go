@@ -0,0 +1,132 @@
+package catzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// failingFS fails the files opened once broken
+type failingFS struct {
+	WriteFS
+	broken atomic.Bool
+}
+
+var errBroken = errors.New("broken")
+
+func (f *failingFS) OpenFile(name string, flag int, perm fs.FileMode) (WriteFile, error) {
+	if f.broken.Load() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errBroken}
+	}
+	return f.WriteFS.OpenFile(name, flag, perm)
+}
+
+// writeSameNames writes the zip file name with entries all named same.log,
+// their content telling them apart
+func writeSameNames(t *testing.T, name string, entries int) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i := 0; i < entries; i++ {
+		f, err := w.Create("same.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(f, "%s-%d\n", filepath.Base(name), i)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The entries of a resumed run keep their numbered names, the new ones,
+// whatever order the workers reach them in, don't take them
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	if err := os.MkdirAll(in, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.zip", "b.zip", "c.zip"} {
+		writeSameNames(t, filepath.Join(in, name), 10)
+	}
+
+	opts := DefaultOptions()
+	opts.Dir = in
+	opts.Ext = ".zip"
+	opts.OutDir = filepath.Join(dir, "out")
+	opts.CheckpointPath = filepath.Join(dir, "checkpoint")
+	opts.Workers = 4
+	opts.Logger = log.New(io.Discard, "", 0)
+	if err := os.MkdirAll(opts.OutDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	failing := &failingFS{WriteFS: OS}
+	var entries atomic.Int64
+	first := opts
+	first.FS = failing
+	first.OnEntry = func(EntryInfo) {
+		if entries.Add(1) == 12 {
+			failing.broken.Store(true)
+		}
+	}
+	if _, err := Run(first); !errors.Is(err, errBroken) {
+		t.Fatalf("first run: got %v, expected it to fail", err)
+	}
+	if _, err := os.Stat(opts.CheckpointPath); err != nil {
+		t.Fatalf("first run left no checkpoint: %v", err)
+	}
+
+	// Walked first, its entries would take the names of the resumed ones
+	writeSameNames(t, filepath.Join(in, "0.zip"), 5)
+	if _, err := Run(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	cat, err := os.ReadFile(filepath.Join(opts.OutDir, opts.CatFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := filepath.Glob(filepath.Join(opts.OutDir, "same*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]bool{}
+	for _, output := range outputs {
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[string(data)] = true
+	}
+	for _, name := range []string{"0.zip", "a.zip", "b.zip", "c.zip"} {
+		count := 10
+		if name == "0.zip" {
+			count = 5
+		}
+		for i := 0; i < count; i++ {
+			content := fmt.Sprintf("%s-%d\n", name, i)
+			if !contents[content] {
+				t.Errorf("no output has %q, it was overwritten", content)
+			}
+			if n := bytes.Count(cat, []byte(content)); n != 1 {
+				t.Errorf("cat file has %q %d times", content, n)
+			}
+		}
+	}
+	if len(outputs) != 35 {
+		t.Errorf("%d outputs, expected 35", len(outputs))
+	}
+}
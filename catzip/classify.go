@@ -0,0 +1,134 @@
+package catzip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Formats recorded in EntryInfo.Format with Options.Classify
+const (
+	FormatJSON   = "json" // A JSON document or JSON lines
+	FormatLogfmt = "logfmt"
+	FormatSyslog = "syslog"
+	FormatCSV    = "csv"
+	FormatText   = "text"
+	FormatBinary = "binary"
+)
+
+// Formats lists the values of EntryInfo.Format
+func Formats() []string {
+	return []string{FormatJSON, FormatLogfmt, FormatSyslog, FormatCSV, FormatText, FormatBinary}
+}
+
+// Bytes from the start of an entry looked at to classify it
+const classifySampleSize = 8 * 1024
+
+var (
+	// RFC 5424 or RFC 3164
+	syslogLine = regexp.MustCompile(`^(<\d{1,3}>\d? |<\d{1,3}>)?(\d{4}-\d\d-\d\dT|[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d )`)
+	logfmtPair = regexp.MustCompile(`(^|\s)[\w.\-/]+=("(\\.|[^"])*"|[^\s"]*)`)
+)
+
+// classify guesses the format of the content starting with sample, "" when
+// it is empty. complete tells if sample is the whole content.
+func classify(sample []byte, complete bool) string {
+	if len(sample) == 0 {
+		return ""
+	}
+	if isBinary(sample) {
+		return FormatBinary
+	}
+
+	trimmed := bytes.TrimSpace(sample)
+	if complete && json.Valid(trimmed) {
+		return FormatJSON
+	}
+
+	lines := bytes.Split(sample, []byte("\n"))
+	if !complete && len(lines) > 1 {
+		lines = lines[:len(lines)-1] // Cut by the end of the sample
+	}
+	var nonEmpty [][]byte
+	for _, line := range lines {
+		if line = bytes.TrimRight(line, "\r"); len(bytes.TrimSpace(line)) > 0 {
+			nonEmpty = append(nonEmpty, line)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return FormatText
+	}
+
+	// Most lines have to agree, a stray banner or a truncated line is fine
+	most := func(match func([]byte) bool) bool {
+		n := 0
+		for _, line := range nonEmpty {
+			if match(line) {
+				n++
+			}
+		}
+		return n*10 >= len(nonEmpty)*8
+	}
+	switch {
+	case most(func(line []byte) bool { return json.Valid(line) && bytes.ContainsAny(line, "{[") }):
+		return FormatJSON
+	case most(syslogLine.Match):
+		return FormatSyslog
+	case most(func(line []byte) bool { return len(logfmtPair.FindAll(line, 2)) == 2 }):
+		return FormatLogfmt
+	case isCSV(nonEmpty):
+		return FormatCSV
+	}
+	return FormatText
+}
+
+func isBinary(sample []byte) bool {
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+	invalid := 0
+	for i := 0; i < len(sample); {
+		r, size := utf8.DecodeRune(sample[i:])
+		// A rune cut by the end of the sample is fine
+		if r == utf8.RuneError && size == 1 && len(sample)-i >= utf8.UTFMax {
+			invalid++
+		}
+		i += size
+	}
+	return invalid*10 > len(sample)
+}
+
+// isCSV tells whether the lines parse as records with the same number of
+// fields, at least two
+func isCSV(lines [][]byte) bool {
+	if len(lines) < 2 {
+		return false
+	}
+	reader := csv.NewReader(bytes.NewReader(bytes.Join(lines, []byte("\n"))))
+	reader.FieldsPerRecord = 0 // All like the first one
+	records, err := reader.ReadAll()
+	return err == nil && len(records[0]) > 1
+}
+
+// classifier keeps the start of an entry as it is written
+type classifier struct {
+	sample []byte
+	total  int64
+}
+
+func (c *classifier) Write(p []byte) (int, error) {
+	if room := classifySampleSize - len(c.sample); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		c.sample = append(c.sample, p[:room]...)
+	}
+	c.total += int64(len(p))
+	return len(p), nil
+}
+
+func (c *classifier) format() string {
+	return classify(c.sample, c.total == int64(len(c.sample)))
+}
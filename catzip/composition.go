@@ -0,0 +1,80 @@
+package catzip
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// SizeBucket counts the entries smaller than Below and at least as large as
+// the ones of the previous bucket
+type SizeBucket struct {
+	Below   uint64 // 0 for the last bucket, with the larger entries
+	Entries int
+	Bytes   uint64
+}
+
+// TypeCount counts the entries of a Type, the Format of the entries
+// Options.Classify recognized or else the extension of their name, "" for
+// the ones without
+type TypeCount struct {
+	Type    string
+	Entries int
+	Bytes   uint64
+}
+
+// Bounds of the SizeBuckets but the last, empty entries first
+var sizeBounds = []uint64{1, 1 << 10, 16 << 10, 256 << 10, 4 << 20, 64 << 20, 1 << 30}
+
+// composition adds up the entries done into Summary.Sizes and
+// Summary.Types
+type composition struct {
+	sizes []SizeBucket
+	types map[string]*TypeCount
+}
+
+func (c *composition) add(entry *EntryInfo) {
+	if c.sizes == nil {
+		for _, below := range sizeBounds {
+			c.sizes = append(c.sizes, SizeBucket{Below: below})
+		}
+		c.sizes = append(c.sizes, SizeBucket{})
+		c.types = map[string]*TypeCount{}
+	}
+	bucket := &c.sizes[len(c.sizes)-1]
+	for i := range sizeBounds {
+		if entry.Size < sizeBounds[i] {
+			bucket = &c.sizes[i]
+			break
+		}
+	}
+	bucket.Entries++
+	bucket.Bytes += entry.Size
+
+	kind := entry.Format
+	if kind == "" {
+		kind = strings.ToLower(path.Ext(path.Base(entry.Name)))
+	}
+	count, ok := c.types[kind]
+	if !ok {
+		count = &TypeCount{Type: kind}
+		c.types[kind] = count
+	}
+	count.Entries++
+	count.Bytes += entry.Size
+}
+
+// counts returns the size buckets and the types, the most common first
+func (c *composition) counts() ([]SizeBucket, []TypeCount) {
+	types := make([]TypeCount, 0, len(c.types))
+	for _, count := range c.types {
+		types = append(types, *count)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].Entries != types[j].Entries {
+			return types[i].Entries > types[j].Entries
+		}
+		return types[i].Type < types[j].Type
+	})
+	return c.sizes, types
+}
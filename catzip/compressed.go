@@ -0,0 +1,234 @@
+package catzip
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// handleCompressed extracts an input compressed as a single file other than
+// gz next to it, like handleGz. Their headers have no name, time or comment.
+func (r *run) handleCompressed(filename string, kind inputKind) error {
+	file, err := r.in.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	base := singleOutput(filename, strings.TrimSuffix(filename, filepath.Ext(filename)))
+	entry := &EntryInfo{
+		Archive:        filename,
+		Name:           filepath.Base(base),
+		CompressedSize: uint64(info.Size()),
+		Mode:           0666,
+		ModTime:        info.ModTime(),
+		Entry:          1,
+		Entries:        1,
+	}
+	newFilename, ok, err := r.plainOutput(entry, base)
+	if err != nil || !ok {
+		r.manifest.add(manifestArchive{Path: filename, Entries: []*EntryInfo{entry}})
+		return err
+	}
+	entry.Output = newFilename
+	if r.resumed(entry) {
+		r.manifest.add(manifestArchive{Path: filename, Entries: []*EntryInfo{entry}})
+		return nil
+	}
+
+	reader := decompressor(kind, file)
+	defer reader.Close()
+
+	writer := r.newWriteTask(entry, compressedSizeHint(r.in, filename, kind))
+	defer writer.Close()
+
+	if err = r.ioCopy(writer, reader); err != nil {
+		return err
+	}
+
+	r.manifest.add(manifestArchive{Path: filename, Entries: []*EntryInfo{entry}})
+	return nil
+}
+
+// decompressor reads the content of a single compressed file of kind
+func decompressor(kind inputKind, r io.Reader) io.ReadCloser {
+	switch kind {
+	case kindZst:
+		return newCommandReader(r, "zstd", "-d", "-c")
+	case kindBz2:
+		return io.NopCloser(bzip2.NewReader(r))
+	case kindXz:
+		return io.NopCloser(newXzReader(r))
+	case kindLz4:
+		return newLZ4Reader(r)
+	case kindBr:
+		return newCommandReader(r, "brotli", "-d", "-c")
+	case kindSz:
+		return newSnappyReader(r)
+	}
+	return errReadCloser{fmt.Errorf("no decompressor for input kind %d", kind)}
+}
+
+// sniffDecompressor decompresses r with the gzip, xz, zstd or bzip2
+// decompressor its magic bytes tell, r is read as it is without them
+func sniffDecompressor(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, []byte("\x1f\x8b")):
+		gzReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return gzReader, nil
+	case bytes.HasPrefix(magic, []byte("\xfd7zXZ\x00")):
+		return decompressor(kindXz, buffered), nil
+	case bytes.HasPrefix(magic, []byte("\x28\xb5\x2f\xfd")):
+		return decompressor(kindZst, buffered), nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return decompressor(kindBz2, buffered), nil
+	}
+	return io.NopCloser(buffered), nil
+}
+
+// compressedSizeHint returns the uncompressed size when the header of the
+// file has it, -1 otherwise
+func compressedSizeHint(fsys fs.FS, name string, kind inputKind) int64 {
+	switch kind {
+	case kindGz, kindTarGz, kindCpioGz:
+		return gzipSizeHint(fsys, name)
+	case kindZst, kindTarZst:
+		return zstdSizeHint(fsys, name)
+	case kindXz, kindTarXz:
+		return xzSizeHint(fsys, name)
+	case kindLz4, kindTarLz4:
+		return lz4SizeHint(fsys, name)
+	}
+	return -1
+}
+
+// zstdSizeHint reads the content size of the first zstd frame, it is only the
+// size of the whole file when there is a single frame
+func zstdSizeHint(fsys fs.FS, name string) int64 {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return -1
+	}
+	defer file.Close()
+
+	// Magic number, frame header descriptor, window descriptor, dictionary
+	// ID and content size, at most 4+1+1+4+8 bytes
+	header := make([]byte, 18)
+	n, _ := io.ReadFull(file, header)
+	header = header[:n]
+	if len(header) < 5 || binary.LittleEndian.Uint32(header) != 0xFD2FB528 {
+		return -1
+	}
+
+	descriptor := header[4]
+	singleSegment := descriptor&0x20 != 0
+	offset := 5
+	if !singleSegment {
+		offset++ // Window descriptor
+	}
+	offset += []int{0, 1, 2, 4}[descriptor&0x3]
+
+	var size int
+	switch descriptor >> 6 {
+	case 0:
+		if !singleSegment {
+			return -1
+		}
+		size = 1
+	case 1:
+		size = 2
+	case 2:
+		size = 4
+	case 3:
+		size = 8
+	}
+	if len(header) < offset+size {
+		return -1
+	}
+
+	field := header[offset : offset+size]
+	switch size {
+	case 1:
+		return int64(field[0])
+	case 2:
+		return int64(binary.LittleEndian.Uint16(field)) + 256
+	case 4:
+		return int64(binary.LittleEndian.Uint32(field))
+	default:
+		return int64(binary.LittleEndian.Uint64(field))
+	}
+}
+
+// xzSizeHint adds up the uncompressed sizes of the blocks in the index of the
+// last xz stream, it is only the size of the whole file when there is a
+// single stream
+func xzSizeHint(fsys fs.FS, name string) int64 {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return -1
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.Size() < 24 {
+		return -1
+	}
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		return -1
+	}
+
+	// CRC32, backward size, stream flags and magic bytes
+	footer := make([]byte, 12)
+	if _, err = readerAt.ReadAt(footer, info.Size()-12); err != nil || string(footer[10:]) != "YZ" {
+		return -1
+	}
+	indexSize := (int64(binary.LittleEndian.Uint32(footer[4:])) + 1) * 4
+	if indexSize > info.Size()-24 || indexSize > 1<<20 {
+		return -1
+	}
+	index := make([]byte, indexSize)
+	if _, err = readerAt.ReadAt(index, info.Size()-12-indexSize); err != nil || index[0] != 0 {
+		return -1
+	}
+
+	// Index indicator, number of records, then unpadded and uncompressed
+	// size pairs, all multibyte integers
+	index = index[1:]
+	records, n := binary.Uvarint(index)
+	if n <= 0 {
+		return -1
+	}
+	index = index[n:]
+	var total int64
+	for i := uint64(0); i < records; i++ {
+		if _, n = binary.Uvarint(index); n <= 0 {
+			return -1
+		}
+		index = index[n:]
+		size, n := binary.Uvarint(index)
+		if n <= 0 {
+			return -1
+		}
+		index = index[n:]
+		total += int64(size)
+	}
+	return total
+}
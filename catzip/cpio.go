@@ -0,0 +1,195 @@
+package catzip
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// handleCpio extracts the members of a cpio archive of kind like the members
+// of a tar file, one at a time
+func (r *run) handleCpio(f string, kind inputKind) error {
+	reader, closer, err := openCpio(r.in, f, kind)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	destination, err := filepath.Abs(r.opts.OutDir)
+	if err != nil {
+		return fmt.Errorf("unable to find absolute path for dir %s: %w", r.opts.OutDir, err)
+	}
+
+	archive := manifestArchive{Path: f}
+	for i := 0; ; i++ {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read cpio file: %w", err)
+		}
+
+		entry := &EntryInfo{
+			Archive: f,
+			Name:    header.name,
+			Size:    uint64(header.size),
+			Mode:    header.fileMode(),
+			ModTime: header.modTime,
+			Entry:   i + 1,
+			index:   i,
+		}
+		if reason := unsupportedCpioReason(header); reason != "" {
+			r.skipEntry(entry, reason)
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		name := r.renamed(header.name)
+		if name == "" {
+			r.skipEntry(entry, "renamed to an empty name")
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		isDir := header.dir()
+		if isDir && filepath.Clean(name) == "." {
+			// The . member of archives made with find . | cpio
+			continue
+		}
+		if !isDir {
+			var ok bool
+			if name, ok, err = r.applyPolicy(entry, name); err != nil {
+				return err
+			}
+			if !ok {
+				archive.Entries = append(archive.Entries, entry)
+				continue
+			}
+		}
+
+		open := func() (io.ReadCloser, error) { return io.NopCloser(reader), nil }
+		if err := r.extractEntry(name, entry, destination, isDir, header.size, open); err != nil {
+			return fmt.Errorf("unable to extract file inside archive: %w", err)
+		}
+		if entry.Output != "" {
+			archive.Entries = append(archive.Entries, entry)
+		}
+	}
+	r.manifest.add(archive)
+	return nil
+}
+
+// unsupportedCpioReason tells why the member of header can't be extracted,
+// or "" when it can
+func unsupportedCpioReason(header *cpioHeader) string {
+	switch header.mode & cpioTypeMask {
+	case cpioTypeRegular:
+		if header.newc && header.nlink > 1 && header.size == 0 {
+			// newc stores the content of hard links with the last one
+			return "hard link, its content is with another member"
+		}
+		return ""
+	case cpioTypeDir:
+		return ""
+	case cpioTypeSymlink:
+		return "link to " + header.link
+	case cpioTypeChar, cpioTypeBlock:
+		return "device"
+	case cpioTypeFifo:
+		return "named pipe"
+	case cpioTypeSocket:
+		return "socket"
+	default:
+		return fmt.Sprintf("unsupported cpio member type %#o", header.mode&cpioTypeMask)
+	}
+}
+
+// openCpio opens the cpio archive name, decompressing it for kind
+func openCpio(fsys fs.FS, name string, kind inputKind) (*cpioReader, io.Closer, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch kind {
+	case kindCpioGz:
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return newCpioReader(gzReader), closers{gzReader, file}, nil
+	case kindRpm:
+		payload, err := openRpmPayload(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return newCpioReader(payload), closers{payload, file}, nil
+	}
+	return newCpioReader(file), file, nil
+}
+
+func listCpio(fsys fs.FS, name string, kind inputKind) ([]listedEntry, error) {
+	reader, closer, err := openCpio(fsys, name, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	entries := []listedEntry{}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, listedEntry{
+			name:    header.name,
+			size:    header.size,
+			modTime: header.modTime,
+			dir:     header.dir(),
+		})
+	}
+}
+
+func countCpioEntries(fsys fs.FS, name string, kind inputKind) (int, error) {
+	entries, err := listCpio(fsys, name, kind)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, entry := range entries {
+		if !entry.dir {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// openCpioEntry opens the member name of the cpio archive at path, or
+// returns nil when it has none
+func openCpioEntry(fsys fs.FS, path string, kind inputKind, name string) (io.ReadCloser, error) {
+	reader, c, err := openCpio(fsys, path, kind)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			c.Close()
+			return nil, nil
+		}
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if header.name == name && header.regular() && unsupportedCpioReason(header) == "" {
+			return readCloser{reader, c}, nil
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package catzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
+)
+
+// The vectors under testdata are from bsdtar --format newc and --format odc
+// of a.log, dir, the hard links dir/code and dir/code.hard, empty and the
+// symlink link. newc stores the content of the hard links with the last
+// one, odc with each.
+
+type cpioTestMember struct {
+	name    string
+	mode    uint32
+	content []byte
+	link    string
+}
+
+var cpioModTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func cpioMembers(newc bool) []cpioTestMember {
+	code := testCode(3001)
+	firstLink := code
+	if newc {
+		firstLink = nil
+	}
+	return []cpioTestMember{
+		{"a.log", 0100644, testLines(15001), ""},
+		{"dir", 040755, nil, ""},
+		{"dir/code", 0100755, firstLink, ""},
+		{"dir/code.hard", 0100755, code, ""},
+		{"empty", 0100644, nil, ""},
+		{"link", 0120777, nil, "a.log"},
+	}
+}
+
+// readCpio reads the members of a cpio archive with their content
+func readCpio(r io.Reader) ([]cpioTestMember, []*cpioHeader, error) {
+	reader := newCpioReader(r)
+	var members []cpioTestMember
+	var headers []*cpioHeader
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return members, headers, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		content, err := readLimited(reader, 1<<20)
+		if err != nil {
+			return nil, nil, err
+		}
+		members = append(members, cpioTestMember{header.name, header.mode, content, header.link})
+		headers = append(headers, header)
+	}
+}
+
+func checkCpioMembers(t *testing.T, what string, members, expected []cpioTestMember) {
+	t.Helper()
+	if len(members) != len(expected) {
+		t.Fatalf("%s: %d members, expected %d", what, len(members), len(expected))
+	}
+	for i, m := range members {
+		e := expected[i]
+		if m.name != e.name || m.mode != e.mode || m.link != e.link || !bytes.Equal(m.content, e.content) {
+			t.Errorf("%s: member %d is %s %#o of %d bytes to %q, expected %s %#o of %d bytes to %q",
+				what, i, m.name, m.mode, len(m.content), m.link, e.name, e.mode, len(e.content), e.link)
+		}
+	}
+}
+
+// cpioNewc encodes members as a newc archive, with the sums of their
+// content when checksum is set
+func cpioNewc(checksum bool, members ...cpioTestMember) []byte {
+	var b []byte
+	pad := func() {
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+	}
+	members = append(members, cpioTestMember{name: cpioTrailer})
+	for i, m := range members {
+		data := m.content
+		if m.link != "" {
+			data = []byte(m.link)
+		}
+		var sum uint32
+		magic := "070701"
+		if checksum {
+			magic = "070702"
+			for _, c := range data {
+				sum += uint32(c)
+			}
+		}
+		b = append(b, magic...)
+		for _, field := range []int{i + 1, int(m.mode), 0, 0, 1, int(cpioModTime.Unix()), len(data), 0, 0, 0, 0, len(m.name) + 1, int(sum)} {
+			b = append(b, fmt.Sprintf("%08X", field)...)
+		}
+		b = append(append(b, m.name...), 0)
+		pad()
+		b = append(b, data...)
+		pad()
+	}
+	return b
+}
+
+func TestCpio(t *testing.T) {
+	newc, odc := readTestdata(t, "test.newc.cpio"), readTestdata(t, "test.odc.cpio")
+	// Like an initramfs: padded to 512 bytes, then another archive
+	initramfs := append(append([]byte{}, newc...), make([]byte, -len(newc)&511)...)
+	initramfs = append(initramfs, odc...)
+
+	for _, v := range []struct {
+		name     string
+		data     []byte
+		expected []cpioTestMember
+	}{
+		{"test.newc.cpio", newc, cpioMembers(true)},
+		{"test.odc.cpio", odc, cpioMembers(false)},
+		{"concatenated", initramfs, append(cpioMembers(true), cpioMembers(false)...)},
+	} {
+		members, headers, err := readCpio(bytes.NewReader(v.data))
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		checkCpioMembers(t, v.name, members, v.expected)
+		for _, header := range headers {
+			if !header.modTime.Equal(cpioModTime) {
+				t.Errorf("%s: %s modified %v", v.name, header.name, header.modTime)
+			}
+		}
+
+		members, _, err = readCpio(iotest.OneByteReader(bytes.NewReader(v.data)))
+		if err != nil {
+			t.Fatalf("%s byte by byte: %v", v.name, err)
+		}
+		checkCpioMembers(t, v.name+" byte by byte", members, v.expected)
+	}
+
+	if _, _, err := readCpio(strings.NewReader("not a cpio archive")); err == nil {
+		t.Error("no error reading text")
+	}
+}
+
+func TestCpioNewc(t *testing.T) {
+	members := []cpioTestMember{
+		{"a", 0100600, []byte("odd"), ""},
+		{"long name/with a directory", 0100644, testLines(5000), ""},
+		{"b", 0120777, nil, "long name"},
+		{"c", 0100644, nil, ""},
+	}
+	for _, checksum := range []bool{false, true} {
+		got, _, err := readCpio(bytes.NewReader(cpioNewc(checksum, members...)))
+		if err != nil {
+			t.Fatalf("checksum %v: %v", checksum, err)
+		}
+		checkCpioMembers(t, fmt.Sprintf("checksum %v", checksum), got, members)
+	}
+
+	// The content of the archives with checksums is checked
+	data := cpioNewc(true, members...)
+	data[bytes.Index(data, []byte("odd"))] ^= 1
+	if _, _, err := readCpio(bytes.NewReader(data)); err == nil {
+		t.Error("no checksum error")
+	}
+}
+
+func TestCpioCorrupt(t *testing.T) {
+	for _, name := range []string{"test.newc.cpio", "test.odc.cpio"} {
+		checkCorrupt(t, readTestdata(t, name), func(data []byte) error {
+			_, _, err := readCpio(bytes.NewReader(data))
+			return err
+		})
+	}
+}
+
+func TestCpioRun(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(readTestdata(t, "test.newc.cpio"))
+	w.Close()
+
+	for _, v := range []struct {
+		ext, name string
+		data      []byte
+	}{
+		{".cpio.gz", "test.cpio.gz", gz.Bytes()},
+		{".cpio", "test.cpio", readTestdata(t, "test.odc.cpio")},
+	} {
+		out := runInMemory(t, v.ext, fstest.MapFS{v.name: {Data: v.data}})
+		for _, m := range cpioMembers(v.ext == ".cpio.gz") {
+			data, err := out.ReadFile("/out/" + m.name)
+			switch {
+			case m.mode&cpioTypeMask != cpioTypeRegular:
+			case m.content == nil && m.name != "empty":
+				// The newc hard link without its content is skipped
+				if err == nil {
+					t.Errorf("%s: extracted %s", v.name, m.name)
+				}
+			case err != nil || !bytes.Equal(data, m.content):
+				t.Errorf("%s: extracted %s: %d bytes, %v", v.name, m.name, len(data), err)
+			}
+		}
+		if _, err := out.ReadFile("/out/link"); err == nil {
+			t.Errorf("%s: extracted the symlink", v.name)
+		}
+	}
+}
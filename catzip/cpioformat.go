@@ -0,0 +1,256 @@
+package catzip
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"time"
+)
+
+// A reader for the portable ASCII cpio formats, newc (with or without
+// checksums) and odc. Archives concatenated after a trailer, like the
+// initramfs ones, are read through.
+
+const (
+	cpioTypeMask    = 0170000
+	cpioTypeSocket  = 0140000
+	cpioTypeSymlink = 0120000
+	cpioTypeRegular = 0100000
+	cpioTypeBlock   = 0060000
+	cpioTypeDir     = 0040000
+	cpioTypeChar    = 0020000
+	cpioTypeFifo    = 0010000
+
+	cpioTrailer = "TRAILER!!!"
+	// Names and link targets longer than this are taken as corruption
+	cpioMaxName = 64 << 10
+)
+
+var errCpioCorrupt = errors.New("cpio: invalid header")
+
+type cpioHeader struct {
+	name        string
+	size        int64
+	mode        uint32 // With the file type bits
+	nlink       uint32
+	modTime     time.Time
+	link        string // Target of symlinks
+	newc        bool
+	checksum    uint32
+	hasChecksum bool
+}
+
+func (h *cpioHeader) dir() bool {
+	return h.mode&cpioTypeMask == cpioTypeDir
+}
+
+func (h *cpioHeader) regular() bool {
+	return h.mode&cpioTypeMask == cpioTypeRegular
+}
+
+func (h *cpioHeader) fileMode() fs.FileMode {
+	mode := fs.FileMode(h.mode & 0777)
+	if h.mode&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if h.mode&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if h.mode&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	switch h.mode & cpioTypeMask {
+	case cpioTypeDir:
+		mode |= fs.ModeDir
+	case cpioTypeSymlink:
+		mode |= fs.ModeSymlink
+	case cpioTypeChar:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case cpioTypeBlock:
+		mode |= fs.ModeDevice
+	case cpioTypeFifo:
+		mode |= fs.ModeNamedPipe
+	case cpioTypeSocket:
+		mode |= fs.ModeSocket
+	}
+	return mode
+}
+
+// cpioReader reads the members of a cpio archive in order, Read reads the
+// content of the current one
+type cpioReader struct {
+	r            *bufio.Reader
+	header       *cpioHeader
+	remaining    int64 // Content of the current member left to read
+	pad          int64 // After its content
+	sum          uint32
+	started      bool
+	afterTrailer bool
+}
+
+func newCpioReader(r io.Reader) *cpioReader {
+	return &cpioReader{r: bufio.NewReader(r)}
+}
+
+// Next skips to the next member, it returns io.EOF after the last trailer
+func (c *cpioReader) Next() (*cpioHeader, error) {
+	for {
+		if _, err := io.CopyN(io.Discard, c.r, c.remaining+c.pad); err != nil {
+			return nil, fmt.Errorf("cpio: %w", unexpectedEOF(err))
+		}
+		c.remaining, c.pad, c.sum = 0, 0, 0
+
+		if c.afterTrailer {
+			// NULs pad the archives, another one can follow
+			for {
+				b, err := c.r.ReadByte()
+				if err == io.EOF {
+					return nil, io.EOF
+				}
+				if err != nil {
+					return nil, err
+				}
+				if b != 0 {
+					c.r.UnreadByte()
+					break
+				}
+			}
+			c.afterTrailer = false
+		}
+
+		header, err := c.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		c.started = true
+		c.header = header
+		c.remaining = header.size
+		if header.newc {
+			c.pad = -header.size & 3
+		}
+		if header.name == cpioTrailer {
+			c.afterTrailer = true
+			continue
+		}
+		if header.mode&cpioTypeMask == cpioTypeSymlink {
+			if header.size > cpioMaxName {
+				return nil, errCpioCorrupt
+			}
+			target := make([]byte, header.size)
+			if _, err := io.ReadFull(c.r, target); err != nil {
+				return nil, fmt.Errorf("cpio: %w", unexpectedEOF(err))
+			}
+			header.link = string(target)
+			c.remaining = 0
+		}
+		return header, nil
+	}
+}
+
+func (c *cpioReader) readHeader() (*cpioHeader, error) {
+	var magic [6]byte
+	if _, err := io.ReadFull(c.r, magic[:]); err != nil {
+		return nil, fmt.Errorf("cpio: %w", unexpectedEOF(err))
+	}
+
+	header := &cpioHeader{}
+	var nameSize uint64
+	switch string(magic[:]) {
+	case "070701", "070702":
+		var fields [104]byte
+		if _, err := io.ReadFull(c.r, fields[:]); err != nil {
+			return nil, fmt.Errorf("cpio: %w", unexpectedEOF(err))
+		}
+		// ino, mode, uid, gid, nlink, mtime, filesize, devmajor, devminor,
+		// rdevmajor, rdevminor, namesize and check
+		var values [13]uint64
+		for i := range values {
+			value, err := strconv.ParseUint(string(fields[i*8:i*8+8]), 16, 32)
+			if err != nil {
+				return nil, errCpioCorrupt
+			}
+			values[i] = value
+		}
+		header.newc = true
+		header.mode = uint32(values[1])
+		header.nlink = uint32(values[4])
+		header.modTime = time.Unix(int64(values[5]), 0)
+		header.size = int64(values[6])
+		nameSize = values[11]
+		header.checksum = uint32(values[12])
+		header.hasChecksum = magic[5] == '2'
+	case "070707":
+		var fields [70]byte
+		if _, err := io.ReadFull(c.r, fields[:]); err != nil {
+			return nil, fmt.Errorf("cpio: %w", unexpectedEOF(err))
+		}
+		// dev, ino, mode, uid, gid, nlink, rdev, mtime, namesize and filesize
+		widths := []int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11}
+		values := make([]uint64, len(widths))
+		for i, offset := 0, 0; i < len(widths); i++ {
+			value, err := strconv.ParseUint(string(fields[offset:offset+widths[i]]), 8, 64)
+			if err != nil {
+				return nil, errCpioCorrupt
+			}
+			values[i] = value
+			offset += widths[i]
+		}
+		header.mode = uint32(values[2])
+		header.nlink = uint32(values[5])
+		header.modTime = time.Unix(int64(values[7]), 0)
+		nameSize = values[8]
+		header.size = int64(values[9])
+	default:
+		if !c.started {
+			return nil, errors.New("cpio: not a newc or odc cpio archive")
+		}
+		return nil, errCpioCorrupt
+	}
+
+	if nameSize == 0 || nameSize > cpioMaxName {
+		return nil, errCpioCorrupt
+	}
+	// The name ends with a NUL, newc pads the header and the name to 4 bytes
+	name := make([]byte, nameSize)
+	if _, err := io.ReadFull(c.r, name); err != nil {
+		return nil, fmt.Errorf("cpio: %w", unexpectedEOF(err))
+	}
+	if name[len(name)-1] != 0 {
+		return nil, errCpioCorrupt
+	}
+	header.name = string(name[:len(name)-1])
+	if header.newc {
+		if _, err := io.CopyN(io.Discard, c.r, int64(-(110+nameSize)&3)); err != nil {
+			return nil, fmt.Errorf("cpio: %w", unexpectedEOF(err))
+		}
+	}
+	return header, nil
+}
+
+// Read reads the content of the current member, checking the sum of its
+// bytes in the newc archives with checksums
+func (c *cpioReader) Read(p []byte) (int, error) {
+	if c.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.header.hasChecksum {
+		for _, b := range p[:n] {
+			c.sum += uint32(b)
+		}
+		if c.remaining == 0 && c.sum != c.header.checksum {
+			return n, errors.New("cpio: checksum error")
+		}
+	}
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package catzip
+
+import "time"
+
+// processCPUTime isn't known here, the auto-tuning goes by throughput only
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
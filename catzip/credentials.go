@@ -0,0 +1,324 @@
+package catzip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials authenticate the requests of the remote inputs. S3 and MinIO
+// sign them with the access keys, GCS and Azure send the bearer Token.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Token           string
+	// Zero when they don't expire
+	Expires time.Time
+	// Provider they come from, for the logs
+	Source string
+}
+
+// CredentialProvider finds the credentials of the remote inputs, it is
+// asked again before their requests once the ones it gave expire
+type CredentialProvider interface {
+	Credentials() (Credentials, error)
+}
+
+// errNoCredentials is returned by the providers with nothing configured, so
+// ChainCredentials tries the next one instead of failing
+var errNoCredentials = errors.New("no credentials")
+
+// EnvCredentials reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN, which MinIO clients use too, or a bearer token in
+// CATZIP_TOKEN
+type EnvCredentials struct{}
+
+func (EnvCredentials) Credentials() (Credentials, error) {
+	if token := os.Getenv("CATZIP_TOKEN"); token != "" {
+		return Credentials{Token: token, Source: "env"}, nil
+	}
+	c := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Source:          "env",
+	}
+	switch {
+	case c.AccessKeyID == "" && c.SecretAccessKey == "":
+		return Credentials{}, fmt.Errorf("env: %w", errNoCredentials)
+	case c.AccessKeyID == "" || c.SecretAccessKey == "":
+		return Credentials{}, errors.New("env: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY go together")
+	}
+	return c, nil
+}
+
+// SharedCredentials reads a profile of the AWS shared credentials and config
+// files, with its keys or its credential_process
+type SharedCredentials struct {
+	// "" is AWS_PROFILE, or default
+	Profile string
+	// "" are AWS_SHARED_CREDENTIALS_FILE and AWS_CONFIG_FILE, or
+	// ~/.aws/credentials and ~/.aws/config
+	CredentialsFile string
+	ConfigFile      string
+}
+
+func (s SharedCredentials) Credentials() (Credentials, error) {
+	profile := s.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	home, _ := os.UserHomeDir()
+	credentialsFile := firstNonEmpty(s.CredentialsFile, os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), filepath.Join(home, ".aws", "credentials"))
+	configFile := firstNonEmpty(s.ConfigFile, os.Getenv("AWS_CONFIG_FILE"), filepath.Join(home, ".aws", "config"))
+
+	// The config file names the profiles other than default [profile name]
+	keys, err := readProfile(credentialsFile, profile)
+	if err != nil {
+		return Credentials{}, err
+	}
+	configSection := "profile " + profile
+	if profile == "default" {
+		configSection = profile
+	}
+	config, err := readProfile(configFile, configSection)
+	if err != nil {
+		return Credentials{}, err
+	}
+	for key, value := range config {
+		if _, ok := keys[key]; !ok {
+			keys[key] = value
+		}
+	}
+
+	source := "shared config " + profile
+	if keys["aws_access_key_id"] != "" {
+		if keys["aws_secret_access_key"] == "" {
+			return Credentials{}, fmt.Errorf("%s: aws_access_key_id without aws_secret_access_key", source)
+		}
+		return Credentials{
+			AccessKeyID:     keys["aws_access_key_id"],
+			SecretAccessKey: keys["aws_secret_access_key"],
+			SessionToken:    keys["aws_session_token"],
+			Source:          source,
+		}, nil
+	}
+	if process := keys["credential_process"]; process != "" {
+		c, err := ExecCredentials{Command: strings.Fields(process)}.Credentials()
+		if err != nil {
+			return Credentials{}, fmt.Errorf("%s: %w", source, err)
+		}
+		c.Source = source
+		return c, nil
+	}
+	return Credentials{}, fmt.Errorf("%s: %w", source, errNoCredentials)
+}
+
+// readProfile reads the keys of section in the INI file at path, a missing
+// file has none
+func readProfile(path, section string) (map[string]string, error) {
+	keys := map[string]string{}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	in := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			in = strings.TrimSpace(line[1:len(line)-1]) == section
+		case in:
+			if key, value, ok := strings.Cut(line, "="); ok {
+				keys[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+			}
+		}
+	}
+	return keys, scanner.Err()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ExecCredentials runs an external helper printing the credentials as the
+// JSON of an AWS credential_process, with AccessToken for a bearer token:
+//
+//	{"Version": 1, "AccessKeyId": "...", "SecretAccessKey": "...",
+//	 "SessionToken": "...", "Expiration": "2026-01-02T15:04:05Z"}
+type ExecCredentials struct {
+	Command []string
+}
+
+func (e ExecCredentials) Credentials() (Credentials, error) {
+	if len(e.Command) == 0 {
+		return Credentials{}, errors.New("exec: empty command")
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command(e.Command[0], e.Command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return Credentials{}, fmt.Errorf("exec %s: %w", e.Command[0], err)
+	}
+
+	var out struct {
+		Version         int
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		SessionToken    string
+		AccessToken     string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return Credentials{}, fmt.Errorf("exec %s: %w", e.Command[0], err)
+	}
+	if out.Version != 1 {
+		return Credentials{}, fmt.Errorf("exec %s: version %d, expected 1", e.Command[0], out.Version)
+	}
+	if out.AccessToken == "" && (out.AccessKeyID == "" || out.SecretAccessKey == "") {
+		return Credentials{}, fmt.Errorf("exec %s: no AccessKeyId and SecretAccessKey, or AccessToken", e.Command[0])
+	}
+	return Credentials{
+		AccessKeyID:     out.AccessKeyID,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.SessionToken,
+		Token:           out.AccessToken,
+		Expires:         out.Expiration,
+		Source:          "exec " + e.Command[0],
+	}, nil
+}
+
+// ChainCredentials returns the credentials of the first provider having
+// some. A provider with something configured that fails stops the chain,
+// rather than carrying on with other credentials.
+type ChainCredentials []CredentialProvider
+
+func (c ChainCredentials) Credentials() (Credentials, error) {
+	var tried []string
+	for _, provider := range c {
+		creds, err := provider.Credentials()
+		if err == nil {
+			return creds, nil
+		}
+		if !errors.Is(err, errNoCredentials) {
+			return Credentials{}, err
+		}
+		tried = append(tried, err.Error())
+	}
+	return Credentials{}, fmt.Errorf("no credentials found, tried %s", strings.Join(tried, ", "))
+}
+
+// DefaultCredentials looks in the environment, for a web identity token, in
+// the shared config files, then asks the metadata servers of AWS and Google
+// Cloud. client, nil for http.DefaultClient, reaches STS.
+func DefaultCredentials(client *http.Client) CredentialProvider {
+	return CacheCredentials(ChainCredentials{
+		EnvCredentials{},
+		WebIdentityCredentials{Client: client},
+		SharedCredentials{},
+		MetadataCredentials{},
+		GoogleMetadataCredentials{},
+	})
+}
+
+// credentialsEarly is how long before they expire credentials are refreshed
+const credentialsEarly = 5 * time.Minute
+
+type cachedCredentials struct {
+	provider CredentialProvider
+	mu       sync.Mutex
+	creds    Credentials
+	ok       bool
+}
+
+// CacheCredentials keeps the credentials of provider until a few minutes
+// before they expire, instead of asking it for each request
+func CacheCredentials(provider CredentialProvider) CredentialProvider {
+	return &cachedCredentials{provider: provider}
+}
+
+func (c *cachedCredentials) Credentials() (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ok && (c.creds.Expires.IsZero() || time.Until(c.creds.Expires) > credentialsEarly) {
+		return c.creds, nil
+	}
+	creds, err := c.provider.Credentials()
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.creds, c.ok = creds, true
+	return creds, nil
+}
+
+// ParseCredentials parses a comma separated chain of providers: env,
+// shared or shared:profile, web-identity, metadata, gce-metadata and
+// default. exec:command args is the last one, its command is split on
+// spaces. client, nil for http.DefaultClient, reaches STS.
+//
+//	env,shared:minio
+//	web-identity,metadata
+//	exec:vault-aws-creds --role reader
+func ParseCredentials(spec string, client *http.Client) (CredentialProvider, error) {
+	var chain ChainCredentials
+	for spec != "" {
+		if strings.HasPrefix(spec, "exec:") {
+			command := strings.Fields(strings.TrimPrefix(spec, "exec:"))
+			if len(command) == 0 {
+				return nil, errors.New("invalid credentials exec:, empty command")
+			}
+			chain = append(chain, ExecCredentials{Command: command})
+			break
+		}
+		name, rest, _ := strings.Cut(spec, ",")
+		spec = rest
+		switch {
+		case name == "env":
+			chain = append(chain, EnvCredentials{})
+		case name == "shared":
+			chain = append(chain, SharedCredentials{})
+		case strings.HasPrefix(name, "shared:"):
+			chain = append(chain, SharedCredentials{Profile: strings.TrimPrefix(name, "shared:")})
+		case name == "web-identity":
+			chain = append(chain, WebIdentityCredentials{Client: client})
+		case name == "metadata":
+			chain = append(chain, MetadataCredentials{})
+		case name == "gce-metadata":
+			chain = append(chain, GoogleMetadataCredentials{})
+		case name == "default":
+			chain = append(chain, DefaultCredentials(client))
+		default:
+			return nil, fmt.Errorf("invalid credentials provider %q, expected env, shared, web-identity, metadata, gce-metadata, default or exec:command", name)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("empty credentials providers")
+	}
+	return CacheCredentials(chain), nil
+}
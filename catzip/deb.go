@@ -0,0 +1,71 @@
+package catzip
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Debian packages are ar archives with a debian-binary version, the control
+// files in control.tar and the installed files in data.tar, both compressed
+// or not. Only the installed files are extracted, like dpkg-deb -x does.
+
+var errDebCorrupt = errors.New("deb: invalid ar header")
+
+// openDebData returns the data.tar member of the Debian package in r,
+// decompressed
+func openDebData(r io.Reader) (io.ReadCloser, error) {
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "!<arch>\n" {
+		return nil, errors.New("deb: not an ar archive")
+	}
+
+	// Name, modification time, owner, group, mode, size and end characters
+	header := make([]byte, 60)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil, errors.New("deb: no data.tar member")
+		} else if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if string(header[58:]) != "`\n" {
+			return nil, errDebCorrupt
+		}
+		// GNU ar ends the names with a /
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return nil, errDebCorrupt
+		}
+
+		if strings.HasPrefix(name, "data.tar") {
+			member := io.LimitReader(r, size)
+			if name == "data.tar.lzma" {
+				return newLZMAAloneReader(member)
+			}
+			return sniffDecompressor(member)
+		}
+		// Members are aligned on 2 bytes
+		if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+}
+
+// newLZMAAloneReader reads the .lzma format of LZMA Utils, which has the
+// properties, dictionary size and uncompressed size of the stream first
+func newLZMAAloneReader(r io.Reader) (io.ReadCloser, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	// All ones for an unknown size, the stream has an end marker then
+	size := int64(binary.LittleEndian.Uint64(header[5:]))
+	reader, err := newLZMAReader(r, header[0], binary.LittleEndian.Uint32(header[1:]), size)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(reader), nil
+}
@@ -0,0 +1,117 @@
+package catzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+)
+
+// detectHead is how much of an input file detectKind reads, up to the ISO
+// 9660 volume descriptor
+const detectHead = 0x8006
+
+// fileKind is how the input file name is read, from its content with Detect
+// and from Ext and its compound extension otherwise or when its content
+// matches no format. With an empty Ext those are copied as they are.
+func (o *Options) fileKind(fsys fs.FS, name string) inputKind {
+	if o.Detect {
+		if kind, ok := detectKind(fsys, name, o.Image); ok {
+			return kind
+		}
+		if o.Ext == "" {
+			return kindPlain
+		}
+	}
+	return compoundKind(name, o.extKind(name))
+}
+
+// detectKind tells the kind of the input file name from its magic bytes.
+// Compressed tar and cpio files are told apart from single compressed files
+// by the start of their content, tar files are images with image set.
+func detectKind(fsys fs.FS, name string, image bool) (inputKind, bool) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	head := make([]byte, detectHead)
+	n, _ := io.ReadFull(file, head)
+	head = head[:n]
+
+	has := func(magic string) bool { return bytes.HasPrefix(head, []byte(magic)) }
+	switch {
+	case has("PK\x03\x04") || has("PK\x05\x06") || has("PK\x07\x08"):
+		return kindZip, true
+	case has("\x1f\x8b"):
+		content := gzipContent(head)
+		switch {
+		case isTarHeader(content):
+			return kindTarGz, true
+		case isCpioHeader(content):
+			return kindCpioGz, true
+		}
+		return kindGz, true
+	case has("\xfd7zXZ\x00"):
+		return compressedKind(kindXz, head), true
+	case has("\x28\xb5\x2f\xfd"):
+		return compressedKind(kindZst, head), true
+	case has("BZh"):
+		return compressedKind(kindBz2, head), true
+	case has("7z\xbc\xaf\x27\x1c"):
+		return kind7z, true
+	case has("Rar!\x1a\x07"):
+		return kindRar, true
+	case has("\x04\x22\x4d\x18"):
+		return compressedKind(kindLz4, head), true
+	case has("\xff\x06\x00\x00sNaPpY"):
+		return kindSz, true
+	case has("\xed\xab\xee\xdb"):
+		return kindRpm, true
+	case has("!<arch>\ndebian-binary"):
+		return kindDeb, true
+	case isCpioHeader(head):
+		return kindCpio, true
+	case isTarHeader(head):
+		if image {
+			return kindImage, true
+		}
+		return kindTar, true
+	case len(head) >= detectHead && string(head[0x8001:0x8006]) == "CD001":
+		return kindIso, true
+	}
+	return 0, false
+}
+
+// gzipContent is the start of the content of the gzip file starting with head
+func gzipContent(head []byte) []byte {
+	reader, err := gzip.NewReader(bytes.NewReader(head))
+	if err != nil {
+		return nil
+	}
+	content := make([]byte, 512)
+	n, _ := io.ReadFull(reader, content)
+	return content[:n]
+}
+
+// compressedKind is kind, or the kind of the tar files compressed that way
+// when the content of head is one. The xz and zstd content is only looked at
+// with their commands.
+func compressedKind(kind inputKind, head []byte) inputKind {
+	reader := decompressor(kind, bytes.NewReader(head))
+	content := make([]byte, 512)
+	n, _ := io.ReadFull(reader, content)
+	reader.Close()
+	if isTarHeader(content[:n]) {
+		return kind.tarred()
+	}
+	return kind
+}
+
+func isTarHeader(b []byte) bool {
+	return len(b) >= 262 && string(b[257:262]) == "ustar"
+}
+
+func isCpioHeader(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("070701")) || bytes.HasPrefix(b, []byte("070702")) || bytes.HasPrefix(b, []byte("070707"))
+}
@@ -0,0 +1,232 @@
+package catzip
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// dirConfig is the config file of a directory of the inputs, see
+// Options.DirConfigFile, merged with the ones of its parents
+type dirConfig struct {
+	route        string
+	formatRoutes map[string]string
+	// Set by an empty filter_command too, which drops Options.FilterCommand
+	filterCommand []string
+	filterSet     bool
+	exts          []string
+}
+
+// parseDirConfig reads the YAML of a config file, as far as its keys need:
+// plain or quoted scalars, [a, b] lists and the block map of format_routes
+func parseDirConfig(data []byte) (*dirConfig, error) {
+	config := &dirConfig{}
+	block := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+			return nil, fmt.Errorf("line %d is indented with a tab", i+1)
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d isn't a key: value", i+1)
+		}
+
+		if line[0] == ' ' {
+			if block != "format_routes" {
+				return nil, fmt.Errorf("line %d is indented outside of format_routes", i+1)
+			}
+			name, err := yamlScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if !validRouteName(name) {
+				return nil, fmt.Errorf("line %d: format_routes sends %s to %q, expected a file name", i+1, key, name)
+			}
+			if !validFormat(key) {
+				return nil, fmt.Errorf("line %d: format_routes has unknown format %q, expected one of %v", i+1, key, Formats())
+			}
+			config.formatRoutes[key] = name
+			continue
+		}
+
+		block = ""
+		var values []string
+		var err error
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				var scalar string
+				if scalar, err = yamlScalar(strings.TrimSpace(item)); err != nil {
+					break
+				}
+				if scalar != "" {
+					values = append(values, scalar)
+				}
+			}
+		} else {
+			var scalar string
+			scalar, err = yamlScalar(value)
+			values = []string{scalar}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		switch key {
+		case "route":
+			if len(values) != 1 || !validRouteName(values[0]) {
+				return nil, fmt.Errorf("line %d: route is %s, expected a file name", i+1, value)
+			}
+			config.route = values[0]
+		case "format_routes":
+			if value != "" {
+				return nil, fmt.Errorf("line %d: format_routes is a map, expected its formats on the next lines", i+1)
+			}
+			block = key
+			config.formatRoutes = map[string]string{}
+		case "filter_command":
+			if len(values) == 1 {
+				values = strings.Fields(values[0])
+			}
+			config.filterCommand, config.filterSet = values, true
+		case "ext":
+			config.exts = splitExts(strings.Join(values, ","))
+			if len(config.exts) == 0 {
+				return nil, fmt.Errorf("line %d: ext is empty", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q, expected route, format_routes, filter_command or ext", i+1, key)
+		}
+	}
+	return config, nil
+}
+
+// stripYAMLComment removes the # comment ending line, the ones inside
+// quotes aside
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func yamlScalar(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		return strconv.Unquote(value)
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	case strings.HasPrefix(value, "\"") || strings.HasPrefix(value, "'"):
+		return "", fmt.Errorf("unterminated quote in %s", value)
+	}
+	return value, nil
+}
+
+func validFormat(format string) bool {
+	for _, f := range Formats() {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// merge returns c with child on top of it. The route of child also drops
+// the format routes of c, a subtree sent to its own file is all of it.
+func (c *dirConfig) merge(child *dirConfig) *dirConfig {
+	if c == nil {
+		return child
+	}
+	merged := *c
+	if child.route != "" {
+		merged.route = child.route
+		merged.formatRoutes = nil
+	}
+	if len(child.formatRoutes) > 0 {
+		merged.formatRoutes = map[string]string{}
+		for format, name := range c.formatRoutes {
+			if child.route == "" {
+				merged.formatRoutes[format] = name
+			}
+		}
+		for format, name := range child.formatRoutes {
+			merged.formatRoutes[format] = name
+		}
+	}
+	if child.filterSet {
+		merged.filterCommand, merged.filterSet = child.filterCommand, true
+	}
+	if child.exts != nil {
+		merged.exts = child.exts
+	}
+	return &merged
+}
+
+// checkDirConfig checks config against the options, like Validate does for
+// the ones it overrides
+func (o *Options) checkDirConfig(config *dirConfig) error {
+	if len(config.formatRoutes) > 0 && !o.Classify {
+		return errors.New("format_routes requires Classify")
+	}
+	if (config.route != "" || len(config.formatRoutes) > 0) && o.AttestationPath != "" {
+		return errors.New("AttestationPath only covers the cat file, route and format_routes can't be set")
+	}
+	if !o.Detect || o.Ext != "" {
+		allowed := splitExts(o.Ext)
+		for _, ext := range config.exts {
+			found := false
+			for _, a := range allowed {
+				found = found || a == ext
+			}
+			if !found {
+				return fmt.Errorf("ext has %s, expected some of the extensions of Ext %q", ext, o.Ext)
+			}
+		}
+	}
+	return nil
+}
+
+// inputConfigs are the dir configs of the inputs the walk selected, by path
+type inputConfigs struct {
+	mu      sync.Mutex
+	byInput map[string]*dirConfig
+}
+
+func (c *inputConfigs) set(input string, config *dirConfig) {
+	if c == nil || config == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byInput == nil {
+		c.byInput = map[string]*dirConfig{}
+	}
+	c.byInput[input] = config
+}
+
+// get returns the dir config of input, nil when it has none
+func (c *inputConfigs) get(input string) *dirConfig {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.byInput[input]
+}
@@ -0,0 +1,95 @@
+package catzip
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+const directIOAlignment = 4096
+
+// directWriter buffers writes into aligned blocks as O_DIRECT requires, the
+// unaligned tail is written through the page cache when it is closed
+type directWriter struct {
+	file *os.File
+	buf  []byte
+	n    int
+}
+
+func newDirectWriter(file *os.File, bufferSize int) *directWriter {
+	blocks := bufferSize / directIOAlignment
+	if blocks < 1 {
+		blocks = 1
+	}
+	return &directWriter{file: file, buf: alignedBuffer(blocks * directIOAlignment)}
+}
+
+func alignedBuffer(size int) []byte {
+	raw := make([]byte, size+directIOAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&raw[0])) & (directIOAlignment - 1)); rem != 0 {
+		offset = directIOAlignment - rem
+	}
+	return raw[offset : offset+size]
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[w.n:], p)
+		w.n += n
+		p = p[n:]
+		written += n
+
+		if w.n == len(w.buf) {
+			if _, err := w.file.Write(w.buf); err != nil {
+				return written, err
+			}
+			w.n = 0
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom reads straight into the aligned blocks
+func (w *directWriter) ReadFrom(r io.Reader) (int64, error) {
+	var written int64
+	for {
+		n, err := r.Read(w.buf[w.n:])
+		w.n += n
+		written += int64(n)
+		if w.n == len(w.buf) {
+			if _, err := w.file.Write(w.buf); err != nil {
+				return written, err
+			}
+			w.n = 0
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+func (w *directWriter) Close() error {
+	if w.n == 0 {
+		return w.file.Close()
+	}
+
+	name := w.file.Name()
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	tail, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = tail.Write(w.buf[:w.n]); err != nil {
+		tail.Close()
+		return err
+	}
+	return tail.Close()
+}
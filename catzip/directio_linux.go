@@ -0,0 +1,10 @@
+package catzip
+
+import (
+	"os"
+	"syscall"
+)
+
+func openDirect(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag|syscall.O_DIRECT, perm)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package catzip
+
+import (
+	"errors"
+	"os"
+)
+
+func openDirect(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return nil, errors.New("direct I/O is only supported on Linux")
+}
@@ -0,0 +1,99 @@
+package catzip
+
+import (
+	"io/fs"
+	"time"
+)
+
+// EntryStatus is what happened to an entry
+type EntryStatus string
+
+const (
+	EntryWritten EntryStatus = "written"
+	EntrySkipped EntryStatus = "skipped"
+)
+
+// EntryInfo describes one entry of an input file, a plain or gzip input is a
+// single entry named after the file. It is the shape used by the manifest,
+// Summary and Options.OnEntry.
+type EntryInfo struct {
+	Archive        string      `json:"archive"`
+	Name           string      `json:"name"`
+	Output         string      `json:"output,omitempty"`       // Extracted file
+	Parts          []string    `json:"parts,omitempty"`        // Output split in order, see Options.SplitEntrySize
+	Recompressed   bool        `json:"recompressed,omitempty"` // Output and Parts are gzipped, see Options.RecompressAbove
+	Layer          string      `json:"layer,omitempty"`        // Member of the image tarball it comes from, see Options.Image
+	Nested         string      `json:"nested,omitempty"`       // Archive inside Archive it comes from, see Options.MaxDepth
+	Size           uint64      `json:"size"`
+	CompressedSize uint64      `json:"compressed_size,omitempty"`
+	Mode           fs.FileMode `json:"mode"`
+	ModTime        time.Time   `json:"mtime"`
+	CRC32          uint32      `json:"crc32,omitempty"`     // From the zip headers
+	Hash           string      `json:"hash,omitempty"`      // Hex digest using Options.Hash
+	Signature      string      `json:"signature,omitempty"` // Hex, using Options.Signature
+	Scan           string      `json:"scan,omitempty"`      // ScanClean or ScanMalicious
+	Format         string      `json:"format,omitempty"`    // One of Formats, with Options.Classify
+	// One of the LineEndings values and of Encodings, with Options.DetectText
+	LineEndings string `json:"line_endings,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+	// How the binary content is escaped in the cat file, with Options.Escape
+	Escaped string `json:"escaped,omitempty"`
+	// Lines cut in the cat file, with Options.MaxLineBytes
+	LongLines int `json:"long_lines,omitempty"`
+	// Where the content is in the cat file, without the newline after it.
+	// Cat is only set when it is a file of Options.FormatRoutes.
+	Cat       string      `json:"cat,omitempty"`
+	CatOffset int64       `json:"cat_offset"`
+	CatLength int64       `json:"cat_length"`
+	Status    EntryStatus `json:"status"`
+	Reason    string      `json:"reason,omitempty"` // Why it was skipped
+	Comment   string      `json:"comment,omitempty"`
+	// Extended attributes of tar members, see HandlerOptions.Xattrs
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+	// Position in the archive from 1, out of Entries including the
+	// directories. Entries is 0 for tar files, their members aren't listed.
+	Entry   int `json:"entry"`
+	Entries int `json:"entries,omitempty"`
+
+	// Set by the policy for the write stage
+	route      string
+	transforms [][]string
+	index      int // In the input file, for the checkpoint
+	depth      int // Nested archives it is in
+}
+
+// entryDone is called once per entry when it is written or skipped
+func (r *run) entryDone(entry *EntryInfo) {
+	if entry.Status == EntryWritten {
+		r.logEntry(entry)
+	}
+	defer r.reportProgress(entry)
+	if entry.Status == EntrySkipped {
+		r.skippedMu.Lock()
+		r.skipped = append(r.skipped, *entry)
+		r.skippedMu.Unlock()
+	}
+
+	if err := r.sinks.call(func(sink Sink) error { return sink.Entry(*entry) }); err != nil {
+		r.fail(err)
+	}
+
+	if r.opts.OnEntry != nil {
+		r.onEntryMu.Lock()
+		defer r.onEntryMu.Unlock()
+		r.opts.OnEntry(*entry)
+	}
+}
+
+// logEntry logs the event of a written entry, with its digest when it is
+// hashed
+func (r *run) logEntry(entry *EntryInfo) {
+	fields := []interface{}{"event", "entry", "archive", entry.Archive, "name", entry.Name, "output", entry.Output, "bytes", entry.Size}
+	if entry.Nested != "" {
+		fields = append(fields, "nested", entry.Nested)
+	}
+	if entry.Hash != "" {
+		fields = append(fields, r.opts.Hash, entry.Hash)
+	}
+	r.logger.Print(LogFields(fields...))
+}
@@ -0,0 +1,200 @@
+package catzip
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// ExtractRange writes the bytes from start to before end of the entry named
+// name to w, from the first input file selected by opts that has it. end is
+// -1 for the end of the entry. The bytes before start are skipped by seeking
+// when the entry is stored and by decompressing them otherwise, for a sample
+// of the middle of a giant log without extracting all of it.
+func ExtractRange(w io.Writer, opts Options, name string, start, end int64) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if start < 0 || (end >= 0 && end < start) {
+		return fmt.Errorf("invalid range %d-%d", start, end)
+	}
+	in := inputFS(opts)
+	defer closeInputs(in)
+	files, _, _, err := selectInputs(opts, in)
+	if err != nil {
+		return err
+	}
+
+	passthrough := parseExtList(opts.PassthroughExt)
+	for _, f := range files {
+		kind := opts.fileKind(in, f)
+		var reader io.ReadCloser
+		switch {
+		case passthrough[filepath.Ext(f)] || kind == kindPlain || kind == kindGz || kind.single():
+			reader, err = openSingleEntry(in, f, kind, passthrough[filepath.Ext(f)] || kind == kindPlain, name)
+		case kind.tar():
+			reader, err = openTarEntry(in, f, kind, name)
+		case kind.cpio():
+			reader, err = openCpioEntry(in, f, kind, name)
+		case kind == kind7z:
+			reader, err = open7zEntry(in, f, name)
+		case kind == kindRar:
+			reader, err = openRarEntry(in, f, name)
+		case kind == kindIso:
+			reader, err = openIsoEntry(in, f, name)
+		case kind == kindImage:
+			reader, err = openImageEntry(in, f, name)
+		default:
+			reader, err = openZipEntry(in, f, name)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+		if reader == nil {
+			continue
+		}
+		defer reader.Close()
+		return copyRange(w, reader, start, end)
+	}
+	return fmt.Errorf("no input file has an entry named %s", name)
+}
+
+// copyRange copies the bytes from start to before end of r to w
+func copyRange(w io.Writer, r io.Reader, start, end int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+	} else if _, err := io.CopyN(io.Discard, r, start); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	if end >= 0 {
+		r = io.LimitReader(r, end-start)
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// openZipEntry opens the entry name of the zip file at path, or returns nil
+// when it has none. Stored entries can be seeked.
+func openZipEntry(fsys fs.FS, path, name string) (io.ReadCloser, error) {
+	reader, closer, err := openZip(fsys, path, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range reader.File {
+		if f.Name != name || f.FileInfo().IsDir() {
+			continue
+		}
+		if f.Method == zip.Store {
+			// The raw reader of a stored entry is a section of the zip file
+			raw, err := f.OpenRaw()
+			if err != nil {
+				closer.Close()
+				return nil, err
+			}
+			if seeker, ok := raw.(io.ReadSeeker); ok {
+				return readSeekCloser{seeker, closer}, nil
+			}
+			return readCloser{raw, closer}, nil
+		}
+		registerEntryDecompressor(reader, f)
+		rc, err := f.Open()
+		if err != nil {
+			closer.Close()
+			return nil, err
+		}
+		return readCloser{rc, closers{rc, closer}}, nil
+	}
+	closer.Close()
+	return nil, nil
+}
+
+// openTarEntry opens the regular file name of the tar file at path, or
+// returns nil when it has none
+func openTarEntry(fsys fs.FS, path string, kind inputKind, name string) (io.ReadCloser, error) {
+	reader, c, err := openTar(fsys, path, kind)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			c.Close()
+			return nil, nil
+		}
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if header.Name == name && (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA) {
+			return readCloser{reader, c}, nil
+		}
+	}
+}
+
+// openSingleEntry opens the content of a gz, zst, bz2, xz, lz4, br, sz or
+// plain input file at path when name is its entry, or returns nil
+func openSingleEntry(fsys fs.FS, path string, kind inputKind, plain bool, name string) (io.ReadCloser, error) {
+	_, entries, err := listEntries(fsys, path, kind, plain)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 || (entries[0].name != name && filepath.Base(entries[0].name) != name) {
+		return nil, nil
+	}
+
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case plain:
+		return file, nil
+	case kind == kindGz:
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return readCloser{reader, closers{reader, file}}, nil
+	}
+	reader := decompressor(kind, file)
+	return readCloser{reader, closers{reader, file}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// WriteTo gives io.Copy the WriteTo of the reader, or the ReadFrom of w,
+// that the struct hides
+func (r readCloser) WriteTo(w io.Writer) (int64, error) { return io.Copy(w, r.Reader) }
+
+type readSeekCloser struct {
+	io.ReadSeeker
+	io.Closer
+}
+
+func (r readSeekCloser) WriteTo(w io.Writer) (int64, error) { return io.Copy(w, r.ReadSeeker) }
+
+// closers closes all of them in order, returning the first error
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var first error
+	for _, closer := range c {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,56 @@
+package catzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// "first line\nsecond line\nthird line\n" as a zip LZMA entry: the SDK
+// version, the size of the properties, lc=3 lp=0 pb=2 with a 64KiB
+// dictionary, then the stream with its end marker
+var lzmaEntry = []byte{
+	0x10, 0x02, 0x05, 0x00, 0x5d, 0x00, 0x00, 0x01, 0x00,
+	0x00, 0x33, 0x1a, 0x4a, 0xac, 0x0c, 0x72, 0xbf, 0x8d, 0x58, 0xf9, 0x4d, 0x6f, 0xbe, 0x9d, 0x69, 0xa7, 0xa5,
+	0xc3, 0xe1, 0x4e, 0xf7, 0x93, 0x93, 0xdc, 0xa9, 0x9c, 0x76, 0x59, 0xff, 0xff, 0xfa, 0x92, 0xe0, 0x00,
+}
+
+func TestExtractRangeLZMA(t *testing.T) {
+	content := []byte("first line\nsecond line\nthird line\n")
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.CreateRaw(&zip.FileHeader{
+		Name:               "logs/app.log",
+		Method:             zipMethodLZMA,
+		Flags:              0x2, // End marker
+		CRC32:              crc32.ChecksumIEEE(content),
+		CompressedSize64:   uint64(len(lzmaEntry)),
+		UncompressedSize64: uint64(len(content)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(lzmaEntry)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err = os.WriteFile(filepath.Join(dir, "logs.zip"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.Dir = dir
+	opts.Ext = ".zip"
+
+	var out bytes.Buffer
+	if err = ExtractRange(&out, opts, "logs/app.log", 11, 23); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "second line\n" {
+		t.Errorf("got %q", out.String())
+	}
+}
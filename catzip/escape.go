@@ -0,0 +1,85 @@
+package catzip
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+)
+
+// Options.Escape values
+const (
+	EscapeBase64 = "base64"
+	EscapeHex    = "hex"
+	EscapeC      = "c-style" // \n, \t, \\ and \xHH for the other bytes that aren't printable ASCII
+)
+
+// EscapeModes lists the values of Options.Escape
+func EscapeModes() []string {
+	return []string{EscapeBase64, EscapeHex, EscapeC}
+}
+
+// escaper writes the content of a binary entry to w as a single line, with
+// Options.Escape. close writes what is left of a base64 one.
+type escaper struct {
+	w       io.Writer
+	mode    string
+	pending []byte // base64 bytes short of a group of 3
+	buf     []byte
+	written int64
+}
+
+func (e *escaper) Write(p []byte) (int, error) {
+	e.buf = e.buf[:0]
+	switch e.mode {
+	case EscapeBase64:
+		data := append(e.pending, p...)
+		whole := len(data) / 3 * 3
+		e.buf = append(e.buf, make([]byte, base64.StdEncoding.EncodedLen(whole))...)
+		base64.StdEncoding.Encode(e.buf, data[:whole])
+		e.pending = append(e.pending[:0], data[whole:]...)
+	case EscapeHex:
+		e.buf = append(e.buf, make([]byte, hex.EncodedLen(len(p)))...)
+		hex.Encode(e.buf, p)
+	default:
+		for _, b := range p {
+			e.buf = appendCEscaped(e.buf, b)
+		}
+	}
+	if err := e.flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (e *escaper) close() error {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	e.buf = append(e.buf[:0], make([]byte, base64.StdEncoding.EncodedLen(len(e.pending)))...)
+	base64.StdEncoding.Encode(e.buf, e.pending)
+	e.pending = e.pending[:0]
+	return e.flush()
+}
+
+func (e *escaper) flush() error {
+	n, err := e.w.Write(e.buf)
+	e.written += int64(n)
+	return err
+}
+
+func appendCEscaped(buf []byte, b byte) []byte {
+	switch {
+	case b == '\n':
+		return append(buf, `\n`...)
+	case b == '\r':
+		return append(buf, `\r`...)
+	case b == '\t':
+		return append(buf, `\t`...)
+	case b == '\\':
+		return append(buf, `\\`...)
+	case b < 0x20 || b >= 0x7f:
+		const digits = "0123456789abcdef"
+		return append(buf, '\\', 'x', digits[b>>4], digits[b&0xf])
+	}
+	return append(buf, b)
+}
@@ -0,0 +1,377 @@
+package catzip
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// handlePlain copies an uncompressed file to outdir and appends it to cat,
+// a file that is already in outdir is only appended
+func (r *run) handlePlain(filename string) error {
+	newFilename := filepath.Join(r.opts.OutDir, filepath.Base(filename))
+	if r.fs == OS && isOSInputs(r.in) && sameFile(filename, newFilename) {
+		entry := &EntryInfo{Archive: filename, Name: filepath.Base(filename), Output: filename, Entry: 1, Entries: 1}
+		if info, err := os.Stat(filename); err == nil {
+			entry.Mode = info.Mode()
+			entry.ModTime = info.ModTime()
+		}
+		// Nothing is written, so only a skip or a route of the policy applies
+		if _, ok, err := r.applyPolicy(entry, entry.Name); err != nil || !ok {
+			r.manifest.add(manifestArchive{Path: filename, Entries: []*EntryInfo{entry}})
+			return err
+		}
+		entry.transforms = nil
+		if !r.resumed(entry) {
+			r.newCatOnlyTask(entry).Close()
+		}
+		r.manifest.add(manifestArchive{Path: filename, Entries: []*EntryInfo{entry}})
+		return nil
+	}
+
+	plainFile, err := r.in.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer plainFile.Close()
+
+	info, err := plainFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	entry := &EntryInfo{
+		Archive: filename,
+		Name:    filepath.Base(filename),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		Entry:   1,
+		Entries: 1,
+	}
+	newFilename, ok, err := r.plainOutput(entry, newFilename)
+	if err != nil || !ok {
+		r.manifest.add(manifestArchive{Path: filename, Entries: []*EntryInfo{entry}})
+		return err
+	}
+	entry.Output = newFilename
+	if r.resumed(entry) {
+		r.manifest.add(manifestArchive{Path: filename, Entries: []*EntryInfo{entry}})
+		return nil
+	}
+	writer := r.newWriteTask(entry, info.Size())
+	defer writer.Close()
+
+	if err = r.ioCopy(writer, plainFile); err != nil {
+		return err
+	}
+
+	r.manifest.add(manifestArchive{Path: filename, Entries: []*EntryInfo{entry}})
+	return nil
+}
+
+func sameFile(a string, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func (r *run) handleGz(gzFilename string) error {
+	gzFile, err := r.in.Open(gzFilename)
+	if err != nil {
+		return err
+	}
+	defer gzFile.Close()
+
+	// Every member is read, gzip.Reader is multistream
+	reader, err := gzip.NewReader(gzFile)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	base := singleOutput(gzFilename, strings.TrimSuffix(gzFilename, ".gz"))
+	if r.opts.Handlers["gz"].UseFName && reader.Name != "" {
+		base = filepath.Join(filepath.Dir(gzFilename), filepath.Base(reader.Name))
+	}
+	entry := &EntryInfo{
+		Archive: gzFilename,
+		Name:    filepath.Base(base),
+		Mode:    0666,
+		ModTime: reader.ModTime,
+		Comment: reader.Comment,
+		Entry:   1,
+		Entries: 1,
+	}
+	if info, err := gzFile.Stat(); err == nil {
+		entry.CompressedSize = uint64(info.Size())
+	}
+	newFilename, ok, err := r.plainOutput(entry, base)
+	if err != nil || !ok {
+		r.manifest.add(manifestArchive{Path: gzFilename, Entries: []*EntryInfo{entry}})
+		return err
+	}
+	entry.Output = newFilename
+	if r.resumed(entry) {
+		r.manifest.add(manifestArchive{Path: gzFilename, Entries: []*EntryInfo{entry}})
+		return nil
+	}
+	writer := r.newWriteTask(entry, gzipSizeHint(r.in, gzFilename))
+	defer writer.Close()
+
+	if err = r.ioCopy(writer, reader); err != nil {
+		return err
+	}
+
+	r.manifest.add(manifestArchive{Path: gzFilename, Entries: []*EntryInfo{entry}})
+	return nil
+}
+
+// singleOutput is where the single compressed input name is extracted, base
+// being name without its extension. An input read with Options.Detect that
+// doesn't have one is extracted to name.out rather than over itself.
+func singleOutput(name, base string) string {
+	if base == name {
+		return name + ".out"
+	}
+	return base
+}
+
+func (r *run) handleZip(f string) error {
+	if isStream(r.in, f) {
+		return r.handleZipStream(f)
+	}
+	reader, closer, err := openZip(r.in, f, r.opts.Mmap, r.logger)
+	if err != nil {
+		return fmt.Errorf("unable to read %s file: %w", r.opts.inputExt(f), err)
+	}
+	defer closer.Close()
+	decodeNames(reader, r.opts.Handlers["zip"].NameEncoding)
+
+	destination, err := filepath.Abs(r.opts.OutDir)
+	if err != nil {
+		return fmt.Errorf("unable to find absolute path for dir %s: %w", r.opts.OutDir, err)
+	}
+
+	archive := manifestArchive{Path: f, Comment: reader.Comment}
+	for i, file := range reader.File {
+		entry := &EntryInfo{
+			Archive:        f,
+			Name:           file.Name,
+			Size:           file.UncompressedSize64,
+			CompressedSize: file.CompressedSize64,
+			Mode:           file.Mode(),
+			ModTime:        file.Modified,
+			CRC32:          file.CRC32,
+			Comment:        file.Comment,
+			Entry:          i + 1,
+			Entries:        len(reader.File),
+			index:          i,
+		}
+		if reason := unsupportedReason(file); reason != "" {
+			r.skipEntry(entry, reason)
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		name := r.renamed(file.Name)
+		if name == "" {
+			r.skipEntry(entry, "renamed to an empty name")
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+		if r.merge != nil && r.opts.Tombstones != "" && file.Name == r.opts.Tombstones {
+			r.skipEntry(entry, "tombstones")
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+		if r.merge != nil && !file.FileInfo().IsDir() {
+			if by, ok := r.merge.deleted[filepath.Clean(name)]; ok {
+				r.skipEntry(entry, "deleted by "+by)
+				archive.Entries = append(archive.Entries, entry)
+				continue
+			}
+			if kept, ok := r.merge.keeps(name, f, i); !ok {
+				r.skipEntry(entry, fmt.Sprintf("merged, the %s entry from %s is kept", r.opts.MergeTrees, kept.Archive))
+				archive.Entries = append(archive.Entries, entry)
+				continue
+			}
+		}
+
+		if !file.FileInfo().IsDir() {
+			var ok bool
+			if name, ok, err = r.applyPolicy(entry, name); err != nil {
+				return err
+			}
+			if !ok {
+				archive.Entries = append(archive.Entries, entry)
+				continue
+			}
+		}
+
+		registerEntryDecompressor(reader, file)
+		if err := r.extractEntry(name, entry, destination, file.FileInfo().IsDir(), int64(file.UncompressedSize64), file.Open); err != nil {
+			return fmt.Errorf("unable to unzip file inside archive: %w", err)
+		}
+		if entry.Output != "" {
+			archive.Entries = append(archive.Entries, entry)
+		}
+	}
+	r.manifest.add(archive)
+	return nil
+}
+
+// openZip opens a zip file for reading, if useMmap is set the archive is
+// memory-mapped, falling back to regular reads logged to logger when that
+// isn't possible. Split zip files are read from all their parts.
+func openZip(fsys fs.FS, name string, useMmap bool, logger *log.Logger) (*zip.Reader, io.Closer, error) {
+	if !isOSInputs(fsys) || isSplitZip(fsys, name) {
+		return openZipFS(fsys, name)
+	}
+	if err := checkInput(fsys, name); err != nil {
+		return nil, nil, err
+	}
+	if useMmap {
+		mapped, err := openMmap(name)
+		if err == nil {
+			reader, err := zip.NewReader(mapped, mapped.Size())
+			if err != nil {
+				mapped.Close()
+				return nil, nil, err
+			}
+			registerDecompressors(reader)
+			return reader, mapped, nil
+		}
+		logger.Printf("Unable to memory-map %s, reading it instead: %v", name, err)
+	}
+
+	reader, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	registerDecompressors(&reader.Reader)
+	return &reader.Reader, reader, nil
+}
+
+// autoRenameRepeatedFiles reserves filePath, returning a numbered variant of
+// it when the name was already taken by a previous file or by an entry of
+// the run being resumed
+func (r *run) autoRenameRepeatedFiles(filePath string) string {
+	r.unzipedFilesMu.Lock()
+	defer r.unzipedFilesMu.Unlock()
+
+	for {
+		counter, repeated := r.unzipedFiles[filePath]
+		r.unzipedFiles[filePath] += 1
+		reserved := filePath
+		if repeated {
+			dir := filepath.Dir(filePath)
+			ext := filepath.Ext(filePath)
+			fileName := filepath.Base(filePath)
+			fileName = fileName[:len(fileName)-len(ext)]
+			fileName = fmt.Sprintf("%s(%d)%s", fileName, counter, ext)
+			reserved = filepath.Join(dir, fileName)
+		}
+		if !r.checkpoint.resuming() || !r.checkpoint.outputs[reserved] {
+			return reserved
+		}
+	}
+}
+
+// extractEntry extracts an entry of an archive as name, its name after the
+// rename rules, and sets the path it was written to in entry, which stays
+// empty for directories. open is only called when the entry is written.
+func (r *run) extractEntry(name string, entry *EntryInfo, destination string, isDir bool, size int64, open func() (io.ReadCloser, error)) error {
+	//Check if file paths are not vulnerable to Zip Slip
+	filePath := filepath.Join(destination, name)
+	if !strings.HasPrefix(filePath, filepath.Clean(destination)+string(os.PathSeparator)) {
+		return fmt.Errorf("invalid file path: %s", filePath)
+	}
+
+	// Not needed but will create directory tree
+	if isDir {
+		return r.mkdirAll(filePath, entry)
+	}
+
+	if err := r.mkdirAll(filepath.Dir(filePath), entry); err != nil {
+		return err
+	}
+
+	if kind, ok := r.nestedKind(name, entry); ok {
+		return r.extractNested(filePath, entry, kind, open)
+	}
+
+	// Before reserving a name, the entry has one already
+	if r.resumed(entry) {
+		return nil
+	}
+	// The ziped files migh have files with the same name, solving that
+	entry.Output = r.autoRenameRepeatedFiles(filePath)
+
+	// The write stage creates the destination file and appends it to cat
+	destinationFile := r.newWriteTask(entry, size)
+	defer destinationFile.Close()
+
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return r.ioCopy(destinationFile, reader)
+}
+
+// plainOutput returns the output path of a gz or plain input extracted to
+// path, the rename rules and the policy only change its base name, which can
+// move it to a subdirectory but not out of its directory. It returns false
+// when the entry is skipped.
+func (r *run) plainOutput(entry *EntryInfo, path string) (string, bool, error) {
+	dir := filepath.Dir(path)
+	name := r.renamed(filepath.Base(path))
+	if name == "" {
+		r.skipEntry(entry, "renamed to an empty name")
+		return "", false, nil
+	}
+	name, ok, err := r.applyPolicy(entry, name)
+	if err != nil || !ok {
+		return "", false, err
+	}
+
+	renamed := filepath.Join(dir, name)
+	if rel, err := filepath.Rel(dir, renamed); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", false, fmt.Errorf("invalid file path: %s", renamed)
+	}
+	// The objects of buckets are named in directories of OutDir that don't
+	// exist yet
+	_, remote := r.in.(*urlInputs)
+	if filepath.Dir(renamed) != dir || (remote && dir != filepath.Clean(r.opts.OutDir)) {
+		if err := r.mkdirAll(filepath.Dir(renamed), entry); err != nil {
+			return "", false, err
+		}
+	}
+	return r.autoRenameRepeatedFiles(renamed), true, nil
+}
+
+func (r *run) mkdirAll(path string, entry *EntryInfo) error {
+	if err := r.fs.MkdirAll(path, os.ModePerm); err != nil {
+		return err
+	}
+	return r.audit(AuditMkdir, path, "", entry)
+}
+
+func (r *run) ioCopy(writer io.Writer, reader io.Reader) error {
+	n, err := io.Copy(writer, reader)
+	r.copiedBytes.Add(n)
+	return err
+}
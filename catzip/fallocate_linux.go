@@ -0,0 +1,18 @@
+package catzip
+
+import (
+	"os"
+	"syscall"
+)
+
+const fallocKeepSize = 0x1
+
+// preallocateFile reserves size bytes of disk for f without changing its
+// apparent size, so an overestimated size doesn't leave zeros at the end
+func preallocateFile(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if err == syscall.EOPNOTSUPP {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package catzip
+
+import "os"
+
+// preallocateFile is a no-op where fallocate isn't available
+func preallocateFile(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package catzip
+
+import "os/exec"
+
+// Codec is an input format or a compression method, with the command
+// decoding it when it isn't built in
+type Codec struct {
+	Name    string `json:"name"`
+	Command string `json:"command,omitempty"`
+	// Command was found in PATH, always true when it is built in
+	Available bool `json:"available"`
+}
+
+// Features lists what this build supports, so deployments can check a
+// binary before scheduling jobs on it
+type Features struct {
+	// Options.Ext values. The stored files of rar archives are read without
+	// unrar, deb and rpm payloads compressed with zstd need the zstd
+	// command.
+	Inputs []Codec `json:"inputs"`
+	// Compression methods of zip entries, and of 7z files
+	ZipMethods      []Codec  `json:"zip_methods"`
+	SevenZipMethods []Codec  `json:"7z_methods"`
+	Hashes          []string `json:"hashes"`
+	Signatures      []string `json:"signatures"`
+	Formats         []string `json:"formats"`
+	Encodings       []string `json:"encodings"`
+	AnnotateColumns []string `json:"annotate_columns"`
+	EscapeModes     []string `json:"escape_modes"`
+	Profiles        []string `json:"profiles"`
+	// What ParseSink sends, and where to
+	SinkOutputs []string `json:"sink_outputs"`
+	SinkKinds   []string `json:"sink_kinds"`
+	// ParseCredentials providers
+	Credentials []string `json:"credentials"`
+	// Of Options.URLs
+	URLSchemes []string `json:"url_schemes"`
+}
+
+// Options.Ext values, in the order they are documented
+var inputExts = []string{
+	".zip", ".7z", ".rar", ".iso", ".gz", ".zst", ".bz2", ".xz", ".lz4", ".br", ".sz",
+	".tar", ".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar.zst", ".tzst", ".tar.bz2", ".tbz2",
+	".tar.lz4", ".cpio", ".cpio.gz", ".deb", ".rpm",
+}
+
+// command is the command decoding the inputs of kind, "" when it is built in
+func (k inputKind) command() string {
+	switch k {
+	case kindZst, kindTarZst:
+		return "zstd"
+	case kindBr:
+		return "brotli"
+	case kindRar:
+		return "unrar"
+	}
+	return ""
+}
+
+// requiredCommand is the command all the inputs of kind need, which Validate
+// looks for, "" when they need none or only some of them do, like the
+// compressed files of rar archives
+func (k inputKind) requiredCommand() string {
+	switch k.compression() {
+	case kindZst:
+		return "zstd"
+	case kindBr:
+		return "brotli"
+	}
+	return ""
+}
+
+// SupportedFeatures returns the features of this build, looking for the
+// commands it runs in PATH
+func SupportedFeatures() Features {
+	inputs := make([]Codec, len(inputExts))
+	for i, ext := range inputExts {
+		inputs[i] = newCodec(ext, kindOf(ext).command())
+	}
+	return Features{
+		Inputs: inputs,
+		ZipMethods: []Codec{
+			newCodec("store", ""), newCodec("deflate", ""), newCodec("bzip2", ""),
+			newCodec("lzma", ""), newCodec("zstd", "zstd"), newCodec("xz", ""),
+		},
+		SevenZipMethods: []Codec{
+			newCodec("copy", ""), newCodec("lzma", ""), newCodec("lzma2", ""),
+			newCodec("deflate", ""), newCodec("bzip2", ""), newCodec("bcj", ""),
+		},
+		Hashes:          HashAlgorithms(),
+		Signatures:      []string{SignatureSimHash, SignatureMinHash},
+		Formats:         Formats(),
+		Encodings:       Encodings(),
+		AnnotateColumns: AnnotateColumns(),
+		EscapeModes:     EscapeModes(),
+		Profiles:        Profiles(),
+		SinkOutputs:     []string{"cat", "events"},
+		SinkKinds:       []string{"file", "cmd"},
+		Credentials:     []string{"env", "shared", "web-identity", "metadata", "gce-metadata", "default", "exec"},
+		URLSchemes:      urlSchemes,
+	}
+}
+
+func newCodec(name, command string) Codec {
+	available := true
+	if command != "" {
+		_, err := exec.LookPath(command)
+		available = err == nil
+	}
+	return Codec{Name: name, Command: command, Available: available}
+}
@@ -0,0 +1,90 @@
+package catzip
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// filter pipes the content of an entry through Options.FilterCommand or a
+// transform of the policy on its way to the write stage, the output comes
+// back as chunks from the pool
+type filter struct {
+	cmd    *exec.Cmd
+	out    chan []byte
+	stderr bytes.Buffer
+	err    error // Set before out is closed
+}
+
+func (r *run) startFilter(args []string, in <-chan []byte) (*filter, error) {
+	f := &filter{
+		cmd: exec.Command(args[0], args[1:]...),
+		out: make(chan []byte, r.depths.chunks),
+	}
+	f.cmd.Stderr = &f.stderr
+	stdin, err := f.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := f.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = f.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start the filter: %w", err)
+	}
+
+	go func() {
+		// Keeps draining in after a failed write so the decoder isn't blocked
+		var writeErr error
+		for chunk := range in {
+			if writeErr == nil {
+				_, writeErr = stdin.Write(chunk)
+			}
+			r.chunkPool.Put(chunk[:cap(chunk)])
+		}
+		stdin.Close()
+	}()
+
+	go func() {
+		defer close(f.out)
+		var readErr error
+		for {
+			chunk := r.chunkPool.Get().([]byte)
+			n, err := io.ReadFull(stdout, chunk)
+			if n > 0 {
+				f.out <- chunk[:n]
+			} else {
+				r.chunkPool.Put(chunk)
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				readErr = err
+				break
+			}
+		}
+		if err := f.cmd.Wait(); err != nil {
+			f.err = fmt.Errorf("filter %s: %w", f.cmd.Path, err)
+			if stderr := bytes.TrimSpace(f.stderr.Bytes()); len(stderr) > 0 {
+				f.err = fmt.Errorf("%w: %s", f.err, stderr)
+			}
+		} else if readErr != nil {
+			f.err = fmt.Errorf("filter %s: %w", f.cmd.Path, readErr)
+		}
+	}()
+	return f, nil
+}
+
+// abort stops the filter when its output isn't read to the end
+func (f *filter) abort(pool *sync.Pool) {
+	f.cmd.Process.Kill()
+	go func() {
+		for chunk := range f.out {
+			pool.Put(chunk[:cap(chunk)])
+		}
+	}()
+}
@@ -0,0 +1,240 @@
+package catzip
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WriteFS is where a run writes the extracted files and the concatenated
+// file. Names are OS paths, as built from Options.OutDir.
+type WriteFS interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (WriteFile, error)
+	// Open reads back a file previously written
+	Open(name string) (fs.File, error)
+	MkdirAll(path string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+	// Truncate cuts a file back to size, it recovers the cat file of a run
+	// resumed from a checkpoint
+	Truncate(name string, size int64) error
+}
+
+// WriteFile is a file opened for writing by a WriteFS
+type WriteFile interface {
+	io.Writer
+	io.Closer
+}
+
+// OS writes to the operating system's filesystem
+var OS WriteFS = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (WriteFile, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+// MemFS keeps the outputs in memory, for tests and for hosts without a
+// writable disk
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memData
+	dirs  map[string]bool
+}
+
+type memData struct {
+	mu      sync.Mutex
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{files: map[string]*memData{}, dirs: map[string]bool{}}
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (WriteFile, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.files[name]
+	switch {
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok:
+		d = &memData{mode: perm}
+		m.files[name] = d
+	}
+
+	d.mu.Lock()
+	if flag&os.O_TRUNC != 0 {
+		d.data = nil
+	}
+	d.modTime = time.Now()
+	d.mu.Unlock()
+	return &memWriter{d: d, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	d, ok := m.files[name]
+	m.mu.Unlock()
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	info := memFileInfo{name: filepath.Base(name), size: int64(len(d.data)), mode: d.mode, modTime: d.modTime}
+	return &memReader{Reader: bytes.NewReader(d.data), info: info}, nil
+}
+
+func (m *MemFS) MkdirAll(path string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path = filepath.Clean(path); !m.dirs[path]; path = filepath.Dir(path) {
+		m.dirs[path] = true
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = d
+	return nil
+}
+
+func (m *MemFS) Truncate(name string, size int64) error {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	d, ok := m.files[name]
+	m.mu.Unlock()
+	if !ok {
+		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrNotExist}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if size < int64(len(d.data)) {
+		d.data = d.data[:size]
+	} else {
+		d.data = append(d.data, make([]byte, size-int64(len(d.data)))...)
+	}
+	return nil
+}
+
+// ReadFile returns a copy of the content of a file
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	f, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Names lists the files written, sorted
+func (m *MemFS) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type memWriter struct {
+	d      *memData
+	append bool
+	off    int
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.d.mu.Lock()
+	defer w.d.mu.Unlock()
+	if w.append {
+		w.off = len(w.d.data)
+	}
+	if end := w.off + len(p); end > len(w.d.data) {
+		w.d.data = append(w.d.data, make([]byte, end-len(w.d.data))...)
+	}
+	n := copy(w.d.data[w.off:], p)
+	w.off += n
+	return n, nil
+}
+
+func (w *memWriter) Close() error {
+	return nil
+}
+
+type memReader struct {
+	*bytes.Reader
+	info memFileInfo
+}
+
+func (r *memReader) Stat() (fs.FileInfo, error) { return r.info, nil }
+func (r *memReader) Close() error               { return nil }
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return false }
+func (i memFileInfo) Sys() any           { return nil }
@@ -0,0 +1,103 @@
+package catzip
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// gcsClient builds the requests of the gs:// inputs to the JSON API, with
+// the bearer token of Options.Credentials
+type gcsClient struct {
+	creds    CredentialProvider
+	endpoint string
+}
+
+func newGCSClient(opts Options) *gcsClient {
+	c := &gcsClient{
+		creds:    opts.Credentials,
+		endpoint: firstNonEmpty(opts.GCSEndpoint, os.Getenv("STORAGE_EMULATOR_HOST"), "https://storage.googleapis.com"),
+	}
+	if !strings.Contains(c.endpoint, "://") {
+		// STORAGE_EMULATOR_HOST is often only host:port
+		c.endpoint = "http://" + c.endpoint
+	}
+	if c.creds == nil {
+		c.creds = DefaultCredentials(opts.HTTPClient)
+	}
+	return c
+}
+
+// request builds the GET of the gs://bucket/object URL u, of the content
+// of the object or, without one, of the listing of bucket with the query
+// of u
+func (c *gcsClient) request(u *url.URL) (*http.Request, error) {
+	creds, err := c.creds.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds.Token == "" {
+		return nil, fmt.Errorf("the credentials from %s have no token for GCS requests", creds.Source)
+	}
+	target, err := url.Parse(c.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	objects := strings.TrimSuffix(target.Path, "/") + "/storage/v1/b/" + u.Host + "/o"
+	target.Path = objects
+	target.RawQuery = u.RawQuery
+	if object := strings.TrimPrefix(u.Path, "/"); object != "" {
+		// The slashes of the object are escaped too
+		target.Path = objects + "/" + object
+		target.RawPath = (&url.URL{Path: objects}).EscapedPath() + "/" + url.PathEscape(object)
+		target.RawQuery = "alt=media"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+creds.Token)
+	return req, nil
+}
+
+// listGCS lists the objects of bucket with names starting with prefix, in
+// name order
+func (u *urlInputs) listGCS(bucket, prefix string) ([]bucketObject, error) {
+	var objects []bucketObject
+	token := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name,size,updated),nextPageToken"}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		resp, err := u.get((&url.URL{Scheme: "gs", Host: bucket, Path: "/", RawQuery: query.Encode()}).String(), "")
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name    string    `json:"name"`
+				Size    int64     `json:"size,string"`
+				Updated time.Time `json:"updated"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the listing of gs://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, item := range page.Items {
+			objects = append(objects, bucketObject{item.Name, item.Size, item.Updated})
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		token = page.NextPageToken
+	}
+}
@@ -0,0 +1,72 @@
+package catzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"testing/fstest"
+)
+
+// gzipMembers returns a gzip file of a member for each of contents, as cat
+// a.gz b.gz makes
+func gzipMembers(level int, contents ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, content := range contents {
+		w, _ := gzip.NewWriterLevel(&buf, level)
+		w.Write(content)
+		w.Close()
+	}
+	return buf.Bytes()
+}
+
+func TestGzipMembers(t *testing.T) {
+	lines, code := testLines(40000), testCode(20000)
+	// Stored, the header and the size before it show up in the data
+	fake := append(bytes.Repeat([]byte("-"), 30), "\xff\xff\xff\x7f\x1f\x8b\x08\x00"...)
+	fake = append(fake, bytes.Repeat([]byte("-"), 30)...)
+	smallFake := bytes.Replace(fake, []byte("\xff\xff\xff\x7f"), []byte("\x01\x00\x00\x00"), 1)
+	for _, v := range []struct {
+		name     string
+		data     []byte
+		content  []byte
+		hint     int64
+		trailers bool // Listed from the trailers, without decompressing
+	}{
+		{"single", gzipMembers(gzip.DefaultCompression, lines), lines, 40000, true},
+		{"members", gzipMembers(gzip.DefaultCompression, lines, code, nil), append(append([]byte{}, lines...), code...), 60000, false},
+		{"fake header", gzipMembers(gzip.NoCompression, fake), fake, -1, false},
+		{"small fake header", gzipMembers(gzip.NoCompression, smallFake), smallFake, int64(len(smallFake)) + 1, false},
+	} {
+		fsys := fstest.MapFS{"a.gz": {Data: v.data}}
+		if size := gzipSizeHint(fsys, "a.gz"); size != v.hint {
+			t.Errorf("%s: size hint %d, expected %d", v.name, size, v.hint)
+		}
+		if _, exact := gzipMembersSize(bytes.NewReader(v.data), int64(len(v.data))); exact != v.trailers {
+			t.Errorf("%s: exact %v", v.name, exact)
+		}
+		if _, entries, err := listGz(fsys, "a.gz"); err != nil || len(entries) != 1 || entries[0].size != int64(len(v.content)) {
+			t.Errorf("%s: listed %v, %v", v.name, entries, err)
+		}
+
+		out := runInMemory(t, ".gz", fsys)
+		if data, err := out.ReadFile("a"); err != nil || !bytes.Equal(data, v.content) {
+			t.Errorf("%s: extracted %d bytes, %v", v.name, len(data), err)
+		}
+	}
+
+	// The second header across the end of the first chunk scanned
+	base := len(gzipMembers(gzip.NoCompression, make([]byte, 65000)))
+	for i := -4; i <= 0; i++ {
+		first := make([]byte, 65000+64<<10-base+i)
+		data := gzipMembers(gzip.NoCompression, first, lines)
+		if size, _ := gzipMembersSize(bytes.NewReader(data), int64(len(data))); size != int64(len(first)+len(lines)) {
+			t.Errorf("header at %d: size %d", len(data)-len(gzipMembers(gzip.NoCompression, lines)), size)
+		}
+	}
+
+	// Cut in a member, the trailers can't be trusted
+	data := gzipMembers(gzip.DefaultCompression, lines, code)
+	if _, _, err := listGz(fstest.MapFS{"a.gz": {Data: data[:len(data)-100]}}, "a.gz"); err == nil {
+		t.Error("listed a cut file")
+	}
+}
@@ -0,0 +1,176 @@
+package catzip
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HandlerOptions are the settings of the handler of one format, see
+// Options.Handlers
+//
+// There is no zip password on purpose: encrypted entries are skipped as
+// encrypted, like the ones of rar and 7z files. The password would sit in
+// plain text in the config file, and the traditional zip encryption
+// protects next to nothing anyway.
+type HandlerOptions struct {
+	// zip: encoding of the entry names not flagged as UTF-8, cp437 or
+	// latin1. "" keeps their bytes as they are.
+	NameEncoding string `json:"name_encoding,omitempty"`
+	// gz: name the extracted file after the name in the gzip header, when
+	// it has one, instead of after the input file
+	UseFName bool `json:"use_fname,omitempty"`
+	// tar: record the extended attributes of the members, from their PAX
+	// SCHILY.xattr records, in EntryInfo.Xattrs
+	Xattrs bool `json:"xattrs,omitempty"`
+}
+
+// Options.Handlers keys
+var handlerFormats = []string{"zip", "gz", "tar"}
+
+// ParseHandlers reads the handlers section of a config file, in JSON or in
+// the subset of YAML of the dir configs, see Options.DirConfigFile:
+//
+//	handlers:
+//	  zip:
+//	    name_encoding: cp437
+//	  gz:
+//	    use_fname: true
+//
+// Unknown keys are errors, so a typo doesn't go unnoticed.
+func ParseHandlers(data []byte) (map[string]HandlerOptions, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		var config struct {
+			Handlers map[string]HandlerOptions `json:"handlers"`
+		}
+		if err := decoder.Decode(&config); err != nil {
+			return nil, err
+		}
+		return config.Handlers, nil
+	}
+
+	var handlers map[string]HandlerOptions
+	format := ""
+	// The indentations of the formats and of their options, -1 until seen
+	formatIndent, optionIndent := -1, -1
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if line[indent] == '\t' {
+			return nil, fmt.Errorf("line %d is indented with a tab", i+1)
+		}
+		key, value, ok := strings.Cut(line[indent:], ":")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d isn't a key: value", i+1)
+		}
+
+		switch {
+		case indent == 0:
+			if key != "handlers" {
+				return nil, fmt.Errorf("line %d: unknown key %q, expected handlers", i+1, key)
+			}
+			if value != "" {
+				return nil, fmt.Errorf("line %d: handlers is a map, expected its formats on the next lines", i+1)
+			}
+			handlers = map[string]HandlerOptions{}
+			formatIndent, optionIndent = -1, -1
+		case handlers == nil:
+			return nil, fmt.Errorf("line %d is indented outside of handlers", i+1)
+		case formatIndent < 0 || indent == formatIndent:
+			if value != "" {
+				return nil, fmt.Errorf("line %d: %s is a map, expected its options on the next lines", i+1, key)
+			}
+			formatIndent, format = indent, key
+			handlers[format] = HandlerOptions{}
+		case indent > formatIndent && (optionIndent < 0 || indent == optionIndent):
+			optionIndent = indent
+			scalar, err := yamlScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			options := handlers[format]
+			switch key {
+			case "name_encoding":
+				options.NameEncoding = scalar
+			case "use_fname", "xattrs":
+				flag, err := strconv.ParseBool(scalar)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s is %s, expected true or false", i+1, key, value)
+				}
+				if key == "use_fname" {
+					options.UseFName = flag
+				} else {
+					options.Xattrs = flag
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unknown key %q, expected name_encoding, use_fname or xattrs", i+1, key)
+			}
+			handlers[format] = options
+		default:
+			return nil, fmt.Errorf("line %d isn't indented as the formats or their options", i+1)
+		}
+	}
+	return handlers, nil
+}
+
+// cp437 is the second half of code page 437, the encoding of the zip entry
+// names before UTF-8
+const cp437 = "ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒáíóúñÑªº¿⌐¬½¼¡«»" +
+	"░▒▓│┤╡╢╖╕╣║╗╝╜╛┐└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+	"αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■\u00a0"
+
+var cp437Runes = []rune(cp437)
+
+// decodeNames decodes the names of the entries of reader not flagged as
+// UTF-8 from encoding, a HandlerOptions.NameEncoding
+func decodeNames(reader *zip.Reader, encoding string) {
+	for _, file := range reader.File {
+		if file.NonUTF8 {
+			file.Name = decodeName(file.Name, encoding)
+		}
+	}
+}
+
+func decodeName(name string, encoding string) string {
+	if encoding == "" {
+		return name
+	}
+	var decoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch b := name[i]; {
+		case b < 0x80:
+			decoded.WriteByte(b)
+		case encoding == "cp437":
+			decoded.WriteRune(cp437Runes[b-0x80])
+		default:
+			decoded.WriteRune(rune(b))
+		}
+	}
+	return decoded.String()
+}
+
+// xattrs returns the extended attributes of the tar member of header, nil
+// when it has none
+func xattrs(header *tar.Header) map[string]string {
+	var attrs map[string]string
+	for key, value := range header.PAXRecords {
+		if name := strings.TrimPrefix(key, "SCHILY.xattr."); name != key {
+			if attrs == nil {
+				attrs = map[string]string{}
+			}
+			attrs[name] = value
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,40 @@
+package catzip
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHandlers(t *testing.T) {
+	expected := map[string]HandlerOptions{
+		"zip": {NameEncoding: "cp437"},
+		"gz":  {UseFName: true},
+		"tar": {},
+	}
+	for name, data := range map[string]string{
+		"json":                 `{"handlers": {"zip": {"name_encoding": "cp437"}, "gz": {"use_fname": true}, "tar": {}}}`,
+		"yaml":                 "---\nhandlers:  # by format\n  zip:\n    name_encoding: 'cp437'\n\n  gz:\n    use_fname: true\n  tar:\n",
+		"yaml indented deeper": "handlers:\n    zip:\n        name_encoding: \"cp437\"\n    gz:\n        use_fname: true\n    tar:\n",
+	} {
+		handlers, err := ParseHandlers([]byte(data))
+		if err != nil || !reflect.DeepEqual(handlers, expected) {
+			t.Errorf("%s: %v, %v", name, handlers, err)
+		}
+	}
+
+	for name, data := range map[string]string{
+		"unknown json key":   `{"handlers": {"zip": {"password": "secret"}}}`,
+		"unknown key":        "handlers:\n  zip:\n    password: secret\n",
+		"unknown section":    "zip:\n  name_encoding: cp437\n",
+		"not a flag":         "handlers:\n  gz:\n    use_fname: sometimes\n",
+		"tab":                "handlers:\n\tzip:\n",
+		"option outside":     "  zip:\n",
+		"format with value":  "handlers:\n  zip: cp437\n",
+		"misaligned option":  "handlers:\n  gz:\n    use_fname: true\n     xattrs: true\n",
+		"unterminated quote": "handlers:\n  zip:\n    name_encoding: \"cp437\n",
+	} {
+		if handlers, err := ParseHandlers([]byte(data)); err == nil {
+			t.Errorf("%s: parsed %v", name, handlers)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package catzip
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"sort"
+	"sync"
+)
+
+// HashOff disables hashing the extracted files
+const HashOff = "off"
+
+// HashAlgorithms lists the accepted Options.Hash values besides HashOff
+func HashAlgorithms() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"sha256": sha256.New,
+	"xxh3":   func() hash.Hash { return newXXH3() },
+}
+
+// parseHash validates a hash algorithm name, returning nil when hashing is off
+func parseHash(name string) (func() hash.Hash, error) {
+	if name == HashOff || name == "" {
+		return nil, nil
+	}
+	newHash, ok := hashAlgorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q", name)
+	}
+	return newHash, nil
+}
+
+// sideHasher hashes chunks on its own goroutine so hashing doesn't hold up
+// the writes, chunks are given back to the pool once hashed
+type sideHasher struct {
+	chunks chan []byte
+	sum    chan []byte
+}
+
+func startSideHasher(h hash.Hash, depth int, pool *sync.Pool) *sideHasher {
+	s := &sideHasher{
+		chunks: make(chan []byte, depth),
+		sum:    make(chan []byte, 1),
+	}
+	go func() {
+		for chunk := range s.chunks {
+			h.Write(chunk)
+			pool.Put(chunk[:cap(chunk)])
+		}
+		s.sum <- h.Sum(nil)
+	}()
+	return s
+}
+
+// Sum waits for the pending chunks and returns the hash
+func (s *sideHasher) Sum() []byte {
+	close(s.chunks)
+	return <-s.sum
+}
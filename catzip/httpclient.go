@@ -0,0 +1,66 @@
+package catzip
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// HTTPConfig is how the remote inputs and the credential providers reach
+// their servers, through a proxy and with a private CA on corporate networks
+type HTTPConfig struct {
+	// http, https or socks5 URL, "" uses HTTPS_PROXY, HTTP_PROXY and
+	// NO_PROXY
+	Proxy string
+	// PEM certificates trusted on top of the system ones
+	CACertFile string
+	// PEM certificate and key sent to the servers asking for one
+	ClientCertFile string
+	ClientKeyFile  string
+	// Accept any server certificate, for tests against self-signed ones
+	InsecureSkipVerify bool
+}
+
+// Client returns an http.Client using c
+func (c HTTPConfig) Client() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q, expected a URL like http://proxy:3128", c.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	config := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CACertFile != "" {
+		pem, err := os.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificate in %s", c.CACertFile)
+		}
+		config.RootCAs = pool
+	}
+	switch {
+	case c.ClientCertFile != "" && c.ClientKeyFile != "":
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	case c.ClientCertFile != "" || c.ClientKeyFile != "":
+		return nil, errors.New("ClientCertFile and ClientKeyFile go together")
+	}
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
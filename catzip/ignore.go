@@ -0,0 +1,138 @@
+package catzip
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a pattern of an ignore file, read like the ones of
+// .gitignore
+type ignoreRule struct {
+	// Of the ignore file, the paths are matched relative to it
+	dir string
+	// Slash separated, each matched with path.Match and ** with any number
+	// of them
+	parts    []string
+	negate   bool
+	dirOnly  bool
+	anchored bool // Matched from dir only, rather than at any depth
+}
+
+// ignoreRules are the rules of the ignore files of a directory and of its
+// parents, in the order they apply
+type ignoreRules []ignoreRule
+
+// readIgnore adds the rules of the ignore file of dir, if it has one, to
+// the ones of its parents. The bad patterns are logged and left out.
+func (opts walkOptions) readIgnore(fsys fs.FS, dir string, parents ignoreRules) ignoreRules {
+	if opts.ignoreFile == "" {
+		return parents
+	}
+	data, name, err := readDirFile(fsys, dir, opts.ignoreFile)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			opts.logger.Printf("unable to read %s: %v", name, err)
+		}
+		return parents
+	}
+
+	// Appended to a copy, the parents are shared by the other directories
+	rules := parents[:len(parents):len(parents)]
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		pattern := strings.TrimRight(scanner.Text(), " \t\r")
+		if pattern == "" || pattern[0] == '#' {
+			continue
+		}
+		rule := ignoreRule{dir: filepath.Clean(dir)}
+		if pattern[0] == '!' {
+			rule.negate = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		rule.anchored = strings.Contains(pattern, "/")
+		rule.parts = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+
+		bad := pattern == ""
+		for _, part := range rule.parts {
+			if _, err := path.Match(part, ""); err != nil {
+				bad = true
+			}
+		}
+		if bad {
+			opts.logger.Printf("line %d of %s has a bad pattern %q, it is left out", line, name, scanner.Text())
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// readDirFile reads the file name of the directory dir of the inputs,
+// returning its path too
+func readDirFile(fsys fs.FS, dir, name string) ([]byte, string, error) {
+	if isOSInputs(fsys) {
+		name = filepath.Join(dir, name)
+		if err := checkInput(fsys, name); err != nil {
+			return nil, name, err
+		}
+		data, err := os.ReadFile(name)
+		return data, name, err
+	}
+	name = path.Join(dir, name)
+	data, err := fs.ReadFile(fsys, name)
+	return data, name, err
+}
+
+// ignores tells whether the file or directory at p, under the directories
+// of the rules, is left out. The last rule matching it decides.
+func (rules ignoreRules) ignores(p string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel := filepath.ToSlash(p)
+		if rule.dir != "." {
+			rel = strings.TrimPrefix(strings.TrimPrefix(rel, filepath.ToSlash(rule.dir)), "/")
+		}
+		parts := rule.parts
+		if !rule.anchored {
+			parts = append([]string{"**"}, parts...)
+		}
+		if matchIgnore(parts, strings.Split(rel, "/")) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func matchIgnore(pattern, names []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(names); i++ {
+				if matchIgnore(pattern[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], names[0]); !ok {
+			return false
+		}
+		pattern, names = pattern[1:], names[1:]
+	}
+	return len(names) == 0
+}
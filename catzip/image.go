@@ -0,0 +1,186 @@
+package catzip
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// handleImage extracts the flattened root filesystem of each image of a
+// docker save or OCI layout tarball like the members of a tar file. The
+// layers are read twice, once for their headers and once for the paths they
+// leave.
+func (r *run) handleImage(f string) error {
+	images, err := flattenImages(r.in, f)
+	if err != nil {
+		return fmt.Errorf("unable to read image: %w", err)
+	}
+
+	destination, err := filepath.Abs(r.opts.OutDir)
+	if err != nil {
+		return fmt.Errorf("unable to find absolute path for dir %s: %w", r.opts.OutDir, err)
+	}
+
+	total := 0
+	for _, image := range images {
+		total += len(image.entries)
+	}
+	archive := manifestArchive{Path: f}
+	n := 0
+	for _, image := range images {
+		// What each layer leaves, by position in it
+		layers := map[string]map[int]*EntryInfo{}
+		for _, e := range image.entries {
+			if layers[e.layer] == nil {
+				layers[e.layer] = map[int]*EntryInfo{}
+			}
+			layers[e.layer][e.pos] = &EntryInfo{
+				Archive: f,
+				Name:    e.name,
+				Layer:   e.layer,
+				Size:    uint64(e.header.Size),
+				Mode:    e.header.FileInfo().Mode(),
+				ModTime: e.header.ModTime,
+				Entry:   n + 1,
+				Entries: total,
+				index:   n,
+			}
+			n++
+		}
+		for _, member := range image.layers {
+			if len(layers[member]) == 0 {
+				continue
+			}
+			if err := r.extractLayer(f, member, layers[member], destination, &archive); err != nil {
+				return err
+			}
+			delete(layers, member)
+		}
+	}
+	r.manifest.add(archive)
+	return nil
+}
+
+// extractLayer extracts the members of the layer at the positions of keep
+func (r *run) extractLayer(f, member string, keep map[int]*EntryInfo, destination string, archive *manifestArchive) error {
+	reader, closer, err := openLayer(r.in, f, member)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for pos := 0; len(keep) > 0; pos++ {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return fmt.Errorf("layer %s changed while reading it", member)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read layer %s: %w", member, err)
+		}
+		entry := keep[pos]
+		if entry == nil {
+			continue
+		}
+		delete(keep, pos)
+
+		if reason := unsupportedTarReason(header); reason != "" {
+			r.skipEntry(entry, reason)
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		name := r.renamed(entry.Name)
+		if name == "" {
+			r.skipEntry(entry, "renamed to an empty name")
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		isDir := header.Typeflag == tar.TypeDir
+		if !isDir {
+			var ok bool
+			if name, ok, err = r.applyPolicy(entry, name); err != nil {
+				return err
+			}
+			if !ok {
+				archive.Entries = append(archive.Entries, entry)
+				continue
+			}
+		}
+
+		open := func() (io.ReadCloser, error) { return io.NopCloser(reader), nil }
+		if err := r.extractEntry(name, entry, destination, isDir, header.Size, open); err != nil {
+			return fmt.Errorf("unable to extract file inside archive: %w", err)
+		}
+		if entry.Output != "" {
+			archive.Entries = append(archive.Entries, entry)
+		}
+	}
+	return nil
+}
+
+func listImage(fsys fs.FS, name string) ([]listedEntry, error) {
+	images, err := flattenImages(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	entries := []listedEntry{}
+	for _, image := range images {
+		for _, e := range image.entries {
+			entries = append(entries, listedEntry{
+				name:    e.name,
+				size:    e.header.Size,
+				modTime: e.header.ModTime,
+				dir:     e.header.Typeflag == tar.TypeDir,
+			})
+		}
+	}
+	return entries, nil
+}
+
+func countImageEntries(fsys fs.FS, name string) (int, error) {
+	entries, err := listImage(fsys, name)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, entry := range entries {
+		if !entry.dir {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// openImageEntry opens the file name of the flattened root filesystem of the
+// first image of the tarball at path having it, or returns nil when none has
+func openImageEntry(fsys fs.FS, path, name string) (io.ReadCloser, error) {
+	images, err := flattenImages(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		for _, e := range image.entries {
+			if e.name != name || e.header.Typeflag == tar.TypeDir {
+				continue
+			}
+			if reason := unsupportedTarReason(e.header); reason != "" {
+				return nil, fmt.Errorf("%s: %s", name, reason)
+			}
+			reader, closer, err := openLayer(fsys, path, e.layer)
+			if err != nil {
+				return nil, err
+			}
+			for pos := 0; pos <= e.pos; pos++ {
+				if _, err := reader.Next(); err != nil {
+					closer.Close()
+					return nil, fmt.Errorf("layer %s: %w", e.layer, unexpectedEOF(err))
+				}
+			}
+			return readCloser{reader, closer}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,323 @@
+package catzip
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Container images saved as tarballs, by docker save (manifest.json) or as
+// OCI layouts (index.json and blobs). Their layers are tar files, compressed
+// or not, applied bottom first: a .wh.name member deletes name from the
+// layers below and a .wh..wh..opq member empties its directory.
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+	// manifest.json, index.json and the OCI manifests are read whole
+	imageMaxJSON = 16 << 20
+)
+
+// Media types of the multi-platform images
+const (
+	ociIndex   = "application/vnd.oci.image.index.v1+json"
+	dockerList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// imageEntry is a path of a flattened root filesystem, from the layer that
+// last wrote it
+type imageEntry struct {
+	name   string // Cleaned, without a leading / or ./
+	layer  string // Tarball member
+	pos    int    // Of the member in the layer
+	header *tar.Header
+}
+
+// imageRootfs is the flattened root filesystem of an image of the tarball
+type imageRootfs struct {
+	layers  []string
+	entries []*imageEntry // In layer order
+}
+
+// flattenImages reads the layers of every image of the tarball name, the
+// first platform of multi-platform ones, and applies them
+func flattenImages(fsys fs.FS, name string) ([]imageRootfs, error) {
+	images, err := imageLayers(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	var rootfs []imageRootfs
+	for _, layers := range images {
+		entries, err := flattenLayers(fsys, name, layers)
+		if err != nil {
+			return nil, err
+		}
+		rootfs = append(rootfs, imageRootfs{layers: layers, entries: entries})
+	}
+	return rootfs, nil
+}
+
+// imageLayers returns the layer members of each image of the tarball, bottom
+// first
+func imageLayers(fsys fs.FS, name string) ([][]string, error) {
+	var docker []struct{ Layers []string }
+	err := readImageJSON(fsys, name, "manifest.json", &docker)
+	if err == nil {
+		images := make([][]string, 0, len(docker))
+		for _, image := range docker {
+			layers := make([]string, len(image.Layers))
+			for i, layer := range image.Layers {
+				layers[i] = cleanImagePath(layer)
+			}
+			images = append(images, layers)
+		}
+		return images, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var index ociManifest
+	if err := readImageJSON(fsys, name, "index.json", &index); errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.New("not a docker save or OCI layout tarball, it has no manifest.json or index.json")
+	} else if err != nil {
+		return nil, err
+	}
+	var images [][]string
+	for _, descriptor := range index.Manifests {
+		layers, err := ociLayers(fsys, name, descriptor, 0)
+		if err != nil {
+			return nil, err
+		}
+		if layers != nil {
+			images = append(images, layers)
+		}
+	}
+	return images, nil
+}
+
+// ociManifest has the fields of both the OCI indexes and manifests
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Platform  *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform"`
+}
+
+// member is the path of the blob of the descriptor in the tarball
+func (d ociDescriptor) member() (string, error) {
+	algorithm, hex, ok := strings.Cut(d.Digest, ":")
+	if !ok || algorithm == "" || hex == "" || strings.ContainsAny(d.Digest, "/\\") {
+		return "", fmt.Errorf("invalid digest %q", d.Digest)
+	}
+	return "blobs/" + algorithm + "/" + hex, nil
+}
+
+// ociLayers returns the layers of the image manifest of descriptor, nil for
+// an attestation. Indexes, which can be nested, give their manifest for this
+// platform, or their first one.
+func ociLayers(fsys fs.FS, name string, descriptor ociDescriptor, depth int) ([]string, error) {
+	if depth > 8 {
+		return nil, errors.New("OCI indexes nested too deep")
+	}
+	member, err := descriptor.member()
+	if err != nil {
+		return nil, err
+	}
+	var manifest ociManifest
+	if err := readImageJSON(fsys, name, member, &manifest); err != nil {
+		return nil, err
+	}
+
+	if manifest.MediaType == ociIndex || manifest.MediaType == dockerList || descriptor.MediaType == ociIndex || descriptor.MediaType == dockerList {
+		var chosen *ociDescriptor
+		for i, d := range manifest.Manifests {
+			p := d.Platform
+			if p != nil && p.OS == "unknown" {
+				continue // Build attestations
+			}
+			if chosen == nil {
+				chosen = &manifest.Manifests[i]
+			}
+			if p != nil && p.OS == "linux" && p.Architecture == runtime.GOARCH {
+				chosen = &manifest.Manifests[i]
+				break
+			}
+		}
+		if chosen == nil {
+			return nil, fmt.Errorf("index %s has no image manifest", descriptor.Digest)
+		}
+		return ociLayers(fsys, name, *chosen, depth+1)
+	}
+
+	if descriptor.Platform != nil && descriptor.Platform.OS == "unknown" {
+		return nil, nil
+	}
+	layers := make([]string, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		member, err := layer.member()
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, member)
+	}
+	return layers, nil
+}
+
+// readImageJSON decodes the member of the tarball name into v
+func readImageJSON(fsys fs.FS, name, member string, v any) error {
+	reader, closer, err := openImageMember(fsys, name, member)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	b, err := io.ReadAll(io.LimitReader(reader, imageMaxJSON))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %w", member, err)
+	}
+	return nil
+}
+
+// openImageMember opens the member of the tarball name, fs.ErrNotExist when
+// it has none. Skipping the members before it seeks over them.
+func openImageMember(fsys fs.FS, name, member string) (io.Reader, io.Closer, error) {
+	reader, closer, err := openTar(fsys, name, kindTar)
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			closer.Close()
+			return nil, nil, fmt.Errorf("%s: %w", member, fs.ErrNotExist)
+		}
+		if err != nil {
+			closer.Close()
+			return nil, nil, err
+		}
+		if cleanImagePath(header.Name) == member && (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA) {
+			return reader, closer, nil
+		}
+	}
+}
+
+// openLayer opens the layer member of the tarball name, decompressed
+func openLayer(fsys fs.FS, name, member string) (*tar.Reader, io.Closer, error) {
+	reader, closer, err := openImageMember(fsys, name, member)
+	if err != nil {
+		return nil, nil, fmt.Errorf("layer %w", err)
+	}
+	layer, err := sniffDecompressor(reader)
+	if err != nil {
+		closer.Close()
+		return nil, nil, fmt.Errorf("layer %s: %w", member, err)
+	}
+	return tar.NewReader(layer), closers{layer, closer}, nil
+}
+
+// flattenLayers applies the layers of an image of the tarball name, reading
+// their headers, and returns the paths left in layer order
+func flattenLayers(fsys fs.FS, name string, layers []string) ([]*imageEntry, error) {
+	paths := map[string]*imageEntry{}
+	for _, member := range layers {
+		reader, closer, err := openLayer(fsys, name, member)
+		if err != nil {
+			return nil, err
+		}
+
+		// The whiteouts only apply to the layers below, wherever they are
+		// in this one
+		var added []*imageEntry
+		var deleted, emptied []string
+		for pos := 0; ; pos++ {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				closer.Close()
+				return nil, fmt.Errorf("layer %s: %w", member, err)
+			}
+			p := cleanImagePath(header.Name)
+			dir, base := path.Split(p)
+			switch {
+			case p == "":
+			case base == whiteoutOpaque:
+				emptied = append(emptied, strings.TrimSuffix(dir, "/"))
+			case strings.HasPrefix(base, whiteoutPrefix):
+				deleted = append(deleted, dir+strings.TrimPrefix(base, whiteoutPrefix))
+			default:
+				added = append(added, &imageEntry{name: p, layer: member, pos: pos, header: header})
+			}
+		}
+		closer.Close()
+
+		for _, p := range deleted {
+			delete(paths, p)
+			deleteImageTree(paths, p)
+		}
+		for _, p := range emptied {
+			deleteImageTree(paths, p)
+		}
+		for _, entry := range added {
+			if entry.header.Typeflag != tar.TypeDir {
+				// A file replacing a directory of the layers below
+				deleteImageTree(paths, entry.name)
+			}
+			paths[entry.name] = entry
+		}
+	}
+
+	entries := make([]*imageEntry, 0, len(paths))
+	for _, entry := range paths {
+		entries = append(entries, entry)
+	}
+	position := map[string]int{}
+	for i, member := range layers {
+		if _, ok := position[member]; !ok {
+			position[member] = i
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.layer != b.layer {
+			return position[a.layer] < position[b.layer]
+		}
+		return a.pos < b.pos
+	})
+	return entries, nil
+}
+
+// deleteImageTree deletes what is under the directory dir, "" being the
+// root
+func deleteImageTree(paths map[string]*imageEntry, dir string) {
+	prefix := dir + "/"
+	for p := range paths {
+		if dir == "" || strings.HasPrefix(p, prefix) {
+			delete(paths, p)
+		}
+	}
+}
+
+// cleanImagePath turns the /etc, ./etc and etc/ of the tar members into etc
+func cleanImagePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
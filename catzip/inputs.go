@@ -0,0 +1,141 @@
+package catzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// osInputs reads the inputs from the operating system, unlike os.DirFS names
+// are OS paths so the paths logged and recorded don't change when
+// Options.InputFS isn't set. With a root, the files whose real path isn't
+// under it aren't read.
+type osInputs struct{ root string }
+
+var errOutsideInputRoot = errors.New("not under the input root")
+
+func (o osInputs) Open(name string) (fs.File, error) {
+	if err := o.check("open", name); err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+func (o osInputs) Stat(name string) (fs.FileInfo, error) {
+	if err := o.check("stat", name); err != nil {
+		return nil, err
+	}
+	return os.Stat(name)
+}
+
+// check makes sure name, with its links resolved, is under the root
+func (o osInputs) check(op, name string) error {
+	if o.root == "" {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(o.root)
+	if err == nil {
+		root, err = filepath.Abs(root)
+	}
+	if err != nil {
+		return err
+	}
+	path, err := filepath.EvalSymlinks(name)
+	if err == nil {
+		path, err = filepath.Abs(path)
+	}
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(root, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return &fs.PathError{Op: op, Path: name, Err: errOutsideInputRoot}
+	}
+	return nil
+}
+
+// checkInput makes sure the OS input name can be read, for the files opened
+// by path rather than through fsys
+func checkInput(fsys fs.FS, name string) error {
+	if o, ok := fsys.(osInputs); ok {
+		return o.check("open", name)
+	}
+	return nil
+}
+
+func inputFS(opts Options) fs.FS {
+	fsys := opts.InputFS
+	if fsys == nil {
+		fsys = osInputs{root: opts.InputRoot}
+	}
+	if len(opts.URLs) > 0 || isBucketDir(opts.Dir) {
+		return newURLInputs(opts, fsys)
+	}
+	return fsys
+}
+
+// closeInputs ends the sessions the inputs of inputFS keep open
+func closeInputs(fsys fs.FS) {
+	if u, ok := fsys.(*urlInputs); ok {
+		u.close()
+	}
+}
+
+func isOSInputs(fsys fs.FS) bool {
+	_, ok := fsys.(osInputs)
+	return ok
+}
+
+func walkDir(fsys fs.FS, root string, fn fs.WalkDirFunc) error {
+	if isOSInputs(fsys) {
+		return filepath.WalkDir(root, fn)
+	}
+	return fs.WalkDir(fsys, root, fn)
+}
+
+// openZipFS opens a zip file from fsys, a split one from all its parts
+func openZipFS(fsys fs.FS, name string) (*zip.Reader, io.Closer, error) {
+	if isSplitZip(fsys, name) {
+		return openSplitZip(fsys, name)
+	}
+	readerAt, size, closer, err := openReaderAt(fsys, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+	registerDecompressors(reader)
+	return reader, closer, nil
+}
+
+// openReaderAt opens the file name of fsys to read it at random offsets,
+// files that can't be are read into memory
+func openReaderAt(fsys fs.FS, name string) (io.ReaderAt, int64, io.Closer, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			file.Close()
+			return nil, 0, nil, err
+		}
+		return bytes.NewReader(data), int64(len(data)), file, nil
+	}
+	return readerAt, info.Size(), file, nil
+}
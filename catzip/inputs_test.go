@@ -0,0 +1,69 @@
+package catzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeZip(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for file, content := range files {
+		f, err := w.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A link under the input root to an archive out of it isn't read, memory
+// mapped or not
+func TestInputRootLink(t *testing.T) {
+	for _, mmap := range []bool{false, true} {
+		dir := t.TempDir()
+		root := filepath.Join(dir, "root")
+		if err := os.MkdirAll(root, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeZip(t, filepath.Join(root, "a.zip"), map[string]string{"a.log": "a\n"})
+		writeZip(t, filepath.Join(dir, "secret.zip"), map[string]string{"secret.log": "secret\n"})
+		if err := os.Symlink(filepath.Join(dir, "secret.zip"), filepath.Join(root, "link.zip")); err != nil {
+			t.Skip(err)
+		}
+
+		opts := DefaultOptions()
+		opts.Dir = root
+		opts.InputRoot = root
+		opts.Ext = ".zip"
+		opts.Mmap = mmap
+		opts.OutDir = filepath.Join(dir, "out")
+		opts.Logger = log.New(io.Discard, "", 0)
+		if err := os.MkdirAll(opts.OutDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Run(opts)
+		if !errors.Is(err, errOutsideInputRoot) {
+			t.Errorf("mmap %v: got %v, expected the link to be refused", mmap, err)
+		}
+		if _, err := os.Stat(filepath.Join(opts.OutDir, "secret.log")); err == nil {
+			t.Errorf("mmap %v: the entry of the link was extracted", mmap)
+		}
+		if cat, _ := os.ReadFile(filepath.Join(opts.OutDir, opts.CatFileName)); bytes.Contains(cat, []byte("secret")) {
+			t.Errorf("mmap %v: cat file is %q", mmap, cat)
+		}
+	}
+}
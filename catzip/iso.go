@@ -0,0 +1,144 @@
+package catzip
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// handleIso extracts the files of an ISO 9660 image like the entries of a
+// zip file
+func (r *run) handleIso(f string) error {
+	reader, err := openIso(r.in, f)
+	if err != nil {
+		return fmt.Errorf("unable to read %s file: %w", r.opts.inputExt(f), err)
+	}
+	defer reader.Close()
+
+	destination, err := filepath.Abs(r.opts.OutDir)
+	if err != nil {
+		return fmt.Errorf("unable to find absolute path for dir %s: %w", r.opts.OutDir, err)
+	}
+
+	archive := manifestArchive{Path: f}
+	for i, file := range reader.files {
+		entry := &EntryInfo{
+			Archive: f,
+			Name:    file.entryName(),
+			Size:    file.size,
+			Mode:    file.mode,
+			ModTime: file.modTime,
+			Entry:   i + 1,
+			Entries: len(reader.files),
+			index:   i,
+		}
+		if reason := unsupportedIsoReason(file); reason != "" {
+			r.skipEntry(entry, reason)
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		name := r.renamed(entry.Name)
+		if name == "" {
+			r.skipEntry(entry, "renamed to an empty name")
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		if !file.dir {
+			var ok bool
+			if name, ok, err = r.applyPolicy(entry, name); err != nil {
+				return err
+			}
+			if !ok {
+				archive.Entries = append(archive.Entries, entry)
+				continue
+			}
+		}
+
+		open := func() (io.ReadCloser, error) { return reader.open(file) }
+		if err := r.extractEntry(name, entry, destination, file.dir, int64(file.size), open); err != nil {
+			return fmt.Errorf("unable to extract file inside archive: %w", err)
+		}
+		if entry.Output != "" {
+			archive.Entries = append(archive.Entries, entry)
+		}
+	}
+	r.manifest.add(archive)
+	return nil
+}
+
+// unsupportedIsoReason tells why file can't be extracted, or "" when it can
+func unsupportedIsoReason(file *isoFile) string {
+	switch {
+	case file.mode&fs.ModeSymlink != 0:
+		return "link to " + file.link
+	case file.mode&fs.ModeIrregular != 0:
+		return "not a regular file"
+	case file.interleaved:
+		return "interleaved"
+	case file.truncated:
+		return "past the end of the image"
+	}
+	return ""
+}
+
+func listIso(fsys fs.FS, name string) ([]listedEntry, error) {
+	reader, err := openIso(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	entries := make([]listedEntry, 0, len(reader.files))
+	for _, file := range reader.files {
+		entries = append(entries, listedEntry{
+			name:    file.entryName(),
+			size:    int64(file.size),
+			modTime: file.modTime,
+			dir:     file.dir,
+		})
+	}
+	return entries, nil
+}
+
+func countIsoEntries(fsys fs.FS, name string) (int, error) {
+	entries, err := listIso(fsys, name)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, entry := range entries {
+		if !entry.dir {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// openIsoEntry opens the file name of the ISO image at path, or returns nil
+// when it has none
+func openIsoEntry(fsys fs.FS, path, name string) (io.ReadCloser, error) {
+	reader, err := openIso(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range reader.files {
+		if file.entryName() != name || file.dir {
+			continue
+		}
+		if reason := unsupportedIsoReason(file); reason != "" {
+			reader.Close()
+			return nil, fmt.Errorf("%s: %s", name, reason)
+		}
+		rc, err := reader.open(file)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		return readCloser{rc, reader}, nil
+	}
+	reader.Close()
+	return nil, nil
+}
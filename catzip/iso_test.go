@@ -0,0 +1,181 @@
+package catzip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// The vectors under testdata are gzipped images from bsdtar --format
+// iso9660: test.rr.iso with Rock Ridge and Joliet, deep enough for Rock
+// Ridge to move d1/.../d8 under rr_moved, test.joliet.iso with Joliet
+// only and test.plain.iso with neither.
+
+// isoContents returns the content of the files of the images, with the
+// names of the plain one when upper is set. Directories have nil.
+func isoContents(upper bool) map[string][]byte {
+	contents := map[string][]byte{
+		"a.log":                 testLines(15001),
+		"A long file name.text": []byte("long\n"),
+		"dir":                   nil,
+		"dir/code":              testCode(3001),
+		"empty":                 {},
+	}
+	if upper {
+		contents["A_LONG_F.TEX"] = contents["A long file name.text"]
+		delete(contents, "A long file name.text")
+		for _, name := range []string{"a.log", "dir", "dir/code", "empty"} {
+			contents[strings.ToUpper(name)] = contents[name]
+			delete(contents, name)
+		}
+	}
+	return contents
+}
+
+// isoRockRidgeContents adds the files only test.rr.iso has
+func isoRockRidgeContents() map[string][]byte {
+	contents := isoContents(false)
+	deep := "d1"
+	for i := 2; i <= 9; i++ {
+		contents[deep] = nil
+		deep += "/d" + string(rune('0'+i))
+	}
+	contents[deep] = nil
+	contents[deep+"/deep.txt"] = []byte("deep\n")
+	return contents
+}
+
+func TestIso(t *testing.T) {
+	for _, v := range []struct {
+		name     string
+		contents map[string][]byte
+		modes    map[string]fs.FileMode
+	}{
+		{"test.rr.iso", isoRockRidgeContents(), map[string]fs.FileMode{
+			"a.log": 0444, "dir": fs.ModeDir | 0555, "dir/code": 0555, "link": fs.ModeSymlink | 0555,
+		}},
+		{"test.joliet.iso", isoContents(false), map[string]fs.FileMode{"a.log": 0644, "dir": fs.ModeDir | 0755}},
+		{"test.plain.iso", isoContents(true), map[string]fs.FileMode{"A.LOG": 0644, "DIR": fs.ModeDir | 0755}},
+	} {
+		z, err := openIso(fstest.MapFS{v.name: {Data: readGzipTestdata(t, v.name)}}, v.name)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		seen := map[string]bool{}
+		for _, f := range z.files {
+			name := f.entryName()
+			seen[name] = true
+			if mode, ok := v.modes[name]; ok && f.mode != mode {
+				t.Errorf("%s: %s has mode %v", v.name, name, f.mode)
+			}
+			if !f.modTime.Equal(cpioModTime) {
+				t.Errorf("%s: %s modified %v", v.name, name, f.modTime)
+			}
+			if name == "link" {
+				if f.link != "a.log" || unsupportedIsoReason(f) == "" {
+					t.Errorf("%s: link to %q", v.name, f.link)
+				}
+				continue
+			}
+			content, ok := v.contents[name]
+			if !ok {
+				t.Errorf("%s: unexpected %s", v.name, name)
+				continue
+			}
+			if f.dir != (content == nil) {
+				t.Errorf("%s: %s is a directory: %v", v.name, name, f.dir)
+			}
+			if f.dir {
+				continue
+			}
+			if reason := unsupportedIsoReason(f); reason != "" {
+				t.Errorf("%s: %s unsupported: %s", v.name, name, reason)
+				continue
+			}
+			r, _ := z.open(f)
+			if data, err := io.ReadAll(r); err != nil || !bytes.Equal(data, content) {
+				t.Errorf("%s: %s has %d bytes, %v", v.name, name, len(data), err)
+			}
+		}
+		for name := range v.contents {
+			if !seen[name] {
+				t.Errorf("%s: no %s", v.name, name)
+			}
+		}
+		z.Close()
+	}
+
+	if _, err := openIso(fstest.MapFS{"a.iso": {Data: make([]byte, 40000)}}, "a.iso"); err == nil {
+		t.Error("no error opening zeros")
+	}
+}
+
+// errIsoShorter stands for an image cut after the content of its files,
+// which loses padding only
+var errIsoShorter = errors.New("cut after the content")
+
+func decodeIso(data []byte) error {
+	z, err := openIso(fstest.MapFS{"test.iso": {Data: data}}, "test.iso")
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+	for _, f := range z.files {
+		if f.truncated {
+			return errors.New(unsupportedIsoReason(f))
+		}
+		r, _ := z.open(f)
+		if _, err := readLimited(r, 1<<20); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestIsoCorrupt(t *testing.T) {
+	for _, name := range []string{"test.rr.iso", "test.joliet.iso", "test.plain.iso"} {
+		data := readGzipTestdata(t, name)
+		z, err := openIso(fstest.MapFS{name: {Data: data}}, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var end int64
+		for _, f := range z.files {
+			for _, extent := range f.extents {
+				if extent.size > 0 && extent.offset+extent.size > end {
+					end = extent.offset + extent.size
+				}
+			}
+		}
+		z.Close()
+
+		checkCorrupt(t, data, func(cut []byte) error {
+			err := decodeIso(cut)
+			if err == nil && int64(len(cut)) >= end {
+				return errIsoShorter
+			}
+			return err
+		})
+	}
+}
+
+func TestIsoRun(t *testing.T) {
+	out := runInMemory(t, ".iso", fstest.MapFS{"test.iso": {Data: readGzipTestdata(t, "test.rr.iso")}})
+	for name, content := range isoRockRidgeContents() {
+		if content == nil {
+			continue
+		}
+		if data, err := out.ReadFile("/out/" + name); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("extracted %s: %d bytes, %v", name, len(data), err)
+		}
+	}
+	for _, name := range out.Names() {
+		if strings.Contains(name, "rr_moved") || strings.HasSuffix(name, "/link") {
+			t.Errorf("wrote %s", name)
+		}
+	}
+}
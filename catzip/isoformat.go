@@ -0,0 +1,491 @@
+package catzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// A reader for ISO 9660 images, following ECMA-119. The names and modes come
+// from the Rock Ridge extensions when the image has them, else from the
+// Joliet tree, else from the plain ISO 9660 names without their version.
+
+const (
+	isoSector          = 2048
+	isoFirstDescriptor = 16
+	// Volume descriptors looked at before giving up on the terminator
+	isoMaxDescriptors = 64
+	// Directories larger than this are taken as corruption
+	isoMaxDir   = 64 << 20
+	isoMaxDepth = 256
+
+	isoFlagDir         = 0x02
+	isoFlagAssociated  = 0x04
+	isoFlagMultiExtent = 0x80
+)
+
+var errIsoCorrupt = errors.New("iso: corrupt image")
+
+type isoReader struct {
+	r      io.ReaderAt
+	size   int64
+	closer io.Closer
+	files  []*isoFile
+
+	blockSize     int64
+	rockRidge     bool
+	suspSkip      int
+	joliet        bool
+	visited       map[int64]bool // Directory extents, against loops
+	continuations int            // Rock Ridge continuation areas followed, against loops
+}
+
+type isoFile struct {
+	name        string // Path in the image
+	size        uint64
+	modTime     time.Time
+	mode        fs.FileMode
+	dir         bool
+	link        string // Target of Rock Ridge symlinks
+	extents     []isoExtent
+	interleaved bool
+	truncated   bool // Past the end of the image
+}
+
+type isoExtent struct {
+	offset, size int64
+}
+
+// isoRecord is a directory record with its Rock Ridge fields
+type isoRecord struct {
+	extent       int64 // Block
+	size         int64
+	modTime      time.Time
+	flags        byte
+	unitSize     byte
+	name         string
+	mode         fs.FileMode
+	hasMode      bool
+	link         string
+	hasLink      bool
+	childDir     int64 // Rock Ridge CL, the block of a relocated directory
+	relocated    bool  // Rock Ridge RE, listed where CL points to it
+	self, parent bool
+}
+
+func openIso(fsys fs.FS, name string) (*isoReader, error) {
+	readerAt, size, closer, err := openReaderAt(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	z := &isoReader{r: readerAt, size: size, closer: closer, visited: map[int64]bool{}}
+	if err = z.readTree(); err != nil {
+		closer.Close()
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *isoReader) Close() error {
+	return z.closer.Close()
+}
+
+// readTree finds the root directories in the volume descriptors and lists
+// the files under the one used
+func (z *isoReader) readTree() error {
+	var primary, joliet []byte
+	descriptor := make([]byte, isoSector)
+	for i := 0; i < isoMaxDescriptors; i++ {
+		if _, err := z.r.ReadAt(descriptor, int64(isoFirstDescriptor+i)*isoSector); err != nil {
+			return fmt.Errorf("iso: %w", unexpectedEOF(err))
+		}
+		if string(descriptor[1:6]) != "CD001" {
+			if i == 0 {
+				return errors.New("iso: not an ISO 9660 image")
+			}
+			return errIsoCorrupt
+		}
+		switch descriptor[0] {
+		case 1:
+			if primary == nil {
+				primary = append([]byte(nil), descriptor...)
+			}
+		case 2:
+			// Joliet is a supplementary descriptor with UCS-2 escapes
+			escapes := descriptor[88:91]
+			if joliet == nil && escapes[0] == '%' && escapes[1] == '/' && bytes.IndexByte([]byte("@CE"), escapes[2]) >= 0 {
+				joliet = append([]byte(nil), descriptor...)
+			}
+		case 255:
+			i = isoMaxDescriptors
+		}
+	}
+	if primary == nil {
+		return errors.New("iso: no primary volume descriptor")
+	}
+	z.blockSize = int64(binary.LittleEndian.Uint16(primary[128:]))
+	if z.blockSize == 0 || z.blockSize&(z.blockSize-1) != 0 || z.blockSize > isoSector {
+		return errIsoCorrupt
+	}
+
+	root, err := z.parseRecord(primary[156:190])
+	if err != nil {
+		return err
+	}
+	// Rock Ridge images start the system use of the root . record with SP
+	dir, err := z.readExtent(root.extent, root.size)
+	if err != nil {
+		return err
+	}
+	if len(dir) > 0 && int(dir[0]) <= len(dir) {
+		self := dir[:dir[0]]
+		if _, err := z.parseRecord(self); err == nil {
+			if su := z.systemUse(self); len(su) >= 7 && string(su[:2]) == "SP" && su[4] == 0xBE && su[5] == 0xEF {
+				z.rockRidge, z.suspSkip = true, int(su[6])
+			}
+		}
+	}
+	if !z.rockRidge && joliet != nil {
+		z.joliet = true
+		if root, err = z.parseRecord(joliet[156:190]); err != nil {
+			return err
+		}
+	}
+	if err = z.readDir(root, "", 0); err != nil {
+		return err
+	}
+
+	for _, file := range z.files {
+		for _, extent := range file.extents {
+			// Empty files may point anywhere
+			if extent.size > 0 && extent.offset+extent.size > z.size {
+				file.truncated = true
+			}
+		}
+	}
+	return nil
+}
+
+// readDir adds the files of the directory of rec under prefix
+func (z *isoReader) readDir(rec *isoRecord, prefix string, depth int) error {
+	if depth > isoMaxDepth || z.visited[rec.extent] {
+		return errIsoCorrupt
+	}
+	z.visited[rec.extent] = true
+	data, err := z.readExtent(rec.extent, rec.size)
+	if err != nil {
+		return err
+	}
+
+	var pending *isoFile // Multi-extent file missing its last record
+	for i := 0; i < len(data); {
+		length := int(data[i])
+		if length == 0 {
+			// Records don't cross sectors, the rest of this one is padding
+			i = (i/isoSector + 1) * isoSector
+			continue
+		}
+		if i+length > len(data) || length < 34 {
+			return errIsoCorrupt
+		}
+		record, err := z.parseRecord(data[i : i+length])
+		if err != nil {
+			return err
+		}
+		i += length
+		if record.self || record.parent || record.relocated || record.flags&isoFlagAssociated != 0 {
+			continue
+		}
+
+		name := prefix + record.name
+		if record.childDir > 0 {
+			// A deep directory moved elsewhere by Rock Ridge, its . record
+			// has its size
+			moved, err := z.readExtent(record.childDir, 256)
+			if err != nil {
+				return err
+			}
+			self, err := z.parseRecord(moved[:moved[0]])
+			if err != nil {
+				return err
+			}
+			record.extent, record.size, record.flags = self.extent, self.size, isoFlagDir
+		}
+
+		extent := isoExtent{offset: record.extent * z.blockSize, size: record.size}
+		if pending != nil {
+			if pending.name != name {
+				return errIsoCorrupt
+			}
+			pending.extents = append(pending.extents, extent)
+			pending.size += uint64(record.size)
+			if record.flags&isoFlagMultiExtent == 0 {
+				pending = nil
+			}
+			continue
+		}
+
+		file := &isoFile{
+			name:        name,
+			size:        uint64(record.size),
+			modTime:     record.modTime,
+			dir:         record.flags&isoFlagDir != 0,
+			link:        record.link,
+			interleaved: record.unitSize != 0,
+		}
+		switch {
+		case record.hasMode:
+			file.mode = record.mode
+		case file.dir:
+			file.mode = fs.ModeDir | 0755
+		default:
+			file.mode = 0644
+		}
+		if record.hasLink {
+			file.mode = file.mode&^fs.ModeType | fs.ModeSymlink
+		}
+		if file.dir {
+			file.size = 0
+		} else {
+			file.extents = []isoExtent{extent}
+		}
+		z.files = append(z.files, file)
+
+		if file.dir {
+			if err := z.readDir(record, name+"/", depth+1); err != nil {
+				return err
+			}
+			if z.rockRidge && name == "rr_moved" && z.files[len(z.files)-1] == file {
+				// Where Rock Ridge moves the deep directories, found through
+				// their CL records
+				z.files = z.files[:len(z.files)-1]
+			}
+		} else if record.flags&isoFlagMultiExtent != 0 {
+			pending = file
+		}
+	}
+	return nil
+}
+
+func (z *isoReader) readExtent(block, size int64) ([]byte, error) {
+	if size > isoMaxDir || block*z.blockSize+size > z.size {
+		return nil, errIsoCorrupt
+	}
+	data := make([]byte, size)
+	if _, err := z.r.ReadAt(data, block*z.blockSize); err != nil {
+		return nil, fmt.Errorf("iso: %w", unexpectedEOF(err))
+	}
+	return data, nil
+}
+
+func (z *isoReader) parseRecord(b []byte) (*isoRecord, error) {
+	if len(b) < 34 || int(b[0]) > len(b) || 33+int(b[32]) > len(b) {
+		return nil, errIsoCorrupt
+	}
+	rec := &isoRecord{
+		extent:   int64(binary.LittleEndian.Uint32(b[2:])),
+		size:     int64(binary.LittleEndian.Uint32(b[10:])),
+		modTime:  isoTime(b[18:25]),
+		flags:    b[25],
+		unitSize: b[26],
+	}
+	rawName := b[33 : 33+int(b[32])]
+	switch {
+	case len(rawName) == 1 && rawName[0] == 0:
+		rec.self = true
+	case len(rawName) == 1 && rawName[0] == 1:
+		rec.parent = true
+	case z.joliet:
+		rec.name = isoVersionless(jolietName(rawName))
+	default:
+		rec.name = isoVersionless(string(rawName))
+		if rec.flags&isoFlagDir == 0 {
+			// FILE. has no extension
+			rec.name = strings.TrimSuffix(rec.name, ".")
+		}
+	}
+	if z.rockRidge && !rec.self && !rec.parent {
+		su := z.systemUse(b)
+		if z.suspSkip > len(su) {
+			return nil, errIsoCorrupt
+		}
+		if err := z.readRockRidge(rec, su[z.suspSkip:]); err != nil {
+			return nil, err
+		}
+	}
+	if !rec.self && !rec.parent && (rec.name == "" || rec.name == "." || rec.name == ".." || strings.Contains(rec.name, "/")) {
+		// Kept out rather than letting it name another path
+		return nil, fmt.Errorf("iso: invalid file name %q", rec.name)
+	}
+	return rec, nil
+}
+
+// systemUse returns the system use area of the directory record b
+func (z *isoReader) systemUse(b []byte) []byte {
+	start := 33 + int(b[32])
+	if start%2 == 1 {
+		start++
+	}
+	if start > len(b) {
+		return nil
+	}
+	return b[start:]
+}
+
+// readRockRidge fills rec with the SUSP entries of su, following the
+// continuation areas
+func (z *isoReader) readRockRidge(rec *isoRecord, su []byte) error {
+	var name []byte
+	var hasName bool
+	var link []string
+	linkContinues := false
+	for len(su) >= 4 {
+		length := int(su[2])
+		if length < 4 || length > len(su) {
+			break
+		}
+		entry := su[:length]
+		su = su[length:]
+
+		switch string(entry[:2]) {
+		case "NM":
+			if length < 5 {
+				return errIsoCorrupt
+			}
+			flags := entry[4]
+			if flags&0x06 != 0 {
+				// The current or parent directory
+				continue
+			}
+			name = append(name, entry[5:]...)
+			hasName = true
+		case "PX":
+			if length >= 12 {
+				// The POSIX mode
+				unix := binary.LittleEndian.Uint32(entry[4:])
+				rec.mode = fs.FileMode(unix & 0777)
+				switch unix & 0170000 {
+				case 0040000:
+					rec.mode |= fs.ModeDir
+				case 0120000:
+					rec.mode |= fs.ModeSymlink
+				case 0100000:
+				default:
+					rec.mode |= fs.ModeIrregular
+				}
+				rec.hasMode = true
+			}
+		case "SL":
+			if length < 5 {
+				return errIsoCorrupt
+			}
+			rec.hasLink = true
+			for c := entry[5:]; len(c) >= 2; {
+				flags, n := c[0], int(c[1])
+				if 2+n > len(c) {
+					return errIsoCorrupt
+				}
+				text := string(c[2 : 2+n])
+				c = c[2+n:]
+				switch {
+				case flags&0x02 != 0:
+					text = "."
+				case flags&0x04 != 0:
+					text = ".."
+				case flags&0x08 != 0:
+					text = ""
+				}
+				if linkContinues && len(link) > 0 {
+					link[len(link)-1] += text
+				} else {
+					link = append(link, text)
+				}
+				linkContinues = flags&0x01 != 0
+			}
+		case "CL":
+			if length >= 12 {
+				rec.childDir = int64(binary.LittleEndian.Uint32(entry[4:]))
+			}
+		case "RE":
+			rec.relocated = true
+		case "CE":
+			if length < 28 {
+				return errIsoCorrupt
+			}
+			z.continuations++
+			if z.continuations > 1<<16 {
+				return errIsoCorrupt
+			}
+			block := int64(binary.LittleEndian.Uint32(entry[4:]))
+			offset := int64(binary.LittleEndian.Uint32(entry[12:]))
+			size := int64(binary.LittleEndian.Uint32(entry[20:]))
+			if offset+size > z.blockSize {
+				return errIsoCorrupt
+			}
+			area, err := z.readExtent(block, offset+size)
+			if err != nil {
+				return err
+			}
+			su = area[offset:]
+		case "ST":
+			su = nil
+		}
+	}
+	if hasName {
+		rec.name = string(name)
+	}
+	if rec.hasLink {
+		rec.link = strings.Join(link, "/")
+		if strings.HasPrefix(rec.link, "/") || len(link) > 0 && link[0] == "" {
+			rec.link = "/" + strings.TrimPrefix(rec.link, "/")
+		}
+	}
+	return nil
+}
+
+// isoTime decodes the 7 bytes recording date of directory records
+func isoTime(b []byte) time.Time {
+	if b[1] == 0 || b[2] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// isoVersionless cuts the ;1 version of the ISO 9660 names
+func isoVersionless(name string) string {
+	if i := strings.LastIndexByte(name, ';'); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// jolietName decodes the UCS-2 big endian names of Joliet
+func jolietName(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// open reads the content of f, from its extents one after the other
+func (z *isoReader) open(f *isoFile) (io.ReadCloser, error) {
+	readers := make([]io.Reader, len(f.extents))
+	for i, extent := range f.extents {
+		readers[i] = io.NewSectionReader(z.r, extent.offset, extent.size)
+	}
+	return io.NopCloser(io.MultiReader(readers...)), nil
+}
+
+// entryName returns the path of f in the image
+func (f *isoFile) entryName() string {
+	return path.Clean(f.name)
+}
@@ -0,0 +1,224 @@
+package catzip
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// inputKind is how the input files are read, it follows Options.Ext
+type inputKind int
+
+const (
+	kindZip inputKind = iota
+	kindGz
+	kindTar
+	kindTarGz
+	kindZst
+	kindBz2
+	kindXz
+	kindTarXz
+	kindTarZst
+	kindTarBz2
+	kindTarLz4
+	kind7z
+	kindRar
+	kindLz4
+	kindBr
+	kindSz
+	kindCpio
+	kindCpioGz
+	kindIso
+	kindDeb
+	kindRpm
+	kindImage // .tar with Options.Image
+	kindPlain // Matching no format with Options.Detect and an empty Ext
+)
+
+func kindOf(ext string) inputKind {
+	switch {
+	case ext == ".tar":
+		return kindTar
+	case ext == ".tgz" || strings.HasSuffix(ext, ".tar.gz"):
+		return kindTarGz
+	case ext == ".txz" || strings.HasSuffix(ext, ".tar.xz"):
+		return kindTarXz
+	case ext == ".tzst" || strings.HasSuffix(ext, ".tar.zst"):
+		return kindTarZst
+	case ext == ".tbz2" || ext == ".tbz" || strings.HasSuffix(ext, ".tar.bz2"):
+		return kindTarBz2
+	case strings.HasSuffix(ext, ".tar.lz4"):
+		return kindTarLz4
+	case filepath.Ext(ext) == ".cpio":
+		return kindCpio
+	case strings.HasSuffix(ext, ".cpio.gz"):
+		return kindCpioGz
+	case filepath.Ext(ext) == ".gz":
+		return kindGz
+	case filepath.Ext(ext) == ".zst":
+		return kindZst
+	case filepath.Ext(ext) == ".bz2":
+		return kindBz2
+	case filepath.Ext(ext) == ".xz":
+		return kindXz
+	case filepath.Ext(ext) == ".7z":
+		return kind7z
+	case filepath.Ext(ext) == ".rar":
+		return kindRar
+	case filepath.Ext(ext) == ".iso":
+		return kindIso
+	case filepath.Ext(ext) == ".deb":
+		return kindDeb
+	case filepath.Ext(ext) == ".rpm":
+		return kindRpm
+	case filepath.Ext(ext) == ".lz4":
+		return kindLz4
+	case filepath.Ext(ext) == ".br":
+		return kindBr
+	case filepath.Ext(ext) == ".sz":
+		return kindSz
+	}
+	return kindZip
+}
+
+// hasExt tells whether name has the extension ext, which can be a double
+// one like .tar.gz
+func hasExt(name string, ext string) bool {
+	if strings.Count(ext, ".") > 1 {
+		return len(name) > len(ext) && strings.HasSuffix(name, ext)
+	}
+	return filepath.Ext(name) == ext
+}
+
+// splitExts lists the extensions of Options.Ext, separated by commas
+func splitExts(ext string) []string {
+	var exts []string
+	for _, e := range strings.Split(ext, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			exts = append(exts, e)
+		}
+	}
+	return exts
+}
+
+// matchExt returns the longest of exts name has, so .tar.gz wins over .gz,
+// or "" when it has none
+func matchExt(name string, exts []string) string {
+	match := ""
+	for _, ext := range exts {
+		if len(ext) > len(match) && hasExt(name, ext) {
+			match = ext
+		}
+	}
+	return match
+}
+
+// inputExt is the extension of Ext the input file name has, or Ext when it
+// has none, for the errors
+func (o *Options) inputExt(name string) string {
+	if ext := matchExt(name, splitExts(o.Ext)); ext != "" {
+		return ext
+	}
+	return o.Ext
+}
+
+// kinds are the kinds of the extensions of Ext, kindImage for images
+func (o *Options) kinds() []inputKind {
+	if o.Image {
+		return []inputKind{kindImage}
+	}
+	var kinds []inputKind
+	for _, ext := range splitExts(o.Ext) {
+		kinds = append(kinds, kindOf(ext))
+	}
+	return kinds
+}
+
+// extKind is how the input file name is read after Ext, as the extension
+// of Ext it has, or the first one when it has none
+func (o *Options) extKind(name string) inputKind {
+	if o.Image {
+		return kindImage
+	}
+	exts := splitExts(o.Ext)
+	if ext := matchExt(name, exts); ext != "" {
+		return kindOf(ext)
+	}
+	if len(exts) > 0 {
+		return kindOf(exts[0])
+	}
+	return kindOf(o.Ext)
+}
+
+// compoundKind is the kind of the input file name selected with kind, a
+// single compressed file of kind unless its compound extension, like
+// .tar.gz for a .gz kind, tells it is a tar file compressed that way
+func compoundKind(name string, kind inputKind) inputKind {
+	if !kind.single() {
+		return kind
+	}
+	if tarKind := kindOf(strings.ToLower(name)); tarKind.tar() && tarKind.compression() == kind {
+		return tarKind
+	}
+	return kind
+}
+
+// ExtractsInPlace tells whether some inputs are single compressed files,
+// like gz and xz files, which are extracted next to them instead of in OutDir
+func (o *Options) ExtractsInPlace() bool {
+	for _, kind := range o.kinds() {
+		if kind.single() {
+			return true
+		}
+	}
+	return false
+}
+
+// single tells whether the inputs of kind are a single compressed file
+func (k inputKind) single() bool {
+	return k == kindGz || k == kindZst || k == kindBz2 || k == kindXz || k == kindLz4 || k == kindBr || k == kindSz
+}
+
+// tar tells whether the inputs of kind are tar files, compressed or not, or
+// Debian packages with a tar payload
+func (k inputKind) tar() bool {
+	switch k {
+	case kindTar, kindTarGz, kindTarXz, kindTarZst, kindTarBz2, kindTarLz4, kindDeb:
+		return true
+	}
+	return false
+}
+
+// compression is the single compressed kind the tar files of kind are
+// compressed as, kind itself for the others
+func (k inputKind) compression() inputKind {
+	switch k {
+	case kindTarGz:
+		return kindGz
+	case kindTarXz:
+		return kindXz
+	case kindTarZst:
+		return kindZst
+	case kindTarBz2:
+		return kindBz2
+	case kindTarLz4:
+		return kindLz4
+	}
+	return k
+}
+
+// tarred is the kind of the tar files compressed as the single compressed
+// kind k, k itself for the others
+func (k inputKind) tarred() inputKind {
+	for _, tarKind := range []inputKind{kindTarGz, kindTarXz, kindTarZst, kindTarBz2, kindTarLz4} {
+		if tarKind.compression() == k {
+			return tarKind
+		}
+	}
+	return k
+}
+
+// cpio tells whether the inputs of kind are cpio archives, compressed or not,
+// or RPM packages with a cpio payload
+func (k inputKind) cpio() bool {
+	return k == kindCpio || k == kindCpioGz || k == kindRpm
+}
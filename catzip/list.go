@@ -0,0 +1,182 @@
+package catzip
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// listedEntry is an entry as the headers of its input file describe it
+type listedEntry struct {
+	name    string
+	size    int64 // -1 when the headers don't have it
+	modTime time.Time
+	dir     bool
+	comment string
+}
+
+// List prints the entries of the input files selected by opts to w, without
+// extracting them
+func List(w io.Writer, opts Options, printComments bool) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	in := inputFS(opts)
+	defer closeInputs(in)
+	files, _, _, err := selectInputs(opts, in)
+	if err != nil {
+		return err
+	}
+
+	passthrough := parseExtList(opts.PassthroughExt)
+	for _, f := range files {
+		comment, entries, err := listEntries(in, f, opts.fileKind(in, f), passthrough[filepath.Ext(f)])
+		if err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+
+		fmt.Fprintln(w, f)
+		if printComments && comment != "" {
+			printComment(w, comment)
+		}
+		for _, e := range entries {
+			fmt.Fprintf(w, "%12d  %s  %s\n", e.size, e.modTime.Format("2006-01-02 15:04"), e.name)
+			if printComments && e.comment != "" {
+				printComment(w, e.comment)
+			}
+		}
+	}
+	return nil
+}
+
+// listEntries reads the entries of the input file name from its headers, a
+// plain file is its own entry. It returns the comment of the archive too.
+func listEntries(fsys fs.FS, name string, kind inputKind, plain bool) (string, []listedEntry, error) {
+	switch {
+	case plain || kind == kindPlain:
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			return "", nil, err
+		}
+		return "", []listedEntry{{name: filepath.Base(name), size: info.Size(), modTime: info.ModTime()}}, nil
+	case kind == kindGz:
+		return listGz(fsys, name)
+	case kind.single():
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			return "", nil, err
+		}
+		base := filepath.Base(strings.TrimSuffix(name, filepath.Ext(name)))
+		return "", []listedEntry{{name: base, size: compressedSizeHint(fsys, name, kind), modTime: info.ModTime()}}, nil
+	case kind.tar():
+		entries, err := listTar(fsys, name, kind)
+		return "", entries, err
+	case kind.cpio():
+		entries, err := listCpio(fsys, name, kind)
+		return "", entries, err
+	case kind == kind7z:
+		entries, err := list7z(fsys, name)
+		return "", entries, err
+	case kind == kindRar:
+		entries, err := listRar(fsys, name)
+		return "", entries, err
+	case kind == kindIso:
+		entries, err := listIso(fsys, name)
+		return "", entries, err
+	case kind == kindImage:
+		entries, err := listImage(fsys, name)
+		return "", entries, err
+	default:
+		return listZip(fsys, name)
+	}
+}
+
+func listZip(fsys fs.FS, name string) (string, []listedEntry, error) {
+	reader, closer, err := openZip(fsys, name, false, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer closer.Close()
+
+	entries := make([]listedEntry, 0, len(reader.File))
+	for _, f := range reader.File {
+		entries = append(entries, listedEntry{
+			name:    f.Name,
+			size:    int64(f.UncompressedSize64),
+			modTime: f.Modified,
+			dir:     f.FileInfo().IsDir(),
+			comment: f.Comment,
+		})
+	}
+	return reader.Comment, entries, nil
+}
+
+func listGz(fsys fs.FS, name string) (string, []listedEntry, error) {
+	gzFile, err := fsys.Open(name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer gzFile.Close()
+
+	reader, err := gzip.NewReader(gzFile)
+	if err != nil {
+		return "", nil, err
+	}
+	defer reader.Close()
+
+	entryName := reader.Name
+	if entryName == "" {
+		entryName = strings.TrimSuffix(name, ".gz")
+	}
+	entry := listedEntry{name: entryName, modTime: reader.ModTime, comment: reader.Comment}
+	// The trailers have the sizes of the members, the file is read through
+	// when they can't be trusted
+	if readerAt, ok := gzFile.(io.ReaderAt); ok {
+		if info, err := gzFile.Stat(); err == nil {
+			if size, exact := gzipMembersSize(readerAt, info.Size()); exact {
+				entry.size = size
+				return "", []listedEntry{entry}, nil
+			}
+		}
+	}
+	if entry.size, err = io.Copy(io.Discard, reader); err != nil {
+		return "", nil, err
+	}
+	return "", []listedEntry{entry}, nil
+}
+
+func listTar(fsys fs.FS, name string, kind inputKind) ([]listedEntry, error) {
+	reader, closer, err := openTar(fsys, name, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	entries := []listedEntry{}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, listedEntry{
+			name:    header.Name,
+			size:    header.Size,
+			modTime: header.ModTime,
+			dir:     header.Typeflag == tar.TypeDir,
+		})
+	}
+}
+
+func printComment(w io.Writer, comment string) {
+	for _, line := range strings.Split(comment, "\n") {
+		fmt.Fprintf(w, "    # %s\n", line)
+	}
+}
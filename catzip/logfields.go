@@ -0,0 +1,25 @@
+package catzip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LogFields formats keyvals, keys and values in turn, as the key=value pairs
+// of the event lines of a run. Empty values and the ones with spaces, quotes
+// or = are quoted.
+func LogFields(keyvals ...interface{}) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		value := fmt.Sprint(keyvals[i+1])
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, "%v=%s", keyvals[i], value)
+	}
+	return b.String()
+}
@@ -0,0 +1,25 @@
+package catzip
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLogFields(t *testing.T) {
+	for _, v := range []struct {
+		keyvals  []interface{}
+		expected string
+	}{
+		{[]interface{}{"event", "entry", "bytes", 42}, `event=entry bytes=42`},
+		{[]interface{}{"name", "a b.log", "reason", ""}, `name="a b.log" reason=""`},
+		{[]interface{}{"name", `say "hi"`, "expr", "a=b"}, `name="say \"hi\"" expr="a=b"`},
+		{[]interface{}{"msg", "two\nlines", "tab", "a\tb"}, `msg="two\nlines" tab="a\tb"`},
+		{[]interface{}{"err", errors.New("no such file")}, `err="no such file"`},
+		{[]interface{}{"path", "ünï/côdé", "odd"}, `path=ünï/côdé`},
+		{nil, ``},
+	} {
+		if line := LogFields(v.keyvals...); line != v.expected {
+			t.Errorf("%v: %s, expected %s", v.keyvals, line, v.expected)
+		}
+	}
+}
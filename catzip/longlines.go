@@ -0,0 +1,71 @@
+package catzip
+
+import (
+	"bytes"
+	"io"
+)
+
+// Options.LongLines values
+const (
+	LongLinesTruncate = "truncate" // Drop what is past MaxLineBytes
+	LongLinesWrap     = "wrap"     // Break the line every MaxLineBytes
+)
+
+// lineLimiter writes the content of an entry to w with no line longer than
+// max bytes, the newline aside, with Options.MaxLineBytes
+type lineLimiter struct {
+	w       io.Writer
+	max     int64
+	wrap    bool
+	column  int64 // Bytes of the current line written so far
+	long    bool  // The current line was counted already
+	buf     []byte
+	written int64
+	lines   int // Truncated or wrapped
+}
+
+func (l *lineLimiter) Write(p []byte) (int, error) {
+	l.buf = l.buf[:0]
+	for rest := p; len(rest) > 0; {
+		line := rest
+		newline := bytes.IndexByte(rest, '\n')
+		if newline >= 0 {
+			line = rest[:newline]
+		}
+		rest = rest[len(line):]
+
+		for len(line) > 0 {
+			if l.column == l.max {
+				if !l.long {
+					l.lines++
+					l.long = true
+				}
+				if !l.wrap {
+					break
+				}
+				l.buf = append(l.buf, '\n')
+				l.column = 0
+			}
+			n := int64(len(line))
+			if n > l.max-l.column {
+				n = l.max - l.column
+			}
+			l.buf = append(l.buf, line[:n]...)
+			l.column += n
+			line = line[n:]
+		}
+
+		if newline >= 0 {
+			l.buf = append(l.buf, '\n')
+			l.column = 0
+			l.long = false
+			rest = rest[1:]
+		}
+	}
+	n, err := l.w.Write(l.buf)
+	l.written += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
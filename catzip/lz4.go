@@ -0,0 +1,401 @@
+package catzip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/bits"
+)
+
+// A decoder for the LZ4 frame format, and for the legacy format of the
+// first lz4 tools, following the LZ4 frame and block format descriptions.
+// Concatenated and skippable frames are read through.
+
+const (
+	lz4Magic          = 0x184D2204
+	lz4LegacyMagic    = 0x184C2102
+	lz4SkippableMagic = 0x184D2A50 // Up to 0x184D2A5F
+
+	// Matches reach back this far, into the previous blocks when they are
+	// linked
+	lz4Window = 64 << 10
+	// Blocks of the legacy format, compressed up to a bit more
+	lz4LegacyBlock = 8 << 20
+)
+
+var errLZ4Corrupt = errors.New("lz4: corrupt stream")
+
+type lz4Reader struct {
+	r       io.Reader
+	started bool
+	err     error
+
+	// Current frame
+	inFrame       bool
+	legacy        bool
+	independent   bool
+	blockChecksum bool
+	maxBlock      int
+	contentHash   *xxh32 // nil without a content checksum
+
+	buf []byte // Compressed block
+	out []byte // The window of the previous blocks, then the new output
+	pos int    // Next byte of out to read
+}
+
+func newLZ4Reader(r io.Reader) io.ReadCloser {
+	return &lz4Reader{r: r}
+}
+
+func (z *lz4Reader) Read(p []byte) (int, error) {
+	for z.pos == len(z.out) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.next()
+	}
+	n := copy(p, z.out[z.pos:])
+	z.pos += n
+	return n, nil
+}
+
+func (z *lz4Reader) Close() error { return nil }
+
+// next decodes the next block, or reads the next frame header
+func (z *lz4Reader) next() error {
+	if !z.inFrame {
+		var magic [4]byte
+		n, err := io.ReadFull(z.r, magic[:])
+		if n == 0 && err == io.EOF && z.started {
+			return io.EOF
+		}
+		if err != nil {
+			return fmt.Errorf("lz4: %w", unexpectedEOF(err))
+		}
+		return z.startFrame(binary.LittleEndian.Uint32(magic[:]))
+	}
+
+	var header [4]byte
+	n, err := io.ReadFull(z.r, header[:])
+	if z.legacy && n == 0 && err == io.EOF {
+		// Legacy frames go on until the end
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("lz4: %w", unexpectedEOF(err))
+	}
+	size := binary.LittleEndian.Uint32(header[:])
+
+	if z.legacy {
+		if size > lz4LegacyBlock+lz4LegacyBlock/255+16 {
+			// Too large for a block, the magic number of the next frame
+			return z.startFrame(size)
+		}
+		return z.block(int(size), false)
+	}
+	if size == 0 {
+		return z.endFrame()
+	}
+	return z.block(int(size&0x7FFFFFFF), size&0x80000000 != 0)
+}
+
+func (z *lz4Reader) startFrame(magic uint32) error {
+	z.started = true
+	z.out, z.pos = z.out[:0], 0
+	switch {
+	case magic == lz4LegacyMagic:
+		z.inFrame, z.legacy, z.independent = true, true, true
+		z.blockChecksum, z.contentHash = false, nil
+		z.maxBlock = lz4LegacyBlock
+		return nil
+	case magic&0xFFFFFFF0 == lz4SkippableMagic:
+		var size [4]byte
+		if _, err := io.ReadFull(z.r, size[:]); err != nil {
+			return fmt.Errorf("lz4: %w", unexpectedEOF(err))
+		}
+		skip := int64(binary.LittleEndian.Uint32(size[:]))
+		if n, err := io.CopyN(io.Discard, z.r, skip); n < skip {
+			return fmt.Errorf("lz4: %w", unexpectedEOF(err))
+		}
+		return nil
+	case magic != lz4Magic:
+		return errors.New("lz4: not an lz4 stream")
+	}
+
+	// Frame descriptor, then the optional content size and dictionary ID,
+	// then the header checksum
+	descriptor := make([]byte, 2, 15)
+	if _, err := io.ReadFull(z.r, descriptor); err != nil {
+		return fmt.Errorf("lz4: %w", unexpectedEOF(err))
+	}
+	flags, blockDescriptor := descriptor[0], descriptor[1]
+	if flags>>6 != 1 {
+		return fmt.Errorf("lz4: unsupported frame version %d", flags>>6)
+	}
+	if flags&0x01 != 0 {
+		return errors.New("lz4: frames with a dictionary are unsupported")
+	}
+	rest := 1
+	if flags&0x08 != 0 {
+		rest += 8
+	}
+	descriptor = descriptor[:2+rest]
+	if _, err := io.ReadFull(z.r, descriptor[2:]); err != nil {
+		return fmt.Errorf("lz4: %w", unexpectedEOF(err))
+	}
+	checksum := descriptor[len(descriptor)-1]
+	descriptor = descriptor[:len(descriptor)-1]
+	if byte(xxh32Sum(descriptor)>>8) != checksum {
+		return errLZ4Corrupt
+	}
+
+	blockSize := blockDescriptor >> 4 & 0x07
+	if blockSize < 4 {
+		return errLZ4Corrupt
+	}
+	z.maxBlock = 1 << (2*blockSize + 8)
+	z.inFrame, z.legacy = true, false
+	z.independent = flags&0x20 != 0
+	z.blockChecksum = flags&0x10 != 0
+	z.contentHash = nil
+	if flags&0x04 != 0 {
+		z.contentHash = newXXH32()
+	}
+	return nil
+}
+
+func (z *lz4Reader) endFrame() error {
+	z.inFrame = false
+	if z.contentHash == nil {
+		return nil
+	}
+	var checksum [4]byte
+	if _, err := io.ReadFull(z.r, checksum[:]); err != nil {
+		return fmt.Errorf("lz4: %w", unexpectedEOF(err))
+	}
+	if z.contentHash.Sum32() != binary.LittleEndian.Uint32(checksum[:]) {
+		return errors.New("lz4: checksum error")
+	}
+	return nil
+}
+
+// block reads a block of size bytes and decodes it after the window
+func (z *lz4Reader) block(size int, stored bool) error {
+	if size > z.maxBlock+z.maxBlock/255+16 || (stored && size > z.maxBlock) {
+		return errLZ4Corrupt
+	}
+	if cap(z.buf) < size {
+		z.buf = make([]byte, size)
+	}
+	z.buf = z.buf[:size]
+	if _, err := io.ReadFull(z.r, z.buf); err != nil {
+		return fmt.Errorf("lz4: %w", unexpectedEOF(err))
+	}
+	if z.blockChecksum {
+		var checksum [4]byte
+		if _, err := io.ReadFull(z.r, checksum[:]); err != nil {
+			return fmt.Errorf("lz4: %w", unexpectedEOF(err))
+		}
+		if xxh32Sum(z.buf) != binary.LittleEndian.Uint32(checksum[:]) {
+			return errors.New("lz4: block checksum error")
+		}
+	}
+
+	// Everything was read, keep the window of linked blocks
+	if z.independent {
+		z.out = z.out[:0]
+	} else if len(z.out) > lz4Window {
+		z.out = z.out[:copy(z.out, z.out[len(z.out)-lz4Window:])]
+	}
+	z.pos = len(z.out)
+
+	if stored {
+		z.out = append(z.out, z.buf...)
+	} else {
+		var err error
+		if z.out, err = lz4DecodeBlock(z.out, z.buf, z.pos+z.maxBlock); err != nil {
+			return err
+		}
+	}
+	if z.contentHash != nil {
+		z.contentHash.Write(z.out[z.pos:])
+	}
+	return nil
+}
+
+// lz4DecodeBlock appends the decoded block src to dst, which holds the data
+// its matches can refer to, without going past limit
+func lz4DecodeBlock(dst, src []byte, limit int) ([]byte, error) {
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		literals := int(token >> 4)
+		if literals == 15 {
+			for {
+				if i >= len(src) {
+					return nil, errLZ4Corrupt
+				}
+				b := src[i]
+				i++
+				literals += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if literals > len(src)-i || len(dst)+literals > limit {
+			return nil, errLZ4Corrupt
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+		if i == len(src) {
+			// The last sequence only has literals
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, errLZ4Corrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, errLZ4Corrupt
+		}
+		length := int(token & 0x0F)
+		if length == 15 {
+			for {
+				if i >= len(src) {
+					return nil, errLZ4Corrupt
+				}
+				b := src[i]
+				i++
+				length += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		length += 4
+		if len(dst)+length > limit {
+			return nil, errLZ4Corrupt
+		}
+		start := len(dst) - offset
+		if offset >= length {
+			dst = append(dst, dst[start:start+length]...)
+		} else {
+			// Overlapping, it repeats the last offset bytes
+			for k := 0; k < length; k++ {
+				dst = append(dst, dst[start+k])
+			}
+		}
+	}
+	return dst, nil
+}
+
+// lz4SizeHint reads the content size of the first lz4 frame, it is only the
+// size of the whole file when there is a single frame
+func lz4SizeHint(fsys fs.FS, name string) int64 {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return -1
+	}
+	defer file.Close()
+
+	// Magic number, frame descriptor and content size
+	header := make([]byte, 14)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return -1
+	}
+	if binary.LittleEndian.Uint32(header) != lz4Magic || header[4]&0x08 == 0 {
+		return -1
+	}
+	return int64(binary.LittleEndian.Uint64(header[6:]))
+}
+
+const (
+	xxh32Prime1 uint32 = 2654435761
+	xxh32Prime2 uint32 = 2246822519
+	xxh32Prime3 uint32 = 3266489917
+	xxh32Prime4 uint32 = 668265263
+	xxh32Prime5 uint32 = 374761393
+)
+
+// xxh32 is XXH32 with seed 0, the checksum of lz4 frames
+type xxh32 struct {
+	v     [4]uint32
+	buf   [16]byte
+	n     int
+	total uint64
+}
+
+func newXXH32() *xxh32 {
+	var seed uint32
+	return &xxh32{v: [4]uint32{seed + xxh32Prime1 + xxh32Prime2, seed + xxh32Prime2, seed, seed - xxh32Prime1}}
+}
+
+func xxh32Sum(b []byte) uint32 {
+	h := newXXH32()
+	h.Write(b)
+	return h.Sum32()
+}
+
+func xxh32Round(acc, input uint32) uint32 {
+	return bits.RotateLeft32(acc+input*xxh32Prime2, 13) * xxh32Prime1
+}
+
+func (h *xxh32) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += uint64(n)
+	if h.n > 0 {
+		c := copy(h.buf[h.n:], p)
+		h.n += c
+		p = p[c:]
+		if h.n < 16 {
+			return n, nil
+		}
+		h.stripe(h.buf[:])
+		h.n = 0
+	}
+	for ; len(p) >= 16; p = p[16:] {
+		h.stripe(p)
+	}
+	h.n = copy(h.buf[:], p)
+	return n, nil
+}
+
+func (h *xxh32) stripe(b []byte) {
+	for i := range h.v {
+		h.v[i] = xxh32Round(h.v[i], binary.LittleEndian.Uint32(b[4*i:]))
+	}
+}
+
+func (h *xxh32) Sum32() uint32 {
+	var sum uint32
+	if h.total >= 16 {
+		sum = bits.RotateLeft32(h.v[0], 1) + bits.RotateLeft32(h.v[1], 7) +
+			bits.RotateLeft32(h.v[2], 12) + bits.RotateLeft32(h.v[3], 18)
+	} else {
+		sum = xxh32Prime5
+	}
+	sum += uint32(h.total)
+
+	b := h.buf[:h.n]
+	for ; len(b) >= 4; b = b[4:] {
+		sum += binary.LittleEndian.Uint32(b) * xxh32Prime3
+		sum = bits.RotateLeft32(sum, 17) * xxh32Prime4
+	}
+	for _, c := range b {
+		sum += uint32(c) * xxh32Prime5
+		sum = bits.RotateLeft32(sum, 11) * xxh32Prime1
+	}
+
+	sum ^= sum >> 15
+	sum *= xxh32Prime2
+	sum ^= sum >> 13
+	sum *= xxh32Prime3
+	sum ^= sum >> 16
+	return sum
+}
@@ -0,0 +1,139 @@
+package catzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+)
+
+// The vectors under testdata are from the lz4 command: mixed.lz4 with its
+// defaults, independent blocks and a content checksum, lines.linked.lz4
+// with -BD -B4 -BX --content-size, linked 64KiB blocks with their
+// checksums, and mixed.legacy.lz4 with -l.
+
+func lz4Mixed() []byte {
+	return append(testLines(40000)[:20000], bytes.Repeat([]byte("a"), 10000)...)
+}
+
+func lz4Linked() []byte {
+	return append(testLines(40000), testLines(40000)...)
+}
+
+func decodeLZ4(data []byte, limit int64) ([]byte, error) {
+	return readLimited(newLZ4Reader(bytes.NewReader(data)), limit)
+}
+
+func TestLZ4(t *testing.T) {
+	mixed, linked := readTestdata(t, "mixed.lz4"), readTestdata(t, "lines.linked.lz4")
+	legacy := readTestdata(t, "mixed.legacy.lz4")
+	// A skippable frame between two frames
+	skippable := []byte{0x5A, 0x2A, 0x4D, 0x18, 4, 0, 0, 0, 1, 2, 3, 4}
+	for _, v := range []struct {
+		name    string
+		data    []byte
+		content []byte
+	}{
+		{"mixed.lz4", mixed, lz4Mixed()},
+		{"lines.linked.lz4", linked, lz4Linked()},
+		{"mixed.legacy.lz4", legacy, lz4Mixed()},
+		{"concatenated", append(append(append([]byte{}, mixed...), skippable...), linked...), append(lz4Mixed(), lz4Linked()...)},
+		{"legacy then frame", append(append([]byte{}, legacy...), mixed...), append(lz4Mixed(), lz4Mixed()...)},
+	} {
+		out, err := decodeLZ4(v.data, int64(len(v.content)))
+		if err != nil {
+			t.Errorf("%s: %v", v.name, err)
+		} else if !bytes.Equal(out, v.content) {
+			t.Errorf("%s: decoded %d bytes, not the content", v.name, len(out))
+		}
+
+		out, err = io.ReadAll(iotest.OneByteReader(newLZ4Reader(iotest.OneByteReader(bytes.NewReader(v.data)))))
+		if err != nil || !bytes.Equal(out, v.content) {
+			t.Errorf("%s byte by byte: %d bytes, %v", v.name, len(out), err)
+		}
+	}
+
+	if size := lz4SizeHint(fstest.MapFS{"a.lz4": {Data: linked}}, "a.lz4"); size != int64(len(lz4Linked())) {
+		t.Errorf("size hint %d", size)
+	}
+	if size := lz4SizeHint(fstest.MapFS{"a.lz4": {Data: mixed}}, "a.lz4"); size != -1 {
+		t.Errorf("size hint %d without a content size", size)
+	}
+}
+
+// lz4Stored encodes data as a frame of stored 64KiB blocks, with their
+// checksums and the content checksum
+func lz4Stored(data []byte) []byte {
+	frame := binary.LittleEndian.AppendUint32(nil, lz4Magic)
+	descriptor := []byte{0x40 | 0x20 | 0x10 | 0x04, 4 << 4}
+	frame = append(frame, descriptor...)
+	frame = append(frame, byte(xxh32Sum(descriptor)>>8))
+	for rest := data; len(rest) > 0; {
+		n := len(rest)
+		if n > 64<<10 {
+			n = 64 << 10
+		}
+		frame = binary.LittleEndian.AppendUint32(frame, uint32(n)|0x80000000)
+		frame = append(frame, rest[:n]...)
+		frame = binary.LittleEndian.AppendUint32(frame, xxh32Sum(rest[:n]))
+		rest = rest[n:]
+	}
+	frame = binary.LittleEndian.AppendUint32(frame, 0)
+	return binary.LittleEndian.AppendUint32(frame, xxh32Sum(data))
+}
+
+func TestLZ4Stored(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	for _, n := range []int{0, 1, 64 << 10, 200000} {
+		content := make([]byte, n)
+		rnd.Read(content)
+		out, err := decodeLZ4(lz4Stored(content), int64(n))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(out, content) {
+			t.Errorf("%d bytes: decoded %d bytes, not the content", n, len(out))
+		}
+	}
+
+	// The block and the content checksums are checked
+	frame := lz4Stored([]byte("some content\n"))
+	frame[len(frame)-20] ^= 1
+	if _, err := decodeLZ4(frame, 100); err == nil {
+		t.Error("no block checksum error")
+	}
+	frame = lz4Stored([]byte("some content\n"))
+	frame[len(frame)-1] ^= 1
+	if _, err := decodeLZ4(frame, 100); err == nil {
+		t.Error("no content checksum error")
+	}
+}
+
+func TestXXH32(t *testing.T) {
+	for input, sum := range map[string]uint32{
+		"":    0x02CC5D05,
+		"a":   0x550D7456,
+		"abc": 0x32D153FF,
+		"Nobody inspects the spammish repetition": 0xE2293B2F,
+	} {
+		if got := xxh32Sum([]byte(input)); got != sum {
+			t.Errorf("%q: %#08x, expected %#08x", input, got, sum)
+		}
+	}
+}
+
+func TestLZ4Corrupt(t *testing.T) {
+	for _, name := range []string{"mixed.lz4", "lines.linked.lz4", "mixed.legacy.lz4"} {
+		checkCorrupt(t, readTestdata(t, name), func(data []byte) error {
+			_, err := decodeLZ4(data, 80000)
+			return err
+		})
+	}
+	checkCorrupt(t, lz4Stored(testLines(1000)), func(data []byte) error {
+		_, err := decodeLZ4(data, 1000)
+		return err
+	})
+}
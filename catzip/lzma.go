@@ -0,0 +1,492 @@
+package catzip
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// A decoder for LZMA streams, as found in zip entries using method 14. The
+// decoding follows the LZMA SDK specification.
+
+const (
+	lzmaNumStates     = 12
+	lzmaMaxPosStates  = 16
+	lzmaMinMatchLen   = 2
+	lzmaMaxMatchLen   = 273
+	lzmaEndPosModel   = 14
+	lzmaFullDistances = 128
+	lzmaAlignBits     = 4
+	lzmaProbInit      = 1024
+	lzmaTopValue      = 1 << 24
+)
+
+var errLZMACorrupt = errors.New("lzma: corrupt stream")
+
+type lzmaRangeDecoder struct {
+	r    io.ByteReader
+	rng  uint32
+	code uint32
+	err  error
+}
+
+func (rc *lzmaRangeDecoder) init(r io.ByteReader) error {
+	rc.r = r
+	rc.rng = 0xFFFFFFFF
+	rc.code = 0
+	rc.err = nil
+
+	first := rc.readByte()
+	for i := 0; i < 4; i++ {
+		rc.code = rc.code<<8 | uint32(rc.readByte())
+	}
+	if rc.err != nil {
+		return rc.err
+	}
+	if first != 0 || rc.code == rc.rng {
+		return errLZMACorrupt
+	}
+	return nil
+}
+
+func (rc *lzmaRangeDecoder) readByte() byte {
+	b, err := rc.r.ReadByte()
+	if err != nil && rc.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		rc.err = err
+	}
+	return b
+}
+
+func (rc *lzmaRangeDecoder) normalize() {
+	if rc.rng < lzmaTopValue {
+		rc.rng <<= 8
+		rc.code = rc.code<<8 | uint32(rc.readByte())
+	}
+}
+
+func (rc *lzmaRangeDecoder) bit(prob *uint16) uint32 {
+	bound := (rc.rng >> 11) * uint32(*prob)
+	var bit uint32
+	if rc.code < bound {
+		*prob += (2048 - *prob) >> 5
+		rc.rng = bound
+	} else {
+		*prob -= *prob >> 5
+		rc.code -= bound
+		rc.rng -= bound
+		bit = 1
+	}
+	rc.normalize()
+	return bit
+}
+
+func (rc *lzmaRangeDecoder) direct(numBits int) uint32 {
+	var result uint32
+	for ; numBits > 0; numBits-- {
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		t := 0 - (rc.code >> 31)
+		rc.code += rc.rng & t
+		rc.normalize()
+		result = result<<1 + t + 1
+	}
+	return result
+}
+
+func (rc *lzmaRangeDecoder) bitTree(probs []uint16, numBits int) uint32 {
+	m := uint32(1)
+	for i := 0; i < numBits; i++ {
+		m = m<<1 + rc.bit(&probs[m])
+	}
+	return m - 1<<numBits
+}
+
+func (rc *lzmaRangeDecoder) reverseBitTree(probs []uint16, numBits int) uint32 {
+	m := uint32(1)
+	var symbol uint32
+	for i := 0; i < numBits; i++ {
+		bit := rc.bit(&probs[m])
+		m = m<<1 + bit
+		symbol |= bit << i
+	}
+	return symbol
+}
+
+func lzmaInitProbs(probs []uint16) {
+	for i := range probs {
+		probs[i] = lzmaProbInit
+	}
+}
+
+type lzmaLenDecoder struct {
+	choice  uint16
+	choice2 uint16
+	low     [lzmaMaxPosStates][1 << 3]uint16
+	mid     [lzmaMaxPosStates][1 << 3]uint16
+	high    [1 << 8]uint16
+}
+
+func (d *lzmaLenDecoder) reset() {
+	d.choice = lzmaProbInit
+	d.choice2 = lzmaProbInit
+	for i := range d.low {
+		lzmaInitProbs(d.low[i][:])
+		lzmaInitProbs(d.mid[i][:])
+	}
+	lzmaInitProbs(d.high[:])
+}
+
+func (d *lzmaLenDecoder) decode(rc *lzmaRangeDecoder, posState uint32) uint32 {
+	if rc.bit(&d.choice) == 0 {
+		return rc.bitTree(d.low[posState][:], 3)
+	}
+	if rc.bit(&d.choice2) == 0 {
+		return 8 + rc.bitTree(d.mid[posState][:], 3)
+	}
+	return 16 + rc.bitTree(d.high[:], 8)
+}
+
+// lzmaWindow is the sliding dictionary, it also holds the decoded bytes
+// that haven't been read yet
+type lzmaWindow struct {
+	buf     []byte
+	pos     int
+	total   uint64 // Bytes decoded since the last dictionary reset
+	pending int
+}
+
+// reset starts a new dictionary, the pending bytes stay readable
+func (w *lzmaWindow) reset() {
+	w.total = 0
+}
+
+func (w *lzmaWindow) putByte(b byte) {
+	w.buf[w.pos] = b
+	w.pos++
+	if w.pos == len(w.buf) {
+		w.pos = 0
+	}
+	w.total++
+	w.pending++
+}
+
+// getByte returns the byte dist+1 positions behind the current one
+func (w *lzmaWindow) getByte(dist uint32) byte {
+	i := w.pos - int(dist) - 1
+	if i < 0 {
+		i += len(w.buf)
+	}
+	return w.buf[i]
+}
+
+func (w *lzmaWindow) hasDistance(dist uint32) bool {
+	return uint64(dist) < w.total && int(dist) < len(w.buf)
+}
+
+func (w *lzmaWindow) copyMatch(dist uint32, length int) {
+	for ; length > 0; length-- {
+		w.putByte(w.getByte(dist))
+	}
+}
+
+// free is how many bytes can be decoded before pending bytes get overwritten
+func (w *lzmaWindow) free() int {
+	return len(w.buf) - w.pending
+}
+
+func (w *lzmaWindow) read(p []byte) int {
+	n := 0
+	for n < len(p) && w.pending > 0 {
+		start := w.pos - w.pending
+		if start < 0 {
+			start += len(w.buf)
+		}
+		end := start + w.pending
+		if end > len(w.buf) {
+			end = len(w.buf)
+		}
+		c := copy(p[n:], w.buf[start:end])
+		n += c
+		w.pending -= c
+	}
+	return n
+}
+
+// lzmaDecoder holds the probability model, it is kept separate from the
+// window and the range decoder so LZMA2 chunks can reset them independently
+type lzmaDecoder struct {
+	lc, lp, pb uint32
+
+	state int
+	rep   [4]uint32
+
+	isMatch    [lzmaNumStates][lzmaMaxPosStates]uint16
+	isRep      [lzmaNumStates]uint16
+	isRepG0    [lzmaNumStates]uint16
+	isRepG1    [lzmaNumStates]uint16
+	isRepG2    [lzmaNumStates]uint16
+	isRep0Long [lzmaNumStates][lzmaMaxPosStates]uint16
+	literal    []uint16
+	posSlot    [4][1 << 6]uint16
+	posSpecial [1 + lzmaFullDistances - lzmaEndPosModel]uint16
+	align      [1 << lzmaAlignBits]uint16
+	matchLen   lzmaLenDecoder
+	repLen     lzmaLenDecoder
+}
+
+// decodeProperties splits the lc/lp/pb properties byte
+func lzmaDecodeProperties(props byte) (lc, lp, pb uint32, err error) {
+	d := uint32(props)
+	if d >= 9*5*5 {
+		return 0, 0, 0, errors.New("lzma: invalid properties")
+	}
+	return d % 9, (d / 9) % 5, d / 45, nil
+}
+
+func (d *lzmaDecoder) setProperties(lc, lp, pb uint32) {
+	d.lc, d.lp, d.pb = lc, lp, pb
+	size := 0x300 << (lc + lp)
+	if cap(d.literal) < size {
+		d.literal = make([]uint16, size)
+	}
+	d.literal = d.literal[:size]
+}
+
+func (d *lzmaDecoder) reset() {
+	d.state = 0
+	d.rep = [4]uint32{}
+	for i := 0; i < lzmaNumStates; i++ {
+		lzmaInitProbs(d.isMatch[i][:])
+		lzmaInitProbs(d.isRep0Long[i][:])
+	}
+	lzmaInitProbs(d.isRep[:])
+	lzmaInitProbs(d.isRepG0[:])
+	lzmaInitProbs(d.isRepG1[:])
+	lzmaInitProbs(d.isRepG2[:])
+	lzmaInitProbs(d.literal)
+	for i := range d.posSlot {
+		lzmaInitProbs(d.posSlot[i][:])
+	}
+	lzmaInitProbs(d.posSpecial[:])
+	lzmaInitProbs(d.align[:])
+	d.matchLen.reset()
+	d.repLen.reset()
+}
+
+func (d *lzmaDecoder) decodeLiteral(rc *lzmaRangeDecoder, w *lzmaWindow) {
+	var prev uint32
+	if w.total > 0 {
+		prev = uint32(w.getByte(0))
+	}
+	lpMask := uint32(1)<<d.lp - 1
+	base := 0x300 * ((uint32(w.total)&lpMask)<<d.lc + prev>>(8-d.lc))
+	probs := d.literal[base : base+0x300]
+
+	symbol := uint32(1)
+	if d.state >= 7 {
+		matchByte := uint32(w.getByte(d.rep[0]))
+		for symbol < 0x100 {
+			matchBit := (matchByte >> 7) & 1
+			matchByte <<= 1
+			bit := rc.bit(&probs[0x100+(matchBit<<8)+symbol])
+			symbol = symbol<<1 | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for symbol < 0x100 {
+		symbol = symbol<<1 | rc.bit(&probs[symbol])
+	}
+	w.putByte(byte(symbol))
+
+	switch {
+	case d.state < 4:
+		d.state = 0
+	case d.state < 10:
+		d.state -= 3
+	default:
+		d.state -= 6
+	}
+}
+
+func (d *lzmaDecoder) decodeDistance(rc *lzmaRangeDecoder, length uint32) uint32 {
+	lenState := length
+	if lenState > 3 {
+		lenState = 3
+	}
+	posSlot := rc.bitTree(d.posSlot[lenState][:], 6)
+	if posSlot < 4 {
+		return posSlot
+	}
+
+	numDirectBits := int(posSlot>>1) - 1
+	dist := (2 | posSlot&1) << numDirectBits
+	if posSlot < lzmaEndPosModel {
+		return dist + rc.reverseBitTree(d.posSpecial[dist-posSlot:], numDirectBits)
+	}
+	dist += rc.direct(numDirectBits-lzmaAlignBits) << lzmaAlignBits
+	return dist + rc.reverseBitTree(d.align[:], lzmaAlignBits)
+}
+
+// decodeSymbol decodes one literal or match into w, limit is the most bytes
+// it may produce. It reports whether the end marker was found.
+func (d *lzmaDecoder) decodeSymbol(rc *lzmaRangeDecoder, w *lzmaWindow, limit uint64) (bool, error) {
+	posState := uint32(w.total) & (uint32(1)<<d.pb - 1)
+	state := d.state
+
+	if rc.bit(&d.isMatch[state][posState]) == 0 {
+		if d.state >= 7 && !w.hasDistance(d.rep[0]) {
+			return false, errLZMACorrupt
+		}
+		d.decodeLiteral(rc, w)
+		return false, nil
+	}
+
+	var length uint32
+	if rc.bit(&d.isRep[state]) == 0 {
+		length = d.matchLen.decode(rc, posState)
+		dist := d.decodeDistance(rc, length)
+		if dist == 0xFFFFFFFF {
+			return true, nil
+		}
+		d.rep[3], d.rep[2], d.rep[1], d.rep[0] = d.rep[2], d.rep[1], d.rep[0], dist
+		d.state = 7
+		if state >= 7 {
+			d.state = 10
+		}
+	} else {
+		if w.total == 0 {
+			return false, errLZMACorrupt
+		}
+		if rc.bit(&d.isRepG0[state]) == 0 {
+			if rc.bit(&d.isRep0Long[state][posState]) == 0 {
+				d.state = 9
+				if state >= 7 {
+					d.state = 11
+				}
+				w.putByte(w.getByte(d.rep[0]))
+				return false, nil
+			}
+		} else {
+			var dist uint32
+			if rc.bit(&d.isRepG1[state]) == 0 {
+				dist = d.rep[1]
+			} else {
+				if rc.bit(&d.isRepG2[state]) == 0 {
+					dist = d.rep[2]
+				} else {
+					dist = d.rep[3]
+					d.rep[3] = d.rep[2]
+				}
+				d.rep[2] = d.rep[1]
+			}
+			d.rep[1] = d.rep[0]
+			d.rep[0] = dist
+		}
+		length = d.repLen.decode(rc, posState)
+		d.state = 8
+		if state >= 7 {
+			d.state = 11
+		}
+	}
+
+	if !w.hasDistance(d.rep[0]) {
+		return false, errLZMACorrupt
+	}
+	n := uint64(length + lzmaMinMatchLen)
+	if n > limit {
+		return false, errLZMACorrupt
+	}
+	w.copyMatch(d.rep[0], int(n))
+	return false, nil
+}
+
+// lzmaReader decodes a single LZMA stream
+type lzmaReader struct {
+	rc   lzmaRangeDecoder
+	dec  lzmaDecoder
+	win  lzmaWindow
+	size int64 // Uncompressed size, -1 when the stream has an end marker
+	eof  bool
+	err  error
+}
+
+func newLZMAReader(r io.Reader, props byte, dictSize uint32, size int64) (*lzmaReader, error) {
+	lc, lp, pb, err := lzmaDecodeProperties(props)
+	if err != nil {
+		return nil, err
+	}
+
+	bufSize := int64(dictSize)
+	if size >= 0 && size < bufSize {
+		bufSize = size
+	}
+	if bufSize < 4096 {
+		bufSize = 4096
+	}
+
+	z := &lzmaReader{size: size}
+	z.win.buf = make([]byte, bufSize)
+	z.dec.setProperties(lc, lp, pb)
+	z.dec.reset()
+
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	if err = z.rc.init(br); err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		z.eof = true
+	}
+	return z, nil
+}
+
+func (z *lzmaReader) Read(p []byte) (int, error) {
+	for !z.eof && z.err == nil && z.win.pending < len(p) && z.win.free() > lzmaMaxMatchLen {
+		limit := uint64(z.win.free())
+		if z.size >= 0 {
+			remaining := uint64(z.size) - z.win.total
+			if remaining == 0 {
+				z.eof = true
+				break
+			}
+			if remaining < limit {
+				limit = remaining
+			}
+		}
+		if z.rc.err != nil {
+			z.err = z.rc.err
+			break
+		}
+
+		end, err := z.dec.decodeSymbol(&z.rc, &z.win, limit)
+		if err != nil {
+			z.err = err
+		} else if end {
+			if z.size >= 0 && uint64(z.size) != z.win.total {
+				z.err = errLZMACorrupt
+			} else if z.rc.err != nil {
+				z.err = z.rc.err // Truncated in the end marker
+			}
+			z.eof = true
+		}
+	}
+
+	n := z.win.read(p)
+	if n > 0 {
+		return n, nil
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.eof {
+		return 0, io.EOF
+	}
+	return 0, nil
+}
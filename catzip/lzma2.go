@@ -0,0 +1,227 @@
+package catzip
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// A decoder for LZMA2 streams, as found in 7z and xz files. LZMA2 splits the
+// data in chunks, either stored or LZMA, which can reset the dictionary, the
+// probability model or the properties.
+
+var errLZMA2Corrupt = errors.New("lzma2: corrupt stream")
+
+// lzma2DictSize decodes the dictionary size property byte
+func lzma2DictSize(props byte) (uint32, error) {
+	switch {
+	case props > 40:
+		return 0, errors.New("lzma2: invalid dictionary size")
+	case props == 40:
+		return 0xFFFFFFFF, nil
+	}
+	return (2 | uint32(props)&1) << (props/2 + 11), nil
+}
+
+type lzma2Reader struct {
+	r   io.ByteReader
+	rc  lzmaRangeDecoder
+	dec lzmaDecoder
+	win lzmaWindow
+
+	chunk    chunkReader // Compressed bytes of the current LZMA chunk
+	unpacked int64       // Bytes left to decode in the current chunk
+	stored   bool        // The current chunk is stored, not LZMA
+	started  bool        // The first chunk reset the dictionary
+	props    bool        // Properties were set
+	eof      bool
+	err      error
+}
+
+// chunkReader reads the n compressed bytes of an LZMA chunk. The range
+// decoder normalizes after each bit so it reads a byte past the end of the
+// chunk after the last one, that byte is never used.
+type chunkReader struct {
+	r io.ByteReader
+	n int64
+}
+
+func (c *chunkReader) ReadByte() (byte, error) {
+	if c.n <= 0 {
+		return 0, nil
+	}
+	c.n--
+	return c.r.ReadByte()
+}
+
+// newLZMA2Reader decodes r with a dictionary of dictSize, size is the
+// uncompressed size when known, -1 otherwise, to allocate less
+func newLZMA2Reader(r io.Reader, dictSize uint32, size int64) *lzma2Reader {
+	bufSize := int64(dictSize)
+	if size >= 0 && size < bufSize {
+		bufSize = size
+	}
+	if bufSize < 4096 {
+		bufSize = 4096
+	}
+
+	z := &lzma2Reader{}
+	z.win.buf = make([]byte, bufSize)
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	z.r = br
+	return z
+}
+
+// nextChunk reads the header of the next chunk
+func (z *lzma2Reader) nextChunk() error {
+	control, err := z.r.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if control == 0 {
+		z.eof = true
+		return nil
+	}
+
+	header := make([]byte, 2)
+	if control < 0x80 {
+		// Stored chunk, 1 resets the dictionary
+		if control > 2 {
+			return errLZMA2Corrupt
+		}
+		if err = readBytes(z.r, header); err != nil {
+			return err
+		}
+		if control == 1 {
+			z.win.reset()
+			z.started = true
+		} else if !z.started {
+			return errLZMA2Corrupt
+		}
+		z.stored = true
+		z.unpacked = int64(header[0])<<8 | int64(header[1]) + 1
+		return nil
+	}
+
+	if err = readBytes(z.r, header); err != nil {
+		return err
+	}
+	z.unpacked = int64(control&0x1F)<<16 | int64(header[0])<<8 | int64(header[1]) + 1
+	if err = readBytes(z.r, header); err != nil {
+		return err
+	}
+	packed := int64(header[0])<<8 | int64(header[1]) + 1
+
+	// 0 continues, 1 resets the state, 2 sets the properties too and 3
+	// resets the dictionary too
+	reset := control >> 5 & 3
+	if reset == 3 {
+		z.win.reset()
+		z.started = true
+	} else if !z.started {
+		return errLZMA2Corrupt
+	}
+	if reset >= 2 {
+		props, err := z.r.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		lc, lp, pb, err := lzmaDecodeProperties(props)
+		if err != nil {
+			return err
+		}
+		if lc+lp > 4 {
+			return errLZMA2Corrupt
+		}
+		z.dec.setProperties(lc, lp, pb)
+		z.props = true
+	} else if !z.props {
+		return errLZMA2Corrupt
+	}
+	if reset >= 1 {
+		z.dec.reset()
+	}
+
+	z.stored = false
+	z.chunk = chunkReader{r: z.r, n: packed}
+	return z.rc.init(&z.chunk)
+}
+
+func (z *lzma2Reader) Read(p []byte) (int, error) {
+	for !z.eof && z.err == nil && z.win.pending < len(p) {
+		if z.unpacked == 0 {
+			if !z.stored && z.chunk.n != 0 {
+				z.err = errLZMA2Corrupt
+				break
+			}
+			z.err = z.nextChunk()
+			continue
+		}
+
+		if z.stored {
+			if z.win.free() == 0 {
+				break
+			}
+			b, err := z.r.ReadByte()
+			if err != nil {
+				z.err = unexpectedEOF(err)
+				break
+			}
+			z.win.putByte(b)
+			z.unpacked--
+			continue
+		}
+
+		if z.win.free() <= lzmaMaxMatchLen {
+			break
+		}
+		limit := uint64(z.win.free())
+		if uint64(z.unpacked) < limit {
+			limit = uint64(z.unpacked)
+		}
+		before := z.win.total
+		end, err := z.dec.decodeSymbol(&z.rc, &z.win, limit)
+		switch {
+		case err != nil:
+			z.err = err
+		case end:
+			z.err = errLZMA2Corrupt // Chunks have no end marker
+		case z.rc.err != nil:
+			z.err = z.rc.err
+		}
+		z.unpacked -= int64(z.win.total - before)
+	}
+
+	n := z.win.read(p)
+	if n > 0 {
+		return n, nil
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.eof {
+		return 0, io.EOF
+	}
+	return 0, nil
+}
+
+func readBytes(r io.ByteReader, p []byte) error {
+	for i := range p {
+		b, err := r.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		p[i] = b
+	}
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,278 @@
+package catzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// testLines and testCode are the contents of the vectors under testdata,
+// compressed with the reference implementations
+
+var testWords = []string{"open", "read", "write", "close", "seek", "stat", "sync", "flush"}
+
+// testLines returns n bytes of log lines
+func testLines(n int) []byte {
+	var b []byte
+	x := uint32(1)
+	for i := 0; len(b) < n; i++ {
+		x = x*1103515245 + 12345
+		b = append(b, fmt.Sprintf("%06d %s %s\n", i, testWords[x>>16%8], testWords[x>>20%8])...)
+	}
+	return b[:n]
+}
+
+// testCode returns n bytes sprinkled with x86 CALL instructions, for the BCJ
+// filter
+func testCode(n int) []byte {
+	b := make([]byte, 0, n)
+	x := uint32(7)
+	for len(b) < n {
+		x = x*1103515245 + 12345
+		r := x >> 16
+		if r%5 == 0 {
+			off := int32(r%4096) - 2048
+			b = append(b, 0xE8, byte(off), byte(off>>8), byte(off>>16), byte(off>>24))
+		} else {
+			b = append(b, byte(r%16))
+		}
+	}
+	return b[:n]
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readGzipTestdata reads the vector name, gzipped under testdata as it is
+// mostly padding or repeats
+func readGzipTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(readTestdata(t, name+".gz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// checkCorrupt decodes the truncations of data, which must fail, and data
+// with a byte flipped here and there, which may decode but must neither
+// panic nor loop.
+func checkCorrupt(t *testing.T, data []byte, decode func([]byte) error) {
+	t.Helper()
+	step := 1
+	if len(data) > 1024 {
+		step = len(data) / 512
+	}
+	for n := 0; n < len(data); n += step {
+		if n+step >= len(data) {
+			n = len(data) - 1 // Short of the last byte only
+		}
+		err := decodeGuarded(data[:n], decode)
+		if _, ok := err.(decodeFailure); ok {
+			t.Fatalf("truncated to %d of %d bytes: %v", n, len(data), err)
+		} else if err == nil {
+			t.Errorf("truncated to %d of %d bytes: no error", n, len(data))
+		}
+	}
+	corrupt := make([]byte, len(data))
+	for i := 0; i < len(data); i += step {
+		copy(corrupt, data)
+		corrupt[i] ^= 0x55
+		if err, ok := decodeGuarded(corrupt, decode).(decodeFailure); ok {
+			t.Fatalf("byte %d flipped: %v", i, err)
+		}
+	}
+}
+
+// decodeFailure is a decode that panicked or didn't return
+type decodeFailure string
+
+func (f decodeFailure) Error() string { return string(f) }
+
+const decodeTimeout = 10 * time.Second
+
+// decodeGuarded runs decode, turning a panic or a decode still running
+// after decodeTimeout into a decodeFailure
+func decodeGuarded(data []byte, decode func([]byte) error) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- decodeFailure(fmt.Sprintf("panic: %v", p))
+			}
+		}()
+		done <- decode(data)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(decodeTimeout):
+		return decodeFailure(fmt.Sprintf("still decoding after %v", decodeTimeout))
+	}
+}
+
+// readLimited reads r to the end, failing past limit bytes so a corrupt
+// stream can't inflate without end
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err == nil && int64(len(out)) > limit {
+		err = fmt.Errorf("more than %d bytes", limit)
+	}
+	return out, err
+}
+
+// lines.lzma is lzma --format=lzma: the properties, the dictionary size and
+// an unknown size, then the stream with its end marker
+func TestLZMA(t *testing.T) {
+	data := readTestdata(t, "lines.lzma")
+	content := testLines(40000)
+	decode := func(data []byte, size int64) ([]byte, error) {
+		if len(data) < 13 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		dictSize := uint32(data[1]) | uint32(data[2])<<8 | uint32(data[3])<<16 | uint32(data[4])<<24
+		z, err := newLZMAReader(bytes.NewReader(data[13:]), data[0], dictSize, size)
+		if err != nil {
+			return nil, err
+		}
+		return readLimited(z, int64(len(content)))
+	}
+
+	for _, size := range []int64{-1, int64(len(content))} {
+		out, err := decode(data, size)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(out, content) {
+			t.Errorf("size %d: decoded %d bytes, not the content", size, len(out))
+		}
+	}
+
+	// Read a byte at a time from a reader giving one at a time
+	z, err := newLZMAReader(iotest.OneByteReader(bytes.NewReader(data[13:])), data[0], 1<<16, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := io.ReadAll(iotest.OneByteReader(z)); err != nil || !bytes.Equal(out, content) {
+		t.Errorf("byte by byte: %d bytes, %v", len(out), err)
+	}
+
+	checkCorrupt(t, data, func(data []byte) error {
+		_, err := decode(data, -1)
+		return err
+	})
+	// Shorter than it says
+	if _, err := decode(data, int64(len(content))+1); err == nil {
+		t.Error("no error decoding more than the stream holds")
+	}
+}
+
+// lines.lzma2 is a raw LZMA2 stream with a 4KiB dictionary, so the matches
+// wrap around the window
+func TestLZMA2(t *testing.T) {
+	data := readTestdata(t, "lines.lzma2")
+	content := testLines(40000)
+	decode := func(data []byte) ([]byte, error) {
+		return readLimited(newLZMA2Reader(bytes.NewReader(data), 4096, -1), int64(len(content)))
+	}
+
+	out, err := decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, content) {
+		t.Errorf("decoded %d bytes, not the content", len(out))
+	}
+	checkCorrupt(t, data, func(data []byte) error {
+		_, err := decode(data)
+		return err
+	})
+}
+
+// lzma2Stored encodes data as stored LZMA2 chunks
+func lzma2Stored(data []byte) []byte {
+	var out []byte
+	control := byte(1) // Resets the dictionary
+	for len(data) > 0 {
+		n := len(data)
+		if n > 1<<16 {
+			n = 1 << 16
+		}
+		out = append(out, control, byte((n-1)>>8), byte(n-1))
+		out = append(out, data[:n]...)
+		data = data[n:]
+		control = 2
+	}
+	return append(out, 0)
+}
+
+func TestLZMA2Stored(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 4096, 1<<16 + 1, 200000} {
+		content := make([]byte, n)
+		rnd.Read(content)
+		out, err := io.ReadAll(newLZMA2Reader(bytes.NewReader(lzma2Stored(content)), 4096, int64(n)))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(out, content) {
+			t.Errorf("%d bytes: decoded %d bytes, not the content", n, len(out))
+		}
+	}
+
+	// A stored chunk that doesn't reset the dictionary can't come first, a
+	// control byte above 2 is for LZMA chunks
+	for _, data := range [][]byte{{2, 0, 0, 'a', 0}, {3, 0, 0, 'a', 0}} {
+		if _, err := io.ReadAll(newLZMA2Reader(bytes.NewReader(data), 4096, -1)); err == nil {
+			t.Errorf("%x: no error", data)
+		}
+	}
+}
+
+// code.bcj.lzma2 is testCode filtered by BCJ x86 then compressed as raw
+// LZMA2. It is read in pieces of various sizes, so instructions straddle the
+// reads.
+func TestBCJ(t *testing.T) {
+	data := readTestdata(t, "code.bcj.lzma2")
+	content := testCode(20000)
+	for _, piece := range []int{1, 5, 4096, 1 << 20} {
+		r := newBCJReader(newLZMA2Reader(bytes.NewReader(data), 1<<16, -1))
+		var out []byte
+		buf := make([]byte, piece)
+		for {
+			n, err := r.Read(buf)
+			out = append(out, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("pieces of %d: %v", piece, err)
+			}
+		}
+		if !bytes.Equal(out, content) {
+			t.Errorf("pieces of %d: decoded %d bytes, not the content", piece, len(out))
+		}
+	}
+
+	checkCorrupt(t, data, func(data []byte) error {
+		_, err := readLimited(newBCJReader(newLZMA2Reader(bytes.NewReader(data), 1<<16, -1)), int64(len(content)))
+		return err
+	})
+}
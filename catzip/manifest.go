@@ -0,0 +1,42 @@
+package catzip
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// manifest describes what a run extracted, it is written as JSON at the end
+type manifest struct {
+	mu       sync.Mutex
+	Hash     string            `json:"hash,omitempty"` // Algorithm of the entries hashes
+	Cat      string            `json:"cat,omitempty"`
+	Archives []manifestArchive `json:"archives"`
+}
+
+// The entries are still being written when the archive is added, they are
+// complete by the time the manifest is written
+type manifestArchive struct {
+	Path    string       `json:"path"`
+	Nested  string       `json:"nested,omitempty"` // Archive inside Path, see Options.MaxDepth
+	Comment string       `json:"comment,omitempty"`
+	Entries []*EntryInfo `json:"entries"`
+}
+
+// add records archive, m is nil when no manifest is written
+func (m *manifest) add(archive manifestArchive) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Archives = append(m.Archives, archive)
+}
+
+func (m *manifest) write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
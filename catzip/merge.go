@@ -0,0 +1,222 @@
+package catzip
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MergeTrees values, which entry is kept when several zip files have one for
+// the same output path
+const (
+	MergeNewest = "newest" // By modification time, the first one on ties
+	MergeOldest = "oldest"
+	MergeFirst  = "first" // In input order
+	MergeLast   = "last"
+)
+
+// MergeConflict is an output path with different content in several zip files
+type MergeConflict struct {
+	Path    string           `json:"path"`
+	Kept    MergeCandidate   `json:"kept"`
+	Dropped []MergeCandidate `json:"dropped"`
+}
+
+type MergeCandidate struct {
+	Archive string    `json:"archive"`
+	ModTime time.Time `json:"mtime"`
+	Size    uint64    `json:"size"`
+	CRC32   uint32    `json:"crc32"`
+	index   int       // In the archive
+}
+
+// mergePlan maps each output path to the only entry written for it
+type mergePlan struct {
+	winners   map[string]MergeCandidate
+	conflicts []MergeConflict
+	// Paths left deleted by Options.Tombstones, to the archive deleting them
+	deleted map[string]string
+}
+
+// planMerge reads the zip files central directories and picks the entry kept
+// for every output path, according to policy
+func (r *run) planMerge(files []string, policy string) (*mergePlan, error) {
+	passthrough := parseExtList(r.opts.PassthroughExt)
+	candidates := map[string][]MergeCandidate{}
+	deleted := map[string]string{}
+	seen := map[string]bool{}
+	var paths []string
+	for _, f := range files {
+		if passthrough[filepath.Ext(f)] || r.fileKind(f) != kindZip {
+			continue
+		}
+		reader, closer, err := openZip(r.in, f, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		decodeNames(reader, r.opts.Handlers["zip"].NameEncoding)
+
+		// The deletions apply to the previous archives, before the entries
+		// of this one
+		for _, file := range reader.File {
+			if r.opts.Tombstones == "" || file.Name != r.opts.Tombstones {
+				continue
+			}
+			registerEntryDecompressor(reader, file)
+			deletedPaths, err := r.readTombstones(file)
+			if err != nil {
+				closer.Close()
+				return nil, fmt.Errorf("%s: %w", f, err)
+			}
+			for _, path := range deletedPaths {
+				delete(candidates, path)
+				deleted[path] = f
+			}
+		}
+
+		for i, file := range reader.File {
+			if r.opts.Tombstones != "" && file.Name == r.opts.Tombstones {
+				continue
+			}
+			name := r.renamed(file.Name)
+			if name == "" || file.FileInfo().IsDir() || unsupportedReason(file) != "" {
+				continue
+			}
+			path := filepath.Clean(name)
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+			delete(deleted, path)
+			candidates[path] = append(candidates[path], MergeCandidate{
+				Archive: f,
+				ModTime: file.Modified,
+				Size:    file.UncompressedSize64,
+				CRC32:   file.CRC32,
+				index:   i,
+			})
+		}
+		closer.Close()
+	}
+
+	plan := &mergePlan{winners: map[string]MergeCandidate{}, deleted: deleted}
+	for _, path := range paths {
+		if len(candidates[path]) == 0 {
+			continue // Deleted
+		}
+		kept := pickMergeWinner(candidates[path], policy)
+		plan.winners[path] = kept
+
+		conflict := MergeConflict{Path: path, Kept: kept}
+		for _, c := range candidates[path] {
+			if c == kept {
+				continue
+			}
+			// Identical copies are what merging is for, not conflicts
+			if c.Size != kept.Size || c.CRC32 != kept.CRC32 {
+				conflict.Dropped = append(conflict.Dropped, c)
+			}
+		}
+		if len(conflict.Dropped) > 0 {
+			plan.conflicts = append(plan.conflicts, conflict)
+		}
+	}
+	sort.Slice(plan.conflicts, func(i, j int) bool { return plan.conflicts[i].Path < plan.conflicts[j].Path })
+	return plan, nil
+}
+
+func pickMergeWinner(candidates []MergeCandidate, policy string) MergeCandidate {
+	kept := candidates[0]
+	for _, c := range candidates[1:] {
+		switch policy {
+		case MergeNewest:
+			if c.ModTime.After(kept.ModTime) {
+				kept = c
+			}
+		case MergeOldest:
+			if c.ModTime.Before(kept.ModTime) {
+				kept = c
+			}
+		case MergeLast:
+			kept = c
+		}
+	}
+	return kept
+}
+
+// keeps tells whether the entry at index in archive is written for path
+func (p *mergePlan) keeps(path string, archive string, index int) (MergeCandidate, bool) {
+	kept := p.winners[filepath.Clean(path)]
+	return kept, kept.Archive == archive && kept.index == index
+}
+
+// readTombstones reads the paths listed in a tombstones entry, one per line,
+// ignoring empty lines and # comments
+func (r *run) readTombstones(file *zip.File) ([]string, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var paths []string
+	lines := bufio.NewScanner(rc)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name := r.renamed(line); name != "" {
+			paths = append(paths, filepath.Clean(name))
+		}
+	}
+	return paths, lines.Err()
+}
+
+// removeDeleted removes the outputs of previous runs for the paths left
+// deleted by the tombstones
+func (r *run) removeDeleted(destination string) error {
+	paths := make([]string, 0, len(r.merge.deleted))
+	for path := range r.merge.deleted {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		filePath := filepath.Join(destination, path)
+		if !strings.HasPrefix(filePath, filepath.Clean(destination)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid file path in tombstones of %s: %s", r.merge.deleted[path], filePath)
+		}
+		err := r.fs.Remove(filePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		r.logger.Printf("removed %s, deleted by %s", filePath, r.merge.deleted[path])
+		if err = r.audit(AuditDelete, filePath, "", nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeConflictReport(path string, conflicts []MergeConflict) error {
+	if conflicts == nil {
+		conflicts = []MergeConflict{}
+	}
+	data, err := json.MarshalIndent(conflicts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,289 @@
+package catzip
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Credentials given to the machine or the pod by the cloud it runs in
+
+// metadataClient gives up quickly on the link-local addresses, which
+// nothing answers outside the cloud. It never goes through a proxy.
+var metadataClient = &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{}}
+
+// MetadataCredentials asks the ECS or EKS Pod Identity container endpoint
+// when the environment has one, the EC2 instance metadata service (IMDSv2)
+// otherwise
+type MetadataCredentials struct{}
+
+func (MetadataCredentials) Credentials() (Credentials, error) {
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return containerCredentials("http://169.254.170.2" + uri)
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); uri != "" {
+		return containerCredentials(uri)
+	}
+	return instanceCredentials()
+}
+
+// awsMetadataCredentials is the JSON of the container endpoint and of the
+// instance metadata service
+type awsMetadataCredentials struct {
+	Code            string // Success, only from the instance metadata service
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+func (m awsMetadataCredentials) credentials(source string) (Credentials, error) {
+	if m.AccessKeyID == "" || m.SecretAccessKey == "" {
+		return Credentials{}, fmt.Errorf("%s: no AccessKeyId and SecretAccessKey", source)
+	}
+	return Credentials{
+		AccessKeyID:     m.AccessKeyID,
+		SecretAccessKey: m.SecretAccessKey,
+		SessionToken:    m.Token,
+		Expires:         m.Expiration,
+		Source:          source,
+	}, nil
+}
+
+func containerCredentials(uri string) (Credentials, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if path := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return Credentials{}, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	var m awsMetadataCredentials
+	if err := metadataJSON(req, &m); err != nil {
+		return Credentials{}, fmt.Errorf("container metadata: %w", err)
+	}
+	return m.credentials("container metadata")
+}
+
+func instanceCredentials() (Credentials, error) {
+	if os.Getenv("AWS_EC2_METADATA_DISABLED") == "true" {
+		return Credentials{}, fmt.Errorf("instance metadata: %w", errNoCredentials)
+	}
+	endpoint := firstNonEmpty(os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "http://169.254.169.254")
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	req, err := http.NewRequest(http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		// Not on EC2
+		return Credentials{}, fmt.Errorf("instance metadata: %w", errNoCredentials)
+	}
+	token, err := metadataBody(resp)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("instance metadata: %w", err)
+	}
+
+	get := func(path string) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, endpoint+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if err == nil {
+			req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		}
+		return req, err
+	}
+	req, err = get("")
+	if err != nil {
+		return Credentials{}, err
+	}
+	resp, err = metadataClient.Do(req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("instance metadata: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		// An instance without a role
+		return Credentials{}, fmt.Errorf("instance metadata: %w", errNoCredentials)
+	}
+	roles, err := metadataBody(resp)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("instance metadata: %w", err)
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+
+	if req, err = get(role); err != nil {
+		return Credentials{}, err
+	}
+	var m awsMetadataCredentials
+	if err := metadataJSON(req, &m); err != nil {
+		return Credentials{}, fmt.Errorf("instance metadata: %w", err)
+	}
+	if m.Code != "Success" {
+		return Credentials{}, fmt.Errorf("instance metadata: role %s: %s", role, m.Code)
+	}
+	return m.credentials("instance metadata " + role)
+}
+
+// GoogleMetadataCredentials asks the GCE and GKE metadata server for an
+// access token of the default service account
+type GoogleMetadataCredentials struct{}
+
+func (GoogleMetadataCredentials) Credentials() (Credentials, error) {
+	host := firstNonEmpty(os.Getenv("GCE_METADATA_HOST"), "metadata.google.internal")
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		// Not on Google Cloud
+		return Credentials{}, fmt.Errorf("gce metadata: %w", errNoCredentials)
+	}
+	body, err := metadataBody(resp)
+	if err == nil {
+		err = json.Unmarshal(body, &token)
+	}
+	if err != nil {
+		return Credentials{}, fmt.Errorf("gce metadata: %w", err)
+	}
+	if token.AccessToken == "" {
+		return Credentials{}, errors.New("gce metadata: no access_token")
+	}
+	return Credentials{
+		Token:   token.AccessToken,
+		Expires: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+		Source:  "gce metadata",
+	}, nil
+}
+
+// WebIdentityCredentials exchanges the token of a Kubernetes service
+// account for the credentials of an IAM role with STS, like IRSA does
+type WebIdentityCredentials struct {
+	// "" are AWS_WEB_IDENTITY_TOKEN_FILE, AWS_ROLE_ARN and
+	// AWS_ROLE_SESSION_NAME, or cat-zip
+	TokenFile   string
+	RoleARN     string
+	SessionName string
+	// "" is the STS endpoint of AWS_REGION, or the global one
+	Endpoint string
+	// nil is http.DefaultClient
+	Client *http.Client
+}
+
+func (w WebIdentityCredentials) Credentials() (Credentials, error) {
+	tokenFile := firstNonEmpty(w.TokenFile, os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	roleARN := firstNonEmpty(w.RoleARN, os.Getenv("AWS_ROLE_ARN"))
+	if tokenFile == "" || roleARN == "" {
+		return Credentials{}, fmt.Errorf("web identity: %w", errNoCredentials)
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("web identity: %w", err)
+	}
+
+	endpoint := w.Endpoint
+	if endpoint == "" {
+		endpoint = "https://sts.amazonaws.com"
+		if region := os.Getenv("AWS_REGION"); region != "" {
+			endpoint = "https://sts." + region + ".amazonaws.com"
+		}
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {firstNonEmpty(w.SessionName, os.Getenv("AWS_ROLE_SESSION_NAME"), "cat-zip")},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("web identity: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Credentials{}, fmt.Errorf("web identity: %w", err)
+	}
+
+	var out struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+		Error struct {
+			Code    string
+			Message string
+		}
+	}
+	if err := xml.Unmarshal(body, &out); err != nil {
+		return Credentials{}, fmt.Errorf("web identity: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, fmt.Errorf("web identity: %s: %s %s", resp.Status, out.Error.Code, out.Error.Message)
+	}
+	c := out.Credentials
+	return Credentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+		Expires:         c.Expiration,
+		Source:          "web identity " + roleARN,
+	}, nil
+}
+
+// metadataJSON decodes the JSON answer of a metadata server to req
+func metadataJSON(req *http.Request, v any) error {
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return err
+	}
+	body, err := metadataBody(resp)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// metadataBody reads the body of a metadata server answer, failing on
+// anything but 200
+func metadataBody(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package catzip
+
+import (
+	"bytes"
+	"errors"
+)
+
+type mmapFile struct {
+	*bytes.Reader
+}
+
+func openMmap(name string) (*mmapFile, error) {
+	return nil, errors.New("memory-mapped reading is not supported on this platform")
+}
+
+func (m *mmapFile) Close() error {
+	return nil
+}
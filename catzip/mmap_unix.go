@@ -0,0 +1,47 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package catzip
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+)
+
+// mmapFile serves reads straight from a read-only mapping of a file
+type mmapFile struct {
+	*bytes.Reader
+	data []byte
+}
+
+func openMmap(name string) (*mmapFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	// The mapping stays valid after the descriptor is closed
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, errors.New("can't map an empty file")
+	}
+	if int64(int(size)) != size {
+		return nil, errors.New("file is too large to be mapped")
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &mmapFile{Reader: bytes.NewReader(data), data: data}, nil
+}
+
+func (m *mmapFile) Close() error {
+	return syscall.Munmap(m.data)
+}
@@ -0,0 +1,230 @@
+package catzip
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// nestedKind tells whether the entry extracted as name is an archive to
+// extract in turn, with Options.MaxDepth
+func (r *run) nestedKind(name string, entry *EntryInfo) (inputKind, bool) {
+	if entry.depth >= r.opts.MaxDepth {
+		return 0, false
+	}
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		return kindTarGz, true
+	case strings.HasSuffix(lower, ".gz"):
+		return kindGz, true
+	case strings.HasSuffix(lower, ".zip"):
+		return kindZip, true
+	}
+	return 0, false
+}
+
+// extractNested extracts the archive entry, that would be written to
+// filePath, into a directory named after it. A gz one is extracted next to
+// it instead. Its members are extracted like the entries of the inputs, so
+// the archives among them are extracted in turn until Options.MaxDepth.
+func (r *run) extractNested(filePath string, entry *EntryInfo, kind inputKind, open func() (io.ReadCloser, error)) error {
+	nested := entry.Name
+	if entry.Nested != "" {
+		nested = entry.Nested + "/" + entry.Name
+	}
+	archive := manifestArchive{Path: entry.Archive, Nested: nested}
+	member := func(i int) *EntryInfo {
+		return &EntryInfo{Archive: entry.Archive, Nested: nested, Entry: i + 1, index: i, depth: entry.depth + 1}
+	}
+
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	switch kind {
+	case kindGz:
+		gzEntry := member(0)
+		gzEntry.Name = strings.TrimSuffix(path.Base(entry.Name), path.Ext(entry.Name))
+		err = r.extractNestedGz(filepath.Dir(filePath), reader, gzEntry, &archive)
+	case kindTarGz:
+		err = r.extractNestedTar(nestedDir(filePath), reader, member, &archive)
+	default:
+		err = r.extractNestedZip(nestedDir(filePath), reader, member, &archive)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", nested, err)
+	}
+	r.manifest.add(archive)
+	return nil
+}
+
+// nestedDir is the directory the members of the archive at filePath go to
+func nestedDir(filePath string) string {
+	lower := strings.ToLower(filePath)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			return filePath[:len(filePath)-len(ext)]
+		}
+	}
+	return filePath
+}
+
+func (r *run) extractNestedGz(dir string, reader io.Reader, entry *EntryInfo, archive *manifestArchive) error {
+	gzReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer gzReader.Close()
+
+	entry.Mode = 0666
+	entry.ModTime = gzReader.ModTime
+	entry.Comment = gzReader.Comment
+	entry.Entries = 1
+	open := func() (io.ReadCloser, error) { return io.NopCloser(gzReader), nil }
+	return r.extractMember(dir, entry, "", false, -1, open, archive)
+}
+
+func (r *run) extractNestedTar(dir string, reader io.Reader, member func(int) *EntryInfo, archive *manifestArchive) error {
+	gzReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer gzReader.Close()
+
+	// The members replace the archive in the total, as they are found
+	r.addTotalEntries(-1)
+	tarReader := tar.NewReader(gzReader)
+	for i := 0; ; i++ {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read tar file: %w", err)
+		}
+		isDir := header.Typeflag == tar.TypeDir
+		if !isDir {
+			r.addTotalEntries(1)
+		}
+
+		entry := member(i)
+		entry.Name = header.Name
+		entry.Size = uint64(header.Size)
+		entry.Mode = header.FileInfo().Mode()
+		entry.ModTime = header.ModTime
+		if r.opts.Handlers["tar"].Xattrs {
+			entry.Xattrs = xattrs(header)
+		}
+		open := func() (io.ReadCloser, error) { return io.NopCloser(tarReader), nil }
+		if err := r.extractMember(dir, entry, unsupportedTarReason(header), isDir, header.Size, open, archive); err != nil {
+			return err
+		}
+	}
+}
+
+// Of the spooled nested zip files, the walk leaves them out when OutDir is
+// under Dir
+const nestedSpoolPrefix = ".catzip-nested-"
+
+// extractNestedZip spools the zip file to a hidden file of OutDir first, its
+// central directory is at the end. It is written through r.fs like the
+// other outputs, so the sandbox and the quotas apply to it.
+func (r *run) extractNestedZip(dir string, reader io.Reader, member func(int) *EntryInfo, archive *manifestArchive) error {
+	name := filepath.Join(r.opts.OutDir, fmt.Sprintf("%s%d-%d.zip", nestedSpoolPrefix, os.Getpid(), r.nestedSpools.Add(1)))
+	spooled, err := r.fs.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer r.fs.Remove(name)
+	_, err = io.Copy(spooled, reader)
+	if closeErr := spooled.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	readerAt, size, closer, err := openReaderAt(r.fs, name)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	zipReader, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return err
+	}
+	registerDecompressors(zipReader)
+	decodeNames(zipReader, r.opts.Handlers["zip"].NameEncoding)
+	archive.Comment = zipReader.Comment
+
+	files := 0
+	for _, file := range zipReader.File {
+		if !file.FileInfo().IsDir() {
+			files++
+		}
+	}
+	r.addTotalEntries(files - 1)
+	for i, file := range zipReader.File {
+		entry := member(i)
+		entry.Name = file.Name
+		entry.Size = file.UncompressedSize64
+		entry.CompressedSize = file.CompressedSize64
+		entry.Mode = file.Mode()
+		entry.ModTime = file.Modified
+		entry.CRC32 = file.CRC32
+		entry.Comment = file.Comment
+		entry.Entries = len(zipReader.File)
+		registerEntryDecompressor(zipReader, file)
+		if err := r.extractMember(dir, entry, unsupportedReason(file), file.FileInfo().IsDir(), int64(file.UncompressedSize64), file.Open, archive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractMember extracts a member of a nested archive to dir like the
+// handlers do with the entries of the inputs, unless reason tells why it
+// can't be
+func (r *run) extractMember(dir string, entry *EntryInfo, reason string, isDir bool, size int64, open func() (io.ReadCloser, error), archive *manifestArchive) error {
+	if reason != "" {
+		r.skipEntry(entry, reason)
+		archive.Entries = append(archive.Entries, entry)
+		return nil
+	}
+	name := r.renamed(entry.Name)
+	if name == "" {
+		r.skipEntry(entry, "renamed to an empty name")
+		archive.Entries = append(archive.Entries, entry)
+		return nil
+	}
+	if isDir && filepath.Clean(name) == "." {
+		return nil
+	}
+	if !isDir {
+		var ok bool
+		var err error
+		if name, ok, err = r.applyPolicy(entry, name); err != nil {
+			return err
+		}
+		if !ok {
+			archive.Entries = append(archive.Entries, entry)
+			return nil
+		}
+	}
+
+	if err := r.extractEntry(name, entry, dir, isDir, size, open); err != nil {
+		return err
+	}
+	if entry.Output != "" {
+		archive.Entries = append(archive.Entries, entry)
+	}
+	return nil
+}
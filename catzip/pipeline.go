@@ -0,0 +1,487 @@
+package catzip
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+)
+
+// Size of the buffers carrying the decoded entries to the write stage
+const defaultChunkSize = 256 * 1024
+
+// queueDepths bounds the queues between the walk, decode and write stages.
+// Whatever the number of entries in the inputs, at most
+// (entries + write workers) * chunks buffers of Options.ChunkSize are in flight.
+type queueDepths struct {
+	files   int // Input files waiting for a decode worker
+	entries int // Decoded entries waiting for a write worker
+	chunks  int // Buffers waiting to be written, per entry
+}
+
+// Returned to the decoders once the run has failed, so they stop early
+var errRunFailed = errors.New("catzip: run failed")
+
+// writeTask carries the content of one decoded entry from a decode worker to
+// a write worker, it is an io.WriteCloser so the decoders can io.Copy into it
+type writeTask struct {
+	r       *run
+	entry   *EntryInfo // Completed by the write stage
+	size    int64      // Expected size or -1 when unknown
+	chunks  chan []byte
+	catOnly bool     // entry.Output is an input file that is only appended to cat
+	plain   []string // Output files gzipped by recompress, removed once in cat
+}
+
+// newWriteTask queues entry on the write stage, blocking while the queue is
+// full so the decoders can't get too far ahead of the disk
+func (r *run) newWriteTask(entry *EntryInfo, size int64) *writeTask {
+	t := &writeTask{
+		r:      r,
+		entry:  entry,
+		size:   size,
+		chunks: make(chan []byte, r.depths.chunks),
+	}
+	r.writeTasks <- t
+	return t
+}
+
+// newCatOnlyTask queues an existing file to be appended to cat, it has to be
+// closed without writing to it
+func (r *run) newCatOnlyTask(entry *EntryInfo) *writeTask {
+	t := &writeTask{
+		r:       r,
+		entry:   entry,
+		size:    -1,
+		chunks:  make(chan []byte),
+		catOnly: true,
+	}
+	r.writeTasks <- t
+	return t
+}
+
+func (t *writeTask) Write(p []byte) (int, error) {
+	if t.r.failed() {
+		return 0, errRunFailed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		chunk := t.r.chunkPool.Get().([]byte)
+		n := copy(chunk, p)
+		t.chunks <- chunk[:n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// ReadFrom reads straight into the chunks, without the buffer of io.Copy
+func (t *writeTask) ReadFrom(reader io.Reader) (int64, error) {
+	var written int64
+	for {
+		if t.r.failed() {
+			return written, errRunFailed
+		}
+
+		chunk := t.r.chunkPool.Get().([]byte)
+		n := 0
+		var err error
+		for n < len(chunk) && err == nil {
+			var m int
+			m, err = reader.Read(chunk[n:])
+			n += m
+		}
+		if n > 0 {
+			t.chunks <- chunk[:n]
+			written += int64(n)
+		} else {
+			t.r.chunkPool.Put(chunk)
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+func (t *writeTask) Close() error {
+	close(t.chunks)
+	return nil
+}
+
+// startWriters starts the write stage, the returned function closes the queue
+// and waits for the pending entries to be written
+func (r *run) startWriters() func() {
+	r.writeTasks = make(chan *writeTask, r.depths.entries)
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.opts.WriteWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range r.writeTasks {
+				if r.failed() {
+					t.discard()
+					continue
+				}
+				if err := t.write(); err != nil {
+					r.fail(err)
+					t.discard()
+				}
+			}
+		}()
+	}
+
+	return func() {
+		close(r.writeTasks)
+		wg.Wait()
+	}
+}
+
+// discard drops the remaining chunks so the decoder feeding t isn't blocked
+func (t *writeTask) discard() {
+	for chunk := range t.chunks {
+		t.r.chunkPool.Put(chunk[:cap(chunk)])
+	}
+}
+
+func (t *writeTask) write() error {
+	r := t.r
+	if t.catOnly {
+		<-t.chunks
+		return t.appendToCat()
+	}
+
+	destinationFile, err := r.fs.OpenFile(t.entry.Output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, t.entry.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	if err = r.audit(AuditCreate, t.entry.Output, "", t.entry); err != nil {
+		destinationFile.Close()
+		return err
+	}
+
+	var filterCommands [][]string
+	filterCommand := r.opts.FilterCommand
+	if config := r.configs.get(t.entry.Archive); config != nil && config.filterSet {
+		filterCommand = config.filterCommand
+	}
+	if len(filterCommand) > 0 {
+		filterCommands = append(filterCommands, filterCommand)
+	}
+	filterCommands = append(filterCommands, t.entry.transforms...)
+
+	// The filters output can be of any size
+	size := t.size
+	if len(filterCommands) > 0 {
+		size = -1
+	}
+	split := r.opts.SplitEntrySize > 0 && (size < 0 || size > r.opts.SplitEntrySize)
+	if osFile, ok := destinationFile.(*os.File); ok && r.opts.Preallocate && size > 0 && !split {
+		if err = preallocateFile(osFile, size); err != nil {
+			destinationFile.Close()
+			return err
+		}
+	}
+	if r.opts.SplitEntrySize > 0 {
+		destinationFile = r.newSplitWriter(t.entry, destinationFile)
+	}
+
+	var scan *scanner
+	if len(r.opts.ScanCommand) > 0 {
+		if scan, err = r.startScanner(); err != nil {
+			destinationFile.Close()
+			return err
+		}
+	}
+
+	var chunks <-chan []byte = t.chunks
+	var filters []*filter
+	abortFilters := func() {
+		for _, f := range filters {
+			f.abort(&r.chunkPool)
+		}
+	}
+	for _, args := range filterCommands {
+		f, err := r.startFilter(args, chunks)
+		if err != nil {
+			abortFilters()
+			destinationFile.Close()
+			if scan != nil {
+				scan.abort()
+			}
+			return err
+		}
+		filters = append(filters, f)
+		chunks = f.out
+	}
+
+	var hasher *sideHasher
+	if r.newHash != nil {
+		hasher = startSideHasher(r.newHash(), r.depths.chunks, &r.chunkPool)
+	}
+
+	var sig *signer
+	if r.opts.Signature != "" {
+		sig = newSigner(r.opts.Signature)
+	}
+	var class *classifier
+	if r.opts.Classify {
+		class = &classifier{}
+	}
+	var text *textDetector
+	if r.opts.DetectText {
+		text = &textDetector{}
+	}
+
+	var written int64
+	for chunk := range chunks {
+		written += int64(len(chunk))
+		_, err = destinationFile.Write(chunk)
+		if scan != nil {
+			scan.Write(chunk)
+		}
+		if sig != nil {
+			sig.Write(chunk)
+		}
+		if class != nil {
+			class.Write(chunk)
+		}
+		if text != nil {
+			text.Write(chunk)
+		}
+		if hasher != nil {
+			hasher.chunks <- chunk
+		} else {
+			r.chunkPool.Put(chunk[:cap(chunk)])
+		}
+		if err != nil {
+			destinationFile.Close()
+			if hasher != nil {
+				hasher.Sum()
+			}
+			if scan != nil {
+				scan.abort()
+			}
+			abortFilters()
+			return err
+		}
+	}
+	err = destinationFile.Close()
+	// The first failing filter is the cause, the next ones only got less input
+	for _, f := range filters {
+		if err == nil {
+			err = f.err
+		}
+	}
+	if err != nil {
+		if hasher != nil {
+			hasher.Sum()
+		}
+		if scan != nil {
+			scan.abort()
+		}
+		return err
+	}
+
+	t.entry.Size = uint64(written)
+	if sig != nil {
+		t.entry.Signature = sig.Sum()
+	}
+	if class != nil {
+		t.entry.Format = class.format()
+	}
+	if text != nil {
+		t.entry.LineEndings, t.entry.Encoding = text.result()
+	}
+	if hasher != nil {
+		t.entry.Hash = hex.EncodeToString(hasher.Sum())
+	}
+
+	if scan != nil {
+		verdict, output, err := scan.verdict()
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.entry.Output, err)
+		}
+		t.entry.Scan = verdict
+		if verdict == ScanMalicious {
+			r.logger.Printf("%s was flagged by the scanner, leaving it out of cat: %s", t.entry.Output, output)
+			return r.quarantine(t.entry, output)
+		}
+	}
+
+	if err := t.recompress(); err != nil {
+		return err
+	}
+	// The extracted file is appended to cat instead of decoding everything twice
+	return t.appendToCat()
+}
+
+func (t *writeTask) appendToCat() error {
+	r := t.r
+	var reader io.Reader
+	if t.catOnly {
+		extractedFile, err := r.in.Open(t.entry.Output)
+		if err != nil {
+			return err
+		}
+		defer extractedFile.Close()
+		reader = extractedFile
+	} else {
+		extracted, closeExtracted, err := r.openOutput(t.entry)
+		if err != nil {
+			return err
+		}
+		defer closeExtracted()
+		reader = extracted
+	}
+
+	// Not written by the write stage, so not hashed yet
+	var inputHash hash.Hash
+	if t.catOnly && r.newHash != nil {
+		inputHash = r.newHash()
+		reader = io.TeeReader(reader, inputHash)
+	}
+	var sig *signer
+	if t.catOnly && r.opts.Signature != "" {
+		sig = newSigner(r.opts.Signature)
+		reader = io.TeeReader(reader, sig)
+	}
+	var text *textDetector
+	if t.catOnly && r.opts.DetectText {
+		text = &textDetector{}
+		reader = io.TeeReader(reader, text)
+	}
+
+	if t.catOnly && r.opts.Classify {
+		buffered := bufio.NewReaderSize(reader, classifySampleSize)
+		sample, err := buffered.Peek(classifySampleSize)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return err
+		}
+		t.entry.Format = classify(sample, err == io.EOF)
+		reader = buffered
+	}
+	binary := false
+	if r.opts.Escape != "" {
+		// The buffer of Classify when there is one
+		buffered := bufio.NewReaderSize(reader, classifySampleSize)
+		sample, err := buffered.Peek(classifySampleSize)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return err
+		}
+		binary = isBinary(sample)
+		reader = buffered
+	}
+
+	routeName := t.entry.route
+	if config := r.configs.get(t.entry.Archive); routeName == "" && config != nil {
+		routeName = firstNonEmpty(config.formatRoutes[t.entry.Format], config.route)
+	}
+	if routeName == "" {
+		routeName = r.opts.FormatRoutes[t.entry.Format]
+	}
+
+	r.catFileMu.Lock()
+	out, offset, lines := r.catOut, &r.catOffset, &r.catLines
+	if routeName != "" && routeName != r.opts.CatFileName {
+		route, err := r.routeTarget(routeName)
+		if err != nil {
+			r.catFileMu.Unlock()
+			return err
+		}
+		out, offset, lines = route.file, &route.offset, &route.lines
+		t.entry.Cat = route.path
+	}
+	var w io.Writer = out
+	var annotated *annotator
+	if len(r.opts.Annotate) > 0 {
+		annotated = newAnnotator(out, r.opts.Annotate, t.entry, *lines)
+		w = annotated
+	}
+	// Stripped and limited before the lines are annotated
+	var limited *lineLimiter
+	if r.opts.MaxLineBytes > 0 {
+		limited = &lineLimiter{w: w, max: r.opts.MaxLineBytes, wrap: r.opts.LongLines == LongLinesWrap}
+		w = limited
+	}
+	var stripped *ansiStripper
+	if r.opts.StripANSI {
+		stripped = &ansiStripper{w: w}
+		w = stripped
+	}
+	// Escaped first, so the escapes of binary entries go through as they are
+	var escaped *escaper
+	if binary {
+		escaped = &escaper{w: w, mode: r.opts.Escape}
+		w = escaped
+		t.entry.Escaped = r.opts.Escape
+	}
+	n, err := io.Copy(w, reader)
+	if err == nil && escaped != nil {
+		err = escaped.close()
+	}
+	switch {
+	case annotated != nil:
+		t.entry.CatLength = annotated.written
+		// The newline appended below ends the last line
+		*lines += annotated.newlines + 1
+	case limited != nil:
+		t.entry.CatLength = limited.written
+	case stripped != nil:
+		t.entry.CatLength = stripped.written
+	case escaped != nil:
+		t.entry.CatLength = escaped.written
+	default:
+		t.entry.CatLength = n
+	}
+	if limited != nil {
+		t.entry.LongLines = limited.lines
+		r.longLines += limited.lines
+	}
+	t.entry.CatOffset = *offset
+	*offset += t.entry.CatLength
+	if err == nil {
+		_, err = io.WriteString(out, "\n")
+		*offset++
+	}
+	if err != nil {
+		r.catFileMu.Unlock()
+		return err
+	}
+
+	if t.catOnly {
+		t.entry.Size = uint64(n)
+	}
+	if inputHash != nil {
+		t.entry.Hash = hex.EncodeToString(inputHash.Sum(nil))
+	}
+	if sig != nil {
+		t.entry.Signature = sig.Sum()
+	}
+	if text != nil {
+		t.entry.LineEndings, t.entry.Encoding = text.result()
+	}
+	t.recompressed()
+	t.entry.Status = EntryWritten
+	// Along with the append, so the checkpoint offsets match its entries
+	if r.checkpoint != nil {
+		err = r.recordCheckpoint(t.entry)
+	}
+	r.catFileMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := t.removePlain(); err != nil {
+		return err
+	}
+	r.entryDone(t.entry)
+	return nil
+}
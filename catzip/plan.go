@@ -0,0 +1,59 @@
+package catzip
+
+import "errors"
+
+// PlanResult is what a run with the same options would do with the inputs
+type PlanResult struct {
+	Added     []string // Inputs not in the state file
+	Changed   []string // Inputs whose size or modification time changed
+	Unchanged int      // Inputs that would be skipped
+}
+
+// Plan compares the input files selected by opts with opts.StatePath,
+// without processing anything
+func Plan(opts Options) (*PlanResult, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.StatePath == "" {
+		return nil, errors.New("plan requires a state file")
+	}
+	state, err := openState(opts.StatePath, opts.StateIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	walkOpts := walkOptions{
+		exts:          splitExts(opts.Ext),
+		passthrough:   parseExtList(opts.PassthroughExt),
+		requireMarker: opts.RequireMarker,
+		stableFor:     opts.StableFor,
+		ignoreFile:    opts.IgnoreFile,
+		dirConfigFile: opts.DirConfigFile,
+		checkConfig:   opts.checkDirConfig,
+		logger:        opts.logger(),
+	}
+	if opts.OnlyNewerThanState {
+		walkOpts.onlyNewerThan = state.newest()
+	}
+	in := inputFS(opts)
+	defer closeInputs(in)
+	files, infos := walkInputs(in, opts.Dir, walkOpts)
+
+	changes, err := state.changes(files, infos)
+	if err != nil {
+		return nil, err
+	}
+	plan := &PlanResult{}
+	for i, f := range files {
+		switch changes[i] {
+		case stateNew:
+			plan.Added = append(plan.Added, f)
+		case stateChanged:
+			plan.Changed = append(plan.Changed, f)
+		default:
+			plan.Unchanged++
+		}
+	}
+	return plan, nil
+}
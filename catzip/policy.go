@@ -0,0 +1,229 @@
+package catzip
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PolicyDecision is what Options.PolicyScript or Options.PolicyCommand
+// decides for an entry, the zero value extracts it as usual
+type PolicyDecision struct {
+	Skip   bool   `json:"skip"`
+	Reason string `json:"reason"` // Why it is skipped
+	// New entry name, before the output path is computed. gz and plain inputs
+	// only have their base name replaced.
+	Rename string `json:"rename"`
+	// File in OutDir the entry is concatenated to instead of the cat file
+	Route string `json:"route"`
+	// Commands, split on spaces, the entry is piped through in order after
+	// Options.FilterCommand
+	Transform []string `json:"transform"`
+}
+
+// policy decides what to do with the entries, it is called by the workers
+// at the same time
+type policy interface {
+	decide(entry *EntryInfo) (PolicyDecision, error)
+	Close() error
+}
+
+// scriptPolicy runs the decide function of Options.PolicyScript
+type scriptPolicy struct {
+	script *starScript
+}
+
+// compilePolicyScript runs src, which must define decide(entry)
+func compilePolicyScript(src string) (*scriptPolicy, error) {
+	script, err := compileStarlark("policy script", src)
+	if err != nil {
+		return nil, err
+	}
+	if fn, ok := script.globals["decide"].(*starFunc); !ok || len(fn.params) != 1 {
+		return nil, errors.New("policy script must define decide(entry)")
+	}
+	return &scriptPolicy{script: script}, nil
+}
+
+// entryStruct is the entry argument of the script, the attributes have the
+// names of the JSON fields of EntryInfo
+func entryStruct(entry *EntryInfo) *starStruct {
+	xattrs := newStarDict()
+	names := make([]string, 0, len(entry.Xattrs))
+	for name := range entry.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		xattrs.set(name, entry.Xattrs[name])
+	}
+	starFreeze(xattrs)
+	return &starStruct{name: "entry", attrs: map[string]starValue{
+		"archive":         entry.Archive,
+		"name":            entry.Name,
+		"nested":          entry.Nested,
+		"layer":           entry.Layer,
+		"size":            int64(entry.Size),
+		"compressed_size": int64(entry.CompressedSize),
+		"mode":            int64(entry.Mode.Perm()),
+		"is_dir":          entry.Mode.IsDir(),
+		"mtime":           entry.ModTime.Unix(), // Seconds since 1970
+		"crc32":           int64(entry.CRC32),
+		"comment":         entry.Comment,
+		"format":          entry.Format,
+		"xattrs":          xattrs,
+		"entry":           int64(entry.Entry),
+		"entries":         int64(entry.Entries),
+	}}
+}
+
+func (p *scriptPolicy) decide(entry *EntryInfo) (PolicyDecision, error) {
+	result, err := p.script.call("decide", entryStruct(entry))
+	if err != nil {
+		return PolicyDecision{}, fmt.Errorf("%w, for %s in %s", err, entry.Name, entry.Archive)
+	}
+	var decision PolicyDecision
+	if result == nil {
+		return decision, nil
+	}
+	d, ok := result.(*starDict)
+	if !ok {
+		return PolicyDecision{}, fmt.Errorf("policy script decided %s for %s in %s, expected a dict or None", starString(result, true), entry.Name, entry.Archive)
+	}
+	for _, key := range d.keys {
+		value := d.values[key]
+		var ok bool
+		switch key {
+		case "skip":
+			decision.Skip, ok = value.(bool)
+		case "reason":
+			decision.Reason, ok = value.(string)
+		case "rename":
+			decision.Rename, ok = value.(string)
+		case "route":
+			decision.Route, ok = value.(string)
+		case "transform":
+			var l *starList
+			if l, ok = value.(*starList); ok {
+				decision.Transform, err = starStringArgs(l.elems)
+				ok = err == nil
+			}
+		default:
+			return PolicyDecision{}, fmt.Errorf("policy script decided %s for %s in %s, expected skip, reason, rename, route or transform", starString(key, true), entry.Name, entry.Archive)
+		}
+		if !ok {
+			return PolicyDecision{}, fmt.Errorf("policy script decided %s: %s for %s in %s, expected a bool for skip, a list of strings for transform and strings otherwise",
+				starString(key, true), starString(value, true), entry.Name, entry.Archive)
+		}
+	}
+	return decision, checkDecision("policy script", entry, decision)
+}
+
+func (p *scriptPolicy) Close() error { return nil }
+
+// checkDecision makes sure the route and the transforms of decision, from
+// source, can be used
+func checkDecision(source string, entry *EntryInfo, decision PolicyDecision) error {
+	if decision.Route != "" && !validRouteName(decision.Route) {
+		return fmt.Errorf("%s routed %s in %s to %q, expected a file name", source, entry.Name, entry.Archive, decision.Route)
+	}
+	for _, transform := range decision.Transform {
+		if len(strings.Fields(transform)) == 0 {
+			return fmt.Errorf("%s gave an empty transform for %s in %s", source, entry.Name, entry.Archive)
+		}
+	}
+	return nil
+}
+
+// commandPolicy is the process running Options.PolicyCommand. It gets one
+// JSON EntryInfo per line on stdin and answers each with a JSON
+// PolicyDecision line on stdout, one entry at a time.
+type commandPolicy struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func startPolicy(args []string) (*commandPolicy, error) {
+	p := &commandPolicy{cmd: exec.Command(args[0], args[1:]...)}
+	p.cmd.Stderr = os.Stderr
+	var err error
+	if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start the policy command: %w", err)
+	}
+	p.stdout = bufio.NewReader(stdout)
+	return p, nil
+}
+
+func (p *commandPolicy) decide(entry *EntryInfo) (PolicyDecision, error) {
+	request, err := json.Marshal(entry)
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err = p.stdin.Write(append(request, '\n')); err != nil {
+		return PolicyDecision{}, fmt.Errorf("policy command: %w", err)
+	}
+	line, err := p.stdout.ReadBytes('\n')
+	if err != nil {
+		return PolicyDecision{}, fmt.Errorf("policy command didn't answer for %s in %s: %w", entry.Name, entry.Archive, err)
+	}
+
+	var decision PolicyDecision
+	if err = json.Unmarshal(line, &decision); err != nil {
+		return PolicyDecision{}, fmt.Errorf("policy command answered %q for %s in %s: %w", strings.TrimSpace(string(line)), entry.Name, entry.Archive, err)
+	}
+	return decision, checkDecision("policy command", entry, decision)
+}
+
+func (p *commandPolicy) Close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+// applyPolicy asks the policy about entry, it returns false when the
+// entry was skipped. The route and transforms are kept in entry for the write
+// stage, the rename is returned.
+func (r *run) applyPolicy(entry *EntryInfo, name string) (string, bool, error) {
+	if r.policy == nil {
+		return name, true, nil
+	}
+	decision, err := r.policy.decide(entry)
+	if err != nil {
+		return "", false, err
+	}
+	if decision.Skip {
+		reason := "policy"
+		if decision.Reason != "" {
+			reason += ": " + decision.Reason
+		}
+		r.skipEntry(entry, reason)
+		return "", false, nil
+	}
+
+	entry.route = decision.Route
+	for _, transform := range decision.Transform {
+		entry.transforms = append(entry.transforms, strings.Fields(transform))
+	}
+	if decision.Rename != "" {
+		name = decision.Rename
+	}
+	return name, true, nil
+}
@@ -0,0 +1,115 @@
+package catzip
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPolicy = `
+# Routed apart from the other entries
+ROUTES = {".csv": "csv_blob"}
+
+def route(name):
+    for ext, file in ROUTES.items():
+        if name.endswith(ext):
+            return file
+    return None
+
+def decide(entry):
+    if entry.size == 0:
+        return {"skip": True, "reason": "empty"}
+    file = route(entry.name)
+    if file:
+        return {"route": file}
+    if entry.name.startswith("old_"):
+        return {"rename": entry.name.removeprefix("old_")}
+    if entry.name == "up.txt":
+        return {"transform": ["tr a-z A-Z"]}
+`
+
+func TestPolicyScript(t *testing.T) {
+	if _, err := exec.LookPath("tr"); err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	if err := os.MkdirAll(in, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeZip(t, filepath.Join(in, "a.zip"), map[string]string{
+		"empty.log":   "",
+		"data.csv":    "1,2\n",
+		"old_app.log": "app\n",
+		"up.txt":      "up\n",
+		"kept.log":    "kept\n",
+	})
+
+	opts := DefaultOptions()
+	opts.Dir = in
+	opts.Ext = ".zip"
+	opts.OutDir = filepath.Join(dir, "out")
+	opts.PolicyScript = testPolicy
+	opts.Logger = log.New(io.Discard, "", 0)
+	if err := os.MkdirAll(opts.OutDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := Run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(summary.Skipped) != 1 || summary.Skipped[0].Name != "empty.log" || summary.Skipped[0].Reason != "policy: empty" {
+		t.Errorf("skipped %+v, expected empty.log", summary.Skipped)
+	}
+	if _, err := os.Stat(filepath.Join(opts.OutDir, "app.log")); err != nil {
+		t.Errorf("old_app.log wasn't renamed: %v", err)
+	}
+	routed, err := os.ReadFile(filepath.Join(opts.OutDir, "csv_blob"))
+	if err != nil || !bytes.HasPrefix(routed, []byte("1,2\n")) {
+		t.Errorf("csv_blob is %q, %v", routed, err)
+	}
+	cat, err := os.ReadFile(filepath.Join(opts.OutDir, opts.CatFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"app\n", "UP\n", "kept\n"} {
+		if !bytes.Contains(cat, []byte(want)) {
+			t.Errorf("cat file %q doesn't have %q", cat, want)
+		}
+	}
+	if bytes.Contains(cat, []byte("1,2")) {
+		t.Errorf("cat file %q has the routed entry", cat)
+	}
+}
+
+func TestPolicyScriptErrors(t *testing.T) {
+	entry := &EntryInfo{Archive: "a.zip", Name: "a.log", Size: 3}
+	for _, test := range []struct {
+		script, err string
+	}{
+		{"x = 1\n", "must define decide(entry)"},
+		{"def decide(entry):\n  return {\"skip\": 1}\n", `"skip": 1`},
+		{"def decide(entry):\n  return {\"route\": \"../x\"}\n", "expected a file name"},
+		{"def decide(entry):\n  return {\"delete\": True}\n", "expected skip, reason, rename, route or transform"},
+		{"def decide(entry):\n  return entry.nope\n", "policy script:2: entry has no attribute nope"},
+		{"def decide(entry):\n  return decide(entry)\n", "called recursively"},
+		{"def decide(entry):\n  fail(\"no\", entry.size)\n", "policy script:2: fail: no 3"},
+		{"def decide(entry):\n  for x in [0] * 2000000:\n    pass\n", "list longer than"},
+		{"L = []\ndef decide(entry):\n  L.append(1)\n", "frozen list"},
+		{"def decide(entry):\n  if True\n    pass\n", "policy script:2: expected :"},
+	} {
+		p, err := compilePolicyScript(test.script)
+		if err == nil {
+			_, err = p.decide(entry)
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got %v, expected %q", test.script, err, test.err)
+		}
+	}
+}
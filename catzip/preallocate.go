@@ -0,0 +1,151 @@
+package catzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+)
+
+// gzipMaxRatio bounds the uncompressed size of deflate data, a bit more than
+// 1030 times its compressed size at most
+const gzipMaxRatio = 1032
+
+// gzipSizeHint adds up the uncompressed sizes the trailers of the members of
+// the gzip file give, see gzipMembersSize
+func gzipSizeHint(fsys fs.FS, name string) int64 {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return -1
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return -1
+	}
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		return -1
+	}
+	size, _ := gzipMembersSize(readerAt, info.Size())
+	return size
+}
+
+// gzipMembersSize adds up the sizes, modulo 2^32, in the trailers of the
+// members of the gzip data of size bytes in r, files made by appending gzip
+// files having several. The members are found by their headers, which can
+// also show up in deflate data by chance, so the total is -1 when it can't
+// be the size of the data. It is exact when there is a single member too
+// small for its size to wrap around, so the data needn't be decompressed.
+func gzipMembersSize(r io.ReaderAt, size int64) (total int64, exact bool) {
+	// The smallest member, a header, an empty deflate block and a trailer
+	const minMember = 20
+	if size < minMember {
+		return -1, false
+	}
+
+	// The ends of the members, the starts of the next ones
+	var ends []int64
+	buf := make([]byte, 64<<10)
+	for offset := int64(0); offset < size; {
+		n, err := r.ReadAt(buf, offset)
+		if n == 0 {
+			return -1, false
+		}
+		chunk := buf[:n]
+		last := offset+int64(n) >= size
+		for i := 0; ; i++ {
+			j := bytes.Index(chunk[i:], []byte{0x1f, 0x8b, 8})
+			if j < 0 {
+				break
+			}
+			i += j
+			if i+4 > len(chunk) {
+				break
+			}
+			start := offset + int64(i)
+			previous := int64(0)
+			if len(ends) > 0 {
+				previous = ends[len(ends)-1]
+			}
+			// The reserved flags are zero in a header
+			if chunk[i+3]&0xE0 == 0 && start-previous >= minMember && size-start >= minMember {
+				ends = append(ends, start)
+			}
+		}
+		if last {
+			break
+		}
+		if err != nil {
+			return -1, false
+		}
+		// The chunks overlap by the bytes of a header cut at the end
+		offset += int64(n) - 3
+	}
+
+	trailer := make([]byte, 4)
+	for _, end := range append(ends, size) {
+		if _, err := r.ReadAt(trailer, end-4); err != nil {
+			return -1, false
+		}
+		total += int64(binary.LittleEndian.Uint32(trailer))
+	}
+	if total > size*gzipMaxRatio {
+		return -1, false
+	}
+	return total, len(ends) == 0 && size*gzipMaxRatio < 1<<32
+}
+
+// estimateCatSize adds up the uncompressed sizes of every input file, plus
+// the newline appended after each of them. tar and cpio files are a bit
+// larger than their members.
+func estimateCatSize(fsys fs.FS, files []string, fileKind func(string) inputKind) int64 {
+	var total int64
+	for _, f := range files {
+		kind := fileKind(f)
+		switch kind {
+		case kindGz, kindTarGz, kindCpioGz, kindZst, kindTarZst, kindXz, kindTarXz, kindLz4, kindTarLz4:
+			if size := compressedSizeHint(fsys, f, kind); size > 0 {
+				total += size + 1
+			}
+			continue
+		case kindDeb, kindRpm, kindTarBz2:
+			// Compressed without a size in the header
+			continue
+		case kindTar, kindCpio, kindImage, kindPlain:
+			if info, err := fs.Stat(fsys, f); err == nil {
+				total += info.Size()
+			}
+			continue
+		case kind7z, kindRar, kindIso:
+			list := list7z
+			switch kind {
+			case kindRar:
+				list = listRar
+			case kindIso:
+				list = listIso
+			}
+			if entries, err := list(fsys, f); err == nil {
+				for _, entry := range entries {
+					if !entry.dir {
+						total += entry.size + 1
+					}
+				}
+			}
+			continue
+		}
+
+		reader, closer, err := openZipFS(fsys, f)
+		if err != nil {
+			continue
+		}
+		for _, entry := range reader.File {
+			if !entry.FileInfo().IsDir() {
+				total += int64(entry.UncompressedSize64) + 1
+			}
+		}
+		closer.Close()
+	}
+	return total
+}
@@ -0,0 +1,45 @@
+package catzip
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Profiles bundle the options for a kind of host, applied on top of the
+// options they are given
+var profiles = map[string]func(*Options){
+	// Small boards like a Raspberry Pi: one input and one entry at a time,
+	// small buffers and nothing mapped or read ahead
+	"low-memory": func(o *Options) {
+		o.Workers = 1
+		o.MaxWorkers = 1
+		o.WriteWorkers = 1
+		o.FilesQueue = 0
+		o.EntriesQueue = 0
+		o.ChunksQueue = 1
+		o.ChunkSize = 32 * 1024
+		o.Mmap = false
+		o.DirectIO = false
+		o.PreallocateCat = false
+	},
+}
+
+// Profiles lists the names accepted by ApplyProfile
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyProfile overrides the options covered by the named profile
+func (o *Options) ApplyProfile(name string) error {
+	profile, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, expected one of %v", name, Profiles())
+	}
+	profile(o)
+	return nil
+}
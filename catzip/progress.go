@@ -0,0 +1,118 @@
+package catzip
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// Progress counts the entries of a run, see Options.OnProgress
+type Progress struct {
+	Archive string // Of the last entry
+	Entry   int    // Position of the last entry in its archive, from 1
+	Entries int    // In its archive, including the directories
+	Done    int    // Entries written or skipped in the run, directories aside
+	Total   int    // Entries of every input file, directories aside
+}
+
+// reportProgress counts entry as done and calls OnProgress, one call at a time
+func (r *run) reportProgress(entry *EntryInfo) {
+	r.progressMu.Lock()
+	defer r.progressMu.Unlock()
+	r.entriesDone++
+	r.composition.add(entry)
+	if r.opts.OnProgress != nil {
+		r.opts.OnProgress(Progress{
+			Archive: entry.Archive,
+			Entry:   entry.Entry,
+			Entries: entry.Entries,
+			Done:    r.entriesDone,
+			Total:   r.totalEntries,
+		})
+	}
+}
+
+// addTotalEntries adds the entries of a nested archive to the total, less
+// the archive itself
+func (r *run) addTotalEntries(n int) {
+	r.progressMu.Lock()
+	defer r.progressMu.Unlock()
+	r.totalEntries += n
+}
+
+// countEntries adds up the entries of the input files but the directories,
+// from the zip central directories, the 7z and rar headers and the ISO
+// directories. tar and cpio files are read through, once.
+func countEntries(fsys fs.FS, files []string, fileKind func(string) inputKind, passthrough map[string]bool) (int, error) {
+	total := 0
+	for _, f := range files {
+		kind := fileKind(f)
+		if passthrough[filepath.Ext(f)] || kind == kindPlain || kind.single() {
+			total++
+			continue
+		}
+
+		var n int
+		var err error
+		switch {
+		case kind.tar():
+			n, err = countTarEntries(fsys, f, kind)
+		case kind.cpio():
+			n, err = countCpioEntries(fsys, f, kind)
+		case kind == kind7z:
+			n, err = count7zEntries(fsys, f)
+		case kind == kindRar:
+			n, err = countRarEntries(fsys, f)
+		case kind == kindIso:
+			n, err = countIsoEntries(fsys, f)
+		case kind == kindImage:
+			n, err = countImageEntries(fsys, f)
+		default:
+			n, err = countZipEntries(fsys, f)
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func countZipEntries(fsys fs.FS, name string) (int, error) {
+	reader, closer, err := openZipFS(fsys, name)
+	if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+
+	n := 0
+	for _, f := range reader.File {
+		if !f.FileInfo().IsDir() {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func countTarEntries(fsys fs.FS, name string, kind inputKind) (int, error) {
+	reader, closer, err := openTar(fsys, name, kind)
+	if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+
+	n := 0
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if header.Typeflag != tar.TypeDir {
+			n++
+		}
+	}
+}
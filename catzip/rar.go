@@ -0,0 +1,141 @@
+package catzip
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// handleRar extracts the files of a rar archive, and of the next volumes of
+// its set, like the entries of a zip file
+func (r *run) handleRar(f string) error {
+	reader, err := openRar(r.in, f)
+	if err != nil {
+		return fmt.Errorf("unable to read %s file: %w", r.opts.inputExt(f), err)
+	}
+
+	destination, err := filepath.Abs(r.opts.OutDir)
+	if err != nil {
+		return fmt.Errorf("unable to find absolute path for dir %s: %w", r.opts.OutDir, err)
+	}
+
+	archive := manifestArchive{Path: f}
+	for i, file := range reader.files {
+		entry := &EntryInfo{
+			Archive: f,
+			Name:    file.entryName(),
+			Size:    file.size,
+			Mode:    file.mode,
+			ModTime: file.modTime,
+			CRC32:   file.crc,
+			Entry:   i + 1,
+			Entries: len(reader.files),
+			index:   i,
+		}
+		if reason := unsupportedRarReason(reader, file); reason != "" {
+			r.skipEntry(entry, reason)
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		name := r.renamed(entry.Name)
+		if name == "" {
+			r.skipEntry(entry, "renamed to an empty name")
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		if !file.dir {
+			var ok bool
+			if name, ok, err = r.applyPolicy(entry, name); err != nil {
+				return err
+			}
+			if !ok {
+				archive.Entries = append(archive.Entries, entry)
+				continue
+			}
+		}
+
+		open := func() (io.ReadCloser, error) { return reader.open(file) }
+		if err := r.extractEntry(name, entry, destination, file.dir, int64(file.size), open); err != nil {
+			return fmt.Errorf("unable to extract file inside archive: %w", err)
+		}
+		if entry.Output != "" {
+			archive.Entries = append(archive.Entries, entry)
+		}
+	}
+	r.manifest.add(archive)
+	return nil
+}
+
+// entryName returns the name of f with / separators, RAR 4 stores Windows
+// ones
+func (f *rarFile) entryName() string {
+	return strings.ReplaceAll(f.name, "\\", "/")
+}
+
+// unsupportedRarReason tells why file can't be extracted, or "" when it can
+func unsupportedRarReason(reader *rarReader, file *rarFile) string {
+	switch {
+	case file.encrypted:
+		return "encrypted"
+	case file.link || file.mode&fs.ModeSymlink != 0:
+		return "link"
+	case !file.dir && !file.stored && !isOSInputs(reader.fsys):
+		return "compressed, unrar only reads rar files from the file system"
+	}
+	return ""
+}
+
+func listRar(fsys fs.FS, name string) ([]listedEntry, error) {
+	reader, err := openRar(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]listedEntry, 0, len(reader.files))
+	for _, file := range reader.files {
+		entries = append(entries, listedEntry{
+			name:    file.entryName(),
+			size:    int64(file.size),
+			modTime: file.modTime,
+			dir:     file.dir,
+		})
+	}
+	return entries, nil
+}
+
+func countRarEntries(fsys fs.FS, name string) (int, error) {
+	entries, err := listRar(fsys, name)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, entry := range entries {
+		if !entry.dir {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// openRarEntry opens the file name of the rar file at path, or returns nil
+// when it has none
+func openRarEntry(fsys fs.FS, path, name string) (io.ReadCloser, error) {
+	reader, err := openRar(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range reader.files {
+		if file.entryName() != name || file.dir {
+			continue
+		}
+		if reason := unsupportedRarReason(reader, file); reason != "" {
+			return nil, fmt.Errorf("%s: %s", name, reason)
+		}
+		return reader.open(file)
+	}
+	return nil, nil
+}
@@ -0,0 +1,375 @@
+package catzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"math/rand"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// The archives are built following the technote of RARLAB, with stored
+// files as the RAR compression isn't decoded.
+
+var rarModTime = time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)
+
+// rar5Block returns a RAR 5.0 header with its data, fields follow the
+// type and the flags, sizes and extra area
+func rar5Block(headerType, flags uint64, fields, data []byte) []byte {
+	if data != nil {
+		flags |= 0x02
+	}
+	body := binary.AppendUvarint(nil, headerType)
+	body = binary.AppendUvarint(body, flags)
+	if data != nil {
+		body = binary.AppendUvarint(body, uint64(len(data)))
+	}
+	body = append(body, fields...)
+	header := append(binary.AppendUvarint(nil, uint64(len(body))), body...)
+	block := binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(header))
+	return append(append(block, header...), data...)
+}
+
+type rarTestFile struct {
+	name    string
+	data    []byte // Of the file, or of this part of it
+	crc     uint32 // Of the whole file, in its last part
+	size    int    // Of the whole file
+	mode    uint64 // Unix
+	dir     bool
+	packed  bool // Compressed, not stored
+	before  bool // Continued from the previous volume
+	after   bool // Continued in the next volume
+	unicode []byte
+}
+
+func newRarTestFile(name string, data []byte, mode uint64) rarTestFile {
+	return rarTestFile{name: name, data: data, crc: crc32.ChecksumIEEE(data), size: len(data), mode: mode}
+}
+
+func rar5FileBlock(f rarTestFile) []byte {
+	var flags uint64
+	if f.before {
+		flags |= 0x08
+	}
+	if f.after {
+		flags |= 0x10
+	}
+	fileFlags := uint64(0x02 | 0x04)
+	if f.dir {
+		fileFlags |= 0x01
+	}
+	fields := binary.AppendUvarint(nil, fileFlags)
+	fields = binary.AppendUvarint(fields, uint64(f.size))
+	fields = binary.AppendUvarint(fields, f.mode)
+	fields = binary.LittleEndian.AppendUint32(fields, uint32(rarModTime.Unix()))
+	fields = binary.LittleEndian.AppendUint32(fields, f.crc)
+	var compression uint64
+	if f.packed {
+		compression = 3 << 7
+	}
+	fields = binary.AppendUvarint(fields, compression)
+	fields = binary.AppendUvarint(fields, 1) // Unix
+	fields = binary.AppendUvarint(fields, uint64(len(f.name)))
+	fields = append(fields, f.name...)
+	data := f.data
+	if data == nil {
+		data = []byte{}
+	}
+	return rar5Block(rar5File, flags, fields, data)
+}
+
+// rar5Volume returns a RAR 5.0 volume of files, more tells that the next
+// one follows
+func rar5Volume(volume, more bool, files ...rarTestFile) []byte {
+	var archiveFlags uint64
+	if volume {
+		archiveFlags = 0x01
+	}
+	b := append([]byte{}, rar5Signature...)
+	b = append(b, rar5Block(1, 0, binary.AppendUvarint(nil, archiveFlags), nil)...)
+	for _, f := range files {
+		b = append(b, rar5FileBlock(f)...)
+	}
+	var endFlags uint64
+	if more {
+		endFlags = 0x01
+	}
+	return append(b, rar5Block(rar5End, 0, binary.AppendUvarint(nil, endFlags), nil)...)
+}
+
+// rar4Block returns a RAR 1.5 to 4.x block with its data
+func rar4Block(blockType byte, flags uint16, fields, data []byte) []byte {
+	header := []byte{0, 0, blockType}
+	header = binary.LittleEndian.AppendUint16(header, flags)
+	header = binary.LittleEndian.AppendUint16(header, uint16(7+len(fields)))
+	header = append(header, fields...)
+	binary.LittleEndian.PutUint16(header, uint16(crc32.ChecksumIEEE(header[2:])))
+	return append(header, data...)
+}
+
+func rar4FileBlock(f rarTestFile) []byte {
+	flags := uint16(0x8000)
+	if f.before {
+		flags |= 0x01
+	}
+	if f.after {
+		flags |= 0x02
+	}
+	if f.dir {
+		flags |= 0xE0
+	}
+	name := []byte(f.name)
+	if f.unicode != nil {
+		flags |= 0x200
+		name = append(append(name, 0), f.unicode...)
+	}
+	local := rarModTime.Local()
+	dosTime := uint32(local.Year()-1980)<<25 | uint32(local.Month())<<21 | uint32(local.Day())<<16 |
+		uint32(local.Hour())<<11 | uint32(local.Minute())<<5 | uint32(local.Second()/2)
+	method := byte(0x30)
+	if f.packed {
+		method = 0x33
+	}
+	fields := binary.LittleEndian.AppendUint32(nil, uint32(len(f.data)))
+	fields = binary.LittleEndian.AppendUint32(fields, uint32(f.size))
+	fields = append(fields, 3) // Unix
+	fields = binary.LittleEndian.AppendUint32(fields, f.crc)
+	fields = binary.LittleEndian.AppendUint32(fields, dosTime)
+	fields = append(fields, 20, method)
+	fields = binary.LittleEndian.AppendUint16(fields, uint16(len(name)))
+	fields = binary.LittleEndian.AppendUint32(fields, uint32(f.mode))
+	fields = append(fields, name...)
+	return rar4Block(rar4File, flags, fields, f.data)
+}
+
+// rar4Volume returns a RAR 4 volume of files, and the offsets of its blocks
+func rar4Volume(more bool, files ...rarTestFile) ([]byte, []int) {
+	b := append([]byte{}, rar4Signature...)
+	b = append(b, rar4Block(rar4Main, 0, make([]byte, 6), nil)...)
+	var blocks []int
+	for _, f := range files {
+		blocks = append(blocks, len(b))
+		b = append(b, rar4FileBlock(f)...)
+	}
+	blocks = append(blocks, len(b))
+	var endFlags uint16
+	if more {
+		endFlags = 0x01
+	}
+	return append(b, rar4Block(rar4End, endFlags, nil, nil)...), blocks
+}
+
+// rarTestFiles returns the files of the test archives: a stored file, a
+// directory with a file, a compressed one and a split one
+func rarTestFiles() (files []rarTestFile, split []byte) {
+	lines := testLines(40000)
+	random := make([]byte, 2000)
+	rand.New(rand.NewSource(2)).Read(random)
+	packed := newRarTestFile("packed.bin", []byte{1, 2, 3}, 0100644)
+	packed.packed = true
+	packed.size = 100
+	logs := newRarTestFile("logs", nil, 040755)
+	logs.dir = true
+	return []rarTestFile{
+		newRarTestFile("a.txt", lines[:3000], 0100644),
+		logs,
+		newRarTestFile("logs/b.bin", random, 0100600),
+		packed,
+	}, lines[3000:]
+}
+
+// rarSplit returns the parts of a file split across two volumes
+func rarSplit(name string, data []byte) (first, second rarTestFile) {
+	first = newRarTestFile(name, data[:len(data)/3], 0100644)
+	first.crc, first.size, first.after = 0, len(data), true
+	second = newRarTestFile(name, data[len(data)/3:], 0100644)
+	second.crc, second.size, second.before = crc32.ChecksumIEEE(data), len(data), true
+	return first, second
+}
+
+// readRar reads the stored files of the rar file name
+func readRar(fsys fs.FS, name string) (*rarReader, map[string][]byte, error) {
+	z, err := openRar(fsys, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	contents := map[string][]byte{}
+	for _, f := range z.files {
+		if f.dir || !f.stored {
+			continue
+		}
+		r, err := z.open(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := readLimited(r, 1<<20)
+		r.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		contents[f.entryName()] = data
+	}
+	return z, contents, nil
+}
+
+func checkRarFiles(t *testing.T, version string, z *rarReader, contents map[string][]byte, split []byte) {
+	t.Helper()
+	files, _ := rarTestFiles()
+	if len(z.files) != len(files)+1 {
+		t.Fatalf("RAR %s: %d files", version, len(z.files))
+	}
+	for i, f := range files {
+		got := z.files[i]
+		if got.entryName() != f.name || got.dir != f.dir || got.stored == f.packed || got.size != uint64(f.size) {
+			t.Errorf("RAR %s: %+v, expected %s", version, got, f.name)
+		}
+		if !got.modTime.Equal(rarModTime) {
+			t.Errorf("RAR %s: %s modified %v", version, f.name, got.modTime)
+		}
+		if !f.dir && !f.packed && !bytes.Equal(contents[f.name], f.data) {
+			t.Errorf("RAR %s: %s is %d bytes, not its content", version, f.name, len(contents[f.name]))
+		}
+	}
+	if mode := z.files[2].mode; mode != 0600 {
+		t.Errorf("RAR %s: logs/b.bin mode %v", version, mode)
+	}
+	if mode := z.files[1].mode; mode != fs.ModeDir|0755 {
+		t.Errorf("RAR %s: logs mode %v", version, mode)
+	}
+	if reason := unsupportedRarReason(z, z.files[3]); reason == "" {
+		t.Errorf("RAR %s: compressed file supported outside the file system", version)
+	}
+	if !bytes.Equal(contents["split.log"], split) {
+		t.Errorf("RAR %s: split.log is %d bytes, not its content", version, len(contents["split.log"]))
+	}
+}
+
+func TestRar5(t *testing.T) {
+	files, split := rarTestFiles()
+	first, second := rarSplit("split.log", split)
+	fsys := fstest.MapFS{
+		"set.part1.rar": {Data: rar5Volume(true, true, append(files, first)...)},
+		"set.part2.rar": {Data: rar5Volume(true, false, second)},
+	}
+	z, contents, err := readRar(fsys, "set.part1.rar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRarFiles(t, "5.0", z, contents, split)
+
+	delete(fsys, "set.part2.rar")
+	if _, err := openRar(fsys, "set.part1.rar"); err == nil {
+		t.Error("no error without the second volume")
+	}
+
+	// A file altered in the archive doesn't match its CRC
+	data := rar5Volume(false, false, files[0])
+	data[bytes.Index(data, files[0].data)] ^= 1
+	if _, _, err := readRar(fstest.MapFS{"a.rar": {Data: data}}, "a.rar"); err == nil {
+		t.Error("no checksum error")
+	}
+}
+
+func TestRar5Corrupt(t *testing.T) {
+	files, _ := rarTestFiles()
+	data := rar5Volume(false, false, files...)
+	decode := func(data []byte) error {
+		_, _, err := readRar(fstest.MapFS{"a.rar": {Data: data}}, "a.rar")
+		return err
+	}
+	checkCorrupt(t, data, decode)
+
+	// Cut between the headers
+	end := rar5Block(rar5End, 0, []byte{0}, nil)
+	if err := decode(data[:len(data)-len(end)]); err == nil {
+		t.Error("no error without the end header")
+	}
+	if err := decode(data[:len(data)-len(end)-len(rar5FileBlock(files[3]))]); err == nil {
+		t.Error("no error without the last file and the end header")
+	}
+}
+
+func TestRar4(t *testing.T) {
+	files, split := rarTestFiles()
+	first, second := rarSplit("split.log", split)
+	volume1, _ := rar4Volume(true, append(files, first)...)
+	volume2, _ := rar4Volume(false, second)
+	fsys := fstest.MapFS{"old.rar": {Data: volume1}, "old.r00": {Data: volume2}}
+	z, contents, err := readRar(fsys, "old.rar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRarFiles(t, "4", z, contents, split)
+
+	// The name in UTF-16 after the one in the OEM code page
+	unicode := newRarTestFile("?.txt", []byte("x\n"), 0100644)
+	unicode.unicode = []byte{0x00, 0x00, 0xE9, '.', 't', 'x', 0x00, 't'}
+	data, _ := rar4Volume(false, unicode)
+	if z, _, err = readRar(fstest.MapFS{"u.rar": {Data: data}}, "u.rar"); err != nil {
+		t.Fatal(err)
+	}
+	if name := z.files[0].name; name != "é.txt" {
+		t.Errorf("unicode name %q", name)
+	}
+}
+
+// errRarShorter stands for a RAR 4 archive cut between its blocks, which
+// reads as a shorter one: archives older than RAR 2.9 have no end block
+var errRarShorter = errors.New("cut between blocks")
+
+func TestRar4Corrupt(t *testing.T) {
+	files, _ := rarTestFiles()
+	data, blocks := rar4Volume(false, files...)
+	checkCorrupt(t, data, func(cut []byte) error {
+		_, _, err := readRar(fstest.MapFS{"a.rar": {Data: cut}}, "a.rar")
+		for _, offset := range blocks {
+			if err == nil && len(cut) == offset {
+				return errRarShorter
+			}
+		}
+		return err
+	})
+}
+
+func TestRarVolumeNames(t *testing.T) {
+	for name, next := range map[string]string{
+		"a.part1.rar":  "a.part2.rar",
+		"a.part09.rar": "a.part10.rar",
+		"a.PART9.RAR":  "a.PART10.RAR",
+		"a.rar":        "a.r00",
+		"a.r41":        "a.r42",
+		"a.r99":        "a.s00",
+	} {
+		if got := nextRarVolume(name); got != next {
+			t.Errorf("after %s: %s, expected %s", name, got, next)
+		}
+	}
+	if !laterRarVolume("a.part2.rar") || laterRarVolume("a.part1.rar") || laterRarVolume("a.rar") {
+		t.Error("later volumes")
+	}
+}
+
+// A volume set is extracted from its first volume only
+func TestRarRun(t *testing.T) {
+	files, split := rarTestFiles()
+	first, second := rarSplit("split.log", split)
+	out := runInMemory(t, ".rar", fstest.MapFS{
+		"set.part1.rar": {Data: rar5Volume(true, true, append(files[:3], first)...)},
+		"set.part2.rar": {Data: rar5Volume(true, false, second)},
+	})
+	for name, content := range map[string][]byte{"a.txt": files[0].data, "logs/b.bin": files[2].data, "split.log": split} {
+		if data, err := out.ReadFile("/out/" + name); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("extracted %s: %d bytes, %v", name, len(data), err)
+		}
+	}
+	for _, name := range out.Names() {
+		if name != "/out" && !bytes.HasPrefix([]byte(name), []byte("/out/")) {
+			t.Errorf("wrote %s", name)
+		}
+	}
+}
@@ -0,0 +1,571 @@
+package catzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// A reader for the headers of rar archives, RAR 1.5 to 4.x and RAR 5.0,
+// following the technote of RARLAB. Volume sets are followed from their first
+// volume. Stored files are read from the archive, compressed ones with the
+// unrar command as the RAR compression is only described by its sources.
+
+var (
+	rar4Signature = []byte("Rar!\x1a\x07\x00")
+	rar5Signature = []byte("Rar!\x1a\x07\x01\x00")
+)
+
+var errRarCorrupt = errors.New("rar: corrupt archive")
+
+// Block types of RAR 1.5 to 4.x
+const (
+	rar4Main    = 0x73
+	rar4File    = 0x74
+	rar4Service = 0x7A
+	rar4End     = 0x7B
+)
+
+// Header types of RAR 5.0
+const (
+	rar5File       = 2
+	rar5Encryption = 4
+	rar5End        = 5
+)
+
+// rarFile is a file or directory of the archive
+type rarFile struct {
+	name      string
+	size      uint64
+	modTime   time.Time
+	mode      fs.FileMode
+	dir       bool
+	crc       uint32
+	hasCRC    bool
+	stored    bool
+	encrypted bool
+	link      bool
+	parts     []rarPart // Its data, split across volumes
+}
+
+type rarPart struct {
+	volume       int
+	offset, size int64
+}
+
+// rarReader reads the files of a rar archive and of the next volumes
+type rarReader struct {
+	fsys    fs.FS
+	volumes []string
+	files   []*rarFile
+	split   bool // The last file continues in the next volume
+}
+
+// openRar reads the headers of the rar file name, and of the next volumes
+// of its set
+func openRar(fsys fs.FS, name string) (*rarReader, error) {
+	z := &rarReader{fsys: fsys}
+	for volume := name; ; volume = nextRarVolume(volume) {
+		more, err := z.readVolume(volume)
+		if err != nil {
+			if len(z.volumes) > 1 && errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("rar: missing volume %s", volume)
+			}
+			return nil, err
+		}
+		if !more {
+			return z, nil
+		}
+	}
+}
+
+var rarPartName = regexp.MustCompile(`(?i)\.part(\d+)\.rar$`)
+
+// nextRarVolume returns the name of the volume after name, name.part2.rar
+// after name.part1.rar, or name.r00 after name.rar for the old naming
+func nextRarVolume(name string) string {
+	if m := rarPartName.FindStringSubmatchIndex(name); m != nil {
+		digits := name[m[2]:m[3]]
+		n, _ := strconv.Atoi(digits)
+		next := strconv.Itoa(n + 1)
+		if len(next) < len(digits) {
+			next = strings.Repeat("0", len(digits)-len(next)) + next
+		}
+		return name[:m[2]] + next + name[m[3]:]
+	}
+
+	ext := filepath.Ext(name)
+	base := name[:len(name)-len(ext)]
+	if strings.EqualFold(ext, ".rar") {
+		return base + ".r00"
+	}
+	// .r00 to .r99, then .s00 and on
+	if len(ext) == 4 {
+		if n, err := strconv.Atoi(ext[2:]); err == nil {
+			if n == 99 {
+				return base + "." + string(ext[1]+1) + "00"
+			}
+			return fmt.Sprintf("%s.%c%02d", base, ext[1], n+1)
+		}
+	}
+	return name + ".next"
+}
+
+// laterRarVolume tells whether name is a volume of a set but the first one,
+// which are read along with it
+func laterRarVolume(name string) bool {
+	m := rarPartName.FindStringSubmatch(name)
+	if m == nil {
+		return false
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n > 1
+}
+
+// readVolume reads the headers of the volume name, telling whether the
+// archive goes on in the next volume
+func (z *rarReader) readVolume(name string) (bool, error) {
+	readerAt, size, closer, err := openReaderAt(z.fsys, name)
+	if err != nil {
+		return false, err
+	}
+	defer closer.Close()
+	z.volumes = append(z.volumes, name)
+
+	start := make([]byte, len(rar5Signature))
+	if _, err := readerAt.ReadAt(start, 0); err != nil {
+		return false, fmt.Errorf("rar: %w", unexpectedEOF(err))
+	}
+	switch {
+	case bytes.Equal(start, rar5Signature):
+		return z.readVolume5(readerAt, size)
+	case bytes.HasPrefix(start, rar4Signature):
+		return z.readVolume4(readerAt, size)
+	case bytes.HasPrefix(start, rar5Signature[:6]):
+		return false, fmt.Errorf("rar: unsupported version %d", start[6]+1)
+	}
+	return false, errors.New("rar: not a rar file")
+}
+
+func (z *rarReader) readVolume4(r io.ReaderAt, size int64) (bool, error) {
+	offset := int64(len(rar4Signature))
+	for offset < size {
+		base := make([]byte, 7)
+		if _, err := r.ReadAt(base, offset); err != nil {
+			return false, fmt.Errorf("rar: %w", unexpectedEOF(err))
+		}
+		headCRC := binary.LittleEndian.Uint16(base)
+		blockType := base[2]
+		flags := binary.LittleEndian.Uint16(base[3:])
+		headSize := int64(binary.LittleEndian.Uint16(base[5:]))
+		if headSize < 7 {
+			return false, errRarCorrupt
+		}
+		header := make([]byte, headSize)
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return false, fmt.Errorf("rar: %w", unexpectedEOF(err))
+		}
+
+		h := &rarBuf{b: header[7:]}
+		var dataSize int64
+		switch blockType {
+		case rar4File, rar4Service:
+			if uint16(crc32.ChecksumIEEE(header[2:])) != headCRC {
+				return false, errRarCorrupt
+			}
+			packSize := uint64(h.uint32())
+			unpSize := uint64(h.uint32())
+			hostOS := h.byte()
+			fileCRC := h.uint32()
+			dosTime := h.uint32()
+			h.byte() // Version needed
+			method := h.byte()
+			nameSize := int(h.uint16())
+			attrib := h.uint32()
+			if flags&0x100 != 0 {
+				packSize |= uint64(h.uint32()) << 32
+				unpSize |= uint64(h.uint32()) << 32
+			}
+			name := h.bytes(nameSize)
+			if h.err != nil || packSize > uint64(size) {
+				return false, errRarCorrupt
+			}
+			dataSize = int64(packSize)
+			if blockType == rar4Service {
+				break
+			}
+
+			part := rarPart{volume: len(z.volumes) - 1, offset: offset + headSize, size: dataSize}
+			if flags&0x01 != 0 {
+				// Continued from the previous volume, the last header has
+				// the CRC of the whole file
+				if !z.split || len(z.files) == 0 {
+					return false, errRarCorrupt
+				}
+				f := z.files[len(z.files)-1]
+				f.parts = append(f.parts, part)
+				f.crc = fileCRC
+			} else {
+				dir := flags&0xE0 == 0xE0
+				z.files = append(z.files, &rarFile{
+					name:      rar4Name(name, flags&0x200 != 0),
+					size:      unpSize,
+					modTime:   msDosTime(dosTime),
+					mode:      rarMode(hostOS == 3, uint64(attrib), dir),
+					dir:       dir,
+					crc:       fileCRC,
+					hasCRC:    true,
+					stored:    method == 0x30,
+					encrypted: flags&0x04 != 0,
+					parts:     []rarPart{part},
+				})
+			}
+			z.split = flags&0x02 != 0
+		case rar4Main:
+			if flags&0x80 != 0 {
+				return false, errors.New("rar: encrypted headers")
+			}
+		case rar4End:
+			return flags&0x01 != 0 || z.split, nil
+		default:
+			if flags&0x8000 != 0 {
+				dataSize = int64(h.uint32())
+			}
+		}
+		if h.err != nil || dataSize > size-offset-headSize {
+			return false, errRarCorrupt
+		}
+		offset += headSize + dataSize
+	}
+	// Archives older than RAR 2.9 have no end block
+	return z.split, nil
+}
+
+func (z *rarReader) readVolume5(r io.ReaderAt, size int64) (bool, error) {
+	offset := int64(len(rar5Signature))
+	for offset < size {
+		// The CRC, then the header size in up to 3 bytes
+		start := make([]byte, 7)
+		n, err := r.ReadAt(start, offset)
+		if n < 5 {
+			return false, fmt.Errorf("rar: %w", unexpectedEOF(err))
+		}
+		headCRC := binary.LittleEndian.Uint32(start)
+		headSize, sizeLen := binary.Uvarint(start[4:n])
+		if sizeLen <= 0 || sizeLen > 3 || headSize == 0 {
+			return false, errRarCorrupt
+		}
+		header := make([]byte, int64(sizeLen)+int64(headSize))
+		if _, err := r.ReadAt(header, offset+4); err != nil {
+			return false, fmt.Errorf("rar: %w", unexpectedEOF(err))
+		}
+		if crc32.ChecksumIEEE(header) != headCRC {
+			return false, errRarCorrupt
+		}
+
+		h := &rarBuf{b: header[sizeLen:]}
+		headerType := h.vint()
+		flags := h.vint()
+		var extraSize, dataSize uint64
+		if flags&0x01 != 0 {
+			extraSize = h.vint()
+		}
+		if flags&0x02 != 0 {
+			dataSize = h.vint()
+		}
+		if h.err != nil || extraSize > uint64(len(h.b)) || dataSize > uint64(size) {
+			return false, errRarCorrupt
+		}
+		extra := h.b[len(h.b)-int(extraSize):]
+		h.b = h.b[:len(h.b)-int(extraSize)]
+		dataOffset := offset + 4 + int64(len(header))
+		if int64(dataSize) > size-dataOffset {
+			return false, errRarCorrupt
+		}
+
+		switch headerType {
+		case rar5Encryption:
+			return false, errors.New("rar: encrypted headers")
+		case rar5File:
+			part := rarPart{volume: len(z.volumes) - 1, offset: dataOffset, size: int64(dataSize)}
+			f, err := readRar5File(h, extra)
+			if err != nil {
+				return false, err
+			}
+			if flags&0x08 != 0 {
+				if !z.split || len(z.files) == 0 {
+					return false, errRarCorrupt
+				}
+				prev := z.files[len(z.files)-1]
+				prev.parts = append(prev.parts, part)
+				prev.crc, prev.hasCRC = f.crc, f.hasCRC
+			} else {
+				f.parts = []rarPart{part}
+				z.files = append(z.files, f)
+			}
+			z.split = flags&0x10 != 0
+		case rar5End:
+			endFlags := h.vint()
+			return endFlags&0x01 != 0 || z.split, h.err
+		}
+		offset = dataOffset + int64(dataSize)
+	}
+	// Cut before its end header
+	return false, fmt.Errorf("rar: %w", io.ErrUnexpectedEOF)
+}
+
+// readRar5File reads a file header, then its extra records
+func readRar5File(h *rarBuf, extra []byte) (*rarFile, error) {
+	fileFlags := h.vint()
+	unpSize := h.vint()
+	attrib := h.vint()
+	f := &rarFile{dir: fileFlags&0x01 != 0}
+	if fileFlags&0x02 != 0 {
+		f.modTime = time.Unix(int64(h.uint32()), 0).UTC()
+	}
+	if fileFlags&0x04 != 0 {
+		f.crc, f.hasCRC = h.uint32(), true
+	}
+	compression := h.vint()
+	hostOS := h.vint()
+	name := h.bytes(int(h.vint()))
+	if h.err != nil {
+		return nil, errRarCorrupt
+	}
+	f.name = string(name)
+	if fileFlags&0x08 == 0 {
+		f.size = unpSize
+	}
+	f.stored = compression>>7&0x07 == 0
+	f.mode = rarMode(hostOS == 1, attrib, f.dir)
+
+	for len(extra) > 0 {
+		e := &rarBuf{b: extra}
+		recordSize := e.vint()
+		if e.err != nil || recordSize > uint64(len(e.b)) {
+			return nil, errRarCorrupt
+		}
+		record := &rarBuf{b: e.b[:recordSize]}
+		extra = e.b[recordSize:]
+		switch record.vint() {
+		case 1:
+			f.encrypted = true
+		case 3:
+			timeFlags := record.vint()
+			if timeFlags&0x02 == 0 {
+				break
+			}
+			if timeFlags&0x01 != 0 {
+				f.modTime = time.Unix(int64(record.uint32()), 0).UTC()
+			} else {
+				f.modTime = fileTime(record.uint64())
+			}
+		case 5:
+			f.link = true
+		}
+		if record.err != nil {
+			return nil, errRarCorrupt
+		}
+	}
+	return f, nil
+}
+
+// rar4Name decodes a file name, which is followed by its compressed UTF-16
+// form after a NUL byte when unicode is set
+func rar4Name(name []byte, unicode bool) string {
+	i := bytes.IndexByte(name, 0)
+	if !unicode || i < 0 {
+		return string(name)
+	}
+	plain, enc := name[:i], name[i+1:]
+	if len(enc) == 0 {
+		return string(plain)
+	}
+
+	high := uint16(enc[0])
+	pos := 1
+	var out []uint16
+	var flags byte
+	flagBits := 0
+	for pos < len(enc) {
+		if flagBits == 0 {
+			flags = enc[pos]
+			pos++
+			flagBits = 8
+			if pos >= len(enc) {
+				break
+			}
+		}
+		switch flags >> 6 {
+		case 0:
+			out = append(out, uint16(enc[pos]))
+			pos++
+		case 1:
+			out = append(out, uint16(enc[pos])|high<<8)
+			pos++
+		case 2:
+			if pos+1 >= len(enc) {
+				return string(plain)
+			}
+			out = append(out, uint16(enc[pos])|uint16(enc[pos+1])<<8)
+			pos += 2
+		case 3:
+			// A run of the plain name
+			length := int(enc[pos])
+			pos++
+			if length&0x80 != 0 {
+				if pos >= len(enc) {
+					return string(plain)
+				}
+				correction := enc[pos]
+				pos++
+				for length = length&0x7F + 2; length > 0 && len(out) < len(plain); length-- {
+					out = append(out, uint16(plain[len(out)]+correction)|high<<8)
+				}
+			} else {
+				for length += 2; length > 0 && len(out) < len(plain); length-- {
+					out = append(out, uint16(plain[len(out)]))
+				}
+			}
+		}
+		flags <<= 2
+		flagBits -= 2
+	}
+	return string(utf16.Decode(out))
+}
+
+// rarMode maps the Unix mode, or the Windows attributes, to a file mode
+func rarMode(unix bool, attrib uint64, dir bool) fs.FileMode {
+	if unix {
+		mode := fs.FileMode(attrib & 0777)
+		switch attrib & 0170000 {
+		case 0040000:
+			mode |= fs.ModeDir
+		case 0120000:
+			mode |= fs.ModeSymlink
+		}
+		return mode
+	}
+	mode := fs.FileMode(0644)
+	if attrib&0x1 != 0 {
+		mode = 0444
+	}
+	if dir {
+		mode = fs.ModeDir | 0755
+	}
+	return mode
+}
+
+// msDosTime converts an MS-DOS date and time, in local time
+func msDosTime(t uint32) time.Time {
+	return time.Date(
+		int(t>>25)+1980, time.Month(t>>21&0x0F), int(t>>16&0x1F),
+		int(t>>11&0x1F), int(t>>5&0x3F), int(t&0x1F)*2, 0, time.Local)
+}
+
+// open returns the content of f
+func (z *rarReader) open(f *rarFile) (io.ReadCloser, error) {
+	if !f.stored {
+		// unrar finds the next volumes next to the first one
+		return newCommandReader(nil, "unrar", "p", "-inul", "-p-", "--", z.volumes[0], f.entryName()), nil
+	}
+
+	readers := make([]io.Reader, 0, len(f.parts))
+	var c closers
+	for _, part := range f.parts {
+		readerAt, _, closer, err := openReaderAt(z.fsys, z.volumes[part.volume])
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c = append(c, closer)
+		readers = append(readers, io.NewSectionReader(readerAt, part.offset, part.size))
+	}
+	reader := &rarFileReader{r: io.MultiReader(readers...), f: f, crc: crc32.NewIEEE()}
+	return readCloser{reader, c}, nil
+}
+
+// rarFileReader reads a stored file, checking its CRC
+type rarFileReader struct {
+	r   io.Reader
+	f   *rarFile
+	crc hash.Hash32
+}
+
+func (r *rarFileReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc.Write(p[:n])
+	if err == io.EOF && r.f.hasCRC && r.crc.Sum32() != r.f.crc {
+		return n, fmt.Errorf("rar: checksum error in %s", r.f.name)
+	}
+	return n, err
+}
+
+// rarBuf reads the headers, its first error sticks
+type rarBuf struct {
+	b   []byte
+	err error
+}
+
+func (h *rarBuf) bytes(n int) []byte {
+	if h.err != nil || n < 0 || n > len(h.b) {
+		h.err = errRarCorrupt
+		return nil
+	}
+	b := h.b[:n]
+	h.b = h.b[n:]
+	return b
+}
+
+func (h *rarBuf) byte() byte {
+	if b := h.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (h *rarBuf) uint16() uint16 {
+	if b := h.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (h *rarBuf) uint32() uint32 {
+	if b := h.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (h *rarBuf) uint64() uint64 {
+	if b := h.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// vint reads a RAR 5.0 variable length integer, 7 bits per byte
+func (h *rarBuf) vint() uint64 {
+	if h.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(h.b)
+	if n <= 0 {
+		h.err = errRarCorrupt
+		return 0
+	}
+	h.b = h.b[n:]
+	return v
+}
@@ -0,0 +1,87 @@
+package catzip
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// readerFSHead is how much of the start of a ReaderFS file is kept to read
+// it again
+const readerFSHead = 1 << 20
+
+// ReaderFS returns an Options.InputFS with a single file, name, read from r
+// as it goes, like stdin. Options.Dir is name, its extension or Detect tell
+// its format and zip ones are read in order from their local headers. name
+// can be a path, single compressed files are extracted next to it. The
+// start of r is kept so it can be opened again once its format is known,
+// OnProgress, which reads it through first, doesn't work with it.
+func ReaderFS(name string, r io.Reader) fs.FS {
+	return &readerFS{name: name, r: r}
+}
+
+type readerFS struct {
+	name string
+	mu   sync.Mutex
+	r    io.Reader
+	head []byte // The start of r, up to readerFSHead
+	read int64  // From r so far
+}
+
+func (f *readerFS) Open(name string) (fs.File, error) {
+	if name != f.name {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &readerFile{fsys: f}, nil
+}
+
+func (f *readerFS) Stat(name string) (fs.FileInfo, error) {
+	if name != f.name {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return readerInfo{f.name}, nil
+}
+
+func (f *readerFS) readAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off < int64(len(f.head)) {
+		return copy(p, f.head[off:]), nil
+	}
+	if off != f.read {
+		return 0, fmt.Errorf("%s can't be read again past its first %d bytes", f.name, len(f.head))
+	}
+	n, err := f.r.Read(p)
+	if f.read == int64(len(f.head)) && len(f.head)+n <= readerFSHead {
+		f.head = append(f.head, p[:n]...)
+	}
+	f.read += int64(n)
+	return n, err
+}
+
+// readerFile reads a readerFS from the start
+type readerFile struct {
+	fsys *readerFS
+	off  int64
+}
+
+func (f *readerFile) Read(p []byte) (int, error) {
+	n, err := f.fsys.readAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *readerFile) Stat() (fs.FileInfo, error) { return readerInfo{f.fsys.name}, nil }
+func (f *readerFile) Close() error               { return nil }
+
+// readerInfo is a named pipe of unknown size
+type readerInfo struct{ name string }
+
+func (i readerInfo) Name() string       { return i.name }
+func (i readerInfo) Size() int64        { return 0 }
+func (i readerInfo) Mode() fs.FileMode  { return fs.ModeNamedPipe | 0444 }
+func (i readerInfo) ModTime() time.Time { return time.Time{} }
+func (i readerInfo) IsDir() bool        { return false }
+func (i readerInfo) Sys() any           { return nil }
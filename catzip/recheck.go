@@ -0,0 +1,129 @@
+package catzip
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Drift is an output that no longer matches the manifest
+type Drift struct {
+	Archive string
+	Name    string
+	Path    string
+	Problem string
+}
+
+// Recheck hashes again the extracted files and the slices of the cat file
+// listed in a manifest, without changing anything. catPath overrides the
+// cat file recorded in the manifest. It returns the number of entries
+// checked and the ones that drifted.
+func Recheck(manifestPath string, catPath string) (int, []Drift, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	m := &manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return 0, nil, err
+	}
+	if m.Hash == "" {
+		return 0, nil, errors.New("the manifest has no hashes, the run needs a hash algorithm")
+	}
+	newHash, err := parseHash(m.Hash)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if catPath == "" {
+		catPath = m.Cat
+	}
+	var cat *os.File
+	if catPath != "" {
+		if cat, err = os.Open(catPath); err != nil {
+			return 0, nil, err
+		}
+		defer cat.Close()
+	}
+
+	routes := map[string]*os.File{}
+	checked := 0
+	var drifts []Drift
+	for _, archive := range m.Archives {
+		for _, e := range archive.Entries {
+			if e.Status != EntryWritten || e.Hash == "" {
+				continue
+			}
+			checked++
+			drift := func(path string, problem string) {
+				drifts = append(drifts, Drift{Archive: e.Archive, Name: e.Name, Path: path, Problem: problem})
+			}
+
+			if e.Output != "" {
+				if path, problem := recheckFile(outputFiles(e), e.Recompressed, e.Hash, newHash()); problem != "" {
+					drift(path, problem)
+				}
+			}
+
+			entryCat, entryCatPath := cat, catPath
+			if e.Cat != "" {
+				// A format route
+				if routes[e.Cat] == nil {
+					if routes[e.Cat], err = os.Open(e.Cat); err != nil {
+						return 0, nil, err
+					}
+					defer routes[e.Cat].Close()
+				}
+				entryCat, entryCatPath = routes[e.Cat], e.Cat
+			}
+			if entryCat != nil {
+				h := newHash()
+				n, err := io.Copy(h, io.NewSectionReader(entryCat, e.CatOffset, e.CatLength))
+				switch {
+				case err != nil:
+					drift(entryCatPath, err.Error())
+				case n != e.CatLength:
+					drift(entryCatPath, fmt.Sprintf("slice at %d is %d bytes, expected %d", e.CatOffset, n, e.CatLength))
+				case hex.EncodeToString(h.Sum(nil)) != e.Hash:
+					drift(entryCatPath, fmt.Sprintf("slice at %d has %s %x, expected %s", e.CatOffset, m.Hash, h.Sum(nil), e.Hash))
+				}
+			}
+		}
+	}
+	return checked, drifts, nil
+}
+
+// recheckFile hashes paths one after the other, the parts of a split output,
+// returning the path at fault with the problem. Recompressed paths are
+// hashed decompressed.
+func recheckFile(paths []string, gzipped bool, want string, h hash.Hash) (string, string) {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return path, err.Error()
+		}
+		var content io.Reader = f
+		if gzipped {
+			var gz *gzip.Reader
+			if gz, err = gzip.NewReader(f); err == nil {
+				content = gz
+			}
+		}
+		if err == nil {
+			_, err = io.Copy(h, content)
+		}
+		f.Close()
+		if err != nil {
+			return path, err.Error()
+		}
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return paths[0], fmt.Sprintf("hash is %s, expected %s", got, want)
+	}
+	return "", ""
+}
@@ -0,0 +1,85 @@
+package catzip
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// recompress gzips the extracted files of an entry larger than
+// Options.RecompressAbove next to them, to name.gz. The plain files are
+// still the ones appended to cat, recompressed then switches the entry to
+// the gzip files and removes them.
+func (t *writeTask) recompress() error {
+	r, entry := t.r, t.entry
+	if r.opts.RecompressAbove <= 0 || entry.Size <= uint64(r.opts.RecompressAbove) {
+		return nil
+	}
+	for _, name := range outputFiles(entry) {
+		if err := r.gzipFile(name, name+".gz", entry); err != nil {
+			return err
+		}
+		t.plain = append(t.plain, name)
+	}
+	return nil
+}
+
+func (r *run) gzipFile(name, gzName string, entry *EntryInfo) error {
+	in, err := r.fs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := r.fs.OpenFile(gzName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	if err = r.audit(AuditCreate, gzName, "", entry); err != nil {
+		out.Close()
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	gz.ModTime = entry.ModTime
+	_, err = io.Copy(gz, in)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// recompressed points the entry at the gzip files, along with the append to
+// cat so the checkpoint has them
+func (t *writeTask) recompressed() {
+	if len(t.plain) == 0 {
+		return
+	}
+	entry := t.entry
+	if len(entry.Parts) > 0 {
+		parts := make([]string, len(entry.Parts))
+		for i, part := range entry.Parts {
+			parts[i] = part + ".gz"
+		}
+		entry.Parts = parts
+	} else {
+		entry.Output += ".gz"
+	}
+	entry.Recompressed = true
+}
+
+// removePlain removes the files that were recompressed
+func (t *writeTask) removePlain() error {
+	r := t.r
+	for _, name := range t.plain {
+		if err := r.fs.Remove(name); err != nil {
+			return err
+		}
+		if err := r.audit(AuditDelete, name, "", t.entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package catzip
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// RemoteFS is a WriteFS served by ServeFS at the other end of a connection,
+// so the writes can be done by another, more confined, process
+type RemoteFS struct {
+	mu  sync.Mutex
+	enc *gob.Encoder
+	dec *gob.Decoder
+}
+
+type remoteOp int
+
+const (
+	remoteOpenFile remoteOp = iota
+	remoteOpen
+	remoteWrite
+	remoteRead
+	remoteClose
+	remoteMkdirAll
+	remoteRemove
+	remoteRename
+	remoteTruncate
+)
+
+type remoteRequest struct {
+	Op     remoteOp
+	Name   string
+	Name2  string // New name of a rename
+	Flag   int
+	Perm   fs.FileMode
+	Handle int
+	Data   []byte
+	N      int
+	Size   int64 // Of a truncate
+}
+
+type remoteResponse struct {
+	Handle  int
+	Data    []byte
+	EOF     bool
+	Err     string
+	ErrKind string // notexist, exist or permission, so errors.Is still works
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+func NewRemoteFS(r io.Reader, w io.Writer) *RemoteFS {
+	return &RemoteFS{enc: gob.NewEncoder(w), dec: gob.NewDecoder(r)}
+}
+
+func (c *RemoteFS) call(req remoteRequest) (*remoteResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(req); err != nil {
+		return nil, err
+	}
+	resp := &remoteResponse{}
+	if err := c.dec.Decode(resp); err != nil {
+		return nil, err
+	}
+	if resp.Err != "" {
+		return resp, remoteError(req.Name, resp)
+	}
+	return resp, nil
+}
+
+func remoteError(name string, resp *remoteResponse) error {
+	var kind error
+	switch resp.ErrKind {
+	case "notexist":
+		kind = fs.ErrNotExist
+	case "exist":
+		kind = fs.ErrExist
+	case "permission":
+		kind = fs.ErrPermission
+	default:
+		return errors.New(resp.Err)
+	}
+	return &fs.PathError{Op: "remote", Path: name, Err: &remoteErr{msg: resp.Err, kind: kind}}
+}
+
+type remoteErr struct {
+	msg  string
+	kind error
+}
+
+func (e *remoteErr) Error() string        { return e.msg }
+func (e *remoteErr) Is(target error) bool { return target == e.kind }
+
+func (c *RemoteFS) OpenFile(name string, flag int, perm fs.FileMode) (WriteFile, error) {
+	resp, err := c.call(remoteRequest{Op: remoteOpenFile, Name: name, Flag: flag, Perm: perm})
+	if err != nil {
+		return nil, err
+	}
+	return &remoteFile{c: c, handle: resp.Handle}, nil
+}
+
+func (c *RemoteFS) Open(name string) (fs.File, error) {
+	resp, err := c.call(remoteRequest{Op: remoteOpen, Name: name})
+	if err != nil {
+		return nil, err
+	}
+	info := memFileInfo{name: name, size: resp.Size, mode: resp.Mode, modTime: resp.ModTime}
+	return &remoteFile{c: c, handle: resp.Handle, info: info}, nil
+}
+
+func (c *RemoteFS) MkdirAll(path string, perm fs.FileMode) error {
+	_, err := c.call(remoteRequest{Op: remoteMkdirAll, Name: path, Perm: perm})
+	return err
+}
+
+func (c *RemoteFS) Remove(name string) error {
+	_, err := c.call(remoteRequest{Op: remoteRemove, Name: name})
+	return err
+}
+
+func (c *RemoteFS) Rename(oldpath, newpath string) error {
+	_, err := c.call(remoteRequest{Op: remoteRename, Name: oldpath, Name2: newpath})
+	return err
+}
+
+func (c *RemoteFS) Truncate(name string, size int64) error {
+	_, err := c.call(remoteRequest{Op: remoteTruncate, Name: name, Size: size})
+	return err
+}
+
+type remoteFile struct {
+	c      *RemoteFS
+	handle int
+	info   memFileInfo
+	eof    bool
+}
+
+func (f *remoteFile) Write(p []byte) (int, error) {
+	if _, err := f.c.call(remoteRequest{Op: remoteWrite, Handle: f.handle, Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *remoteFile) Read(p []byte) (int, error) {
+	if f.eof {
+		return 0, io.EOF
+	}
+	resp, err := f.c.call(remoteRequest{Op: remoteRead, Handle: f.handle, N: len(p)})
+	if err != nil {
+		return 0, err
+	}
+	f.eof = resp.EOF
+	n := copy(p, resp.Data)
+	if n == 0 && f.eof {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (f *remoteFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *remoteFile) Close() error {
+	_, err := f.c.call(remoteRequest{Op: remoteClose, Handle: f.handle})
+	return err
+}
+
+// ServeFS answers the requests of a RemoteFS with fsys until r is closed
+func ServeFS(fsys WriteFS, r io.Reader, w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	dec := gob.NewDecoder(r)
+	files := map[int]any{}
+	next := 0
+
+	for {
+		var req remoteRequest
+		if err := dec.Decode(&req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		resp := remoteResponse{}
+		var err error
+		switch req.Op {
+		case remoteOpenFile:
+			var f WriteFile
+			if f, err = fsys.OpenFile(req.Name, req.Flag, req.Perm); err == nil {
+				next++
+				files[next] = f
+				resp.Handle = next
+			}
+		case remoteOpen:
+			var f fs.File
+			if f, err = fsys.Open(req.Name); err == nil {
+				var info fs.FileInfo
+				if info, err = f.Stat(); err != nil {
+					f.Close()
+					break
+				}
+				next++
+				files[next] = f
+				resp.Handle = next
+				resp.Size, resp.Mode, resp.ModTime = info.Size(), info.Mode(), info.ModTime()
+			}
+		case remoteWrite:
+			f, ok := files[req.Handle].(WriteFile)
+			if !ok {
+				err = fs.ErrClosed
+				break
+			}
+			_, err = f.Write(req.Data)
+		case remoteRead:
+			f, ok := files[req.Handle].(fs.File)
+			if !ok {
+				err = fs.ErrClosed
+				break
+			}
+			buf := make([]byte, req.N)
+			var n int
+			n, err = io.ReadFull(f, buf)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				resp.EOF, err = true, nil
+			}
+			resp.Data = buf[:n]
+		case remoteClose:
+			f, ok := files[req.Handle].(io.Closer)
+			if !ok {
+				err = fs.ErrClosed
+				break
+			}
+			delete(files, req.Handle)
+			err = f.Close()
+		case remoteMkdirAll:
+			err = fsys.MkdirAll(req.Name, req.Perm)
+		case remoteRemove:
+			err = fsys.Remove(req.Name)
+		case remoteRename:
+			err = fsys.Rename(req.Name, req.Name2)
+		case remoteTruncate:
+			err = fsys.Truncate(req.Name, req.Size)
+		default:
+			err = errors.New("unknown request")
+		}
+
+		if err != nil {
+			resp.Err = err.Error()
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				resp.ErrKind = "notexist"
+			case errors.Is(err, fs.ErrExist):
+				resp.ErrKind = "exist"
+			case errors.Is(err, fs.ErrPermission):
+				resp.ErrKind = "permission"
+			}
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
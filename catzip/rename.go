@@ -0,0 +1,101 @@
+package catzip
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RenameRule rewrites entry names before the output paths are computed, see
+// ParseRenameRule
+type RenameRule struct {
+	re     *regexp.Regexp
+	repl   string // In regexp.Expand syntax
+	global bool
+}
+
+// ParseRenameRule parses a sed-style s/regexp/replacement/[g] rule, any
+// character can be the delimiter. The regexp has Go syntax, \1 to \9 and &
+// in the replacement are the groups and the whole match.
+func ParseRenameRule(expr string) (RenameRule, error) {
+	if len(expr) < 2 || expr[0] != 's' {
+		return RenameRule{}, fmt.Errorf("invalid rename rule %q, expected s/regexp/replacement/", expr)
+	}
+	delim := expr[1]
+	parts := splitUnescaped(expr[2:], delim)
+	if len(parts) != 3 || (parts[2] != "" && parts[2] != "g") {
+		return RenameRule{}, fmt.Errorf("invalid rename rule %q, expected s/regexp/replacement/ or s/regexp/replacement/g", expr)
+	}
+
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return RenameRule{}, fmt.Errorf("invalid rename rule %q: %w", expr, err)
+	}
+	return RenameRule{re: re, repl: sedReplacement(parts[1]), global: parts[2] == "g"}, nil
+}
+
+// Apply returns name rewritten by the rule
+func (rule RenameRule) Apply(name string) string {
+	if rule.global {
+		return rule.re.ReplaceAllString(name, rule.repl)
+	}
+	match := rule.re.FindStringSubmatchIndex(name)
+	if match == nil {
+		return name
+	}
+	expanded := rule.re.ExpandString(nil, rule.repl, name, match)
+	return name[:match[0]] + string(expanded) + name[match[1]:]
+}
+
+// splitUnescaped splits s on delim, \delim being a literal delim
+func splitUnescaped(s string, delim byte) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			part.WriteByte(delim)
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			// Kept for the regexp or the replacement
+			part.WriteString(s[i : i+2])
+			i++
+		case s[i] == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(s[i])
+		}
+	}
+	return append(parts, part.String())
+}
+
+// sedReplacement converts a sed replacement to regexp.Expand syntax
+func sedReplacement(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			fmt.Fprintf(&out, "${%c}", s[i+1])
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			out.WriteByte(s[i+1])
+			i++
+		case s[i] == '&':
+			out.WriteString("${0}")
+		case s[i] == '$':
+			out.WriteString("$$")
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+	return out.String()
+}
+
+// renamed applies Options.Rename to name
+func (r *run) renamed(name string) string {
+	for _, rule := range r.opts.Rename {
+		name = rule.Apply(name)
+	}
+	return name
+}
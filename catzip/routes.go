@@ -0,0 +1,69 @@
+package catzip
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// catTarget is a file in OutDir entries are concatenated to instead of the
+// cat file, see Options.FormatRoutes and PolicyDecision.Route
+type catTarget struct {
+	path   string
+	file   WriteFile
+	offset int64
+	lines  int64 // With Options.Annotate
+}
+
+// routeTarget returns the route named name, creating its file the first
+// time. It is called with catFileMu held, which guards the routes.
+func (r *run) routeTarget(name string) (*catTarget, error) {
+	if route, ok := r.routes[name]; ok {
+		return route, nil
+	}
+	path := filepath.Join(r.opts.OutDir, name)
+	var f WriteFile
+	var offset int64
+	var err error
+	if saved, ok := r.checkpoint.routeOffset(name); ok {
+		f, err = r.resumeFile(path, saved)
+		offset = saved
+	} else {
+		f, err = r.fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open route %s: %w", name, err)
+	}
+	if r.routes == nil {
+		r.routes = map[string]*catTarget{}
+	}
+	r.routes[name] = &catTarget{path: path, file: f, offset: offset}
+	return r.routes[name], r.audit(AuditCreate, path, "", nil)
+}
+
+// openRoutes creates the files of Options.FormatRoutes up front, so they
+// exist even when no entry has their format
+func (r *run) openRoutes() error {
+	for _, name := range r.opts.FormatRoutes {
+		if _, err := r.routeTarget(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *run) closeRoutes() error {
+	var firstErr error
+	for _, route := range r.routes {
+		if err := route.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	r.routes = nil
+	return firstErr
+}
+
+// validRouteName tells whether name can be a file in OutDir
+func validRouteName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name
+}
@@ -0,0 +1,43 @@
+package catzip
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// RPM packages have a lead, a signature header, the package header and then
+// the payload, a cpio archive compressed or not. Only the payload is
+// extracted, like rpm2cpio | cpio -i does.
+
+var errRpmCorrupt = errors.New("rpm: invalid header")
+
+// openRpmPayload returns the cpio payload of the RPM package in r,
+// decompressed
+func openRpmPayload(r io.Reader) (io.ReadCloser, error) {
+	lead := make([]byte, 96)
+	if _, err := io.ReadFull(r, lead); err != nil || string(lead[:4]) != "\xed\xab\xee\xdb" {
+		return nil, errors.New("rpm: not an RPM package")
+	}
+
+	// The signature header is padded to 8 bytes, the package header isn't
+	for _, padded := range []bool{true, false} {
+		// Magic, version, reserved bytes, number of index entries and size
+		// of the data they point to
+		intro := make([]byte, 16)
+		if _, err := io.ReadFull(r, intro); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if string(intro[:3]) != "\x8e\xad\xe8" {
+			return nil, errRpmCorrupt
+		}
+		size := int64(binary.BigEndian.Uint32(intro[8:]))*16 + int64(binary.BigEndian.Uint32(intro[12:]))
+		if padded {
+			size += (8 - size%8) % 8
+		}
+		if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	return sniffDecompressor(r)
+}
@@ -0,0 +1,141 @@
+package catzip
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3Client builds the requests of the s3:// inputs, signed with the access
+// keys of Options.Credentials
+type s3Client struct {
+	creds  CredentialProvider
+	client *http.Client
+	// "" is AWS, reached with virtual hosted requests
+	endpoint string
+	// "" is the one of each bucket
+	region string
+
+	mu      sync.Mutex
+	regions map[string]string // By bucket
+}
+
+func newS3Client(opts Options, client *http.Client) *s3Client {
+	c := &s3Client{
+		creds:    opts.Credentials,
+		client:   client,
+		endpoint: firstNonEmpty(opts.S3Endpoint, os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")),
+		region:   opts.S3Region,
+		regions:  map[string]string{},
+	}
+	if c.creds == nil {
+		c.creds = DefaultCredentials(opts.HTTPClient)
+	}
+	return c
+}
+
+// request builds the signed GET of the s3://bucket/key?query URL u
+func (c *s3Client) request(u *url.URL) (*http.Request, error) {
+	creds, err := c.creds.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds.AccessKeyID == "" {
+		return nil, fmt.Errorf("the credentials from %s have no access keys to sign S3 requests", creds.Source)
+	}
+	region := c.bucketRegion(u.Host)
+	target := c.bucketURL(u.Host, region)
+	target.Path += strings.TrimPrefix(u.Path, "/")
+	target.RawQuery = u.RawQuery
+
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	signV4(req, creds, region, "s3", time.Now())
+	return req, nil
+}
+
+// bucketURL is the URL of the root of bucket, ending with a slash. Buckets
+// with dots aren't covered by the certificates of their virtual hosts.
+func (c *s3Client) bucketURL(bucket, region string) *url.URL {
+	if c.endpoint != "" {
+		u, _ := url.Parse(c.endpoint)
+		return &url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/"}
+	}
+	if strings.Contains(bucket, ".") {
+		return &url.URL{Scheme: "https", Host: "s3." + region + ".amazonaws.com", Path: "/" + bucket + "/"}
+	}
+	return &url.URL{Scheme: "https", Host: bucket + ".s3." + region + ".amazonaws.com", Path: "/"}
+}
+
+// bucketRegion is the region the requests to bucket are signed for,
+// Options.S3Region or the one it tells to an anonymous HEAD, which S3 and
+// MinIO answer even when it is denied
+func (c *s3Client) bucketRegion(bucket string) string {
+	if c.region != "" {
+		return c.region
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if region, ok := c.regions[bucket]; ok {
+		return region
+	}
+
+	region := ""
+	head := c.bucketURL(bucket, "us-east-1")
+	if c.endpoint == "" {
+		// The global endpoint knows every bucket
+		head.Host = strings.Replace(head.Host, ".us-east-1.", ".", 1)
+	}
+	if resp, err := c.client.Head(head.String()); err == nil {
+		resp.Body.Close()
+		region = resp.Header.Get("X-Amz-Bucket-Region")
+	}
+	region = firstNonEmpty(region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	c.regions[bucket] = region
+	return region
+}
+
+// listS3 lists the objects of bucket with keys starting with prefix, in key
+// order
+func (u *urlInputs) listS3(bucket, prefix string) ([]bucketObject, error) {
+	var objects []bucketObject
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := u.get((&url.URL{Scheme: "s3", Host: bucket, Path: "/", RawQuery: query.Encode()}).String(), "")
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				LastModified time.Time
+				Size         int64
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the listing of s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, bucketObject{object.Key, object.Size, object.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
@@ -0,0 +1,124 @@
+package catzip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Scan verdicts recorded in EntryInfo.Scan
+const (
+	ScanClean     = "clean"
+	ScanMalicious = "malicious"
+)
+
+// scanner streams an entry to Options.ScanCommand while it is written. The
+// command reads the content on stdin and exits 0 when it is clean and 1 when
+// it is malicious, like clamdscan. Anything else fails the run, unscanned
+// content is never let through.
+type scanner struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	output bytes.Buffer
+	// The scanner may stop reading once it has decided
+	stopped bool
+}
+
+func (r *run) startScanner() (*scanner, error) {
+	s := &scanner{cmd: exec.Command(r.opts.ScanCommand[0], r.opts.ScanCommand[1:]...)}
+	s.cmd.Stdout = &s.output
+	s.cmd.Stderr = &s.output
+	var err error
+	if s.stdin, err = s.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err = s.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start the scanner: %w", err)
+	}
+	return s, nil
+}
+
+func (s *scanner) Write(p []byte) {
+	if s.stopped {
+		return
+	}
+	if _, err := s.stdin.Write(p); err != nil {
+		s.stopped = true
+	}
+}
+
+// verdict waits for the scanner, returning ScanClean or ScanMalicious along
+// with what the scanner printed
+func (s *scanner) verdict() (string, string, error) {
+	s.stdin.Close()
+	err := s.cmd.Wait()
+	output := strings.TrimSpace(s.output.String())
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return ScanClean, output, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return ScanMalicious, output, nil
+	default:
+		return "", output, fmt.Errorf("scanner failed: %v: %s", err, output)
+	}
+}
+
+// abort stops the scanner when the entry couldn't be written
+func (s *scanner) abort() {
+	s.stdin.Close()
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+}
+
+// quarantine moves a flagged output to Options.QuarantineDir, or removes it
+// when there is none, and records it as skipped
+func (r *run) quarantine(entry *EntryInfo, output string) error {
+	entry.Status = EntrySkipped
+	entry.Reason = "flagged by the scanner"
+	if output != "" {
+		entry.Reason += ": " + output
+	}
+
+	if r.opts.QuarantineDir == "" {
+		for _, name := range outputFiles(entry) {
+			if err := r.fs.Remove(name); err != nil {
+				return err
+			}
+			if err := r.audit(AuditDelete, name, "", entry); err != nil {
+				return err
+			}
+		}
+		entry.Output = ""
+		entry.Parts = nil
+	} else {
+		if err := r.mkdirAll(r.opts.QuarantineDir, entry); err != nil {
+			return err
+		}
+		quarantined := r.autoRenameRepeatedFiles(filepath.Join(r.opts.QuarantineDir, filepath.Base(entry.Output)))
+		var parts []string
+		for i, name := range outputFiles(entry) {
+			moved := quarantined
+			if len(entry.Parts) > 0 {
+				moved = partName(quarantined, i)
+				parts = append(parts, moved)
+			}
+			if err := r.fs.Rename(name, moved); err != nil {
+				return err
+			}
+			if err := r.audit(AuditRename, name, moved, entry); err != nil {
+				return err
+			}
+		}
+		entry.Output = quarantined
+		entry.Parts = parts
+	}
+
+	r.entryDone(entry)
+	return nil
+}
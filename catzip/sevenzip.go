@@ -0,0 +1,151 @@
+package catzip
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// handle7z extracts the files of a 7z archive like the entries of a zip
+// file. Files of a solid folder are decoded in order from a single stream,
+// so they are written one at a time.
+func (r *run) handle7z(f string) error {
+	reader, err := open7z(r.in, f)
+	if err != nil {
+		return fmt.Errorf("unable to read %s file: %w", r.opts.inputExt(f), err)
+	}
+	defer reader.Close()
+
+	destination, err := filepath.Abs(r.opts.OutDir)
+	if err != nil {
+		return fmt.Errorf("unable to find absolute path for dir %s: %w", r.opts.OutDir, err)
+	}
+
+	archive := manifestArchive{Path: f}
+	for i, file := range reader.files {
+		entry := &EntryInfo{
+			Archive: f,
+			Name:    file.entryName(),
+			Size:    file.size,
+			Mode:    file.mode(),
+			ModTime: file.modTime,
+			CRC32:   file.crc,
+			Entry:   i + 1,
+			Entries: len(reader.files),
+			index:   i,
+		}
+		if reason := unsupported7zReason(reader, file); reason != "" {
+			r.skipEntry(entry, reason)
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		name := r.renamed(entry.Name)
+		if name == "" {
+			r.skipEntry(entry, "renamed to an empty name")
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		if !file.dir {
+			var ok bool
+			if name, ok, err = r.applyPolicy(entry, name); err != nil {
+				return err
+			}
+			if !ok {
+				archive.Entries = append(archive.Entries, entry)
+				continue
+			}
+		}
+
+		open := func() (io.ReadCloser, error) { return reader.open(file) }
+		if err := r.extractEntry(name, entry, destination, file.dir, int64(file.size), open); err != nil {
+			return fmt.Errorf("unable to extract file inside archive: %w", err)
+		}
+		if entry.Output != "" {
+			archive.Entries = append(archive.Entries, entry)
+		}
+	}
+	r.manifest.add(archive)
+	return nil
+}
+
+// entryName returns the name of f with / separators, 7-Zip stores
+// Windows ones
+func (f *sevenZipFile) entryName() string {
+	return strings.ReplaceAll(f.name, "\\", "/")
+}
+
+// unsupported7zReason tells why file can't be extracted, or "" when it can
+func unsupported7zReason(reader *sevenZipReader, file *sevenZipFile) string {
+	switch {
+	case file.anti:
+		return "deleted by the archive"
+	case file.mode()&fs.ModeSymlink != 0:
+		return "symbolic link"
+	case file.hasStream:
+		return reader.folders[file.folder].unsupported
+	}
+	return ""
+}
+
+func list7z(fsys fs.FS, name string) ([]listedEntry, error) {
+	reader, err := open7z(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	entries := make([]listedEntry, 0, len(reader.files))
+	for _, file := range reader.files {
+		entries = append(entries, listedEntry{
+			name:    file.entryName(),
+			size:    int64(file.size),
+			modTime: file.modTime,
+			dir:     file.dir,
+		})
+	}
+	return entries, nil
+}
+
+func count7zEntries(fsys fs.FS, name string) (int, error) {
+	entries, err := list7z(fsys, name)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, entry := range entries {
+		if !entry.dir {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// open7zEntry opens the file name of the 7z file at path, or returns nil when
+// it has none
+func open7zEntry(fsys fs.FS, path, name string) (io.ReadCloser, error) {
+	reader, err := open7z(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range reader.files {
+		if file.entryName() != name || file.dir {
+			continue
+		}
+		if reason := unsupported7zReason(reader, file); reason != "" {
+			reader.Close()
+			return nil, fmt.Errorf("%s: %s", name, reason)
+		}
+		rc, err := reader.open(file)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		return readCloser{rc, reader}, nil
+	}
+	reader.Close()
+	return nil, nil
+}
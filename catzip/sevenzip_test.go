@@ -0,0 +1,277 @@
+package catzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand"
+	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf16"
+)
+
+// runInMemory runs with the inputs of ext in inputs, writing to /out of the
+// MemFS returned
+func runInMemory(t *testing.T, ext string, inputs fs.FS) *MemFS {
+	t.Helper()
+	out := NewMemFS()
+	opts := DefaultOptions()
+	opts.Ext = ext
+	opts.InputFS = inputs
+	opts.OutDir = "/out"
+	opts.FS = out
+	opts.Logger = log.New(io.Discard, "", 0)
+	if _, err := Run(opts); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// sevenZipNumber encodes v as a 7z number, the leading one bits of the
+// first byte telling how many bytes follow
+func sevenZipNumber(v uint64) []byte {
+	for i := 0; i < 8; i++ {
+		if v>>(8*i) < 0x80>>i {
+			b := []byte{^byte(0xFF>>i) | byte(v>>(8*i))}
+			for j := 0; j < i; j++ {
+				b = append(b, byte(v>>(8*j)))
+			}
+			return b
+		}
+	}
+	b := []byte{0xFF}
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+// sevenZipBuilder writes the headers of a 7z archive
+type sevenZipBuilder struct{ b []byte }
+
+func (w *sevenZipBuilder) bytes(b ...byte) *sevenZipBuilder {
+	w.b = append(w.b, b...)
+	return w
+}
+
+func (w *sevenZipBuilder) number(v ...uint64) *sevenZipBuilder {
+	for _, v := range v {
+		w.b = append(w.b, sevenZipNumber(v)...)
+	}
+	return w
+}
+
+func (w *sevenZipBuilder) crcs(crcs ...uint32) *sevenZipBuilder {
+	w.b = append(w.b, sevenZipCRC, 1) // All defined
+	for _, crc := range crcs {
+		w.b = binary.LittleEndian.AppendUint32(w.b, crc)
+	}
+	return w
+}
+
+// property writes the property id of FilesInfo with its size
+func (w *sevenZipBuilder) property(id byte, data []byte) *sevenZipBuilder {
+	w.bytes(id).number(uint64(len(data)))
+	return w.bytes(data...)
+}
+
+// sevenZipFileOf writes the start header, the pack streams and the header
+func sevenZipFileOf(packs [][]byte, header []byte) []byte {
+	var packed []byte
+	for _, pack := range packs {
+		packed = append(packed, pack...)
+	}
+	start := make([]byte, 32)
+	copy(start, sevenZipSignature)
+	start[7] = 4
+	binary.LittleEndian.PutUint64(start[12:], uint64(len(packed)))
+	binary.LittleEndian.PutUint64(start[20:], uint64(len(header)))
+	binary.LittleEndian.PutUint32(start[28:], crc32.ChecksumIEEE(header))
+	binary.LittleEndian.PutUint32(start[8:], crc32.ChecksumIEEE(start[12:32]))
+	return append(append(start, packed...), header...)
+}
+
+var sevenZipModTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// testSevenZip returns a 7z archive of the files of sevenZipContents:
+//   - a.log and b.log in a solid LZMA folder, compressed by the reference
+//     encoder
+//   - bin/code in a folder of BCJ then LZMA2, from the reference encoder
+//   - rand.bin copied, with a Unix mode
+//   - empty.txt and the directory dir, without stream
+//
+// With encodeHeader the header is in a copied folder of its own.
+func testSevenZip(t *testing.T, encodeHeader bool) []byte {
+	lzma := readTestdata(t, "lines.lzma")
+	bcj := readTestdata(t, "code.bcj.lzma2")
+	contents := sevenZipContents()
+	lines, code, random := testLines(40000), testCode(20000), contents["rand.bin"]
+	packs := [][]byte{lzma[13:], bcj, random}
+
+	h := &sevenZipBuilder{}
+	h.bytes(sevenZipHeader, sevenZipMainStreamsInfo)
+	h.bytes(sevenZipPackInfo).number(0, 3)
+	h.bytes(sevenZipSize).number(uint64(len(packs[0])), uint64(len(packs[1])), uint64(len(packs[2]))).bytes(sevenZipEnd)
+
+	h.bytes(sevenZipUnpackInfo, sevenZipFolderInfo).number(3).bytes(0)
+	h.number(1).bytes(0x20 | byte(len(sevenZipLZMA))).bytes([]byte(sevenZipLZMA)...).number(5).bytes(lzma[:5]...)
+	// BCJ reads the output of LZMA2, which reads the pack stream
+	h.number(2).bytes(byte(len(sevenZipBCJ))).bytes([]byte(sevenZipBCJ)...)
+	h.bytes(0x20 | 1).bytes([]byte(sevenZipLZMA2)...).number(1).bytes(8) // 64KiB
+	h.number(0, 1)
+	h.number(1).bytes(1).bytes([]byte(sevenZipCopy)...)
+	h.bytes(sevenZipCodersUnpackSize).number(uint64(len(lines)), uint64(len(code)), uint64(len(code)), uint64(len(random)))
+	h.crcs(crc32.ChecksumIEEE(lines), crc32.ChecksumIEEE(code), crc32.ChecksumIEEE(random)).bytes(sevenZipEnd)
+
+	h.bytes(sevenZipSubStreamsInfo, sevenZipNumUnpackStream).number(2, 1, 1)
+	h.bytes(sevenZipSize).number(uint64(len(contents["a.log"])))
+	h.crcs(crc32.ChecksumIEEE(contents["a.log"]), crc32.ChecksumIEEE(contents["b.log"]))
+	h.bytes(sevenZipEnd, sevenZipEnd)
+
+	names := []string{"a.log", "b.log", "bin\\code", "rand.bin", "empty.txt", "dir"}
+	h.bytes(sevenZipFilesInfo).number(uint64(len(names)))
+	h.property(sevenZipEmptyStream, []byte{0x0C})
+	h.property(sevenZipEmptyFile, []byte{0x80})
+	nameData := []byte{0}
+	for _, name := range names {
+		for _, unit := range utf16.Encode([]rune(name + "\x00")) {
+			nameData = binary.LittleEndian.AppendUint16(nameData, unit)
+		}
+	}
+	h.property(sevenZipName, nameData)
+	times := []byte{1, 0}
+	attributes := []byte{1, 0}
+	for _, name := range names {
+		times = binary.LittleEndian.AppendUint64(times, uint64(sevenZipModTime.Unix())*1e7+116444736000000000)
+		switch name {
+		case "rand.bin":
+			attributes = binary.LittleEndian.AppendUint32(attributes, 0x8000|0100755<<16)
+		case "dir":
+			attributes = binary.LittleEndian.AppendUint32(attributes, 0x10)
+		default:
+			attributes = binary.LittleEndian.AppendUint32(attributes, 0x20)
+		}
+	}
+	h.property(sevenZipMTime, times)
+	h.property(sevenZipWinAttributes, attributes)
+	h.bytes(sevenZipEnd, sevenZipEnd)
+
+	if !encodeHeader {
+		return sevenZipFileOf(packs, h.b)
+	}
+	e := &sevenZipBuilder{}
+	e.bytes(sevenZipEncodedHeader)
+	e.bytes(sevenZipPackInfo).number(uint64(len(packs[0])+len(packs[1])+len(packs[2])), 1)
+	e.bytes(sevenZipSize).number(uint64(len(h.b))).bytes(sevenZipEnd)
+	e.bytes(sevenZipUnpackInfo, sevenZipFolderInfo).number(1).bytes(0)
+	e.number(1).bytes(1).bytes([]byte(sevenZipCopy)...)
+	e.bytes(sevenZipCodersUnpackSize).number(uint64(len(h.b)))
+	e.crcs(crc32.ChecksumIEEE(h.b)).bytes(sevenZipEnd, sevenZipEnd)
+	return sevenZipFileOf(append(packs, h.b), e.b)
+}
+
+// sevenZipContents returns the content of the files of testSevenZip
+func sevenZipContents() map[string][]byte {
+	lines := testLines(40000)
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	return map[string][]byte{
+		"a.log":     lines[:15000],
+		"b.log":     lines[15000:],
+		"bin/code":  testCode(20000),
+		"rand.bin":  random,
+		"empty.txt": {},
+	}
+}
+
+func TestSevenZip(t *testing.T) {
+	contents := sevenZipContents()
+	for _, encodeHeader := range []bool{false, true} {
+		fsys := fstest.MapFS{"test.7z": {Data: testSevenZip(t, encodeHeader)}}
+		z, err := open7z(fsys, "test.7z")
+		if err != nil {
+			t.Fatalf("encoded header %v: %v", encodeHeader, err)
+		}
+		if len(z.files) != 6 {
+			t.Fatalf("encoded header %v: %d files", encodeHeader, len(z.files))
+		}
+
+		// b.log before a.log decodes the solid folder again
+		for _, i := range []int{1, 0, 2, 3, 4} {
+			f := z.files[i]
+			r, err := z.open(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Errorf("%s: %v", f.entryName(), err)
+			}
+			if !bytes.Equal(data, contents[f.entryName()]) {
+				t.Errorf("%s: %d bytes, not its content", f.entryName(), len(data))
+			}
+			if !f.modTime.Equal(sevenZipModTime) {
+				t.Errorf("%s: modified %v", f.entryName(), f.modTime)
+			}
+		}
+		if mode := z.files[3].mode(); mode != 0755 {
+			t.Errorf("rand.bin: mode %v", mode)
+		}
+		if dir := z.files[5]; !dir.dir || dir.entryName() != "dir" || z.files[4].dir {
+			t.Errorf("dir and empty.txt: %+v, %+v", dir, z.files[4])
+		}
+		z.Close()
+	}
+
+	out := runInMemory(t, ".7z", fstest.MapFS{"test.7z": {Data: testSevenZip(t, true)}})
+	for name, content := range contents {
+		if data, err := out.ReadFile("/out/" + name); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("extracted %s: %d bytes, %v", name, len(data), err)
+		}
+	}
+}
+
+// A file whose content doesn't match its CRC fails
+func TestSevenZipChecksum(t *testing.T) {
+	data := testSevenZip(t, false)
+	// In rand.bin, copied after the two other pack streams
+	data[32+len(readTestdata(t, "lines.lzma"))-13+len(readTestdata(t, "code.bcj.lzma2"))] ^= 1
+	z, err := open7z(fstest.MapFS{"test.7z": {Data: data}}, "test.7z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	r, err := z.open(z.files[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadAll(r); err == nil {
+		t.Error("no checksum error")
+	}
+}
+
+func TestSevenZipCorrupt(t *testing.T) {
+	for _, encodeHeader := range []bool{false, true} {
+		checkCorrupt(t, testSevenZip(t, encodeHeader), func(data []byte) error {
+			z, err := open7z(fstest.MapFS{"test.7z": {Data: data}}, "test.7z")
+			if err != nil {
+				return err
+			}
+			defer z.Close()
+			for _, f := range z.files {
+				r, err := z.open(f)
+				if err != nil {
+					return err
+				}
+				if _, err = readLimited(r, 1<<20); err != nil {
+					return err
+				}
+			}
+			if len(z.files) != 6 {
+				return fs.ErrInvalid
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,903 @@
+package catzip
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// A reader for 7z archives, following the 7z format description of the
+// 7-Zip sources. Folders of the Copy, LZMA, LZMA2, Deflate and BZip2
+// methods are decoded, optionally with the x86 BCJ filter.
+
+var sevenZipSignature = []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}
+
+var errSevenZipCorrupt = errors.New("7z: corrupt archive")
+
+// Property IDs of the headers
+const (
+	sevenZipEnd                   = 0x00
+	sevenZipHeader                = 0x01
+	sevenZipArchiveProperties     = 0x02
+	sevenZipAdditionalStreamsInfo = 0x03
+	sevenZipMainStreamsInfo       = 0x04
+	sevenZipFilesInfo             = 0x05
+	sevenZipPackInfo              = 0x06
+	sevenZipUnpackInfo            = 0x07
+	sevenZipSubStreamsInfo        = 0x08
+	sevenZipSize                  = 0x09
+	sevenZipCRC                   = 0x0A
+	sevenZipFolderInfo            = 0x0B
+	sevenZipCodersUnpackSize      = 0x0C
+	sevenZipNumUnpackStream       = 0x0D
+	sevenZipEmptyStream           = 0x0E
+	sevenZipEmptyFile             = 0x0F
+	sevenZipAnti                  = 0x10
+	sevenZipName                  = 0x11
+	sevenZipMTime                 = 0x14
+	sevenZipWinAttributes         = 0x15
+	sevenZipEncodedHeader         = 0x17
+)
+
+// Method IDs of the coders
+const (
+	sevenZipCopy    = "\x00"
+	sevenZipLZMA    = "\x03\x01\x01"
+	sevenZipLZMA2   = "\x21"
+	sevenZipDeflate = "\x04\x01\x08"
+	sevenZipBZip2   = "\x04\x02\x02"
+	sevenZipBCJ     = "\x03\x03\x01\x03"
+	sevenZipAES     = "\x06\xF1\x07\x01"
+)
+
+type sevenZipCoder struct {
+	method  string
+	in, out int // Number of streams
+	props   []byte
+}
+
+// sevenZipFolder is a unit of compressed data, the content of its files one
+// after the other in solid archives
+type sevenZipFolder struct {
+	coders      []sevenZipCoder
+	bindPairs   [][2]int // In stream index, out stream index
+	packed      []int    // In stream indexes reading pack streams
+	unpackSizes []uint64 // By out stream
+	crc         uint32
+	hasCRC      bool
+
+	packOffsets []int64 // Of its pack streams in the archive
+	packSizes   []int64
+	substreams  []sevenZipSubstream // Its files with content, in order
+	unsupported string              // Why it can't be decoded
+}
+
+type sevenZipSubstream struct {
+	size   uint64
+	crc    uint32
+	hasCRC bool
+}
+
+// sevenZipFile is a file or directory of the archive
+type sevenZipFile struct {
+	name      string
+	size      uint64
+	modTime   time.Time
+	attrib    uint32
+	hasAttrib bool
+	dir       bool
+	anti      bool // Deleted by an update archive
+	hasStream bool
+
+	folder int    // With a stream only
+	offset uint64 // In the content of the folder
+	crc    uint32
+	hasCRC bool
+}
+
+// mode maps the Windows attributes, or the Unix mode 7-Zip stores in the
+// upper bits of them, to a file mode
+func (f *sevenZipFile) mode() fs.FileMode {
+	if f.hasAttrib && f.attrib&0x8000 != 0 {
+		unix := f.attrib >> 16
+		mode := fs.FileMode(unix & 0777)
+		switch unix & 0170000 {
+		case 0040000:
+			mode |= fs.ModeDir
+		case 0120000:
+			mode |= fs.ModeSymlink
+		}
+		return mode
+	}
+	mode := fs.FileMode(0644)
+	if f.hasAttrib && f.attrib&0x1 != 0 {
+		mode = 0444
+	}
+	if f.dir {
+		mode = fs.ModeDir | 0755
+	}
+	return mode
+}
+
+// sevenZipReader reads the files of a 7z archive. Folders are decoded as a
+// stream, so reading their files in order decodes them once.
+type sevenZipReader struct {
+	r       io.ReaderAt
+	closer  io.Closer
+	files   []*sevenZipFile
+	folders []*sevenZipFolder
+
+	// Folder being read, with the offset of stream in its content
+	current int
+	stream  io.Reader
+	closers []io.Closer
+	pos     uint64
+}
+
+// open7z reads the headers of the 7z file name
+func open7z(fsys fs.FS, name string) (*sevenZipReader, error) {
+	readerAt, size, closer, err := openReaderAt(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	z := &sevenZipReader{r: readerAt, closer: closer, current: -1}
+	if err = z.readHeaders(size); err != nil {
+		closer.Close()
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *sevenZipReader) Close() error {
+	z.closeStream()
+	return z.closer.Close()
+}
+
+func (z *sevenZipReader) readHeaders(size int64) error {
+	start := make([]byte, 32)
+	if _, err := z.r.ReadAt(start, 0); err != nil {
+		return fmt.Errorf("7z: %w", unexpectedEOF(err))
+	}
+	if !bytes.Equal(start[:6], sevenZipSignature) {
+		return errors.New("7z: not a 7z file")
+	}
+	if start[6] != 0 {
+		return fmt.Errorf("7z: unsupported version %d.%d", start[6], start[7])
+	}
+	if crc32.ChecksumIEEE(start[12:32]) != binary.LittleEndian.Uint32(start[8:]) {
+		return errSevenZipCorrupt
+	}
+
+	offset := binary.LittleEndian.Uint64(start[12:])
+	length := binary.LittleEndian.Uint64(start[20:])
+	if length == 0 {
+		return nil // An empty archive
+	}
+	if offset > uint64(size) || length > uint64(size)-32-offset {
+		return errSevenZipCorrupt
+	}
+	header := make([]byte, length)
+	if _, err := z.r.ReadAt(header, 32+int64(offset)); err != nil {
+		return fmt.Errorf("7z: %w", unexpectedEOF(err))
+	}
+	if crc32.ChecksumIEEE(header) != binary.LittleEndian.Uint32(start[28:]) {
+		return errSevenZipCorrupt
+	}
+
+	for {
+		h := &sevenZipBuf{b: header}
+		switch h.byte() {
+		case sevenZipHeader:
+			if err := z.readHeader(h); err != nil {
+				return err
+			}
+			return h.err
+		case sevenZipEncodedHeader:
+			// The header is compressed, in the first folder of these streams
+			folders, err := z.readStreamsInfo(h)
+			if err != nil {
+				return err
+			}
+			if h.err != nil || len(folders) == 0 {
+				return errSevenZipCorrupt
+			}
+			if header, err = z.decodeFolder(folders[0]); err != nil {
+				return fmt.Errorf("7z: unable to decode the header: %w", err)
+			}
+		default:
+			return errSevenZipCorrupt
+		}
+	}
+}
+
+// decodeFolder reads the whole content of folder, checking its CRC
+func (z *sevenZipReader) decodeFolder(folder *sevenZipFolder) ([]byte, error) {
+	if folder.unsupported != "" {
+		return nil, errors.New(folder.unsupported)
+	}
+	stream, closers, err := z.folderStream(folder)
+	for _, c := range closers {
+		defer c.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	size := folder.unpackSize()
+	if size > 1<<30 {
+		return nil, errSevenZipCorrupt
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(stream, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if folder.hasCRC && crc32.ChecksumIEEE(data) != folder.crc {
+		return nil, errSevenZipCorrupt
+	}
+	return data, nil
+}
+
+func (z *sevenZipReader) readHeader(h *sevenZipBuf) error {
+	id := h.byte()
+	if id == sevenZipArchiveProperties {
+		for h.byte() != sevenZipEnd && h.err == nil {
+			h.bytes(h.count())
+		}
+		id = h.byte()
+	}
+	if id == sevenZipAdditionalStreamsInfo {
+		return errors.New("7z: additional streams aren't supported")
+	}
+	if id == sevenZipMainStreamsInfo {
+		folders, err := z.readStreamsInfo(h)
+		if err != nil {
+			return err
+		}
+		z.folders = folders
+		id = h.byte()
+	}
+	if id == sevenZipFilesInfo {
+		if err := z.readFilesInfo(h); err != nil {
+			return err
+		}
+		id = h.byte()
+	}
+	if id != sevenZipEnd {
+		return errSevenZipCorrupt
+	}
+	return nil
+}
+
+// readStreamsInfo reads the pack streams and the folders using them, with
+// the sizes and CRCs of their files
+func (z *sevenZipReader) readStreamsInfo(h *sevenZipBuf) ([]*sevenZipFolder, error) {
+	var packPos uint64
+	var packSizes []uint64
+	var folders []*sevenZipFolder
+
+	id := h.byte()
+	if id == sevenZipPackInfo {
+		packPos = h.number()
+		packSizes = make([]uint64, h.count())
+		for id = h.byte(); id != sevenZipEnd && h.err == nil; id = h.byte() {
+			switch id {
+			case sevenZipSize:
+				for i := range packSizes {
+					packSizes[i] = h.number()
+				}
+			case sevenZipCRC:
+				h.digests(len(packSizes))
+			default:
+				return nil, errSevenZipCorrupt
+			}
+		}
+		id = h.byte()
+	}
+
+	if id == sevenZipUnpackInfo {
+		if h.byte() != sevenZipFolderInfo {
+			return nil, errSevenZipCorrupt
+		}
+		folders = make([]*sevenZipFolder, h.count())
+		if h.byte() != 0 {
+			return nil, errors.New("7z: external folders aren't supported")
+		}
+		for i := range folders {
+			if folders[i] = h.folder(); h.err != nil {
+				return nil, h.err
+			}
+		}
+		if h.byte() != sevenZipCodersUnpackSize {
+			return nil, errSevenZipCorrupt
+		}
+		for _, folder := range folders {
+			for i := range folder.unpackSizes {
+				folder.unpackSizes[i] = h.number()
+			}
+		}
+		for id = h.byte(); id != sevenZipEnd && h.err == nil; id = h.byte() {
+			if id != sevenZipCRC {
+				return nil, errSevenZipCorrupt
+			}
+			defined, crcs := h.digests(len(folders))
+			for i, folder := range folders {
+				folder.hasCRC, folder.crc = defined[i], crcs[i]
+			}
+		}
+		id = h.byte()
+	}
+
+	// Offsets of the pack streams, by folder
+	offset := int64(32) + int64(packPos)
+	next := 0
+	for _, folder := range folders {
+		for range folder.packed {
+			if next >= len(packSizes) {
+				return nil, errSevenZipCorrupt
+			}
+			folder.packOffsets = append(folder.packOffsets, offset)
+			folder.packSizes = append(folder.packSizes, int64(packSizes[next]))
+			offset += int64(packSizes[next])
+			next++
+		}
+	}
+
+	if id == sevenZipSubStreamsInfo {
+		if err := readSubStreamsInfo(h, folders); err != nil {
+			return nil, err
+		}
+		id = h.byte()
+	} else {
+		// A file per folder
+		for _, folder := range folders {
+			folder.substreams = []sevenZipSubstream{{size: folder.unpackSize(), crc: folder.crc, hasCRC: folder.hasCRC}}
+		}
+	}
+	if id != sevenZipEnd || h.err != nil {
+		return nil, errSevenZipCorrupt
+	}
+	return folders, nil
+}
+
+// readSubStreamsInfo reads the sizes and the CRCs of the files in folders
+func readSubStreamsInfo(h *sevenZipBuf, folders []*sevenZipFolder) error {
+	counts := make([]int, len(folders))
+	for i := range counts {
+		counts[i] = 1
+	}
+
+	id := h.byte()
+	if id == sevenZipNumUnpackStream {
+		for i := range counts {
+			counts[i] = h.count()
+		}
+		id = h.byte()
+	}
+
+	for i, folder := range folders {
+		folder.substreams = make([]sevenZipSubstream, counts[i])
+		if counts[i] == 0 {
+			continue
+		}
+		// The size of the last file is what is left of the folder
+		left := folder.unpackSize()
+		for j := 0; j < counts[i]-1; j++ {
+			if id != sevenZipSize {
+				return errSevenZipCorrupt
+			}
+			size := h.number()
+			if size > left {
+				return errSevenZipCorrupt
+			}
+			folder.substreams[j].size = size
+			left -= size
+		}
+		folder.substreams[counts[i]-1].size = left
+	}
+	if id == sevenZipSize {
+		id = h.byte()
+	}
+
+	// The CRC of the folder is the one of its file when it has a single one
+	missing := 0
+	for _, folder := range folders {
+		if len(folder.substreams) == 1 && folder.hasCRC {
+			folder.substreams[0].crc, folder.substreams[0].hasCRC = folder.crc, true
+		} else {
+			missing += len(folder.substreams)
+		}
+	}
+	for ; id != sevenZipEnd && h.err == nil; id = h.byte() {
+		if id != sevenZipCRC {
+			h.bytes(h.count())
+			continue
+		}
+		defined, crcs := h.digests(missing)
+		i := 0
+		for _, folder := range folders {
+			if len(folder.substreams) == 1 && folder.hasCRC {
+				continue
+			}
+			for j := range folder.substreams {
+				folder.substreams[j].crc, folder.substreams[j].hasCRC = crcs[i], defined[i]
+				i++
+			}
+		}
+	}
+	return h.err
+}
+
+// readFilesInfo reads the files and places the ones with content in the
+// folders
+func (z *sevenZipReader) readFilesInfo(h *sevenZipBuf) error {
+	files := make([]*sevenZipFile, h.count())
+	for i := range files {
+		files[i] = &sevenZipFile{hasStream: true}
+	}
+
+	var emptyStream, emptyFile, anti []bool
+	for id := h.byte(); id != sevenZipEnd && h.err == nil; id = h.byte() {
+		p := &sevenZipBuf{b: h.bytes(h.count())}
+		switch id {
+		case sevenZipEmptyStream:
+			emptyStream = p.bits(len(files))
+			for i, empty := range emptyStream {
+				files[i].hasStream = !empty
+			}
+		case sevenZipEmptyFile:
+			emptyFile = p.bits(countTrue(emptyStream))
+		case sevenZipAnti:
+			anti = p.bits(countTrue(emptyStream))
+		case sevenZipName:
+			if p.byte() != 0 {
+				return errors.New("7z: external names aren't supported")
+			}
+			for _, f := range files {
+				f.name = p.utf16String()
+			}
+		case sevenZipMTime:
+			defined := p.defined(len(files))
+			if p.byte() != 0 {
+				return errors.New("7z: external times aren't supported")
+			}
+			for i, f := range files {
+				if defined[i] {
+					f.modTime = fileTime(p.uint64())
+				}
+			}
+		case sevenZipWinAttributes:
+			defined := p.defined(len(files))
+			if p.byte() != 0 {
+				return errors.New("7z: external attributes aren't supported")
+			}
+			for i, f := range files {
+				if defined[i] {
+					f.attrib, f.hasAttrib = p.uint32(), true
+				}
+			}
+		}
+		if p.err != nil {
+			return errSevenZipCorrupt
+		}
+	}
+	if h.err != nil {
+		return errSevenZipCorrupt
+	}
+
+	// Files without a stream are empty files or directories, files with one
+	// take the next file of the folders
+	empty := 0
+	folder, substream := 0, 0
+	var offset uint64
+	for _, f := range files {
+		if !f.hasStream {
+			f.dir = empty >= len(emptyFile) || !emptyFile[empty]
+			f.anti = empty < len(anti) && anti[empty]
+			empty++
+			continue
+		}
+		for folder < len(z.folders) && substream == len(z.folders[folder].substreams) {
+			folder, substream, offset = folder+1, 0, 0
+		}
+		if folder == len(z.folders) {
+			return errSevenZipCorrupt
+		}
+		s := z.folders[folder].substreams[substream]
+		f.folder, f.offset = folder, offset
+		f.size, f.crc, f.hasCRC = s.size, s.crc, s.hasCRC
+		offset += s.size
+		substream++
+	}
+	for _, f := range files {
+		if f.hasAttrib && f.attrib&0x10 != 0 {
+			f.dir = true
+		}
+	}
+	z.files = files
+	return nil
+}
+
+func countTrue(bits []bool) int {
+	n := 0
+	for _, b := range bits {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// fileTime converts a Windows FILETIME, in 100ns since 1601
+func fileTime(ft uint64) time.Time {
+	const unixEpoch = 116444736000000000
+	ticks := int64(ft) - unixEpoch
+	return time.Unix(ticks/1e7, ticks%1e7*100).UTC()
+}
+
+func (folder *sevenZipFolder) unpackSize() uint64 {
+	if out := folder.mainOut(); out >= 0 {
+		return folder.unpackSizes[out]
+	}
+	return 0
+}
+
+// mainOut returns the out stream no coder reads, the content of the folder
+func (folder *sevenZipFolder) mainOut() int {
+	for out := range folder.unpackSizes {
+		bound := false
+		for _, pair := range folder.bindPairs {
+			if pair[1] == out {
+				bound = true
+			}
+		}
+		if !bound {
+			return out
+		}
+	}
+	return -1
+}
+
+// folderStream returns the content of folder, the coders must have a single
+// in and out stream each. Closing the closers stops the decoders.
+func (z *sevenZipReader) folderStream(folder *sevenZipFolder) (io.Reader, []io.Closer, error) {
+	var closers []io.Closer
+	var outStream func(out int, depth int) (io.Reader, error)
+	outStream = func(out int, depth int) (io.Reader, error) {
+		if depth > len(folder.coders) || out < 0 || out >= len(folder.coders) {
+			return nil, errSevenZipCorrupt
+		}
+		// With a stream each, coder i has in and out stream i
+		coder := folder.coders[out]
+		var in io.Reader
+		for _, pair := range folder.bindPairs {
+			if pair[0] == out {
+				var err error
+				if in, err = outStream(pair[1], depth+1); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for i, packed := range folder.packed {
+			if packed == out {
+				in = io.NewSectionReader(z.r, folder.packOffsets[i], folder.packSizes[i])
+			}
+		}
+		if in == nil {
+			return nil, errSevenZipCorrupt
+		}
+		decoder, err := sevenZipDecoder(coder, in, folder.unpackSizes[out])
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, decoder)
+		return decoder, nil
+	}
+	stream, err := outStream(folder.mainOut(), 0)
+	return stream, closers, err
+}
+
+// sevenZipDecoder decodes in with coder, size is the size of the output
+func sevenZipDecoder(coder sevenZipCoder, in io.Reader, size uint64) (io.ReadCloser, error) {
+	switch coder.method {
+	case sevenZipCopy:
+		return io.NopCloser(in), nil
+	case sevenZipLZMA:
+		if len(coder.props) < 5 {
+			return nil, errSevenZipCorrupt
+		}
+		reader, err := newLZMAReader(in, coder.props[0], binary.LittleEndian.Uint32(coder.props[1:]), int64(size))
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(reader), nil
+	case sevenZipLZMA2:
+		if len(coder.props) < 1 {
+			return nil, errSevenZipCorrupt
+		}
+		dictSize, err := lzma2DictSize(coder.props[0])
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(newLZMA2Reader(in, dictSize, int64(size))), nil
+	case sevenZipDeflate:
+		return flate.NewReader(in), nil
+	case sevenZipBZip2:
+		return io.NopCloser(bzip2.NewReader(in)), nil
+	case sevenZipBCJ:
+		return io.NopCloser(newBCJReader(in)), nil
+	}
+	return nil, fmt.Errorf("unsupported 7z method %x", coder.method)
+}
+
+// checkCoders tells why folder can't be decoded, or "" when it can
+func (folder *sevenZipFolder) checkCoders() string {
+	for _, coder := range folder.coders {
+		switch coder.method {
+		case sevenZipCopy, sevenZipLZMA, sevenZipLZMA2, sevenZipDeflate, sevenZipBZip2, sevenZipBCJ:
+		case sevenZipAES:
+			return "encrypted"
+		default:
+			return fmt.Sprintf("unsupported 7z method %x", coder.method)
+		}
+		if coder.in != 1 || coder.out != 1 {
+			return fmt.Sprintf("unsupported 7z method %x with %d streams", coder.method, coder.in)
+		}
+	}
+	return ""
+}
+
+// open returns the content of f, which is read from the current folder
+// stream when it is after the last file read
+func (z *sevenZipReader) open(f *sevenZipFile) (io.ReadCloser, error) {
+	if !f.hasStream {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	folder := z.folders[f.folder]
+	if folder.unsupported != "" {
+		return nil, errors.New(folder.unsupported)
+	}
+
+	if z.current != f.folder || f.offset < z.pos {
+		z.closeStream()
+		stream, closers, err := z.folderStream(folder)
+		z.closers = closers
+		if err != nil {
+			z.closeStream()
+			return nil, err
+		}
+		z.current, z.stream, z.pos = f.folder, stream, 0
+	}
+	if skip := f.offset - z.pos; skip > 0 {
+		n, err := io.CopyN(io.Discard, z.stream, int64(skip))
+		z.pos += uint64(n)
+		if err != nil {
+			z.closeStream()
+			return nil, unexpectedEOF(err)
+		}
+	}
+	return &sevenZipFileReader{z: z, f: f, left: f.size, crc: crc32.NewIEEE()}, nil
+}
+
+func (z *sevenZipReader) closeStream() {
+	for _, c := range z.closers {
+		c.Close()
+	}
+	z.current, z.stream, z.closers, z.pos = -1, nil, nil, 0
+}
+
+// sevenZipFileReader reads a file from the folder stream, checking its CRC
+type sevenZipFileReader struct {
+	z    *sevenZipReader
+	f    *sevenZipFile
+	left uint64
+	crc  hash.Hash32
+}
+
+func (r *sevenZipFileReader) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		if r.f.hasCRC && r.crc.Sum32() != r.f.crc {
+			return 0, fmt.Errorf("7z: checksum error in %s", r.f.name)
+		}
+		return 0, io.EOF
+	}
+	if r.z.stream == nil || r.z.current != r.f.folder {
+		return 0, errors.New("7z: file read after the next one was opened")
+	}
+	if uint64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.z.stream.Read(p)
+	r.z.pos += uint64(n)
+	r.left -= uint64(n)
+	r.crc.Write(p[:n])
+	if err == io.EOF {
+		if r.left > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (r *sevenZipFileReader) Close() error { return nil }
+
+// sevenZipBuf reads the headers, its first error sticks
+type sevenZipBuf struct {
+	b   []byte
+	err error
+}
+
+func (h *sevenZipBuf) bytes(n int) []byte {
+	if h.err != nil || n > len(h.b) {
+		h.err = errSevenZipCorrupt
+		return nil
+	}
+	b := h.b[:n]
+	h.b = h.b[n:]
+	return b
+}
+
+func (h *sevenZipBuf) byte() byte {
+	if b := h.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (h *sevenZipBuf) uint32() uint32 {
+	if b := h.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (h *sevenZipBuf) uint64() uint64 {
+	if b := h.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// number reads a variable length number, the leading one bits of the first
+// byte tell how many bytes follow
+func (h *sevenZipBuf) number() uint64 {
+	first := h.byte()
+	var value uint64
+	mask := byte(0x80)
+	for i := 0; i < 8; i++ {
+		if first&mask == 0 {
+			high := uint64(first & (mask - 1))
+			return value | high<<(8*i)
+		}
+		value |= uint64(h.byte()) << (8 * i)
+		mask >>= 1
+	}
+	return value
+}
+
+// count reads a number of things, each taking at least a byte of the
+// headers
+func (h *sevenZipBuf) count() int {
+	n := h.number()
+	if n > uint64(len(h.b)) {
+		h.err = errSevenZipCorrupt
+		return 0
+	}
+	return int(n)
+}
+
+// bits reads n bits, the highest first
+func (h *sevenZipBuf) bits(n int) []bool {
+	b := h.bytes((n + 7) / 8)
+	bits := make([]bool, n)
+	for i := range bits {
+		if b != nil {
+			bits[i] = b[i/8]&(0x80>>(i%8)) != 0
+		}
+	}
+	return bits
+}
+
+// defined reads which of n items are defined, all of them or a bit each
+func (h *sevenZipBuf) defined(n int) []bool {
+	if h.byte() == 0 {
+		return h.bits(n)
+	}
+	all := make([]bool, n)
+	for i := range all {
+		all[i] = true
+	}
+	return all
+}
+
+func (h *sevenZipBuf) digests(n int) ([]bool, []uint32) {
+	defined := h.defined(n)
+	crcs := make([]uint32, n)
+	for i := range crcs {
+		if defined[i] {
+			crcs[i] = h.uint32()
+		}
+	}
+	return defined, crcs
+}
+
+func (h *sevenZipBuf) utf16String() string {
+	var units []uint16
+	for {
+		b := h.bytes(2)
+		if b == nil {
+			return ""
+		}
+		unit := binary.LittleEndian.Uint16(b)
+		if unit == 0 {
+			return string(utf16.Decode(units))
+		}
+		units = append(units, unit)
+	}
+}
+
+func (h *sevenZipBuf) folder() *sevenZipFolder {
+	folder := &sevenZipFolder{}
+	numCoders := h.count()
+	inStreams, outStreams := 0, 0
+	for i := 0; i < numCoders && h.err == nil; i++ {
+		flags := h.byte()
+		if flags&0x80 != 0 {
+			h.err = errors.New("7z: alternative methods aren't supported")
+			return folder
+		}
+		coder := sevenZipCoder{method: string(h.bytes(int(flags & 0xF))), in: 1, out: 1}
+		if flags&0x10 != 0 {
+			coder.in, coder.out = h.count(), h.count()
+		}
+		if flags&0x20 != 0 {
+			coder.props = h.bytes(h.count())
+		}
+		inStreams += coder.in
+		outStreams += coder.out
+		folder.coders = append(folder.coders, coder)
+	}
+	if h.err != nil || outStreams == 0 {
+		h.err = errSevenZipCorrupt
+		return folder
+	}
+
+	for i := 0; i < outStreams-1; i++ {
+		folder.bindPairs = append(folder.bindPairs, [2]int{int(h.number()), int(h.number())})
+	}
+	numPacked := inStreams - len(folder.bindPairs)
+	if numPacked < 1 {
+		h.err = errSevenZipCorrupt
+		return folder
+	}
+	if numPacked == 1 {
+		for in := 0; in < inStreams; in++ {
+			bound := false
+			for _, pair := range folder.bindPairs {
+				if pair[0] == in {
+					bound = true
+				}
+			}
+			if !bound {
+				folder.packed = append(folder.packed, in)
+				break
+			}
+		}
+	} else {
+		for i := 0; i < numPacked; i++ {
+			folder.packed = append(folder.packed, int(h.number()))
+		}
+	}
+	folder.unpackSizes = make([]uint64, outStreams)
+	folder.unsupported = folder.checkCoders()
+	return folder
+}
@@ -0,0 +1,633 @@
+package catzip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The sftp:// inputs are read with version 3 of the SFTP protocol, the one
+// of OpenSSH, over the sftp subsystem of the ssh command so its keys, agent
+// and known hosts apply
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpStat     = 17
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+	sftpReadFlag = 1 // SSH_FXF_READ
+
+	// Reads asked at once by an sftpFile, the latency is paid once per
+	// window rather than once per chunk
+	sftpChunk  = 32 << 10
+	sftpWindow = 16
+)
+
+// defaultSSHCommand is the one of a nil Options.SSHCommand, failing rather
+// than prompting for a password nobody is there to type
+var defaultSSHCommand = []string{"ssh", "-o", "BatchMode=yes"}
+
+// sftpClients keeps one connection per user, host and port of the sftp://
+// inputs, a broken one is dialed again
+type sftpClients struct {
+	command []string
+	mu      sync.Mutex
+	conns   map[string]*sftpConn // By user@host:port
+}
+
+func newSFTPClients(opts Options) *sftpClients {
+	c := &sftpClients{command: opts.SSHCommand, conns: map[string]*sftpConn{}}
+	if len(c.command) == 0 {
+		c.command = defaultSSHCommand
+	}
+	return c
+}
+
+func (c *sftpClients) conn(u *url.URL) (*sftpConn, error) {
+	key := u.User.Username() + "@" + u.Host
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[key]; ok && conn.broken() == nil {
+		return conn, nil
+	}
+	args := append([]string{}, c.command[1:]...)
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	if user := u.User.Username(); user != "" {
+		args = append(args, "-l", user)
+	}
+	conn, err := dialSFTP(c.command[0], append(args, "-s", u.Hostname(), "sftp")...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach %s over sftp: %w", u.Host, err)
+	}
+	c.conns[key] = conn
+	return conn, nil
+}
+
+func (c *sftpClients) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conn := range c.conns {
+		conn.close()
+		delete(c.conns, key)
+	}
+}
+
+// sftpConn is an sftp session, the requests of several files in flight at
+// once matched with their responses by id
+type sftpConn struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  bytes.Buffer // Read once cmd is done
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan []byte
+	err     error         // Why the connection broke, nil while it works
+	done    chan struct{} // Closed once err is set
+}
+
+func dialSFTP(name string, args ...string) (*sftpConn, error) {
+	c := &sftpConn{cmd: exec.Command(name, args...), pending: map[uint32]chan []byte{}, done: make(chan struct{})}
+	c.cmd.Stderr = &c.stderr
+	var err error
+	if c.stdin, err = c.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = c.cmd.Start(); err != nil {
+		return nil, err
+	}
+	out := bufio.NewReader(stdout)
+
+	var init sftpBuf
+	init.uint32(3)
+	if err = c.write(sftpInit, init); err == nil {
+		var packet []byte
+		if packet, err = readSFTPPacket(out); err == nil && packet[0] != sftpVersion {
+			err = fmt.Errorf("unexpected packet %d instead of the version", packet[0])
+		}
+	}
+	if err != nil {
+		c.stdin.Close()
+		return nil, c.exitError(err)
+	}
+	go c.dispatch(out)
+	return c, nil
+}
+
+// dispatch hands the responses to the requests waiting for them until the
+// connection breaks, failing the remaining ones
+func (c *sftpConn) dispatch(out *bufio.Reader) {
+	for {
+		packet, err := readSFTPPacket(out)
+		if err == nil && len(packet) < 5 {
+			err = errors.New("short packet")
+		}
+		if err != nil {
+			c.stdin.Close()
+			err = c.exitError(err)
+			c.mu.Lock()
+			c.err = err
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			close(c.done)
+			return
+		}
+		id := binary.BigEndian.Uint32(packet[1:5])
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			ch <- packet
+		}
+	}
+}
+
+// exitError adds what ssh said to err once it is done
+func (c *sftpConn) exitError(err error) error {
+	if waitErr := c.cmd.Wait(); waitErr != nil {
+		err = fmt.Errorf("ssh: %w", waitErr)
+	}
+	if stderr := bytes.TrimSpace(c.stderr.Bytes()); len(stderr) > 0 {
+		err = fmt.Errorf("%w: %s", err, stderr)
+	}
+	return err
+}
+
+func (c *sftpConn) broken() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *sftpConn) close() {
+	c.stdin.Close()
+}
+
+func (c *sftpConn) write(kind byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)+1))
+	header[4] = kind
+	if _, err := c.stdin.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := c.stdin.Write(payload)
+	return err
+}
+
+func readSFTPPacket(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n == 0 || n > 1<<20 {
+		return nil, fmt.Errorf("bad packet length %d", n)
+	}
+	packet := make([]byte, n)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// start sends a request, its response comes on the channel, which is closed
+// instead when the connection breaks
+func (c *sftpConn) start(kind byte, payload sftpBuf) (<-chan []byte, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan []byte, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	var request sftpBuf
+	request.uint32(id)
+	if err := c.write(kind, append(request, payload...)); err != nil {
+		// ssh is gone, dispatch tells why
+		c.stdin.Close()
+		<-c.done
+		return nil, c.err
+	}
+	return ch, nil
+}
+
+// wait returns the response of a request past its type and id, an
+// sftpStatus one that isn't OK as its error
+func (c *sftpConn) wait(ch <-chan []byte) (byte, *sftpReader, error) {
+	packet, ok := <-ch
+	if !ok {
+		return 0, nil, c.broken()
+	}
+	r := &sftpReader{data: packet[5:]}
+	if packet[0] != sftpStatus {
+		return packet[0], r, nil
+	}
+	code, message := r.uint32(), r.string()
+	switch {
+	case r.err != nil:
+		return 0, nil, r.err
+	case code == 0:
+		return sftpStatus, r, nil
+	case code == 1:
+		return 0, nil, io.EOF
+	case code == 2:
+		return 0, nil, fmt.Errorf("%s: %w", message, fs.ErrNotExist)
+	case code == 3:
+		return 0, nil, fmt.Errorf("%s: %w", message, fs.ErrPermission)
+	}
+	return 0, nil, fmt.Errorf("sftp error %d: %s", code, message)
+}
+
+// request sends a request and waits for a response of kind
+func (c *sftpConn) request(kind byte, payload sftpBuf, want byte) (*sftpReader, error) {
+	ch, err := c.start(kind, payload)
+	if err != nil {
+		return nil, err
+	}
+	got, r, err := c.wait(ch)
+	if err == nil && got != want {
+		err = fmt.Errorf("unexpected packet %d instead of %d", got, want)
+	}
+	return r, err
+}
+
+func (c *sftpConn) stat(p string) (sftpAttributes, error) {
+	var payload sftpBuf
+	payload.string(p)
+	r, err := c.request(sftpStat, payload, sftpAttrs)
+	if err != nil {
+		return sftpAttributes{}, err
+	}
+	attrs := r.attrs()
+	return attrs, r.err
+}
+
+func (c *sftpConn) closeHandle(handle string) error {
+	var payload sftpBuf
+	payload.string(handle)
+	_, err := c.request(sftpClose, payload, sftpStatus)
+	return err
+}
+
+// readDir returns the entries of the directory p but . and ..
+func (c *sftpConn) readDir(p string) ([]sftpEntry, error) {
+	var payload sftpBuf
+	payload.string(p)
+	r, err := c.request(sftpOpendir, payload, sftpHandle)
+	if err != nil {
+		return nil, err
+	}
+	handle := r.string()
+	if r.err != nil {
+		return nil, r.err
+	}
+	defer c.closeHandle(handle)
+
+	var entries []sftpEntry
+	for {
+		payload = nil
+		payload.string(handle)
+		r, err := c.request(sftpReaddir, payload, sftpName)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			name := r.string()
+			r.string() // The ls -l line
+			attrs := r.attrs()
+			if name != "." && name != ".." {
+				entries = append(entries, sftpEntry{name, attrs})
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+}
+
+type sftpEntry struct {
+	name  string
+	attrs sftpAttributes
+}
+
+type sftpAttributes struct {
+	size    int64
+	mode    uint32 // 0 when the server doesn't tell
+	modTime time.Time
+}
+
+// Of the mode of sftpAttributes, like the ones of stat
+func (a sftpAttributes) isDir() bool     { return a.mode&0170000 == 0040000 }
+func (a sftpAttributes) isRegular() bool { return a.mode&0170000 == 0100000 }
+func (a sftpAttributes) isSymlink() bool { return a.mode&0170000 == 0120000 }
+
+// sftpBuf builds the payload of a request
+type sftpBuf []byte
+
+func (b *sftpBuf) uint32(v uint32) { *b = binary.BigEndian.AppendUint32(*b, v) }
+func (b *sftpBuf) uint64(v uint64) { *b = binary.BigEndian.AppendUint64(*b, v) }
+func (b *sftpBuf) string(s string) { b.uint32(uint32(len(s))); *b = append(*b, s...) }
+
+// sftpReader reads a response, err is set once it is too short
+type sftpReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sftpReader) next(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data) {
+		r.err = errors.New("short sftp packet")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *sftpReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *sftpReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *sftpReader) string() string {
+	return string(r.next(int(r.uint32())))
+}
+
+func (r *sftpReader) attrs() sftpAttributes {
+	var a sftpAttributes
+	flags := r.uint32()
+	if flags&0x1 != 0 {
+		a.size = int64(r.uint64())
+	}
+	if flags&0x2 != 0 {
+		r.uint32() // uid
+		r.uint32() // gid
+	}
+	if flags&0x4 != 0 {
+		a.mode = r.uint32()
+	}
+	if flags&0x8 != 0 {
+		r.uint32() // atime
+		a.modTime = time.Unix(int64(r.uint32()), 0)
+	}
+	if flags&0x80000000 != 0 {
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			r.string()
+			r.string()
+		}
+	}
+	return a
+}
+
+// sftpPath is the remote path of the path of an sftp:// URL, absolute or,
+// starting with /~/, relative to the home directory
+func sftpPath(p string) string {
+	if p == "/~" || strings.HasPrefix(p, "/~/") {
+		if rel := strings.Trim(p[2:], "/"); rel != "" {
+			return rel
+		}
+		return "."
+	}
+	if p == "" {
+		return "/"
+	}
+	return p
+}
+
+// listSFTP lists the files under the directory of the sftp://host/dir URL
+// target, following the symbolic links to files, keyed by their path like
+// the objects of a bucket, in name order
+func (u *urlInputs) listSFTP(target *url.URL) ([]bucketObject, error) {
+	conn, err := u.sftp.conn(target)
+	if err != nil {
+		return nil, err
+	}
+	var objects []bucketObject
+	var walk func(dir, key string) error
+	walk = func(dir, key string) error {
+		entries, err := conn.readDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			p, k := path.Join(dir, entry.name), key+entry.name
+			attrs := entry.attrs
+			if attrs.isSymlink() {
+				if attrs, err = conn.stat(p); err != nil {
+					u.logger.Printf("unable to follow %s: %v", p, err)
+					continue
+				}
+				if attrs.isDir() {
+					// Left out, they can loop
+					continue
+				}
+			}
+			switch {
+			case attrs.isDir():
+				if err := walk(p, k+"/"); err != nil {
+					u.logger.Printf("unable to list %s: %v", p, err)
+				}
+			case attrs.isRegular():
+				key := strings.TrimPrefix(path.Join(target.Path, k), "/")
+				objects = append(objects, bucketObject{key, attrs.size, attrs.modTime})
+			}
+		}
+		return nil
+	}
+	if err = walk(sftpPath(target.Path), ""); err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// statSFTP returns the info of an sftp:// URL input
+func (u *urlInputs) statSFTP(name, raw string) (fs.FileInfo, error) {
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := u.sftp.conn(target)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := conn.stat(sftpPath(target.Path))
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat %s: %w", raw, err)
+	}
+	if attrs.isDir() {
+		return nil, fmt.Errorf("%s is a directory", raw)
+	}
+	return urlInfo{name: path.Base(name), size: attrs.size, modTime: attrs.modTime}, nil
+}
+
+// sftpFile reads an sftp:// URL with a window of reads in flight. When the
+// connection breaks it is dialed again and the file read on from where it
+// broke.
+type sftpFile struct {
+	inputs   *urlInputs
+	name     string
+	url      string
+	conn     *sftpConn
+	handle   string
+	read     int64 // Returned so far
+	next     int64 // Of the next read to ask for
+	inflight []sftpPendingRead
+	data     []byte // Of the last response, not returned yet
+	eof      bool
+	failures int
+}
+
+type sftpPendingRead struct {
+	offset int64
+	ch     <-chan []byte
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	for {
+		if len(f.data) > 0 {
+			n := copy(p, f.data)
+			f.data = f.data[n:]
+			f.read += int64(n)
+			return n, nil
+		}
+		if f.eof {
+			return 0, io.EOF
+		}
+
+		err := f.fill()
+		switch {
+		case err == nil:
+			continue
+		case err == io.EOF:
+			f.eof = true
+			continue
+		case f.conn == nil || f.conn.broken() == nil:
+			return 0, fmt.Errorf("unable to download %s: %w", f.url, err)
+		}
+		// The connection broke, the reads in flight are lost with it
+		f.conn, f.handle, f.inflight = nil, "", nil
+		if f.failures++; f.failures > f.inputs.retries {
+			return 0, fmt.Errorf("unable to download %s: %w", f.url, err)
+		}
+		wait := time.Duration(1<<(f.failures-1)) * time.Second
+		f.inputs.logger.Printf("the download of %s broke at %d bytes, resuming it in %v: %v", f.url, f.read, wait, err)
+		time.Sleep(wait)
+	}
+}
+
+// fill opens the file if it isn't, keeps the window of reads in flight and
+// waits for the first one into data
+func (f *sftpFile) fill() error {
+	if f.handle == "" {
+		target, err := url.Parse(f.url)
+		if err != nil {
+			return err
+		}
+		if f.conn, err = f.inputs.sftp.conn(target); err != nil {
+			return err
+		}
+		var payload sftpBuf
+		payload.string(sftpPath(target.Path))
+		payload.uint32(sftpReadFlag)
+		payload.uint32(0) // No attributes
+		r, err := f.conn.request(sftpOpen, payload, sftpHandle)
+		if err != nil {
+			return err
+		}
+		if f.handle = r.string(); r.err != nil {
+			f.handle = ""
+			return r.err
+		}
+		f.next = f.read
+	}
+
+	for len(f.inflight) < sftpWindow {
+		var payload sftpBuf
+		payload.string(f.handle)
+		payload.uint64(uint64(f.next))
+		payload.uint32(sftpChunk)
+		ch, err := f.conn.start(sftpRead, payload)
+		if err != nil {
+			return err
+		}
+		f.inflight = append(f.inflight, sftpPendingRead{f.next, ch})
+		f.next += sftpChunk
+	}
+
+	pending := f.inflight[0]
+	f.inflight = f.inflight[1:]
+	kind, r, err := f.conn.wait(pending.ch)
+	if err != nil {
+		return err
+	}
+	if kind != sftpData {
+		return fmt.Errorf("unexpected packet %d instead of data", kind)
+	}
+	if f.data = []byte(r.string()); r.err != nil {
+		return r.err
+	}
+	if len(f.data) < sftpChunk {
+		// A short read, the ones after it are asked again from its end
+		f.inflight = nil
+		f.next = pending.offset + int64(len(f.data))
+	}
+	return nil
+}
+
+func (f *sftpFile) Stat() (fs.FileInfo, error) { return f.inputs.Stat(f.name) }
+
+func (f *sftpFile) Close() error {
+	if f.handle == "" || f.conn.broken() != nil {
+		return nil
+	}
+	return f.conn.closeHandle(f.handle)
+}
@@ -0,0 +1,114 @@
+package catzip
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+)
+
+// Options.Signature values, similarity signatures computed over the lines of
+// each entry so near-duplicates can be clustered without reading them again.
+// Digits are folded together, so timestamps and counters alone don't make
+// lines differ.
+const (
+	// 64 bits, near-duplicates differ in few bits
+	SignatureSimHash = "simhash"
+	// 64 minimums, the fraction of equal ones estimates the Jaccard
+	// similarity of the sets of lines
+	SignatureMinHash = "minhash"
+)
+
+const (
+	fnvOffset64  = 14695981039346656037
+	fnvPrime64   = 1099511628211
+	minHashCount = 64
+)
+
+// signer computes a signature from the lines written to it
+type signer struct {
+	kind    string
+	line    uint64 // FNV-1a of the current line
+	pending bool   // The current line has content
+	weights [64]int
+	mins    []uint64
+}
+
+func newSigner(kind string) *signer {
+	s := &signer{kind: kind, line: fnvOffset64}
+	if kind == SignatureMinHash {
+		s.mins = make([]uint64, minHashCount)
+		for i := range s.mins {
+			s.mins[i] = math.MaxUint64
+		}
+	}
+	return s
+}
+
+func (s *signer) Write(p []byte) (int, error) {
+	for _, c := range p {
+		switch {
+		case c == '\n':
+			s.endLine()
+			continue
+		case c >= '0' && c <= '9':
+			c = '0'
+		}
+		s.line = (s.line ^ uint64(c)) * fnvPrime64
+		s.pending = true
+	}
+	return len(p), nil
+}
+
+func (s *signer) endLine() {
+	if s.pending {
+		s.addFeature(s.line)
+	}
+	s.line, s.pending = fnvOffset64, false
+}
+
+func (s *signer) addFeature(h uint64) {
+	if s.kind == SignatureMinHash {
+		for i := range s.mins {
+			if v := splitmix64(h ^ uint64(i)*0x9e3779b97f4a7c15); v < s.mins[i] {
+				s.mins[i] = v
+			}
+		}
+		return
+	}
+	for i := range s.weights {
+		if h&(1<<i) != 0 {
+			s.weights[i]++
+		} else {
+			s.weights[i]--
+		}
+	}
+}
+
+// Sum returns the signature in hex, the last line doesn't need a newline
+func (s *signer) Sum() string {
+	s.endLine()
+	if s.kind == SignatureMinHash {
+		sum := make([]byte, 8*len(s.mins))
+		for i, m := range s.mins {
+			binary.BigEndian.PutUint64(sum[8*i:], m)
+		}
+		return hex.EncodeToString(sum)
+	}
+
+	var bits uint64
+	for i, w := range s.weights {
+		if w > 0 {
+			bits |= 1 << i
+		}
+	}
+	sum := make([]byte, 8)
+	binary.BigEndian.PutUint64(sum, bits)
+	return hex.EncodeToString(sum)
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,81 @@
+package catzip
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The payload of the GET requests isn't hashed
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// signV4 signs req for service in region with the access keys of creds, as
+// AWS Signature Version 4. The path and the query of req are rewritten in
+// the encoding they are signed with.
+func signV4(req *http.Request, creds Credentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	headers := []string{"host:" + req.URL.Host, "x-amz-content-sha256:" + unsignedPayload, "x-amz-date:" + amzDate}
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+		headers = append(headers, "x-amz-security-token:"+creds.SessionToken)
+		signedHeaders += ";x-amz-security-token"
+	}
+
+	req.URL.RawPath = uriEncode(req.URL.Path, false)
+	var query []string
+	for key, values := range req.URL.Query() {
+		for _, value := range values {
+			query = append(query, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(query)
+	req.URL.RawQuery = strings.Join(query, "&")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.RawPath,
+		req.URL.RawQuery,
+		strings.Join(headers, "\n") + "\n",
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		creds.AccessKeyID, scope, signedHeaders, key))
+}
+
+// uriEncode percent encodes everything but the unreserved characters of
+// RFC 3986, and the slashes of paths
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,316 @@
+package catzip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Sink receives a copy of what a run produces, on top of the extracted
+// files and the cat file
+type Sink interface {
+	// Cat receives the content of the cat file, in order, as it is appended
+	Cat(p []byte) error
+	// Entry receives each entry once it is written or skipped
+	Entry(entry EntryInfo) error
+	// Close is called at the end of a successful run
+	Close() error
+}
+
+// SinkPolicy is what a sink failing does to the run
+type SinkPolicy string
+
+const (
+	// The run fails with the sink
+	SinkRequired SinkPolicy = "required"
+	// The sink is dropped for the rest of the run, the error is reported in
+	// Summary.SinkErrors
+	SinkBestEffort SinkPolicy = "best-effort"
+)
+
+// AttachedSink is a sink with its failure policy
+type AttachedSink struct {
+	Name   string
+	Sink   Sink
+	Policy SinkPolicy
+	// Run once the sink is closed, {} is replaced with Path. Failing is
+	// failing the sink.
+	PostCommand []string
+	// File written by the sink, if any
+	Path string
+}
+
+// ParseSink parses policy:what:kind:target, what is cat or events and kind
+// is file or cmd. A cmd target is split on spaces and gets the data on stdin.
+// A post-command can follow a |.
+//
+//	required:cat:file:/backup/blob | gzip -f {}
+//	best-effort:events:cmd:kcat -P -b broker:9092 -t entries
+//	required:cat:cmd:aws s3 cp - s3://bucket/blob
+func ParseSink(spec string) (AttachedSink, error) {
+	parts, post, err := splitSink(spec)
+	if err != nil {
+		return AttachedSink{}, err
+	}
+	policy, what, kind, target := SinkPolicy(parts[0]), parts[1], parts[2], parts[3]
+	if policy != SinkRequired && policy != SinkBestEffort {
+		return AttachedSink{}, fmt.Errorf("invalid sink policy %q, expected %s or %s", policy, SinkRequired, SinkBestEffort)
+	}
+
+	var w io.WriteCloser
+	var path string
+	switch kind {
+	case "file":
+		path = target
+		w, err = os.Create(target)
+	case "cmd":
+		w, err = startCommandWriter(strings.Fields(target))
+	default:
+		return AttachedSink{}, fmt.Errorf("invalid sink kind %q, expected file or cmd", kind)
+	}
+	if err != nil {
+		return AttachedSink{}, err
+	}
+
+	var sink Sink
+	switch what {
+	case "cat":
+		sink = &CatSink{W: w}
+	case "events":
+		sink = &EventSink{W: w}
+	default:
+		w.Close()
+		return AttachedSink{}, fmt.Errorf("invalid sink output %q, expected cat or events", what)
+	}
+	return AttachedSink{Name: what + " to " + target, Sink: sink, Policy: policy, PostCommand: post, Path: path}, nil
+}
+
+// SinkFile returns the file a sink spec writes to, empty for a cmd sink or
+// an invalid spec. Nothing is created.
+func SinkFile(spec string) string {
+	parts, _, err := splitSink(spec)
+	if err != nil || parts[2] != "file" {
+		return ""
+	}
+	return parts[3]
+}
+
+// splitSink splits spec into policy, what, kind and target, and the
+// post-command
+func splitSink(spec string) ([]string, []string, error) {
+	var post []string
+	if i := strings.Index(spec, "|"); i >= 0 {
+		post = strings.Fields(spec[i+1:])
+		if len(post) == 0 {
+			return nil, nil, fmt.Errorf("invalid sink %q, empty post-command", spec)
+		}
+		spec = strings.TrimSpace(spec[:i])
+	}
+	parts := strings.SplitN(spec, ":", 4)
+	if len(parts) != 4 {
+		return nil, nil, fmt.Errorf("invalid sink %q, expected policy:what:kind:target", spec)
+	}
+	return parts, post, nil
+}
+
+// CatSink copies the cat file to W
+type CatSink struct{ W io.WriteCloser }
+
+func (s *CatSink) Cat(p []byte) error {
+	_, err := s.W.Write(p)
+	return err
+}
+func (s *CatSink) Entry(EntryInfo) error { return nil }
+func (s *CatSink) Close() error          { return s.W.Close() }
+func (s *CatSink) Abort()                { abortWriter(s.W) }
+
+// EventSink writes each entry to W as a JSON line
+type EventSink struct{ W io.WriteCloser }
+
+func (s *EventSink) Cat([]byte) error { return nil }
+func (s *EventSink) Entry(entry EntryInfo) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.W.Write(append(line, '\n'))
+	return err
+}
+func (s *EventSink) Close() error { return s.W.Close() }
+func (s *EventSink) Abort()       { abortWriter(s.W) }
+
+// aborter is implemented by the sinks and writers that can tell a failed run
+// apart from a finished one, so a partial upload isn't committed
+type aborter interface{ Abort() }
+
+func abortWriter(w io.WriteCloser) {
+	if a, ok := w.(aborter); ok {
+		a.Abort()
+		return
+	}
+	w.Close()
+}
+
+// commandWriter writes to the stdin of a command, closing it waits for the
+// command to succeed
+type commandWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func startCommandWriter(args []string) (*commandWriter, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandWriter{WriteCloser: stdin, cmd: cmd}, nil
+}
+
+// Abort kills the command instead of letting it finish with partial data
+func (c *commandWriter) Abort() {
+	c.cmd.Process.Kill()
+	c.WriteCloser.Close()
+	c.cmd.Wait()
+}
+
+func (c *commandWriter) Close() error {
+	c.WriteCloser.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %w", c.cmd.Path, err)
+	}
+	return nil
+}
+
+// runPostCommand runs args with {} replaced with path
+func runPostCommand(args []string, path string) error {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = strings.ReplaceAll(arg, "{}", path)
+	}
+	cmd := exec.Command(expanded[0], expanded[1:]...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("post-command %s: %w", expanded[0], err)
+	}
+	return nil
+}
+
+// sinks fans the outputs out to the attached sinks, applying their policies
+type sinks struct {
+	mu       sync.Mutex
+	attached []AttachedSink
+	errors   map[string]error // Of the dropped best-effort sinks
+	logger   *log.Logger
+}
+
+func newSinks(attached []AttachedSink, logger *log.Logger) *sinks {
+	if len(attached) == 0 {
+		return nil
+	}
+	return &sinks{attached: attached, errors: map[string]error{}, logger: logger}
+}
+
+// call runs f on every sink still attached, it only returns the errors of
+// required sinks
+func (s *sinks) call(f func(Sink) error) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.attached[:0]
+	var required error
+	for _, a := range s.attached {
+		err := f(a.Sink)
+		switch {
+		case err == nil:
+		case a.Policy == SinkRequired:
+			if required == nil {
+				required = fmt.Errorf("sink %s: %w", a.Name, err)
+			}
+		default:
+			s.logger.Printf("dropping sink %s: %v", a.Name, err)
+			s.errors[a.Name] = err
+			abortSink(a.Sink)
+			continue
+		}
+		kept = append(kept, a)
+	}
+	s.attached = kept
+	return required
+}
+
+// Write makes sinks an io.Writer for the cat file
+func (s *sinks) Write(p []byte) (int, error) {
+	if err := s.call(func(sink Sink) error { return sink.Cat(p) }); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// close closes the sinks still attached, it only returns the errors of
+// required sinks
+func (s *sinks) close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var required error
+	for _, a := range s.attached {
+		err := a.Sink.Close()
+		if err == nil && len(a.PostCommand) > 0 {
+			err = runPostCommand(a.PostCommand, a.Path)
+		}
+		switch {
+		case err == nil:
+		case a.Policy == SinkRequired:
+			if required == nil {
+				required = fmt.Errorf("sink %s: %w", a.Name, err)
+			}
+		default:
+			s.logger.Printf("sink %s failed: %v", a.Name, err)
+			s.errors[a.Name] = err
+		}
+	}
+	s.attached = nil
+	return required
+}
+
+// abort stops the sinks still attached after the run failed
+func (s *sinks) abort() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.attached {
+		abortSink(a.Sink)
+	}
+	s.attached = nil
+}
+
+func abortSink(sink Sink) {
+	if a, ok := sink.(aborter); ok {
+		a.Abort()
+		return
+	}
+	sink.Close()
+}
@@ -0,0 +1,30 @@
+package catzip
+
+import (
+	"archive/zip"
+	"fmt"
+)
+
+// skipEntry leaves entry out of the outputs
+func (r *run) skipEntry(entry *EntryInfo, reason string) {
+	entry.Status = EntrySkipped
+	entry.Reason = reason
+	r.logger.Print(LogFields("event", "skipped", "archive", entry.Archive, "name", entry.Name, "reason", reason))
+	r.entryDone(entry)
+}
+
+// unsupportedReason tells why f can't be extracted, or "" when it can
+func unsupportedReason(f *zip.File) string {
+	if f.Flags&0x1 != 0 {
+		return "encrypted"
+	}
+
+	switch f.Method {
+	case zip.Store, zip.Deflate, zipMethodBzip2, zipMethodLZMA, zipMethodXz:
+		return ""
+	case zipMethodZstd:
+		return missingMethodCommand(f.Method)
+	default:
+		return fmt.Sprintf("unsupported compression method %d", f.Method)
+	}
+}
@@ -0,0 +1,201 @@
+package catzip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// A decoder for the snappy framing format, the .sz files of the snappy
+// tools, following the snappy framing and block format descriptions.
+// Concatenated streams are read through.
+
+const (
+	snappyChunkCompressed   = 0x00
+	snappyChunkUncompressed = 0x01
+	snappyChunkPadding      = 0xFE
+	snappyChunkStream       = 0xFF
+
+	// Uncompressed data of a chunk
+	snappyMaxBlock = 64 << 10
+)
+
+var (
+	errSnappyCorrupt = errors.New("snappy: corrupt stream")
+	snappyMagic      = []byte("sNaPpY")
+	snappyCRCTable   = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type snappyReader struct {
+	r       io.Reader
+	started bool
+	err     error
+
+	buf []byte // Chunk
+	out []byte // Decoded chunk
+	pos int    // Next byte of out to read
+}
+
+func newSnappyReader(r io.Reader) io.ReadCloser {
+	return &snappyReader{r: r}
+}
+
+func (z *snappyReader) Read(p []byte) (int, error) {
+	for z.pos == len(z.out) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.next()
+	}
+	n := copy(p, z.out[z.pos:])
+	z.pos += n
+	return n, nil
+}
+
+func (z *snappyReader) Close() error { return nil }
+
+// next reads the next chunk, decoding it when it has data
+func (z *snappyReader) next() error {
+	var header [4]byte
+	n, err := io.ReadFull(z.r, header[:])
+	if n == 0 && err == io.EOF && z.started {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("snappy: %w", unexpectedEOF(err))
+	}
+	chunkType := header[0]
+	size := int(header[1]) | int(header[2])<<8 | int(header[3])<<16
+	if !z.started && chunkType != snappyChunkStream {
+		return errors.New("snappy: not a snappy framed stream")
+	}
+	if cap(z.buf) < size {
+		z.buf = make([]byte, size)
+	}
+	z.buf = z.buf[:size]
+	if _, err := io.ReadFull(z.r, z.buf); err != nil {
+		return fmt.Errorf("snappy: %w", unexpectedEOF(err))
+	}
+	z.out, z.pos = z.out[:0], 0
+
+	switch {
+	case chunkType == snappyChunkStream:
+		// Also starts each of the concatenated streams
+		if string(z.buf) != string(snappyMagic) {
+			return errors.New("snappy: not a snappy framed stream")
+		}
+		z.started = true
+		return nil
+	case chunkType == snappyChunkCompressed, chunkType == snappyChunkUncompressed:
+		if size < 4 {
+			return errSnappyCorrupt
+		}
+		checksum := binary.LittleEndian.Uint32(z.buf)
+		data := z.buf[4:]
+		if chunkType == snappyChunkUncompressed {
+			if len(data) > snappyMaxBlock {
+				return errSnappyCorrupt
+			}
+			z.out = append(z.out, data...)
+		} else {
+			var err error
+			if z.out, err = snappyDecodeBlock(z.out, data); err != nil {
+				return err
+			}
+		}
+		if snappyMaskedCRC(z.out) != checksum {
+			return errors.New("snappy: checksum error")
+		}
+		return nil
+	case chunkType == snappyChunkPadding, chunkType >= 0x80:
+		// Padding and skippable chunks
+		return nil
+	}
+	return fmt.Errorf("snappy: unsupported chunk type %#x", chunkType)
+}
+
+// snappyMaskedCRC is the CRC-32C of the chunks, rotated and offset so the
+// CRC of data holding CRCs isn't degenerate
+func snappyMaskedCRC(b []byte) uint32 {
+	c := crc32.Checksum(b, snappyCRCTable)
+	return (c>>15 | c<<17) + 0xA282EAD8
+}
+
+// snappyDecodeBlock appends the decoded snappy block src to dst, which is
+// empty so the copies can't reach before the block
+func snappyDecodeBlock(dst, src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 || length > snappyMaxBlock {
+		return nil, errSnappyCorrupt
+	}
+	limit := len(dst) + int(length)
+	start := len(dst)
+	for i := n; i < len(src); {
+		tag := src[i]
+		i++
+
+		var offset, size int
+		switch tag & 0x03 {
+		case 0x00:
+			// Literal, the longer lengths follow the tag
+			size = int(tag>>2) + 1
+			if extra := size - 60; extra > 0 {
+				if i+extra > len(src) {
+					return nil, errSnappyCorrupt
+				}
+				size = 0
+				for k := extra - 1; k >= 0; k-- {
+					size = size<<8 | int(src[i+k])
+				}
+				size++
+				i += extra
+			}
+			if size < 1 || size > len(src)-i || len(dst)+size > limit {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[i:i+size]...)
+			i += size
+			continue
+		case 0x01:
+			if i+1 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			size = int(tag>>2&0x07) + 4
+			offset = int(tag&0xE0)<<3 | int(src[i])
+			i++
+		case 0x02:
+			if i+2 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint16(src[i:]))
+			i += 2
+		case 0x03:
+			if i+4 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint32(src[i:]))
+			i += 4
+		}
+
+		if offset <= 0 || offset > len(dst)-start || len(dst)+size > limit {
+			return nil, errSnappyCorrupt
+		}
+		from := len(dst) - offset
+		if offset >= size {
+			dst = append(dst, dst[from:from+size]...)
+		} else {
+			// Overlapping, it repeats the last offset bytes
+			for k := 0; k < size; k++ {
+				dst = append(dst, dst[from+k])
+			}
+		}
+	}
+	if len(dst) != limit {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,150 @@
+package catzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// The vectors under testdata are from the snappy framing writers of
+// github.com/klauspost/compress: lines.sz from snappy.NewBufferedWriter,
+// two chunks, and mixed.sz from s2.NewWriter with WriterSnappyCompat and
+// WriterBestCompression.
+
+func snappyLines() []byte {
+	return append(testLines(40000), bytes.Repeat([]byte("b"), 40000)...)
+}
+
+func decodeSnappy(data []byte, limit int64) ([]byte, error) {
+	return readLimited(newSnappyReader(bytes.NewReader(data)), limit)
+}
+
+// snappyTestChunk is the content of a chunk, compressed as block when it
+// isn't nil
+type snappyTestChunk struct{ content, block []byte }
+
+// snappyFrame encodes chunks as a snappy framed stream
+func snappyFrame(chunks ...snappyTestChunk) []byte {
+	stream := append([]byte{snappyChunkStream, 6, 0, 0}, snappyMagic...)
+	for _, c := range chunks {
+		chunkType, data := byte(snappyChunkUncompressed), c.content
+		if c.block != nil {
+			chunkType, data = snappyChunkCompressed, c.block
+		}
+		size := len(data) + 4
+		stream = append(stream, chunkType, byte(size), byte(size>>8), byte(size>>16))
+		stream = binary.LittleEndian.AppendUint32(stream, snappyMaskedCRC(c.content))
+		stream = append(stream, data...)
+	}
+	return stream
+}
+
+// snappyChunkBoundaries returns the offsets between the chunks of stream
+func snappyChunkBoundaries(stream []byte) map[int]bool {
+	boundaries := map[int]bool{}
+	for offset := 0; offset+4 <= len(stream); {
+		offset += 4 + (int(stream[offset+1]) | int(stream[offset+2])<<8 | int(stream[offset+3])<<16)
+		boundaries[offset] = true
+	}
+	return boundaries
+}
+
+func TestSnappy(t *testing.T) {
+	lines, mixed := readTestdata(t, "lines.sz"), readTestdata(t, "mixed.sz")
+	for _, v := range []struct {
+		name    string
+		data    []byte
+		content []byte
+	}{
+		{"lines.sz", lines, snappyLines()},
+		{"mixed.sz", mixed, lz4Mixed()},
+		{"concatenated", append(append([]byte{}, lines...), mixed...), append(snappyLines(), lz4Mixed()...)},
+	} {
+		out, err := decodeSnappy(v.data, int64(len(v.content)))
+		if err != nil {
+			t.Errorf("%s: %v", v.name, err)
+		} else if !bytes.Equal(out, v.content) {
+			t.Errorf("%s: decoded %d bytes, not the content", v.name, len(out))
+		}
+
+		out, err = io.ReadAll(iotest.OneByteReader(newSnappyReader(iotest.OneByteReader(bytes.NewReader(v.data)))))
+		if err != nil || !bytes.Equal(out, v.content) {
+			t.Errorf("%s byte by byte: %d bytes, %v", v.name, len(out), err)
+		}
+	}
+}
+
+// A block with a long literal then a copy of each kind, the 2-byte one
+// overlapping what it copies
+func TestSnappyBlock(t *testing.T) {
+	literal := make([]byte, 300)
+	rand.New(rand.NewSource(4)).Read(literal)
+	block := binary.AppendUvarint(nil, 395)
+	block = append(block, 61<<2, 43, 1) // 300 bytes
+	block = append(block, literal...)
+	block = append(block, 0x01|7<<2|1<<5, 44)       // Offset 300, 11 bytes
+	block = append(block, 0x02|63<<2, 1, 0)         // Offset 1, 64 bytes
+	block = append(block, 0x03|19<<2, 119, 1, 0, 0) // Offset 375, 20 bytes
+	content := append(append([]byte{}, literal...), literal[:11]...)
+	content = append(content, bytes.Repeat(literal[10:11], 64)...)
+	content = append(content, literal[:20]...)
+
+	out, err := snappyDecodeBlock(nil, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, content) {
+		t.Errorf("decoded %x", out)
+	}
+
+	// The same in a stream, between uncompressed chunks
+	random := make([]byte, 70000)
+	rand.New(rand.NewSource(5)).Read(random)
+	stream := snappyFrame(
+		snappyTestChunk{random[:snappyMaxBlock], nil},
+		snappyTestChunk{content, block},
+		snappyTestChunk{random[snappyMaxBlock:], nil},
+	)
+	out, err = decodeSnappy(stream, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(append(append([]byte{}, random[:snappyMaxBlock]...), content...), random[snappyMaxBlock:]...)
+	if !bytes.Equal(out, expected) {
+		t.Errorf("stream decoded to %d bytes, not the content", len(out))
+	}
+
+	// Copies from before the block, or past its length
+	for _, bad := range [][]byte{
+		{4, 0x01, 8},                      // Offset 8 of nothing
+		{3, 0x04, 'a', 'b'},               // 2 bytes of literal where it says 3
+		{3, 0x00, 'a', 0x01 | 1<<2, 1},    // 5 bytes copied where it says 3
+		{0xFF, 0xFF, 0xFF, 0xFF, 0x0F, 0}, // Longer than a chunk
+	} {
+		if _, err := snappyDecodeBlock(nil, bad); err == nil {
+			t.Errorf("%x: no error", bad)
+		}
+	}
+}
+
+// errSnappyShorter stands for a stream cut between its chunks, which reads
+// as a shorter one: the format has no end marker
+var errSnappyShorter = errors.New("cut between chunks")
+
+func TestSnappyCorrupt(t *testing.T) {
+	for _, name := range []string{"lines.sz", "mixed.sz"} {
+		data := readTestdata(t, name)
+		boundaries := snappyChunkBoundaries(data)
+		checkCorrupt(t, data, func(cut []byte) error {
+			_, err := decodeSnappy(cut, 80000)
+			if err == nil && boundaries[len(cut)] {
+				return errSnappyShorter
+			}
+			return err
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package catzip
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Options.SortMemory when it is 0
+const defaultSortMemory = 64 << 20
+
+// Bytes counted for each record held in memory on top of its own
+const sortRecordOverhead = 64
+
+// sortCat sorts the CSV records of the complete cat file by the column
+// Options.SortKey. Runs of records up to Options.SortMemory bytes are sorted
+// in memory and spilled to Options.SortTempDir, then merged into a new cat
+// file replacing it. Equal keys keep their order.
+func (r *run) sortCat() error {
+	catPath := filepath.Join(r.opts.OutDir, r.opts.CatFileName)
+	in, err := r.fs.Open(catPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	memory := r.opts.SortMemory
+	if memory == 0 {
+		memory = defaultSortMemory
+	}
+	s := &csvSorter{column: r.opts.SortKey - 1, numeric: r.opts.SortNumeric, fs: r.fs}
+	var runs []string
+
+	// Through r.fs like the other files of the run, which has no MkdirTemp
+	tempDir := r.opts.SortTempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	tempDir = filepath.Join(tempDir, fmt.Sprintf("catzip-sort-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	if err = r.fs.MkdirAll(tempDir, 0700); err != nil {
+		return err
+	}
+	defer func() {
+		for _, run := range runs {
+			r.fs.Remove(run)
+		}
+		r.fs.Remove(tempDir)
+	}()
+
+	records := &csvRecords{r: bufio.NewReaderSize(in, 1<<20)}
+	var batch []*sortRecord
+	var size int64
+	for {
+		record, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimRight(record, "\r\n")) == 0 {
+			// The newlines between entries ending with one
+			continue
+		}
+		batch = append(batch, s.record(record))
+		size += int64(len(record)) + sortRecordOverhead
+		if size >= memory {
+			run, err := s.spill(tempDir, batch)
+			if run != "" {
+				runs = append(runs, run)
+			}
+			if err != nil {
+				return err
+			}
+			batch, size = nil, 0
+		}
+	}
+	if len(runs) > 0 && len(batch) > 0 {
+		run, err := s.spill(tempDir, batch)
+		if run != "" {
+			runs = append(runs, run)
+		}
+		if err != nil {
+			return err
+		}
+		batch = nil
+	}
+
+	sortedPath := catPath + ".sorting"
+	out, err := r.fs.OpenFile(sortedPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(out, 1<<20)
+	if len(runs) == 0 {
+		s.sort(batch)
+		for _, record := range batch {
+			if _, err = w.Write(record.line); err != nil {
+				break
+			}
+		}
+	} else {
+		err = s.merge(w, runs)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = r.audit(AuditCreate, sortedPath, "", nil)
+	}
+	if err != nil {
+		r.fs.Remove(sortedPath)
+		return err
+	}
+
+	in.Close()
+	if err = r.fs.Rename(sortedPath, catPath); err != nil {
+		return err
+	}
+	return r.audit(AuditRename, sortedPath, catPath, nil)
+}
+
+// csvSorter orders CSV records by one of their columns
+type csvSorter struct {
+	column  int // From 0
+	numeric bool
+	fs      WriteFS // Of the sorted runs
+	spilled int
+}
+
+type sortRecord struct {
+	line     []byte // With its newline
+	key      []byte
+	number   float64
+	isNumber bool
+}
+
+func (s *csvSorter) record(line []byte) *sortRecord {
+	record := &sortRecord{line: line, key: csvField(line, s.column)}
+	if s.numeric {
+		number, err := strconv.ParseFloat(string(bytes.TrimSpace(record.key)), 64)
+		record.number, record.isNumber = number, err == nil && !math.IsNaN(number)
+	}
+	return record
+}
+
+// less orders the keys as bytes, or as numbers after the keys that aren't
+// numbers, like the header rows
+func (s *csvSorter) less(a, b *sortRecord) bool {
+	if s.numeric {
+		if a.isNumber != b.isNumber {
+			return !a.isNumber
+		}
+		if a.isNumber {
+			return a.number < b.number
+		}
+	}
+	return bytes.Compare(a.key, b.key) < 0
+}
+
+func (s *csvSorter) sort(records []*sortRecord) {
+	sort.SliceStable(records, func(i, j int) bool { return s.less(records[i], records[j]) })
+}
+
+// spill writes records sorted to a new file of dir, returning its path, set
+// when the file was created even if writing it failed
+func (s *csvSorter) spill(dir string, records []*sortRecord) (string, error) {
+	s.sort(records)
+	s.spilled++
+	name := filepath.Join(dir, "run-"+strconv.Itoa(s.spilled))
+	f, err := s.fs.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	for _, record := range records {
+		if _, err = w.Write(record.line); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return name, err
+}
+
+// merge writes the records of the sorted runs to w in order
+func (s *csvSorter) merge(w io.Writer, runs []string) error {
+	h := &sortRunHeap{s: s}
+	defer func() {
+		for _, run := range h.all {
+			run.file.Close()
+		}
+	}()
+	for i, path := range runs {
+		f, err := s.fs.Open(path)
+		if err != nil {
+			return err
+		}
+		run := &sortRun{file: f, records: &csvRecords{r: bufio.NewReader(f)}, index: i}
+		h.all = append(h.all, run)
+		if err = run.next(s); err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		h.runs = append(h.runs, run)
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		run := h.runs[0]
+		if _, err := w.Write(run.head.line); err != nil {
+			return err
+		}
+		if err := run.next(s); err == io.EOF {
+			heap.Pop(h)
+		} else if err != nil {
+			return err
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+	return nil
+}
+
+type sortRun struct {
+	file    fs.File
+	records *csvRecords
+	head    *sortRecord
+	index   int // Runs are in input order, so equal keys keep it
+}
+
+func (run *sortRun) next(s *csvSorter) error {
+	line, err := run.records.next()
+	if err != nil {
+		return err
+	}
+	run.head = s.record(line)
+	return nil
+}
+
+// sortRunHeap holds the runs with records left, by their next record
+type sortRunHeap struct {
+	s    *csvSorter
+	runs []*sortRun
+	all  []*sortRun
+}
+
+func (h *sortRunHeap) Len() int { return len(h.runs) }
+
+func (h *sortRunHeap) Less(i, j int) bool {
+	a, b := h.runs[i], h.runs[j]
+	if h.s.less(a.head, b.head) {
+		return true
+	}
+	return !h.s.less(b.head, a.head) && a.index < b.index
+}
+
+func (h *sortRunHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *sortRunHeap) Push(x any)    { h.runs = append(h.runs, x.(*sortRun)) }
+
+func (h *sortRunHeap) Pop() any {
+	run := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return run
+}
+
+// csvRecords reads CSV records with their newline, a record goes on past
+// the end of a line inside quotes
+type csvRecords struct {
+	r *bufio.Reader
+}
+
+func (c *csvRecords) next() ([]byte, error) {
+	var record []byte
+	quotes := 0
+	for {
+		line, err := c.r.ReadBytes('\n')
+		record = append(record, line...)
+		quotes += bytes.Count(line, []byte{'"'})
+		if err == io.EOF {
+			if len(record) == 0 {
+				return nil, io.EOF
+			}
+			if record[len(record)-1] != '\n' {
+				record = append(record, '\n')
+			}
+			return record, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if quotes%2 == 0 {
+			return record, nil
+		}
+	}
+}
+
+// csvField returns the field n of record without its quotes, nil when it
+// has fewer fields
+func csvField(record []byte, n int) []byte {
+	record = bytes.TrimRight(record, "\r\n")
+	for i := 0; ; i++ {
+		if len(record) > 0 && record[0] == '"' {
+			// "" is a quote inside quotes
+			var field []byte
+			j := 1
+			for ; j < len(record); j++ {
+				if record[j] == '"' {
+					if j+1 < len(record) && record[j+1] == '"' {
+						j++
+					} else {
+						break
+					}
+				}
+				if i == n {
+					field = append(field, record[j])
+				}
+			}
+			if i == n {
+				if field == nil {
+					field = []byte{}
+				}
+				return field
+			}
+			if j < len(record) {
+				j++
+			}
+			record = record[j:]
+		} else if i == n {
+			if comma := bytes.IndexByte(record, ','); comma >= 0 {
+				return record[:comma]
+			}
+			return record
+		}
+
+		comma := bytes.IndexByte(record, ',')
+		if comma < 0 {
+			return nil
+		}
+		record = record[comma+1:]
+	}
+}
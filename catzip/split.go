@@ -0,0 +1,152 @@
+package catzip
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// splitWriter writes an entry to entry.Output until it reaches limit bytes,
+// then renames it to its first part and continues in numbered parts, listed
+// in entry.Parts
+type splitWriter struct {
+	r       *run
+	entry   *EntryInfo
+	limit   int64
+	cur     WriteFile
+	written int64 // To cur
+}
+
+func (r *run) newSplitWriter(entry *EntryInfo, f WriteFile) *splitWriter {
+	return &splitWriter{r: r, entry: entry, limit: r.opts.SplitEntrySize, cur: f}
+}
+
+func partName(output string, n int) string {
+	return fmt.Sprintf("%s.part%03d", output, n)
+}
+
+func (w *splitWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.written == w.limit {
+			if err := w.nextPart(); err != nil {
+				return total, err
+			}
+		}
+		n := int64(len(p))
+		if n > w.limit-w.written {
+			n = w.limit - w.written
+		}
+		written, err := w.cur.Write(p[:n])
+		total += written
+		w.written += int64(written)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+func (w *splitWriter) nextPart() error {
+	r, entry := w.r, w.entry
+	if err := w.cur.Close(); err != nil {
+		return err
+	}
+	if len(entry.Parts) == 0 {
+		first := partName(entry.Output, 0)
+		if err := r.fs.Rename(entry.Output, first); err != nil {
+			return err
+		}
+		if err := r.audit(AuditRename, entry.Output, first, entry); err != nil {
+			return err
+		}
+		entry.Parts = []string{first}
+	}
+
+	next := partName(entry.Output, len(entry.Parts))
+	f, err := r.fs.OpenFile(next, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	if err = r.audit(AuditCreate, next, "", entry); err != nil {
+		f.Close()
+		return err
+	}
+	w.r.logger.Printf("%s is larger than %d bytes, continuing in %s", entry.Output, w.limit, next)
+	entry.Parts = append(entry.Parts, next)
+	w.cur, w.written = f, 0
+	return nil
+}
+
+func (w *splitWriter) Close() error {
+	return w.cur.Close()
+}
+
+// outputFiles lists the files entry was written to, its parts when split
+func outputFiles(entry *EntryInfo) []string {
+	if len(entry.Parts) > 0 {
+		return entry.Parts
+	}
+	return []string{entry.Output}
+}
+
+// openOutput reads back what the write stage wrote for entry, in order. A
+// single file is returned as it is, for its ReadFrom and WriteTo.
+func (r *run) openOutput(entry *EntryInfo) (io.Reader, func(), error) {
+	var readers []io.Reader
+	var files []io.Closer
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for _, name := range outputFiles(entry) {
+		f, err := r.fs.Open(name)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		readers = append(readers, f)
+		files = append(files, f)
+	}
+	if len(readers) == 1 {
+		return readers[0], closeAll, nil
+	}
+	return &partsReader{readers}, closeAll, nil
+}
+
+// partsReader reads the parts of a split entry one after the other like
+// io.MultiReader, which has no WriteTo before Go 1.20. WriteTo copies them
+// one at a time, so the kernel can copy the files.
+type partsReader struct {
+	parts []io.Reader
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for len(p.parts) > 0 {
+		n, err := p.parts[0].Read(b)
+		if err == io.EOF {
+			p.parts = p.parts[1:]
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+func (p *partsReader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for len(p.parts) > 0 {
+		n, err := io.Copy(w, p.parts[0])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p.parts = p.parts[1:]
+	}
+	return written, nil
+}
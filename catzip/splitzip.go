@@ -0,0 +1,350 @@
+package catzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Split zip files, made by zip -s or WinZip, are x.z01, x.z02 and on with
+// x.zip the last part, holding the central directory. The offsets of the
+// entries are from the start of the part they begin in, which archive/zip
+// doesn't know about, so the parts are read as one file followed by the
+// central directory rewritten with offsets into it.
+
+const (
+	zipDirectoryEndSignature   = 0x06054b50
+	zip64DirectoryEndSignature = 0x06064b50
+	zip64LocatorSignature      = 0x07064b50
+	zipDirectorySignature      = 0x02014b50
+	zipDirectoryEndLen         = 22
+	zip64DirectoryEndLen       = 56
+	zip64LocatorLen            = 20
+	zipDirectoryHeaderLen      = 46
+	zip64ExtraID               = 0x0001
+)
+
+var errSplitZipCorrupt = errors.New("corrupt split zip file")
+
+// splitZipPart is the name of the part disk of the split zip file name, from 1
+func splitZipPart(name string, disk int) string {
+	return name[:len(name)-len(path.Ext(name))] + fmt.Sprintf(".z%02d", disk)
+}
+
+// isSplitZip tells whether the zip file name is the last part of a split one
+func isSplitZip(fsys fs.FS, name string) bool {
+	_, err := fs.Stat(fsys, splitZipPart(name, 1))
+	return err == nil
+}
+
+// splitZipEnd is what the end of the central directory tells about the parts
+type splitZipEnd struct {
+	disks     int // Parts
+	directory struct{ disk, offset, size int64 }
+	// Position of the zip64 end record, disk -1 without one
+	zip64   struct{ disk, offset int64 }
+	comment []byte
+}
+
+func openSplitZip(fsys fs.FS, name string) (*zip.Reader, io.Closer, error) {
+	last, lastSize, lastCloser, err := openReaderAt(fsys, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := closers{lastCloser}
+	fail := func(err error) (*zip.Reader, io.Closer, error) {
+		c.Close()
+		return nil, nil, err
+	}
+	end, err := readSplitZipEnd(last, lastSize)
+	if err != nil {
+		return fail(err)
+	}
+
+	parts := &multiReaderAt{}
+	for disk := 1; disk < end.disks; disk++ {
+		part, size, closer, err := openReaderAt(fsys, splitZipPart(name, disk))
+		if err != nil {
+			return fail(fmt.Errorf("part %d of %d of the split zip file: %w", disk, end.disks, err))
+		}
+		c = append(c, closer)
+		parts.add(part, size)
+	}
+	parts.add(last, lastSize)
+
+	if end.zip64.disk >= 0 {
+		if err := end.readZip64(parts); err != nil {
+			return fail(err)
+		}
+	}
+	directory, err := rewriteSplitDirectory(parts, end)
+	if err != nil {
+		return fail(err)
+	}
+	parts.add(bytes.NewReader(directory), int64(len(directory)))
+
+	reader, err := zip.NewReader(parts, parts.size)
+	if err != nil {
+		return fail(err)
+	}
+	registerDecompressors(reader)
+	return reader, c, nil
+}
+
+// readSplitZipEnd reads the end of central directory record of the last part
+func readSplitZipEnd(last io.ReaderAt, size int64) (*splitZipEnd, error) {
+	tail := int64(zipDirectoryEndLen + 0xffff)
+	if tail > size {
+		tail = size
+	}
+	buf := make([]byte, tail)
+	if _, err := last.ReadAt(buf, size-tail); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	i := len(buf) - zipDirectoryEndLen
+	for ; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) == zipDirectoryEndSignature {
+			break
+		}
+	}
+	if i < 0 {
+		return nil, errors.New("not a zip file, no end of central directory")
+	}
+	b := buf[i:]
+	end := &splitZipEnd{disks: int(binary.LittleEndian.Uint16(b[4:])) + 1}
+	end.directory.disk = int64(binary.LittleEndian.Uint16(b[6:]))
+	end.directory.size = int64(binary.LittleEndian.Uint32(b[12:]))
+	end.directory.offset = int64(binary.LittleEndian.Uint32(b[16:]))
+	if n := int(binary.LittleEndian.Uint16(b[20:])); zipDirectoryEndLen+n <= len(b) {
+		end.comment = b[zipDirectoryEndLen : zipDirectoryEndLen+n]
+	}
+	end.zip64.disk = -1
+
+	// The zip64 locator, right before, gives the number of parts
+	if i >= zip64LocatorLen && binary.LittleEndian.Uint32(buf[i-zip64LocatorLen:]) == zip64LocatorSignature {
+		l := buf[i-zip64LocatorLen:]
+		end.zip64.disk = int64(binary.LittleEndian.Uint32(l[4:]))
+		end.zip64.offset = int64(binary.LittleEndian.Uint64(l[8:]))
+		end.disks = int(binary.LittleEndian.Uint32(l[16:]))
+	}
+	if end.disks < 1 || end.disks > 0xffff {
+		return nil, errSplitZipCorrupt
+	}
+	return end, nil
+}
+
+// readZip64 reads the directory from the zip64 end record, once the parts are
+// known
+func (end *splitZipEnd) readZip64(parts *multiReaderAt) error {
+	b := make([]byte, zip64DirectoryEndLen)
+	offset, ok := parts.offset(end.zip64.disk, end.zip64.offset)
+	if !ok {
+		return errSplitZipCorrupt
+	}
+	if _, err := parts.ReadAt(b, offset); err != nil {
+		return unexpectedEOF(err)
+	}
+	if binary.LittleEndian.Uint32(b) != zip64DirectoryEndSignature {
+		return errSplitZipCorrupt
+	}
+	end.directory.disk = int64(binary.LittleEndian.Uint32(b[20:]))
+	end.directory.size = int64(binary.LittleEndian.Uint64(b[40:]))
+	end.directory.offset = int64(binary.LittleEndian.Uint64(b[48:]))
+	return nil
+}
+
+// rewriteSplitDirectory returns the central directory of parts, its entries
+// on disk 0 at their offsets in the joined parts, followed by its zip64 end
+// records and its end record
+func rewriteSplitDirectory(parts *multiReaderAt, end *splitZipEnd) ([]byte, error) {
+	start, ok := parts.offset(end.directory.disk, end.directory.offset)
+	if !ok || end.directory.size < 0 || end.directory.size > parts.size-start {
+		return nil, errSplitZipCorrupt
+	}
+	directory := make([]byte, end.directory.size)
+	if _, err := parts.ReadAt(directory, start); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	out := make([]byte, 0, len(directory)+zip64DirectoryEndLen+zip64LocatorLen+zipDirectoryEndLen+len(end.comment))
+	entries := uint64(0)
+	for b := directory; len(b) > 0; entries++ {
+		if len(b) < zipDirectoryHeaderLen || binary.LittleEndian.Uint32(b) != zipDirectorySignature {
+			return nil, errSplitZipCorrupt
+		}
+		nameLen := int(binary.LittleEndian.Uint16(b[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(b[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(b[32:]))
+		n := zipDirectoryHeaderLen + nameLen + extraLen + commentLen
+		if len(b) < n {
+			return nil, errSplitZipCorrupt
+		}
+		header, name := b[:zipDirectoryHeaderLen], b[zipDirectoryHeaderLen:zipDirectoryHeaderLen+nameLen]
+		extra, comment := b[zipDirectoryHeaderLen+nameLen:n-commentLen], b[n-commentLen:n]
+		b = b[n:]
+
+		fixed, extra, err := rewriteSplitHeader(parts, header, extra)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, fixed...)
+		out = append(out, name...)
+		out = append(out, extra...)
+		out = append(out, comment...)
+	}
+
+	// Always zip64, it has room for any offset and count
+	size := uint64(len(out))
+	offset := uint64(parts.size)
+	zip64End := offset + size
+	out = binary.LittleEndian.AppendUint32(out, zip64DirectoryEndSignature)
+	out = binary.LittleEndian.AppendUint64(out, zip64DirectoryEndLen-12)
+	out = binary.LittleEndian.AppendUint16(out, 45)
+	out = binary.LittleEndian.AppendUint16(out, 45)
+	out = binary.LittleEndian.AppendUint32(out, 0)
+	out = binary.LittleEndian.AppendUint32(out, 0)
+	out = binary.LittleEndian.AppendUint64(out, entries)
+	out = binary.LittleEndian.AppendUint64(out, entries)
+	out = binary.LittleEndian.AppendUint64(out, size)
+	out = binary.LittleEndian.AppendUint64(out, offset)
+
+	out = binary.LittleEndian.AppendUint32(out, zip64LocatorSignature)
+	out = binary.LittleEndian.AppendUint32(out, 0)
+	out = binary.LittleEndian.AppendUint64(out, zip64End)
+	out = binary.LittleEndian.AppendUint32(out, 1)
+
+	out = binary.LittleEndian.AppendUint32(out, zipDirectoryEndSignature)
+	out = binary.LittleEndian.AppendUint16(out, 0)
+	out = binary.LittleEndian.AppendUint16(out, 0)
+	out = binary.LittleEndian.AppendUint16(out, 0xffff)
+	out = binary.LittleEndian.AppendUint16(out, 0xffff)
+	out = binary.LittleEndian.AppendUint32(out, 0xffffffff)
+	out = binary.LittleEndian.AppendUint32(out, 0xffffffff)
+	out = binary.LittleEndian.AppendUint16(out, uint16(len(end.comment)))
+	return append(out, end.comment...), nil
+}
+
+// rewriteSplitHeader moves the central directory header of an entry to disk
+// 0, at its offset in the joined parts. The zip64 extra field holding the
+// sizes, the offset and the disk is rebuilt with what is still needed.
+func rewriteSplitHeader(parts *multiReaderAt, header, extra []byte) ([]byte, []byte, error) {
+	header = append([]byte(nil), header...)
+	csize := uint64(binary.LittleEndian.Uint32(header[20:]))
+	usize := uint64(binary.LittleEndian.Uint32(header[24:]))
+	disk := int64(binary.LittleEndian.Uint16(header[34:]))
+	offset := uint64(binary.LittleEndian.Uint32(header[42:]))
+
+	var others []byte
+	for e := extra; len(e) >= 4; {
+		id, n := binary.LittleEndian.Uint16(e), int(binary.LittleEndian.Uint16(e[2:]))
+		if len(e) < 4+n {
+			return nil, nil, errSplitZipCorrupt
+		}
+		field := e[4 : 4+n]
+		if id == zip64ExtraID {
+			next := func(ok bool, v *uint64) {
+				if ok && len(field) >= 8 {
+					*v = binary.LittleEndian.Uint64(field)
+					field = field[8:]
+				}
+			}
+			next(usize == 0xffffffff, &usize)
+			next(csize == 0xffffffff, &csize)
+			next(offset == 0xffffffff, &offset)
+			if disk == 0xffff && len(field) >= 4 {
+				disk = int64(binary.LittleEndian.Uint32(field))
+			}
+		} else {
+			others = append(others, e[:4+n]...)
+		}
+		e = e[4+n:]
+	}
+
+	absolute, ok := parts.offset(disk, int64(offset))
+	if !ok {
+		return nil, nil, errSplitZipCorrupt
+	}
+	var zip64 []byte
+	for _, v := range []struct {
+		value uint64
+		at    int
+	}{{usize, 24}, {csize, 20}, {uint64(absolute), 42}} {
+		if v.value >= 0xffffffff {
+			binary.LittleEndian.PutUint32(header[v.at:], 0xffffffff)
+			zip64 = binary.LittleEndian.AppendUint64(zip64, v.value)
+		} else {
+			binary.LittleEndian.PutUint32(header[v.at:], uint32(v.value))
+		}
+	}
+	binary.LittleEndian.PutUint16(header[34:], 0)
+
+	if len(zip64) > 0 {
+		field := binary.LittleEndian.AppendUint16(nil, zip64ExtraID)
+		field = binary.LittleEndian.AppendUint16(field, uint16(len(zip64)))
+		others = append(append(field, zip64...), others...)
+	}
+	if len(others) > 0xffff {
+		return nil, nil, errSplitZipCorrupt
+	}
+	binary.LittleEndian.PutUint16(header[30:], uint16(len(others)))
+	return header, others, nil
+}
+
+// multiReaderAt reads files one after the other as a single one
+type multiReaderAt struct {
+	parts  []io.ReaderAt
+	starts []int64
+	size   int64
+}
+
+func (m *multiReaderAt) add(part io.ReaderAt, size int64) {
+	m.parts = append(m.parts, part)
+	m.starts = append(m.starts, m.size)
+	m.size += size
+}
+
+// offset is the position of offset in the part disk, from 0
+func (m *multiReaderAt) offset(disk, offset int64) (int64, bool) {
+	if disk < 0 || disk >= int64(len(m.parts)) || offset < 0 {
+		return 0, false
+	}
+	return m.starts[disk] + offset, m.starts[disk]+offset <= m.size
+}
+
+func (m *multiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	for len(p) > 0 {
+		if off >= m.size {
+			return n, io.EOF
+		}
+		i := sort.Search(len(m.starts), func(i int) bool { return m.starts[i] > off }) - 1
+		end := m.size
+		if i+1 < len(m.starts) {
+			end = m.starts[i+1]
+		}
+		chunk := p
+		if int64(len(chunk)) > end-off {
+			chunk = chunk[:end-off]
+		}
+		read, err := m.parts[i].ReadAt(chunk, off-m.starts[i])
+		n += read
+		off += int64(read)
+		p = p[read:]
+		if read < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
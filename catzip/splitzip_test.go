@@ -0,0 +1,115 @@
+package catzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+	"testing/fstest"
+)
+
+// The vectors under testdata are from zip -s 64k -n .log, lines.log stored
+// and code.bin deflated across three parts, and the same with -fz for
+// split64: zip64 records and end of central directory.
+
+var splitZipParts = []string{".z01", ".z02", ".zip"}
+
+func splitZipContents() map[string][]byte {
+	return map[string][]byte{"lines.log": testLines(140000), "code.bin": testCode(60000)}
+}
+
+// splitZipFS returns the parts of the split zip name
+func splitZipFS(t *testing.T, name string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, ext := range splitZipParts {
+		fsys[name+ext] = &fstest.MapFile{Data: readGzipTestdata(t, name+ext)}
+	}
+	return fsys
+}
+
+// readSplitZip reads the files of the split zip name.zip in fsys
+func readSplitZip(fsys fstest.MapFS, name string) (map[string][]byte, error) {
+	reader, closer, err := openSplitZip(fsys, name+".zip")
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	files := map[string][]byte{}
+	for _, f := range reader.File {
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := readLimited(r, 1<<20)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[f.Name] = data
+	}
+	return files, nil
+}
+
+func TestSplitZip(t *testing.T) {
+	for _, name := range []string{"split", "split64"} {
+		fsys := splitZipFS(t, name)
+		if !isSplitZip(fsys, name+".zip") {
+			t.Fatalf("%s: not split", name)
+		}
+		files, err := readSplitZip(fsys, name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		contents := splitZipContents()
+		if len(files) != len(contents) {
+			t.Errorf("%s: %d files", name, len(files))
+		}
+		for file, content := range contents {
+			if !bytes.Equal(files[file], content) {
+				t.Errorf("%s: %s has %d bytes, not its content", name, file, len(files[file]))
+			}
+		}
+
+		delete(fsys, name+".z02")
+		if _, err := readSplitZip(fsys, name); err == nil {
+			t.Errorf("%s: no error without its second part", name)
+		}
+	}
+
+	// An archive of a single part is read by archive/zip alone
+	var single bytes.Buffer
+	w := zip.NewWriter(&single)
+	f, _ := w.Create("a")
+	io.WriteString(f, "a\n")
+	w.Close()
+	if isSplitZip(fstest.MapFS{"single.zip": {Data: single.Bytes()}}, "single.zip") {
+		t.Error("single.zip is split")
+	}
+}
+
+func TestSplitZipCorrupt(t *testing.T) {
+	for _, name := range []string{"split", "split64"} {
+		fsys := splitZipFS(t, name)
+		for _, ext := range []string{".z01", ".zip"} {
+			part := fsys[name+ext].Data
+			checkCorrupt(t, part, func(data []byte) error {
+				corrupt := fstest.MapFS{}
+				for name, file := range fsys {
+					corrupt[name] = file
+				}
+				corrupt[name+ext] = &fstest.MapFile{Data: data}
+				_, err := readSplitZip(corrupt, name)
+				return err
+			})
+		}
+	}
+}
+
+func TestSplitZipRun(t *testing.T) {
+	out := runInMemory(t, ".zip", splitZipFS(t, "split64"))
+	for name, content := range splitZipContents() {
+		if data, err := out.ReadFile("/out/" + name); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("extracted %s: %d bytes, %v", name, len(data), err)
+		}
+	}
+}
@@ -0,0 +1,2027 @@
+package catzip
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The policy scripts are written in a subset of Starlark, run by the small
+// interpreter below rather than a dependency:
+//
+//   - def, if, elif, else, for, return, pass, break and continue. There is
+//     no while and no recursion, so every call ends.
+//   - None, True, False, ints, strings, lists and dicts with string, int or
+//     bool keys. No floats, tuples or lambdas.
+//   - and, or, not, the comparisons, in and not in, + - * // %, indexing,
+//     slicing and the conditional expression.
+//   - The builtins len, str, int, bool and fail, the methods of the strings
+//     (startswith, endswith, lower, upper, strip, lstrip, rstrip, replace,
+//     split, rsplit, join, find, count, removeprefix and removesuffix), of
+//     the lists (append) and of the dicts (get, keys, values and items).
+//
+// As in Starlark, the globals are frozen once the script has run, so the
+// functions can be called from several goroutines at a time.
+
+// Steps a call can take, statements and loop iterations, and the longest
+// list or string an operation can build
+const (
+	starMaxSteps = 1000000
+	starMaxLen   = 1 << 20
+)
+
+// starValue is nil for None, bool, int64, string, *starList, *starDict,
+// *starStruct, *starFunc or *starBuiltin
+type starValue interface{}
+
+type starList struct {
+	elems  []starValue
+	frozen bool
+}
+
+// starDict keeps its keys in insertion order, they are strings, ints or bools
+type starDict struct {
+	keys   []starValue
+	values map[starValue]starValue
+	frozen bool
+}
+
+func newStarDict() *starDict { return &starDict{values: map[starValue]starValue{}} }
+
+func (d *starDict) set(key, value starValue) error {
+	if d.frozen {
+		return errors.New("can't change a frozen dict")
+	}
+	if !starHashable(key) {
+		return fmt.Errorf("%s can't be a dict key", starType(key))
+	}
+	if _, ok := d.values[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+	d.values[key] = value
+	return nil
+}
+
+// starStruct has read-only attributes, like the entry given to the scripts
+type starStruct struct {
+	name  string
+	attrs map[string]starValue
+}
+
+type starFunc struct {
+	name   string
+	params []string
+	body   []starStmt
+	script *starScript
+}
+
+type starBuiltin struct {
+	name string
+	fn   func(args []starValue) (starValue, error)
+}
+
+func starHashable(v starValue) bool {
+	switch v.(type) {
+	case string, int64, bool:
+		return true
+	}
+	return false
+}
+
+func starType(v starValue) string {
+	switch v := v.(type) {
+	case nil:
+		return "NoneType"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case string:
+		return "string"
+	case *starList:
+		return "list"
+	case *starDict:
+		return "dict"
+	case *starStruct:
+		return v.name
+	case *starFunc, *starBuiltin:
+		return "function"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func starTruth(v starValue) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case string:
+		return v != ""
+	case *starList:
+		return len(v.elems) > 0
+	case *starDict:
+		return len(v.keys) > 0
+	}
+	return true
+}
+
+// starString is str of v, repr quotes the strings
+func starString(v starValue, repr bool) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		if repr {
+			return strconv.Quote(v)
+		}
+		return v
+	case *starList:
+		elems := make([]string, len(v.elems))
+		for i, e := range v.elems {
+			elems[i] = starString(e, true)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case *starDict:
+		items := make([]string, len(v.keys))
+		for i, k := range v.keys {
+			items[i] = starString(k, true) + ": " + starString(v.values[k], true)
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	case *starStruct:
+		names := make([]string, 0, len(v.attrs))
+		for name := range v.attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = name + " = " + starString(v.attrs[name], true)
+		}
+		return v.name + "(" + strings.Join(names, ", ") + ")"
+	case *starFunc:
+		return "<function " + v.name + ">"
+	case *starBuiltin:
+		return "<built-in function " + v.name + ">"
+	}
+	return "?"
+}
+
+func starFreeze(v starValue) {
+	switch v := v.(type) {
+	case *starList:
+		if !v.frozen {
+			v.frozen = true
+			for _, e := range v.elems {
+				starFreeze(e)
+			}
+		}
+	case *starDict:
+		if !v.frozen {
+			v.frozen = true
+			for _, k := range v.keys {
+				starFreeze(v.values[k])
+			}
+		}
+	}
+}
+
+// Lexer
+
+type starTokenKind int
+
+const (
+	tokEOF starTokenKind = iota
+	tokNewline
+	tokIndent
+	tokDedent
+	tokName
+	tokInt
+	tokString
+	tokOp
+)
+
+type starToken struct {
+	kind  starTokenKind
+	text  string // Of names and operators, the value of strings
+	value int64  // Of ints
+	line  int
+}
+
+var starOps = []string{"//=", "**", "//", "==", "!=", "<=", ">=", "+=", "-=", "*=", "%=",
+	"+", "-", "*", "%", "<", ">", "=", "(", ")", "[", "]", "{", "}", ",", ":", "."}
+
+// starLex splits src into tokens, with INDENT and DEDENT around the blocks
+// and NEWLINE ending the logical lines
+func starLex(src string) ([]starToken, error) {
+	var tokens []starToken
+	indents := []int{0}
+	line := 1
+	depth := 0 // Of the brackets, newlines don't end lines within them
+	atLineStart := true
+	i := 0
+	for i < len(src) {
+		if atLineStart && depth == 0 {
+			// Measure the indentation, blank and comment lines don't count
+			col := 0
+			j := i
+			for j < len(src) && (src[j] == ' ' || src[j] == '\t') {
+				if src[j] == '\t' {
+					col += 8 - col%8
+				} else {
+					col++
+				}
+				j++
+			}
+			if j == len(src) || src[j] == '\n' || src[j] == '\r' || src[j] == '#' {
+				for j < len(src) && src[j] != '\n' {
+					j++
+				}
+				if j < len(src) {
+					j++
+					line++
+				}
+				i = j
+				continue
+			}
+			i = j
+			atLineStart = false
+			switch top := indents[len(indents)-1]; {
+			case col > top:
+				indents = append(indents, col)
+				tokens = append(tokens, starToken{kind: tokIndent, line: line})
+			case col < top:
+				for col < indents[len(indents)-1] {
+					indents = indents[:len(indents)-1]
+					tokens = append(tokens, starToken{kind: tokDedent, line: line})
+				}
+				if col != indents[len(indents)-1] {
+					return nil, fmt.Errorf("%d: unindent doesn't match an outer level", line)
+				}
+			}
+		}
+
+		c := src[i]
+		switch {
+		case c == '\n':
+			if depth == 0 {
+				tokens = append(tokens, starToken{kind: tokNewline, line: line})
+				atLineStart = true
+			}
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			line++
+			i += 2
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '_' || c < 0x80 && unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] < 0x80 && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])))) {
+				j++
+			}
+			tokens = append(tokens, starToken{kind: tokName, text: src[i:j], line: line})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == 'x' || src[j] == 'X' || src[j] == 'o' || src[j] == 'O' ||
+				src[j] >= 'a' && src[j] <= 'f' || src[j] >= 'A' && src[j] <= 'F' || src[j] == '_') {
+				j++
+			}
+			n, err := strconv.ParseInt(src[i:j], 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%d: invalid int %s", line, src[i:j])
+			}
+			tokens = append(tokens, starToken{kind: tokInt, value: n, line: line})
+			i = j
+		case c == '"' || c == '\'':
+			s, n, err := starLexString(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", line, err)
+			}
+			tokens = append(tokens, starToken{kind: tokString, text: s, line: line})
+			line += strings.Count(src[i:i+n], "\n")
+			i += n
+		default:
+			op := ""
+			for _, o := range starOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%d: unexpected %q", line, c)
+			}
+			switch op {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				if depth > 0 {
+					depth--
+				}
+			}
+			tokens = append(tokens, starToken{kind: tokOp, text: op, line: line})
+			i += len(op)
+		}
+	}
+	if !atLineStart {
+		tokens = append(tokens, starToken{kind: tokNewline, line: line})
+	}
+	for len(indents) > 1 {
+		indents = indents[:len(indents)-1]
+		tokens = append(tokens, starToken{kind: tokDedent, line: line})
+	}
+	return append(tokens, starToken{kind: tokEOF, line: line}), nil
+}
+
+// starLexString reads the string literal src starts with, single or triple
+// quoted, returning its value and length
+func starLexString(src string) (string, int, error) {
+	quote := src[:1]
+	if strings.HasPrefix(src, strings.Repeat(quote, 3)) {
+		quote = src[:3]
+	}
+	var b strings.Builder
+	i := len(quote)
+	for {
+		if i >= len(src) {
+			return "", 0, errors.New("unterminated string")
+		}
+		if strings.HasPrefix(src[i:], quote) {
+			return b.String(), i + len(quote), nil
+		}
+		c := src[i]
+		if c == '\n' && len(quote) == 1 {
+			return "", 0, errors.New("unterminated string")
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		if i+1 >= len(src) {
+			return "", 0, errors.New("unterminated string")
+		}
+		switch e := src[i+1]; e {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '0':
+			b.WriteByte(0)
+		case '\\', '\'', '"':
+			b.WriteByte(e)
+		case '\n':
+		default:
+			return "", 0, fmt.Errorf("invalid escape \\%c", e)
+		}
+		i += 2
+	}
+}
+
+// Syntax tree
+
+type starStmt interface{}
+
+type (
+	defStmt struct {
+		line   int
+		name   string
+		params []string
+		body   []starStmt
+	}
+	ifStmt struct {
+		line int
+		cond starExpr
+		body []starStmt
+		els  []starStmt
+	}
+	forStmt struct {
+		line int
+		vars []string // One, or several unpacking the elements
+		iter starExpr
+		body []starStmt
+	}
+	returnStmt struct {
+		line  int
+		value starExpr // nil returns None
+	}
+	assignStmt struct {
+		line   int
+		target starExpr // identExpr or indexExpr
+		op     string   // = or an augmented one like +=
+		value  starExpr
+	}
+	exprStmt struct {
+		line int
+		x    starExpr
+	}
+	branchStmt struct {
+		line int
+		kind string // pass, break or continue
+	}
+)
+
+type starExpr interface{}
+
+type (
+	identExpr struct {
+		line int
+		name string
+	}
+	literalExpr struct{ value starValue }
+	listExpr    struct{ elems []starExpr }
+	dictExpr    struct {
+		line         int
+		keys, values []starExpr
+	}
+	unaryExpr struct {
+		line int
+		op   string
+		x    starExpr
+	}
+	binaryExpr struct {
+		line int
+		op   string
+		x, y starExpr
+	}
+	condExpr struct{ cond, then, els starExpr }
+	callExpr struct {
+		line int
+		fn   starExpr
+		args []starExpr
+	}
+	dotExpr struct {
+		line int
+		x    starExpr
+		name string
+	}
+	indexExpr struct {
+		line     int
+		x, index starExpr
+	}
+	sliceExpr struct {
+		line      int
+		x, lo, hi starExpr // lo and hi can be nil
+	}
+)
+
+// Parser
+
+type starParser struct {
+	tokens []starToken
+	pos    int
+}
+
+func (p *starParser) peek() starToken { return p.tokens[p.pos] }
+
+func (p *starParser) next() starToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *starParser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *starParser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokName && t.text == word
+}
+
+func (p *starParser) expectOp(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("expected %s", op)
+	}
+	p.next()
+	return nil
+}
+
+func (p *starParser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	got := t.text
+	switch t.kind {
+	case tokEOF:
+		got = "end of file"
+	case tokNewline:
+		got = "end of line"
+	case tokIndent:
+		got = "indent"
+	case tokDedent:
+		got = "unindent"
+	case tokInt:
+		got = strconv.FormatInt(t.value, 10)
+	case tokString:
+		got = strconv.Quote(t.text)
+	}
+	return fmt.Errorf("%d: %s, got %s", t.line, fmt.Sprintf(format, args...), got)
+}
+
+var starKeywords = map[string]bool{
+	"and": true, "break": true, "continue": true, "def": true, "elif": true, "else": true, "for": true,
+	"if": true, "in": true, "not": true, "or": true, "pass": true, "return": true,
+	"None": true, "True": true, "False": true,
+	// Reserved by Starlark, not supported here
+	"lambda": true, "load": true, "while": true,
+}
+
+func (p *starParser) name() (string, error) {
+	t := p.peek()
+	if t.kind != tokName || starKeywords[t.text] {
+		return "", p.errorf("expected a name")
+	}
+	p.next()
+	return t.text, nil
+}
+
+func (p *starParser) file() ([]starStmt, error) {
+	var stmts []starStmt
+	for p.peek().kind != tokEOF {
+		if p.peek().kind == tokNewline {
+			p.next()
+			continue
+		}
+		s, err := p.stmt(true)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+	}
+	return stmts, nil
+}
+
+// block parses the statements after a colon, indented or on the same line
+func (p *starParser) block() ([]starStmt, error) {
+	if err := p.expectOp(":"); err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokNewline {
+		s, err := p.simpleStmt()
+		if err != nil {
+			return nil, err
+		}
+		return []starStmt{s}, p.endLine()
+	}
+	p.next()
+	if p.peek().kind != tokIndent {
+		return nil, p.errorf("expected an indented block")
+	}
+	p.next()
+	var stmts []starStmt
+	for p.peek().kind != tokDedent && p.peek().kind != tokEOF {
+		s, err := p.stmt(false)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+	}
+	p.next()
+	return stmts, nil
+}
+
+func (p *starParser) endLine() error {
+	if p.peek().kind != tokNewline && p.peek().kind != tokEOF {
+		return p.errorf("expected the end of the line")
+	}
+	p.next()
+	return nil
+}
+
+func (p *starParser) stmt(topLevel bool) (starStmt, error) {
+	line := p.peek().line
+	switch {
+	case p.isKeyword("def"):
+		if !topLevel {
+			return nil, p.errorf("functions can only be defined at the top level")
+		}
+		p.next()
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectOp("("); err != nil {
+			return nil, err
+		}
+		var params []string
+		for !p.isOp(")") {
+			param, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, param)
+			if !p.isOp(")") {
+				if err = p.expectOp(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+		p.next()
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &defStmt{line: line, name: name, params: params, body: body}, nil
+
+	case p.isKeyword("if"):
+		p.next()
+		return p.ifRest(line)
+
+	case p.isKeyword("for"):
+		p.next()
+		var vars []string
+		for {
+			v, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			vars = append(vars, v)
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+		if !p.isKeyword("in") {
+			return nil, p.errorf("expected in")
+		}
+		p.next()
+		iter, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &forStmt{line: line, vars: vars, iter: iter, body: body}, nil
+	}
+
+	s, err := p.simpleStmt()
+	if err != nil {
+		return nil, err
+	}
+	return s, p.endLine()
+}
+
+// ifRest parses an if statement after its if or elif
+func (p *starParser) ifRest(line int) (starStmt, error) {
+	cond, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	s := &ifStmt{line: line, cond: cond, body: body}
+	switch {
+	case p.isKeyword("elif"):
+		elifLine := p.next().line
+		elif, err := p.ifRest(elifLine)
+		if err != nil {
+			return nil, err
+		}
+		s.els = []starStmt{elif}
+	case p.isKeyword("else"):
+		p.next()
+		if s.els, err = p.block(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *starParser) simpleStmt() (starStmt, error) {
+	line := p.peek().line
+	switch {
+	case p.isKeyword("return"):
+		p.next()
+		if p.peek().kind == tokNewline || p.peek().kind == tokEOF {
+			return &returnStmt{line: line}, nil
+		}
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return &returnStmt{line: line, value: value}, nil
+	case p.isKeyword("pass"), p.isKeyword("break"), p.isKeyword("continue"):
+		return &branchStmt{line: line, kind: p.next().text}, nil
+	case p.isKeyword("while"), p.isKeyword("lambda"), p.isKeyword("load"):
+		return nil, p.errorf("unsupported statement")
+	}
+
+	x, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokOp && (t.text == "=" || t.text == "+=" || t.text == "-=" || t.text == "*=" || t.text == "%=" || t.text == "//=") {
+		switch x.(type) {
+		case *identExpr, *indexExpr:
+		default:
+			return nil, fmt.Errorf("%d: can't assign to this expression", line)
+		}
+		p.next()
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return &assignStmt{line: line, target: x, op: t.text, value: value}, nil
+	}
+	return &exprStmt{line: line, x: x}, nil
+}
+
+// expr parses a conditional expression, the lowest precedence
+func (p *starParser) expr() (starExpr, error) {
+	x, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isKeyword("if") {
+		return x, nil
+	}
+	p.next()
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isKeyword("else") {
+		return nil, p.errorf("expected else")
+	}
+	p.next()
+	els, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return &condExpr{cond: cond, then: x, els: els}, nil
+}
+
+// Binary operators by increasing precedence, not is unary between and and
+// the comparisons
+var starPrecedence = [][]string{
+	{"or"},
+	{"and"},
+	{"not"},
+	{"==", "!=", "<", "<=", ">", ">=", "in", "not in"},
+	{"+", "-"},
+	{"*", "//", "%"},
+}
+
+// binaryOperator returns the binary operator at the current token of level, and
+// the tokens it takes
+func (p *starParser) binaryOperator(level int) (string, int) {
+	t := p.peek()
+	for _, op := range starPrecedence[level] {
+		switch {
+		case op == "not in":
+			if t.kind == tokName && t.text == "not" {
+				if n := p.tokens[p.pos+1]; n.kind == tokName && n.text == "in" {
+					return op, 2
+				}
+			}
+		case op == "not":
+		case t.text == op && (t.kind == tokOp || t.kind == tokName && (op == "or" || op == "and" || op == "in")):
+			return op, 1
+		}
+	}
+	return "", 0
+}
+
+func (p *starParser) binary(level int) (starExpr, error) {
+	if level == len(starPrecedence) {
+		return p.unary()
+	}
+	if starPrecedence[level][0] == "not" {
+		if p.isKeyword("not") {
+			line := p.next().line
+			x, err := p.binary(level)
+			if err != nil {
+				return nil, err
+			}
+			return &unaryExpr{line: line, op: "not", x: x}, nil
+		}
+		return p.binary(level + 1)
+	}
+
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, n := p.binaryOperator(level)
+		if op == "" {
+			return x, nil
+		}
+		line := p.peek().line
+		p.pos += n
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{line: line, op: op, x: x, y: y}
+	}
+}
+
+func (p *starParser) unary() (starExpr, error) {
+	if p.isOp("-") || p.isOp("+") {
+		t := p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{line: t.line, op: t.text, x: x}, nil
+	}
+	return p.postfix()
+}
+
+func (p *starParser) postfix() (starExpr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		line := p.peek().line
+		switch {
+		case p.isOp("."):
+			p.next()
+			name := p.peek()
+			if name.kind != tokName {
+				return nil, p.errorf("expected an attribute name")
+			}
+			p.next()
+			x = &dotExpr{line: line, x: x, name: name.text}
+		case p.isOp("("):
+			p.next()
+			args, err := p.exprList(")")
+			if err != nil {
+				return nil, err
+			}
+			x = &callExpr{line: line, fn: x, args: args}
+		case p.isOp("["):
+			p.next()
+			var lo, hi starExpr
+			if !p.isOp(":") {
+				if lo, err = p.expr(); err != nil {
+					return nil, err
+				}
+			}
+			if p.isOp("]") {
+				p.next()
+				x = &indexExpr{line: line, x: x, index: lo}
+				continue
+			}
+			if err = p.expectOp(":"); err != nil {
+				return nil, err
+			}
+			if !p.isOp("]") {
+				if hi, err = p.expr(); err != nil {
+					return nil, err
+				}
+			}
+			if err = p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			x = &sliceExpr{line: line, x: x, lo: lo, hi: hi}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// exprList parses the expressions separated by commas up to end, which it
+// consumes, a trailing comma is allowed
+func (p *starParser) exprList(end string) ([]starExpr, error) {
+	var list []starExpr
+	for !p.isOp(end) {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, x)
+		if !p.isOp(end) {
+			if err = p.expectOp(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	p.next()
+	return list, nil
+}
+
+func (p *starParser) primary() (starExpr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokInt:
+		p.next()
+		return &literalExpr{value: t.value}, nil
+	case tokString:
+		p.next()
+		s := t.text
+		// Adjacent literals are concatenated
+		for p.peek().kind == tokString {
+			s += p.next().text
+		}
+		return &literalExpr{value: s}, nil
+	case tokName:
+		switch t.text {
+		case "None":
+			p.next()
+			return &literalExpr{value: nil}, nil
+		case "True", "False":
+			p.next()
+			return &literalExpr{value: t.text == "True"}, nil
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		return &identExpr{line: t.line, name: name}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			p.next()
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if p.isOp(",") {
+				return nil, p.errorf("tuples aren't supported")
+			}
+			return x, p.expectOp(")")
+		case "[":
+			p.next()
+			elems, err := p.exprList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listExpr{elems: elems}, nil
+		case "{":
+			p.next()
+			d := &dictExpr{line: t.line}
+			for !p.isOp("}") {
+				key, err := p.expr()
+				if err != nil {
+					return nil, err
+				}
+				if err = p.expectOp(":"); err != nil {
+					return nil, err
+				}
+				value, err := p.expr()
+				if err != nil {
+					return nil, err
+				}
+				d.keys = append(d.keys, key)
+				d.values = append(d.values, value)
+				if !p.isOp("}") {
+					if err = p.expectOp(","); err != nil {
+						return nil, err
+					}
+				}
+			}
+			p.next()
+			return d, nil
+		}
+	}
+	return nil, p.errorf("expected an expression")
+}
+
+// Evaluation
+
+// starScript is a parsed and run script, its frozen globals define its
+// functions
+type starScript struct {
+	name    string // In the errors
+	globals map[string]starValue
+}
+
+// starError is an error of the script at a line
+type starError struct {
+	script string
+	line   int
+	err    error
+}
+
+func (e *starError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.script, e.line, e.err)
+}
+
+func (e *starError) Unwrap() error { return e.err }
+
+// compileStarlark parses and runs the script src, the errors are prefixed
+// with name
+func compileStarlark(name, src string) (*starScript, error) {
+	tokens, err := starLex(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", name, err)
+	}
+	p := &starParser{tokens: tokens}
+	stmts, err := p.file()
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", name, err)
+	}
+
+	s := &starScript{name: name, globals: map[string]starValue{}}
+	th := &starThread{script: s}
+	flow, _, err := th.exec(&starFrame{locals: s.globals}, stmts)
+	if err != nil {
+		return nil, err
+	}
+	if flow != flowNormal {
+		return nil, fmt.Errorf("%s: return, break or continue out of a function", name)
+	}
+	for _, v := range s.globals {
+		starFreeze(v)
+	}
+	return s, nil
+}
+
+// call calls the global function name of the script with args
+func (s *starScript) call(name string, args ...starValue) (starValue, error) {
+	fn, ok := s.globals[name].(*starFunc)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't define a function %s", s.name, name)
+	}
+	th := &starThread{script: s}
+	return th.callFunc(fn, args, 0)
+}
+
+// starThread is a call of the script, with its own steps and call stack
+type starThread struct {
+	script *starScript
+	steps  int
+	active []*starFunc
+}
+
+type starFrame struct {
+	locals map[string]starValue
+}
+
+// Control flow out of exec
+const (
+	flowNormal = iota
+	flowReturn
+	flowBreak
+	flowContinue
+)
+
+func (th *starThread) errorf(line int, format string, args ...interface{}) error {
+	return &starError{script: th.script.name, line: line, err: fmt.Errorf(format, args...)}
+}
+
+// wrap puts the line on an error of a builtin or an operation
+func (th *starThread) wrap(line int, err error) error {
+	var se *starError
+	if err == nil || errors.As(err, &se) {
+		return err
+	}
+	return &starError{script: th.script.name, line: line, err: err}
+}
+
+func (th *starThread) step(line int) error {
+	th.steps++
+	if th.steps > starMaxSteps {
+		return th.errorf(line, "more than %d steps", starMaxSteps)
+	}
+	return nil
+}
+
+func starStmtLine(s starStmt) int {
+	switch s := s.(type) {
+	case *defStmt:
+		return s.line
+	case *ifStmt:
+		return s.line
+	case *forStmt:
+		return s.line
+	case *returnStmt:
+		return s.line
+	case *assignStmt:
+		return s.line
+	case *exprStmt:
+		return s.line
+	case *branchStmt:
+		return s.line
+	}
+	return 0
+}
+
+func (th *starThread) exec(f *starFrame, stmts []starStmt) (int, starValue, error) {
+	for _, stmt := range stmts {
+		if err := th.step(starStmtLine(stmt)); err != nil {
+			return 0, nil, err
+		}
+		switch s := stmt.(type) {
+		case *defStmt:
+			f.locals[s.name] = &starFunc{name: s.name, params: s.params, body: s.body, script: th.script}
+
+		case *ifStmt:
+			cond, err := th.eval(f, s.cond)
+			if err != nil {
+				return 0, nil, err
+			}
+			body := s.els
+			if starTruth(cond) {
+				body = s.body
+			}
+			if flow, v, err := th.exec(f, body); err != nil || flow != flowNormal {
+				return flow, v, err
+			}
+
+		case *forStmt:
+			if flow, v, err := th.execFor(f, s); err != nil || flow == flowReturn {
+				return flow, v, err
+			}
+
+		case *returnStmt:
+			if s.value == nil {
+				return flowReturn, nil, nil
+			}
+			v, err := th.eval(f, s.value)
+			return flowReturn, v, err
+
+		case *assignStmt:
+			if err := th.assign(f, s); err != nil {
+				return 0, nil, err
+			}
+
+		case *exprStmt:
+			if _, err := th.eval(f, s.x); err != nil {
+				return 0, nil, err
+			}
+
+		case *branchStmt:
+			switch s.kind {
+			case "break":
+				return flowBreak, nil, nil
+			case "continue":
+				return flowContinue, nil, nil
+			}
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+// execFor runs a for loop, returning flowReturn when its body returned
+func (th *starThread) execFor(f *starFrame, s *forStmt) (int, starValue, error) {
+	iter, err := th.eval(f, s.iter)
+	if err != nil {
+		return 0, nil, err
+	}
+	elems, err := starIterate(iter)
+	if err != nil {
+		return 0, nil, th.wrap(s.line, err)
+	}
+	if l, ok := iter.(*starList); ok && !l.frozen {
+		// Like Starlark, the list can't change while it is iterated
+		l.frozen = true
+		defer func() { l.frozen = false }()
+	}
+	for _, elem := range elems {
+		if err := th.step(s.line); err != nil {
+			return 0, nil, err
+		}
+		if err := th.bind(f, s.line, s.vars, elem); err != nil {
+			return 0, nil, err
+		}
+		flow, v, err := th.exec(f, s.body)
+		if err != nil || flow == flowReturn {
+			return flow, v, err
+		}
+		if flow == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+// bind assigns elem to the loop variables vars, unpacking it when there
+// are several
+func (th *starThread) bind(f *starFrame, line int, vars []string, elem starValue) error {
+	if len(vars) == 1 {
+		f.locals[vars[0]] = elem
+		return nil
+	}
+	l, ok := elem.(*starList)
+	if !ok || len(l.elems) != len(vars) {
+		return th.errorf(line, "can't unpack %s into %d variables", starString(elem, true), len(vars))
+	}
+	for i, name := range vars {
+		f.locals[name] = l.elems[i]
+	}
+	return nil
+}
+
+func (th *starThread) assign(f *starFrame, s *assignStmt) error {
+	value, err := th.eval(f, s.value)
+	if err != nil {
+		return err
+	}
+	switch target := s.target.(type) {
+	case *identExpr:
+		if s.op != "=" {
+			old, err := th.lookup(f, target)
+			if err != nil {
+				return err
+			}
+			if value, err = starBinaryOp(strings.TrimSuffix(s.op, "="), old, value); err != nil {
+				return th.wrap(s.line, err)
+			}
+		}
+		f.locals[target.name] = value
+	case *indexExpr:
+		x, err := th.eval(f, target.x)
+		if err != nil {
+			return err
+		}
+		index, err := th.eval(f, target.index)
+		if err != nil {
+			return err
+		}
+		if s.op != "=" {
+			old, err := starIndexValue(x, index)
+			if err != nil {
+				return th.wrap(s.line, err)
+			}
+			if value, err = starBinaryOp(strings.TrimSuffix(s.op, "="), old, value); err != nil {
+				return th.wrap(s.line, err)
+			}
+		}
+		return th.wrap(s.line, starSetIndex(x, index, value))
+	}
+	return nil
+}
+
+func (th *starThread) lookup(f *starFrame, x *identExpr) (starValue, error) {
+	if v, ok := f.locals[x.name]; ok {
+		return v, nil
+	}
+	if v, ok := th.script.globals[x.name]; ok {
+		return v, nil
+	}
+	if b, ok := starBuiltins[x.name]; ok {
+		return b, nil
+	}
+	return nil, th.errorf(x.line, "undefined: %s", x.name)
+}
+
+func (th *starThread) eval(f *starFrame, x starExpr) (starValue, error) {
+	switch x := x.(type) {
+	case *literalExpr:
+		return x.value, nil
+
+	case *identExpr:
+		return th.lookup(f, x)
+
+	case *listExpr:
+		l := &starList{}
+		for _, e := range x.elems {
+			v, err := th.eval(f, e)
+			if err != nil {
+				return nil, err
+			}
+			l.elems = append(l.elems, v)
+		}
+		return l, nil
+
+	case *dictExpr:
+		d := newStarDict()
+		for i := range x.keys {
+			k, err := th.eval(f, x.keys[i])
+			if err != nil {
+				return nil, err
+			}
+			v, err := th.eval(f, x.values[i])
+			if err != nil {
+				return nil, err
+			}
+			if err = d.set(k, v); err != nil {
+				return nil, th.wrap(x.line, err)
+			}
+		}
+		return d, nil
+
+	case *unaryExpr:
+		v, err := th.eval(f, x.x)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case "not":
+			return !starTruth(v), nil
+		case "-", "+":
+			n, ok := v.(int64)
+			if !ok {
+				return nil, th.errorf(x.line, "unary %s on %s", x.op, starType(v))
+			}
+			if x.op == "-" {
+				n = -n
+			}
+			return n, nil
+		}
+
+	case *binaryExpr:
+		a, err := th.eval(f, x.x)
+		if err != nil {
+			return nil, err
+		}
+		// and and or only evaluate the right side when needed
+		switch x.op {
+		case "and":
+			if !starTruth(a) {
+				return a, nil
+			}
+			return th.eval(f, x.y)
+		case "or":
+			if starTruth(a) {
+				return a, nil
+			}
+			return th.eval(f, x.y)
+		}
+		b, err := th.eval(f, x.y)
+		if err != nil {
+			return nil, err
+		}
+		v, err := starBinaryOp(x.op, a, b)
+		return v, th.wrap(x.line, err)
+
+	case *condExpr:
+		cond, err := th.eval(f, x.cond)
+		if err != nil {
+			return nil, err
+		}
+		if starTruth(cond) {
+			return th.eval(f, x.then)
+		}
+		return th.eval(f, x.els)
+
+	case *callExpr:
+		fn, err := th.eval(f, x.fn)
+		if err != nil {
+			return nil, err
+		}
+		args := make([]starValue, len(x.args))
+		for i, a := range x.args {
+			if args[i], err = th.eval(f, a); err != nil {
+				return nil, err
+			}
+		}
+		switch fn := fn.(type) {
+		case *starFunc:
+			return th.callFunc(fn, args, x.line)
+		case *starBuiltin:
+			v, err := fn.fn(args)
+			if err != nil {
+				return nil, th.wrap(x.line, fmt.Errorf("%s: %w", fn.name, err))
+			}
+			return v, nil
+		}
+		return nil, th.errorf(x.line, "can't call a %s", starType(fn))
+
+	case *dotExpr:
+		v, err := th.eval(f, x.x)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := starAttribute(v, x.name)
+		return attr, th.wrap(x.line, err)
+
+	case *indexExpr:
+		v, err := th.eval(f, x.x)
+		if err != nil {
+			return nil, err
+		}
+		index, err := th.eval(f, x.index)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := starIndexValue(v, index)
+		return elem, th.wrap(x.line, err)
+
+	case *sliceExpr:
+		v, err := th.eval(f, x.x)
+		if err != nil {
+			return nil, err
+		}
+		var lo, hi starValue
+		if x.lo != nil {
+			if lo, err = th.eval(f, x.lo); err != nil {
+				return nil, err
+			}
+		}
+		if x.hi != nil {
+			if hi, err = th.eval(f, x.hi); err != nil {
+				return nil, err
+			}
+		}
+		s, err := starSlice(v, lo, hi)
+		return s, th.wrap(x.line, err)
+	}
+	return nil, fmt.Errorf("unexpected expression %T", x)
+}
+
+func (th *starThread) callFunc(fn *starFunc, args []starValue, line int) (starValue, error) {
+	for _, active := range th.active {
+		if active == fn {
+			return nil, th.errorf(line, "%s called recursively", fn.name)
+		}
+	}
+	if len(args) != len(fn.params) {
+		return nil, th.errorf(line, "%s takes %d arguments, got %d", fn.name, len(fn.params), len(args))
+	}
+	f := &starFrame{locals: map[string]starValue{}}
+	for i, param := range fn.params {
+		f.locals[param] = args[i]
+	}
+	th.active = append(th.active, fn)
+	defer func() { th.active = th.active[:len(th.active)-1] }()
+	flow, v, err := th.exec(f, fn.body)
+	if err != nil {
+		return nil, err
+	}
+	if flow == flowBreak || flow == flowContinue {
+		return nil, th.errorf(line, "break or continue out of a loop in %s", fn.name)
+	}
+	return v, nil
+}
+
+// iterate returns the elements a for loop goes through, the keys of dicts
+func starIterate(v starValue) ([]starValue, error) {
+	switch v := v.(type) {
+	case *starList:
+		return append([]starValue(nil), v.elems...), nil
+	case *starDict:
+		return append([]starValue(nil), v.keys...), nil
+	}
+	return nil, fmt.Errorf("can't iterate over a %s", starType(v))
+}
+
+func starEqual(a, b starValue) bool {
+	switch a := a.(type) {
+	case *starList:
+		b, ok := b.(*starList)
+		if !ok || len(a.elems) != len(b.elems) {
+			return false
+		}
+		for i := range a.elems {
+			if !starEqual(a.elems[i], b.elems[i]) {
+				return false
+			}
+		}
+		return true
+	case *starDict:
+		b, ok := b.(*starDict)
+		if !ok || len(a.keys) != len(b.keys) {
+			return false
+		}
+		for _, k := range a.keys {
+			bv, ok := b.values[k]
+			if !ok || !starEqual(a.values[k], bv) {
+				return false
+			}
+		}
+		return true
+	case *starStruct, *starFunc, *starBuiltin:
+		return a == b
+	}
+	return a == b
+}
+
+func starBinaryOp(op string, a, b starValue) (starValue, error) {
+	switch op {
+	case "==":
+		return starEqual(a, b), nil
+	case "!=":
+		return !starEqual(a, b), nil
+	case "in", "not in":
+		found, err := starContains(b, a)
+		if op == "not in" {
+			found = !found
+		}
+		return found, err
+	case "<", "<=", ">", ">=":
+		c, err := starCompare(a, b)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			switch op {
+			case "+":
+				return a + b, nil
+			case "-":
+				return a - b, nil
+			case "*":
+				return a * b, nil
+			case "//", "%":
+				if b == 0 {
+					return nil, errors.New("division by zero")
+				}
+				// Rounded down like Starlark, not toward zero like Go
+				q, r := a/b, a%b
+				if r != 0 && (r < 0) != (b < 0) {
+					q--
+					r += b
+				}
+				if op == "//" {
+					return q, nil
+				}
+				return r, nil
+			}
+		case string:
+			if op == "*" {
+				return starRepeatString(b, a)
+			}
+		case *starList:
+			if op == "*" {
+				return starRepeatList(b, a)
+			}
+		}
+	case string:
+		switch b := b.(type) {
+		case string:
+			if op == "+" {
+				if len(a)+len(b) > starMaxLen {
+					return nil, fmt.Errorf("string longer than %d bytes", starMaxLen)
+				}
+				return a + b, nil
+			}
+		case int64:
+			if op == "*" {
+				return starRepeatString(a, b)
+			}
+		}
+	case *starList:
+		switch b := b.(type) {
+		case *starList:
+			if op == "+" {
+				if len(a.elems)+len(b.elems) > starMaxLen {
+					return nil, fmt.Errorf("list longer than %d elements", starMaxLen)
+				}
+				elems := append(append([]starValue(nil), a.elems...), b.elems...)
+				return &starList{elems: elems}, nil
+			}
+		case int64:
+			if op == "*" {
+				return starRepeatList(a, b)
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported %s %s %s", starType(a), op, starType(b))
+}
+
+func starRepeatString(s string, n int64) (starValue, error) {
+	if n <= 0 {
+		return "", nil
+	}
+	if int64(len(s))*n > starMaxLen {
+		return nil, fmt.Errorf("string longer than %d bytes", starMaxLen)
+	}
+	return strings.Repeat(s, int(n)), nil
+}
+
+func starRepeatList(l *starList, n int64) (starValue, error) {
+	if n <= 0 {
+		return &starList{}, nil
+	}
+	if int64(len(l.elems))*n > starMaxLen {
+		return nil, fmt.Errorf("list longer than %d elements", starMaxLen)
+	}
+	var elems []starValue
+	for i := int64(0); i < n; i++ {
+		elems = append(elems, l.elems...)
+	}
+	return &starList{elems: elems}, nil
+}
+
+func starCompare(a, b starValue) (int, error) {
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	}
+	return 0, fmt.Errorf("can't compare %s and %s", starType(a), starType(b))
+}
+
+func starContains(container, x starValue) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		s, ok := x.(string)
+		if !ok {
+			return false, fmt.Errorf("%s in string, expected a string", starType(x))
+		}
+		return strings.Contains(c, s), nil
+	case *starList:
+		for _, e := range c.elems {
+			if starEqual(e, x) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *starDict:
+		if !starHashable(x) {
+			return false, nil
+		}
+		_, ok := c.values[x]
+		return ok, nil
+	}
+	return false, fmt.Errorf("in %s, expected a string, list or dict", starType(container))
+}
+
+// index checks i against n elements, negative ones count from the end
+func starIndex(i starValue, n int) (int, error) {
+	k, ok := i.(int64)
+	if !ok {
+		return 0, fmt.Errorf("index is a %s, expected an int", starType(i))
+	}
+	if k < 0 {
+		k += int64(n)
+	}
+	if k < 0 || k >= int64(n) {
+		return 0, fmt.Errorf("index %d out of range, %d elements", i, n)
+	}
+	return int(k), nil
+}
+
+func starIndexValue(x, i starValue) (starValue, error) {
+	switch x := x.(type) {
+	case string:
+		k, err := starIndex(i, len(x))
+		if err != nil {
+			return nil, err
+		}
+		return x[k : k+1], nil
+	case *starList:
+		k, err := starIndex(i, len(x.elems))
+		if err != nil {
+			return nil, err
+		}
+		return x.elems[k], nil
+	case *starDict:
+		v, ok := x.values[i]
+		if !starHashable(i) || !ok {
+			return nil, fmt.Errorf("key %s not in dict", starString(i, true))
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("can't index a %s", starType(x))
+}
+
+func starSetIndex(x, i, v starValue) error {
+	switch x := x.(type) {
+	case *starList:
+		if x.frozen {
+			return errors.New("can't change a frozen list")
+		}
+		k, err := starIndex(i, len(x.elems))
+		if err != nil {
+			return err
+		}
+		x.elems[k] = v
+		return nil
+	case *starDict:
+		return x.set(i, v)
+	}
+	return fmt.Errorf("can't assign to an element of a %s", starType(x))
+}
+
+// slice returns x[lo:hi], the bounds being clamped like Starlark
+func starSlice(x, lo, hi starValue) (starValue, error) {
+	var n int
+	switch x := x.(type) {
+	case string:
+		n = len(x)
+	case *starList:
+		n = len(x.elems)
+	default:
+		return nil, fmt.Errorf("can't slice a %s", starType(x))
+	}
+	bound := func(b starValue, def int) (int, error) {
+		if b == nil {
+			return def, nil
+		}
+		k, ok := b.(int64)
+		if !ok {
+			return 0, fmt.Errorf("slice bound is a %s, expected an int", starType(b))
+		}
+		if k < 0 {
+			k += int64(n)
+		}
+		if k < 0 {
+			k = 0
+		}
+		if k > int64(n) {
+			k = int64(n)
+		}
+		return int(k), nil
+	}
+	start, err := bound(lo, 0)
+	if err != nil {
+		return nil, err
+	}
+	end, err := bound(hi, n)
+	if err != nil {
+		return nil, err
+	}
+	if end < start {
+		end = start
+	}
+	if s, ok := x.(string); ok {
+		return s[start:end], nil
+	}
+	return &starList{elems: append([]starValue(nil), x.(*starList).elems[start:end]...)}, nil
+}
+
+// Builtins and methods
+
+var starBuiltins map[string]*starBuiltin
+
+func init() {
+	starBuiltins = map[string]*starBuiltin{
+		"len": {name: "len", fn: func(args []starValue) (starValue, error) {
+			if err := starArity(args, 1, 1); err != nil {
+				return nil, err
+			}
+			switch v := args[0].(type) {
+			case string:
+				return int64(len(v)), nil
+			case *starList:
+				return int64(len(v.elems)), nil
+			case *starDict:
+				return int64(len(v.keys)), nil
+			}
+			return nil, fmt.Errorf("a %s has no length", starType(args[0]))
+		}},
+		"str": {name: "str", fn: func(args []starValue) (starValue, error) {
+			if err := starArity(args, 1, 1); err != nil {
+				return nil, err
+			}
+			return starString(args[0], false), nil
+		}},
+		"int": {name: "int", fn: func(args []starValue) (starValue, error) {
+			if err := starArity(args, 1, 1); err != nil {
+				return nil, err
+			}
+			switch v := args[0].(type) {
+			case int64:
+				return v, nil
+			case bool:
+				if v {
+					return int64(1), nil
+				}
+				return int64(0), nil
+			case string:
+				n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid int %q", v)
+				}
+				return n, nil
+			}
+			return nil, fmt.Errorf("can't convert a %s", starType(args[0]))
+		}},
+		"bool": {name: "bool", fn: func(args []starValue) (starValue, error) {
+			if err := starArity(args, 1, 1); err != nil {
+				return nil, err
+			}
+			return starTruth(args[0]), nil
+		}},
+		"fail": {name: "fail", fn: func(args []starValue) (starValue, error) {
+			msgs := make([]string, len(args))
+			for i, a := range args {
+				msgs[i] = starString(a, false)
+			}
+			return nil, errors.New(strings.Join(msgs, " "))
+		}},
+	}
+}
+
+func starArity(args []starValue, least, most int) error {
+	if len(args) < least || len(args) > most {
+		if least == most {
+			return fmt.Errorf("takes %d arguments, got %d", least, len(args))
+		}
+		return fmt.Errorf("takes %d to %d arguments, got %d", least, most, len(args))
+	}
+	return nil
+}
+
+func starStringArgs(args []starValue) ([]string, error) {
+	strs := make([]string, len(args))
+	for i, a := range args {
+		s, ok := a.(string)
+		if !ok {
+			return nil, fmt.Errorf("argument %d is a %s, expected a string", i+1, starType(a))
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
+
+// starStringMethods take the string and the arguments, already checked to be
+// strings for all but split, rsplit and join
+var starStringMethods = map[string]struct {
+	least, most int
+	fn          func(s string, args []string) starValue
+}{
+	"startswith":   {1, 1, func(s string, a []string) starValue { return strings.HasPrefix(s, a[0]) }},
+	"endswith":     {1, 1, func(s string, a []string) starValue { return strings.HasSuffix(s, a[0]) }},
+	"lower":        {0, 0, func(s string, a []string) starValue { return strings.ToLower(s) }},
+	"upper":        {0, 0, func(s string, a []string) starValue { return strings.ToUpper(s) }},
+	"strip":        {0, 0, func(s string, a []string) starValue { return strings.TrimSpace(s) }},
+	"lstrip":       {0, 0, func(s string, a []string) starValue { return strings.TrimLeftFunc(s, unicode.IsSpace) }},
+	"rstrip":       {0, 0, func(s string, a []string) starValue { return strings.TrimRightFunc(s, unicode.IsSpace) }},
+	"replace":      {2, 2, func(s string, a []string) starValue { return strings.ReplaceAll(s, a[0], a[1]) }},
+	"find":         {1, 1, func(s string, a []string) starValue { return int64(strings.Index(s, a[0])) }},
+	"count":        {1, 1, func(s string, a []string) starValue { return int64(strings.Count(s, a[0])) }},
+	"removeprefix": {1, 1, func(s string, a []string) starValue { return strings.TrimPrefix(s, a[0]) }},
+	"removesuffix": {1, 1, func(s string, a []string) starValue { return strings.TrimSuffix(s, a[0]) }},
+}
+
+func starStringList(strs []string) *starList {
+	l := &starList{elems: make([]starValue, len(strs))}
+	for i, s := range strs {
+		l.elems[i] = s
+	}
+	return l
+}
+
+// attribute returns the attribute name of v, the methods bound to it
+func starAttribute(v starValue, name string) (starValue, error) {
+	method := func(fn func(args []starValue) (starValue, error)) (starValue, error) {
+		return &starBuiltin{name: name, fn: fn}, nil
+	}
+	switch v := v.(type) {
+	case *starStruct:
+		if attr, ok := v.attrs[name]; ok {
+			return attr, nil
+		}
+	case string:
+		if m, ok := starStringMethods[name]; ok {
+			return method(func(args []starValue) (starValue, error) {
+				if err := starArity(args, m.least, m.most); err != nil {
+					return nil, err
+				}
+				strs, err := starStringArgs(args)
+				if err != nil {
+					return nil, err
+				}
+				return m.fn(v, strs), nil
+			})
+		}
+		switch name {
+		case "split", "rsplit":
+			return method(func(args []starValue) (starValue, error) {
+				if err := starArity(args, 0, 2); err != nil {
+					return nil, err
+				}
+				limit := int64(-1)
+				if len(args) == 2 {
+					n, ok := args[1].(int64)
+					if !ok {
+						return nil, errors.New("maxsplit isn't an int")
+					}
+					limit = n
+				}
+				if len(args) == 0 || args[0] == nil {
+					if limit >= 0 {
+						return nil, errors.New("maxsplit needs a separator here")
+					}
+					return starStringList(strings.Fields(v)), nil
+				}
+				sep, ok := args[0].(string)
+				if !ok || sep == "" {
+					return nil, errors.New("the separator must be a string that isn't empty")
+				}
+				if limit < 0 {
+					return starStringList(strings.Split(v, sep)), nil
+				}
+				if name == "split" {
+					return starStringList(strings.SplitN(v, sep, int(limit)+1)), nil
+				}
+				// rsplit cuts from the end
+				var parts []string
+				rest := v
+				for ; limit > 0; limit-- {
+					i := strings.LastIndex(rest, sep)
+					if i < 0 {
+						break
+					}
+					parts = append([]string{rest[i+len(sep):]}, parts...)
+					rest = rest[:i]
+				}
+				return starStringList(append([]string{rest}, parts...)), nil
+			})
+		case "join":
+			return method(func(args []starValue) (starValue, error) {
+				if err := starArity(args, 1, 1); err != nil {
+					return nil, err
+				}
+				l, ok := args[0].(*starList)
+				if !ok {
+					return nil, errors.New("expected a list")
+				}
+				strs, err := starStringArgs(l.elems)
+				if err != nil {
+					return nil, err
+				}
+				return strings.Join(strs, v), nil
+			})
+		}
+	case *starList:
+		if name == "append" {
+			return method(func(args []starValue) (starValue, error) {
+				if err := starArity(args, 1, 1); err != nil {
+					return nil, err
+				}
+				if v.frozen {
+					return nil, errors.New("can't change a frozen list")
+				}
+				if len(v.elems) >= starMaxLen {
+					return nil, fmt.Errorf("list longer than %d elements", starMaxLen)
+				}
+				v.elems = append(v.elems, args[0])
+				return nil, nil
+			})
+		}
+	case *starDict:
+		switch name {
+		case "get":
+			return method(func(args []starValue) (starValue, error) {
+				if err := starArity(args, 1, 2); err != nil {
+					return nil, err
+				}
+				if starHashable(args[0]) {
+					if value, ok := v.values[args[0]]; ok {
+						return value, nil
+					}
+				}
+				if len(args) == 2 {
+					return args[1], nil
+				}
+				return nil, nil
+			})
+		case "keys", "values", "items":
+			return method(func(args []starValue) (starValue, error) {
+				if err := starArity(args, 0, 0); err != nil {
+					return nil, err
+				}
+				l := &starList{}
+				for _, k := range v.keys {
+					switch name {
+					case "keys":
+						l.elems = append(l.elems, k)
+					case "values":
+						l.elems = append(l.elems, v.values[k])
+					default:
+						l.elems = append(l.elems, &starList{elems: []starValue{k, v.values[k]}})
+					}
+				}
+				return l, nil
+			})
+		}
+	}
+	return nil, fmt.Errorf("%s has no attribute %s", starType(v), name)
+}
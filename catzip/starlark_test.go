@@ -0,0 +1,70 @@
+package catzip
+
+import "testing"
+
+func TestStarlarkExpressions(t *testing.T) {
+	for _, test := range []struct{ expr, want string }{
+		{"1 + 2 * 3", "7"},
+		{"-7 // 2", "-4"},
+		{"-7 % 3", "2"},
+		{`"app.log"[:-4]`, `"app"`},
+		{`"app.log"[-3:]`, `"log"`},
+		{`"a/b/c.log".rsplit("/", 1)`, `["a/b", "c.log"]`},
+		{`"a b  c".split()`, `["a", "b", "c"]`},
+		{`"-".join(["a", "b"])`, `"a-b"`},
+		{`"x" if 1 > 2 else "y"`, `"y"`},
+		{`"og" in "log" and 3 not in [1, 2]`, "True"},
+		{`0 or "" or None`, "None"},
+		{`{"a": 1}.get("b", 2)`, "2"},
+		{`len({"a": 1, "b": 2}.items())`, "2"},
+		{`[1, 2] + [3] * 2`, "[1, 2, 3, 3]"},
+		{`str(12) + "ab" * 2`, `"12abab"`},
+		{`int(" 42 ") == 42`, "True"},
+		{`"A.CSV".lower().endswith(".csv")`, "True"},
+		{`"abc" < "abd" and not 2 >= 3`, "True"},
+	} {
+		script, err := compileStarlark("test", "def f():\n    return "+test.expr+"\n")
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		got, err := script.call("f")
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+		} else if s := starString(got, true); s != test.want {
+			t.Errorf("%s: got %s, expected %s", test.expr, s, test.want)
+		}
+	}
+}
+
+func TestStarlarkStatements(t *testing.T) {
+	script, err := compileStarlark("test", `
+def f(names):
+    found = []
+    n = 0
+    for name in names:
+        n += 1
+        if name == "skip":
+            continue
+        elif name == "stop":
+            break
+        else:
+            found.append(name)
+    counts = {}
+    for i, name in [[0, "a"], [1, "b"]]:
+        counts[name] = i
+    counts["a"] += 10
+    return [found, n, counts]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := &starList{elems: []starValue{"a", "skip", "b", "stop", "c"}}
+	got, err := script.call("f", names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := starString(got, true); s != `[["a", "b"], 4, {"a": 10, "b": 1}]` {
+		t.Errorf("got %s", s)
+	}
+}
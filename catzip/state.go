@@ -0,0 +1,118 @@
+package catzip
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runState remembers the input files processed by previous runs, so they
+// are skipped unless they changed
+type runState struct {
+	Inputs map[string]stateInput `json:"inputs"`
+}
+
+type stateInput struct {
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// loadState reads the state file, a missing file is an empty state
+func loadState(path string) (*runState, error) {
+	state := &runState{Inputs: map[string]stateInput{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Inputs == nil {
+		state.Inputs = map[string]stateInput{}
+	}
+	return state, nil
+}
+
+// save replaces the state file atomically
+func (s *runState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces path with data, a crash leaves either the previous
+// file or the new one
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *runState) unchanged(path string, info fs.FileInfo) bool {
+	previous, ok := s.Inputs[path]
+	return ok && previous.Size == info.Size() && previous.ModTime.Equal(info.ModTime())
+}
+
+func (s *runState) changes(files []string, infos map[string]fs.FileInfo) ([]stateChange, error) {
+	changes := make([]stateChange, len(files))
+	for i, f := range files {
+		if _, ok := s.Inputs[f]; !ok {
+			changes[i] = stateNew
+		} else if s.unchanged(f, infos[f]) {
+			changes[i] = stateUnchanged
+		} else {
+			changes[i] = stateChanged
+		}
+	}
+	return changes, nil
+}
+
+// changedFiles filters out the files that are unchanged since they were processed
+func changedFiles(state inputState, files []string, infos map[string]fs.FileInfo) ([]string, error) {
+	changes, err := state.changes(files, infos)
+	if err != nil {
+		return nil, err
+	}
+	changed := []string{}
+	for i, f := range files {
+		if changes[i] != stateUnchanged {
+			changed = append(changed, f)
+		}
+	}
+	return changed, nil
+}
+
+func (s *runState) record(path string, info fs.FileInfo) {
+	s.Inputs[path] = stateInput{Size: info.Size(), ModTime: info.ModTime(), ProcessedAt: time.Now()}
+}
+
+// newest is the modification time of the most recent input in the state
+func (s *runState) newest() time.Time {
+	var newest time.Time
+	for _, input := range s.Inputs {
+		if input.ModTime.After(newest) {
+			newest = input.ModTime
+		}
+	}
+	return newest
+}
@@ -0,0 +1,374 @@
+package catzip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// stateIndex is a state file for millions of inputs: a bloom filter of the
+// input paths, then a record per input sorted by path hash, of the hash and
+// of a hash of its size and modification time. Only the header and the bloom
+// filter are loaded, the inputs the filter rules out are new without reading
+// the records and the others are looked up in them.
+//
+//	magic [8]byte
+//	count, newest (Unix ns), bloom words uint64
+//	bloom [words]uint64
+//	records [count]struct{ key, value uint64 }
+type stateIndex struct {
+	path   string // "" when there is no file yet
+	count  uint64
+	latest time.Time // Modification time of the newest input
+	bloom  bloomFilter
+	// Recorded by this run or converted from a JSON state, by key
+	added map[uint64]uint64
+}
+
+const (
+	stateIndexMagic  = "CZSTATE1"
+	stateIndexHeader = 8 + 3*8
+	stateRecordSize  = 16
+	// Candidates looked up one by one instead of reading all the records
+	stateIndexLookups = 1024
+)
+
+// stateChange is an input compared with the state
+type stateChange int
+
+const (
+	stateNew stateChange = iota
+	stateChanged
+	stateUnchanged
+)
+
+// inputState is the state file, JSON or an index
+type inputState interface {
+	changes(files []string, infos map[string]fs.FileInfo) ([]stateChange, error)
+	record(path string, info fs.FileInfo)
+	newest() time.Time
+	save(path string) error
+}
+
+// openState reads the state file at path, an index or JSON. A JSON one is
+// converted to an index when index is set, a missing one is created in that
+// format.
+func openState(path string, index bool) (inputState, error) {
+	file, err := os.Open(path)
+	if err == nil {
+		magic := make([]byte, len(stateIndexMagic))
+		_, err = io.ReadFull(file, magic)
+		file.Close()
+		if err == nil && string(magic) == stateIndexMagic {
+			return loadStateIndex(path)
+		}
+	}
+
+	state, err := loadState(path)
+	if err != nil {
+		return nil, err
+	}
+	if !index {
+		return state, nil
+	}
+	s := &stateIndex{added: map[uint64]uint64{}}
+	for input, previous := range state.Inputs {
+		s.added[stateKey(input)] = stateValue(previous.Size, previous.ModTime)
+	}
+	s.latest = state.newest()
+	return s, nil
+}
+
+func loadStateIndex(path string) (*stateIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, stateIndexHeader)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	s := &stateIndex{path: path, added: map[uint64]uint64{}}
+	s.count = binary.LittleEndian.Uint64(header[8:])
+	if newest := int64(binary.LittleEndian.Uint64(header[16:])); newest != 0 {
+		s.latest = time.Unix(0, newest)
+	}
+	words := binary.LittleEndian.Uint64(header[24:])
+	if words > uint64(info.Size())/8 || s.count > uint64(info.Size())/stateRecordSize || uint64(info.Size()) != stateIndexHeader+words*8+s.count*stateRecordSize {
+		return nil, errors.New("invalid state index, its size doesn't match its header")
+	}
+	s.bloom = make(bloomFilter, words)
+	if err := binary.Read(bufio.NewReader(file), binary.LittleEndian, []uint64(s.bloom)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return s, nil
+}
+
+// stateKey and stateValue are the hashes of the records
+func stateKey(path string) uint64 {
+	h := newXXH3()
+	io.WriteString(h, path)
+	return h.Sum64()
+}
+
+func stateValue(size int64, modTime time.Time) uint64 {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(size))
+	binary.LittleEndian.PutUint64(b[8:], uint64(modTime.UnixNano()))
+	h := newXXH3()
+	h.Write(b[:])
+	return h.Sum64()
+}
+
+func (s *stateIndex) changes(files []string, infos map[string]fs.FileInfo) ([]stateChange, error) {
+	changes := make([]stateChange, len(files))
+	type candidate struct {
+		key, value uint64
+		i          int
+	}
+	var candidates []candidate
+	for i, f := range files {
+		key, value := stateKey(f), stateValue(infos[f].Size(), infos[f].ModTime())
+		if added, ok := s.added[key]; ok {
+			changes[i] = compareState(added, value)
+		} else if s.bloom.has(key) {
+			candidates = append(candidates, candidate{key, value, i})
+		}
+	}
+	if len(candidates) == 0 {
+		return changes, nil
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records := int64(stateIndexHeader + len(s.bloom)*8)
+
+	if len(candidates) <= stateIndexLookups {
+		for _, c := range candidates {
+			value, ok, err := s.lookup(file, records, c.key)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				changes[c.i] = compareState(value, c.value)
+			}
+		}
+		return changes, nil
+	}
+
+	// Merged with the records, read once in order
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].key < candidates[j].key })
+	err = s.scan(file, records, func(key, value uint64) bool {
+		for len(candidates) > 0 && candidates[0].key <= key {
+			if candidates[0].key == key {
+				changes[candidates[0].i] = compareState(value, candidates[0].value)
+			}
+			candidates = candidates[1:]
+		}
+		return len(candidates) > 0
+	})
+	return changes, err
+}
+
+func compareState(recorded, value uint64) stateChange {
+	if recorded == value {
+		return stateUnchanged
+	}
+	return stateChanged
+}
+
+// lookup binary searches the records starting at offset records for key
+func (s *stateIndex) lookup(file *os.File, records int64, key uint64) (uint64, bool, error) {
+	record := make([]byte, stateRecordSize)
+	var err error
+	i := sort.Search(int(s.count), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		_, err = file.ReadAt(record, records+int64(i)*stateRecordSize)
+		return binary.LittleEndian.Uint64(record) >= key
+	})
+	if err != nil || i == int(s.count) {
+		return 0, false, unexpectedEOF(err)
+	}
+	if _, err = file.ReadAt(record, records+int64(i)*stateRecordSize); err != nil {
+		return 0, false, unexpectedEOF(err)
+	}
+	if binary.LittleEndian.Uint64(record) != key {
+		return 0, false, nil
+	}
+	return binary.LittleEndian.Uint64(record[8:]), true, nil
+}
+
+// scan calls f with the records starting at offset records in order, until
+// it returns false
+func (s *stateIndex) scan(file *os.File, records int64, f func(key, value uint64) bool) error {
+	if _, err := file.Seek(records, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(file, 1<<20)
+	record := make([]byte, stateRecordSize)
+	for i := uint64(0); i < s.count; i++ {
+		if _, err := io.ReadFull(reader, record); err != nil {
+			return unexpectedEOF(err)
+		}
+		if !f(binary.LittleEndian.Uint64(record), binary.LittleEndian.Uint64(record[8:])) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *stateIndex) record(path string, info fs.FileInfo) {
+	s.added[stateKey(path)] = stateValue(info.Size(), info.ModTime())
+	if info.ModTime().After(s.latest) {
+		s.latest = info.ModTime()
+	}
+}
+
+func (s *stateIndex) newest() time.Time { return s.latest }
+
+// save merges the records of the index with the added ones into a new index
+// at path, replacing it atomically
+func (s *stateIndex) save(path string) error {
+	added := make([]uint64, 0, len(s.added))
+	for key := range s.added {
+		added = append(added, key)
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if err = s.write(tmp, added); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// write writes the merged index to tmp, the records first and then the
+// header and the bloom filter, only known once they are merged
+func (s *stateIndex) write(tmp *os.File, added []uint64) error {
+	bloom := newBloomFilter(s.count + uint64(len(added)))
+	records := int64(stateIndexHeader + len(bloom)*8)
+	if _, err := tmp.Seek(records, io.SeekStart); err != nil {
+		return err
+	}
+	writer := bufio.NewWriterSize(tmp, 1<<20)
+	record := make([]byte, stateRecordSize)
+	var count uint64
+	put := func(key, value uint64) error {
+		binary.LittleEndian.PutUint64(record, key)
+		binary.LittleEndian.PutUint64(record[8:], value)
+		bloom.add(key)
+		count++
+		_, err := writer.Write(record)
+		return err
+	}
+
+	var err error
+	if s.path != "" && s.count > 0 {
+		var file *os.File
+		if file, err = os.Open(s.path); err != nil {
+			return err
+		}
+		defer file.Close()
+		err = s.scan(file, int64(stateIndexHeader+len(s.bloom)*8), func(key, value uint64) bool {
+			for len(added) > 0 && added[0] <= key && err == nil {
+				if added[0] == key {
+					value = s.added[key]
+				} else {
+					err = put(added[0], s.added[added[0]])
+				}
+				added = added[1:]
+			}
+			if err == nil {
+				err = put(key, value)
+			}
+			return err == nil
+		})
+	}
+	for _, key := range added {
+		if err != nil {
+			break
+		}
+		err = put(key, s.added[key])
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, stateIndexHeader, records)
+	copy(header, stateIndexMagic)
+	binary.LittleEndian.PutUint64(header[8:], count)
+	if !s.latest.IsZero() {
+		binary.LittleEndian.PutUint64(header[16:], uint64(s.latest.UnixNano()))
+	}
+	binary.LittleEndian.PutUint64(header[24:], uint64(len(bloom)))
+	for _, word := range bloom {
+		header = binary.LittleEndian.AppendUint64(header, word)
+	}
+	_, err = tmp.WriteAt(header, 0)
+	return err
+}
+
+// bloomFilter has about 1% of false positives with 10 bits per key
+type bloomFilter []uint64
+
+const bloomHashes = 7
+
+func newBloomFilter(keys uint64) bloomFilter {
+	return make(bloomFilter, (keys*10+63)/64+1)
+}
+
+// positions derives the bits of key from two halves of its hash, which is
+// already uniform
+func (b bloomFilter) positions(key uint64, f func(bit uint64)) {
+	bits := uint64(len(b)) * 64
+	h1, h2 := key, xxh64Avalanche(key)|1
+	for i := uint64(0); i < bloomHashes; i++ {
+		f((h1 + i*h2) % bits)
+	}
+}
+
+func (b bloomFilter) add(key uint64) {
+	b.positions(key, func(bit uint64) { b[bit/64] |= 1 << (bit % 64) })
+}
+
+func (b bloomFilter) has(key uint64) bool {
+	if len(b) == 0 {
+		return false
+	}
+	has := true
+	b.positions(key, func(bit uint64) {
+		if b[bit/64]&(1<<(bit%64)) == 0 {
+			has = false
+		}
+	})
+	return has
+}
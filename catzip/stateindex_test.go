@@ -0,0 +1,213 @@
+package catzip
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var stateModTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// stateInputs returns the inputs input-from to input-to, modified at
+// modTime, with their infos
+func stateInputs(from, to int, modTime time.Time) ([]string, map[string]fs.FileInfo) {
+	fsys := fstest.MapFS{}
+	var files []string
+	for i := from; i < to; i++ {
+		name := fmt.Sprintf("input-%d", i)
+		fsys[name] = &fstest.MapFile{Data: []byte(name), ModTime: modTime}
+		files = append(files, name)
+	}
+	infos := map[string]fs.FileInfo{}
+	for _, name := range files {
+		infos[name], _ = fs.Stat(fsys, name)
+	}
+	return files, infos
+}
+
+// checkChanges compares the changes of files with expected, by index
+func checkChanges(t *testing.T, what string, state inputState, files []string, infos map[string]fs.FileInfo, expected func(i int) stateChange) {
+	t.Helper()
+	changes, err := state.changes(files, infos)
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	wrong := 0
+	for i, change := range changes {
+		if change != expected(i) {
+			if wrong < 5 {
+				t.Errorf("%s: %s is %d, expected %d", what, files[i], change, expected(i))
+			}
+			wrong++
+		}
+	}
+	if wrong > 0 {
+		t.Errorf("%s: %d wrong changes", what, wrong)
+	}
+}
+
+func recordInputs(state inputState, files []string, infos map[string]fs.FileInfo) {
+	for _, f := range files {
+		state.record(f, infos[f])
+	}
+}
+
+func TestStateIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	state, err := openState(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, infos := stateInputs(0, 3000, stateModTime)
+	checkChanges(t, "no state", state, files, infos, func(int) stateChange { return stateNew })
+	recordInputs(state, files, infos)
+	if err := state.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// input-0 to input-999 modified since, input-3000 to input-3999 new
+	modified, modifiedInfos := stateInputs(0, 1000, stateModTime.Add(time.Second))
+	added, addedInfos := stateInputs(3000, 4000, stateModTime)
+	all := append(append(append([]string{}, modified...), files[1000:]...), added...)
+	allInfos := map[string]fs.FileInfo{}
+	for _, m := range []map[string]fs.FileInfo{infos, modifiedInfos, addedInfos} {
+		for name, info := range m {
+			allInfos[name] = info
+		}
+	}
+	expected := func(i int) stateChange {
+		switch {
+		case i < 1000:
+			return stateChanged
+		case i < 3000:
+			return stateUnchanged
+		}
+		return stateNew
+	}
+
+	state, err = openState(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, ok := state.(*stateIndex)
+	if !ok || index.count != 3000 || !index.newest().Equal(stateModTime) {
+		t.Fatalf("reopened as %T", state)
+	}
+	// Merged with the records, then a few looked up one by one
+	checkChanges(t, "scanned", state, all, allInfos, expected)
+	few := []string{all[0], all[1500], all[3500]}
+	checkChanges(t, "looked up", state, few, allInfos, func(i int) stateChange { return expected([]int{0, 1500, 3500}[i]) })
+
+	// Merged with the records of the file
+	recordInputs(state, modified, modifiedInfos)
+	recordInputs(state, added, addedInfos)
+	if err := state.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if state, err = openState(path, true); err != nil {
+		t.Fatal(err)
+	}
+	if count := state.(*stateIndex).count; count != 4000 {
+		t.Errorf("%d records after merging", count)
+	}
+	if !state.newest().Equal(stateModTime.Add(time.Second)) {
+		t.Errorf("newest %v", state.newest())
+	}
+	checkChanges(t, "merged", state, all, allInfos, func(int) stateChange { return stateUnchanged })
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("%d files left beside the index", len(entries))
+	}
+}
+
+// A JSON state is converted to an index when it is saved
+func TestStateIndexFromJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	files, infos := stateInputs(0, 100, stateModTime)
+	json := &runState{Inputs: map[string]stateInput{}}
+	for _, f := range files {
+		json.Inputs[f] = stateInput{Size: infos[f].Size(), ModTime: infos[f].ModTime()}
+	}
+	if err := json.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, index := range []bool{true, false} {
+		state, err := openState(path, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := state.(*stateIndex); ok != index {
+			t.Errorf("opened as %T with index %v", state, index)
+		}
+		checkChanges(t, fmt.Sprintf("index %v", index), state, files, infos, func(int) stateChange { return stateUnchanged })
+	}
+
+	state, _ := openState(path, true)
+	if err := state.save(path); err != nil {
+		t.Fatal(err)
+	}
+	state, err := openState(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.(*stateIndex); !ok {
+		t.Fatalf("saved as %T", state)
+	}
+	checkChanges(t, "converted", state, files, infos, func(int) stateChange { return stateUnchanged })
+}
+
+func TestBloomFilter(t *testing.T) {
+	const keys = 10000
+	bloom := newBloomFilter(keys)
+	for i := 0; i < keys; i++ {
+		bloom.add(stateKey(fmt.Sprint(i)))
+	}
+	falsePositives := 0
+	for i := 0; i < keys; i++ {
+		if !bloom.has(stateKey(fmt.Sprint(i))) {
+			t.Fatalf("%d added but not in the filter", i)
+		}
+		if bloom.has(stateKey(fmt.Sprint("other ", i))) {
+			falsePositives++
+		}
+	}
+	// About 1%
+	if falsePositives > keys/50 {
+		t.Errorf("%d false positives of %d", falsePositives, keys)
+	}
+	if (bloomFilter{}).has(1) {
+		t.Error("an empty filter has a key")
+	}
+}
+
+func TestStateIndexCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state")
+	files, infos := stateInputs(0, 300, stateModTime)
+	state, _ := openState(path, true)
+	recordInputs(state, files, infos)
+	if err := state.save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt")
+	checkCorrupt(t, data, func(data []byte) error {
+		if err := os.WriteFile(corrupt, data, 0644); err != nil {
+			return err
+		}
+		state, err := openState(corrupt, true)
+		if err != nil {
+			return err
+		}
+		_, err = state.changes(files, infos)
+		return err
+	})
+}
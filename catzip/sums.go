@@ -0,0 +1,188 @@
+package catzip
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileSum is a line of a checksum file, the hash of a file under the
+// directory it lists
+type FileSum struct {
+	Path string // Slash separated, relative to the directory
+	Sum  string
+}
+
+// SumsFileName is the name of the checksum file of a hash algorithm, like
+// SHA256SUMS
+func SumsFileName(algorithm string) string {
+	return strings.ToUpper(algorithm) + "SUMS"
+}
+
+// SumFiles hashes the regular files under dir with one of HashAlgorithms,
+// workers at a time, sorted by path. The checksum files at the top of dir,
+// like SHA256SUMS, are left out.
+func SumFiles(dir string, algorithm string, workers int) ([]FileSum, error) {
+	newHash, err := parseSumsHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	sumsFiles := map[string]bool{}
+	for _, name := range HashAlgorithms() {
+		sumsFiles[SumsFileName(name)] = true
+	}
+
+	var sums []FileSum
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if !sumsFiles[rel] {
+			sums = append(sums, FileSum{Path: filepath.ToSlash(rel)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sums, func(i, j int) bool { return sums[i].Path < sums[j].Path })
+
+	got, errs := hashFiles(dir, sums, newHash, workers)
+	for i := range sums {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		sums[i].Sum = got[i]
+	}
+	return sums, nil
+}
+
+// CheckSums hashes again the files of sums under dir, workers at a time,
+// returning the ones that are missing or changed
+func CheckSums(dir string, algorithm string, sums []FileSum, workers int) ([]Drift, error) {
+	newHash, err := parseSumsHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []Drift
+	got, errs := hashFiles(dir, sums, newHash, workers)
+	for i, s := range sums {
+		switch {
+		case errs[i] != nil:
+			drifts = append(drifts, Drift{Path: s.Path, Problem: errs[i].Error()})
+		case got[i] != strings.ToLower(s.Sum):
+			drifts = append(drifts, Drift{Path: s.Path, Problem: fmt.Sprintf("hash is %s, expected %s", got[i], s.Sum)})
+		}
+	}
+	return drifts, nil
+}
+
+func parseSumsHash(algorithm string) (func() hash.Hash, error) {
+	newHash, err := parseHash(algorithm)
+	if err == nil && newHash == nil {
+		err = errors.New("no hash algorithm")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w, expected one of %v", err, HashAlgorithms())
+	}
+	return newHash, nil
+}
+
+// hashFiles hashes the files of sums under dir, workers at a time, with the
+// error of each one
+func hashFiles(dir string, sums []FileSum, newHash func() hash.Hash, workers int) ([]string, []error) {
+	if workers < 1 {
+		workers = 1
+	}
+	got := make([]string, len(sums))
+	errs := make([]error, len(sums))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				got[i], errs[i] = hashFile(filepath.Join(dir, filepath.FromSlash(sums[i].Path)), newHash())
+			}
+		}()
+	}
+	for i := range sums {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return got, errs
+}
+
+func hashFile(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteSums writes sums in the format of sha256sum and the other coreutils,
+// the paths with a newline or a backslash escaped on a line starting with a
+// backslash
+func WriteSums(w io.Writer, sums []FileSum) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range sums {
+		path := s.Path
+		if strings.ContainsAny(path, "\\\n") {
+			path = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(path)
+			bw.WriteByte('\\')
+		}
+		fmt.Fprintf(bw, "%s  %s\n", s.Sum, path)
+	}
+	return bw.Flush()
+}
+
+// ReadSums reads a checksum file written by WriteSums or the coreutils, in
+// text or binary mode
+func ReadSums(r io.Reader) ([]FileSum, error) {
+	var sums []FileSum
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		escaped := text[0] == '\\'
+		if escaped {
+			text = text[1:]
+		}
+		sum, path, ok := strings.Cut(text, " ")
+		if !ok || path == "" || (path[0] != ' ' && path[0] != '*') {
+			return nil, fmt.Errorf("line %d isn't a checksum line", line)
+		}
+		path = path[1:]
+		if escaped {
+			path = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(path)
+		}
+		sums = append(sums, FileSum{Path: path, Sum: sum})
+	}
+	return sums, scanner.Err()
+}
@@ -0,0 +1,129 @@
+package catzip
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// handleTar extracts the members of a tar file of kind like the entries of a
+// zip file. The members are read in order, so they are written one at a time.
+func (r *run) handleTar(f string, kind inputKind) error {
+	reader, closer, err := openTar(r.in, f, kind)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	destination, err := filepath.Abs(r.opts.OutDir)
+	if err != nil {
+		return fmt.Errorf("unable to find absolute path for dir %s: %w", r.opts.OutDir, err)
+	}
+
+	archive := manifestArchive{Path: f}
+	for i := 0; ; i++ {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read tar file: %w", err)
+		}
+
+		entry := &EntryInfo{
+			Archive: f,
+			Name:    header.Name,
+			Size:    uint64(header.Size),
+			Mode:    header.FileInfo().Mode(),
+			ModTime: header.ModTime,
+			Entry:   i + 1,
+			index:   i,
+		}
+		if r.opts.Handlers["tar"].Xattrs {
+			entry.Xattrs = xattrs(header)
+		}
+		if reason := unsupportedTarReason(header); reason != "" {
+			r.skipEntry(entry, reason)
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		name := r.renamed(header.Name)
+		if name == "" {
+			r.skipEntry(entry, "renamed to an empty name")
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+
+		isDir := header.Typeflag == tar.TypeDir
+		if isDir && filepath.Clean(name) == "." {
+			// The ./ member of tar files made with -C dir .
+			continue
+		}
+		if !isDir {
+			var ok bool
+			if name, ok, err = r.applyPolicy(entry, name); err != nil {
+				return err
+			}
+			if !ok {
+				archive.Entries = append(archive.Entries, entry)
+				continue
+			}
+		}
+
+		open := func() (io.ReadCloser, error) { return io.NopCloser(reader), nil }
+		if err := r.extractEntry(name, entry, destination, isDir, header.Size, open); err != nil {
+			return fmt.Errorf("unable to extract file inside archive: %w", err)
+		}
+		if entry.Output != "" {
+			archive.Entries = append(archive.Entries, entry)
+		}
+	}
+	r.manifest.add(archive)
+	return nil
+}
+
+// unsupportedTarReason tells why the member of header can't be extracted, or
+// "" when it can. Links are left out rather than pointing outside OutDir.
+func unsupportedTarReason(header *tar.Header) string {
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeDir:
+		return ""
+	case tar.TypeSymlink, tar.TypeLink:
+		return "link to " + header.Linkname
+	default:
+		return fmt.Sprintf("unsupported tar member type %q", header.Typeflag)
+	}
+}
+
+// openTar opens the tar file name, decompressing it for kind
+func openTar(fsys fs.FS, name string, kind inputKind) (*tar.Reader, io.Closer, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch kind {
+	case kindTarGz:
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(gzReader), closers{gzReader, file}, nil
+	case kindTarXz, kindTarZst, kindTarBz2, kindTarLz4:
+		reader := decompressor(kind.compression(), file)
+		return tar.NewReader(reader), closers{reader, file}, nil
+	case kindDeb:
+		data, err := openDebData(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(data), closers{data, file}, nil
+	}
+	return tar.NewReader(file), file, nil
+}
@@ -0,0 +1,252 @@
+package catzip
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// EntryInfo.LineEndings values, with Options.DetectText. Files without line
+// terminators have none.
+const (
+	LineEndingsLF    = "lf"
+	LineEndingsCRLF  = "crlf"
+	LineEndingsCR    = "cr"
+	LineEndingsMixed = "mixed"
+)
+
+// EntryInfo.Encoding values, with Options.DetectText
+const (
+	EncodingASCII   = "ascii"
+	EncodingUTF8    = "utf-8"
+	EncodingUTF8BOM = "utf-8-bom"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	// Not UTF-8, a single byte encoding like Latin-1 or Windows-1252
+	Encoding8Bit   = "8bit"
+	EncodingBinary = "binary"
+)
+
+// Encodings lists the values of EntryInfo.Encoding
+func Encodings() []string {
+	return []string{EncodingASCII, EncodingUTF8, EncodingUTF8BOM, EncodingUTF16LE, EncodingUTF16BE, Encoding8Bit, EncodingBinary}
+}
+
+// Bytes from the start of an entry looked at to tell UTF-16 from the rest
+const textSampleSize = 512
+
+// textDetector finds the line terminators and the encoding of the content
+// written to it. The encoding comes from the byte order mark, or from where
+// the NUL bytes of the start are for UTF-16 without one.
+type textDetector struct {
+	head  []byte // Until the width of the code units is known
+	width int    // 1, or 2 for UTF-16
+	big   bool   // UTF-16 big-endian
+	bom   bool
+
+	lf, crlf, cr int
+	prevCR       bool
+	odd          []byte // Half a UTF-16 code unit
+
+	nul      bool
+	nonASCII bool
+	invalid  bool   // Not UTF-8
+	rune     []byte // UTF-8 sequence cut by the end of a write
+}
+
+func (d *textDetector) Write(p []byte) (int, error) {
+	n := len(p)
+	if d.width == 0 {
+		d.head = append(d.head, p...)
+		if len(d.head) < textSampleSize {
+			return n, nil
+		}
+		p = d.start()
+	}
+	d.scan(p)
+	return n, nil
+}
+
+// start picks the width of the code units from the start of the content,
+// and returns it without the byte order mark
+func (d *textDetector) start() []byte {
+	head := d.head
+	d.head = nil
+	d.width = 1
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		d.bom = true
+		return head[3:]
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		d.width, d.bom = 2, true
+		return head[2:]
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		d.width, d.big, d.bom = 2, true, true
+		return head[2:]
+	}
+
+	// Mostly ASCII text in UTF-16 has a NUL in every other byte
+	var even, odd int
+	for i, b := range head {
+		if b == 0 {
+			if i%2 == 0 {
+				even++
+			} else {
+				odd++
+			}
+		}
+	}
+	units := len(head) / 2
+	switch {
+	case units > 0 && odd*10 >= units*6 && even*10 < units:
+		d.width = 2
+	case units > 0 && even*10 >= units*6 && odd*10 < units:
+		d.width, d.big = 2, true
+	}
+	return head
+}
+
+func (d *textDetector) scan(p []byte) {
+	if d.width == 2 {
+		d.scanUTF16(p)
+		return
+	}
+
+	for _, b := range p {
+		d.terminator(rune(b))
+		if b == 0 {
+			d.nul = true
+		}
+	}
+
+	if !d.invalid {
+		d.validate(p)
+	}
+}
+
+// validate checks that p continues valid UTF-8, across writes
+func (d *textDetector) validate(p []byte) {
+	if len(d.rune) > 0 {
+		need := 0
+		switch b := d.rune[0]; {
+		case b >= 0xF0:
+			need = 4
+		case b >= 0xE0:
+			need = 3
+		default:
+			need = 2
+		}
+		take := need - len(d.rune)
+		if take > len(p) {
+			take = len(p)
+		}
+		d.rune = append(d.rune, p[:take]...)
+		p = p[take:]
+		if len(d.rune) < need {
+			return
+		}
+		if !utf8.Valid(d.rune) {
+			d.invalid = true
+			return
+		}
+		d.nonASCII = true
+		d.rune = d.rune[:0]
+	}
+
+	// Keep a sequence cut by the end for the next write
+	cut := len(p)
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	for _, b := range p[:cut] {
+		if b >= utf8.RuneSelf {
+			d.nonASCII = true
+			break
+		}
+	}
+	if !utf8.Valid(p[:cut]) {
+		d.invalid = true
+		return
+	}
+	d.rune = append(d.rune, p[cut:]...)
+}
+
+func (d *textDetector) scanUTF16(p []byte) {
+	if len(d.odd) > 0 {
+		p = append(d.odd, p...)
+		d.odd = nil
+	}
+	for i := 0; i+1 < len(p); i += 2 {
+		unit := rune(p[i]) | rune(p[i+1])<<8
+		if d.big {
+			unit = rune(p[i])<<8 | rune(p[i+1])
+		}
+		d.terminator(unit)
+	}
+	if len(p)%2 == 1 {
+		d.odd = []byte{p[len(p)-1]}
+	}
+}
+
+// terminator counts the line terminators, c is the next character
+func (d *textDetector) terminator(c rune) {
+	switch {
+	case c == '\n' && d.prevCR:
+		d.crlf++
+	case c == '\n':
+		d.lf++
+	case d.prevCR:
+		d.cr++
+	}
+	d.prevCR = c == '\r'
+}
+
+// result returns the line endings and the encoding, both empty when nothing
+// was written
+func (d *textDetector) result() (string, string) {
+	if d.width == 0 {
+		if len(d.head) == 0 {
+			return "", ""
+		}
+		d.scan(d.start())
+	}
+	if d.prevCR {
+		d.cr++
+		d.prevCR = false
+	}
+
+	var encoding string
+	switch {
+	case d.width == 2 && d.big:
+		encoding = EncodingUTF16BE
+	case d.width == 2:
+		encoding = EncodingUTF16LE
+	case d.nul:
+		return "", EncodingBinary
+	case d.invalid || len(d.rune) > 0:
+		encoding = Encoding8Bit
+	case d.bom:
+		encoding = EncodingUTF8BOM
+	case d.nonASCII:
+		encoding = EncodingUTF8
+	default:
+		encoding = EncodingASCII
+	}
+
+	var endings string
+	switch {
+	case d.lf > 0 && d.crlf == 0 && d.cr == 0:
+		endings = LineEndingsLF
+	case d.crlf > 0 && d.lf == 0 && d.cr == 0:
+		endings = LineEndingsCRLF
+	case d.cr > 0 && d.lf == 0 && d.crlf == 0:
+		endings = LineEndingsCR
+	case d.lf+d.crlf+d.cr > 0:
+		endings = LineEndingsMixed
+	}
+	return endings, encoding
+}
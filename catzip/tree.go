@@ -0,0 +1,102 @@
+package catzip
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TreeNode is an input file, or a directory or a file inside it, see Tree
+type TreeNode struct {
+	Name string `json:"name"` // The path of an input file
+	// Of a file, or everything under a directory or an input file. -1 when
+	// the headers of a compressed file don't have it.
+	Size    int64      `json:"size"`
+	ModTime *time.Time `json:"mtime,omitempty"` // Directories only listed in paths have none
+	Dir     bool       `json:"dir,omitempty"`
+	// Files and directories under it, sorted by name
+	Children []*TreeNode `json:"children,omitempty"`
+}
+
+// Tree returns the directory tree of each input file selected by opts from
+// the headers, without extracting anything
+func Tree(opts Options) ([]*TreeNode, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	in := inputFS(opts)
+	defer closeInputs(in)
+	files, infos, _, err := selectInputs(opts, in)
+	if err != nil {
+		return nil, err
+	}
+
+	passthrough := parseExtList(opts.PassthroughExt)
+	trees := []*TreeNode{}
+	for _, f := range files {
+		_, entries, err := listEntries(in, f, opts.fileKind(in, f), passthrough[filepath.Ext(f)])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+
+		modTime := infos[f].ModTime()
+		root := &TreeNode{Name: f, ModTime: &modTime, Dir: true}
+		for _, e := range entries {
+			root.add(e)
+		}
+		root.finish()
+		trees = append(trees, root)
+	}
+	return trees, nil
+}
+
+// add places e under n, creating the directories of its path
+func (n *TreeNode) add(e listedEntry) {
+	name := strings.Trim(path.Clean("/"+filepath.ToSlash(e.name)), "/")
+	if name == "" {
+		return
+	}
+
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		n = n.child(part, true)
+	}
+	leaf := n.child(parts[len(parts)-1], e.dir)
+	modTime := e.modTime
+	leaf.ModTime = &modTime
+	if !e.dir {
+		leaf.Size = e.size
+	}
+}
+
+// child returns the child of n named name, adding it when it is missing
+func (n *TreeNode) child(name string, dir bool) *TreeNode {
+	for _, c := range n.Children {
+		if c.Name == name && c.Dir == dir {
+			return c
+		}
+	}
+	c := &TreeNode{Name: name, Dir: dir}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// finish sorts the children of n and adds up the sizes of the directories
+func (n *TreeNode) finish() {
+	if !n.Dir {
+		return
+	}
+	sort.SliceStable(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	n.Size = 0
+	for _, c := range n.Children {
+		c.finish()
+		if c.Size < 0 || n.Size < 0 {
+			n.Size = -1
+			continue
+		}
+		n.Size += c.Size
+	}
+}
@@ -0,0 +1,405 @@
+package catzip
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options.URLs schemes
+var urlSchemes = []string{"http", "https", "s3", "gs", "az", "sftp", "webdav", "webdavs"}
+
+// Schemes of the buckets Options.Dir can list, and of the remote
+// directories listed like them
+var bucketSchemes = []string{"s3", "gs", "az", "sftp", "webdav", "webdavs"}
+
+// isBucketDir tells whether Options.Dir is a bucket, like
+// s3://bucket/prefix, listed instead of walked
+func isBucketDir(dir string) bool {
+	for _, scheme := range bucketSchemes {
+		if strings.HasPrefix(dir, scheme+"://") {
+			return true
+		}
+	}
+	return false
+}
+
+// bucketObject is an object of the listing of a bucket
+type bucketObject struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// urlInputs adds the Options.URLs to the inputs of fs.FS, each named after
+// the last element of its path inside OutDir, where single compressed ones
+// are extracted next to it, and the objects of a bucket Options.Dir. They
+// are downloaded as they are read, so zip ones are read from their local
+// headers.
+type urlInputs struct {
+	fs.FS
+	outDir  string
+	exts    []string
+	names   []string // In the order of Options.URLs
+	client  *http.Client
+	s3      *s3Client
+	gcs     *gcsClient
+	azure   *azureClient
+	sftp    *sftpClients
+	webdav  *webdavClient
+	retries int
+	logger  *log.Logger
+
+	urlsMu sync.RWMutex
+	urls   map[string]string // By name, the listed objects too
+
+	mu    sync.Mutex
+	infos map[string]fs.FileInfo
+}
+
+func newURLInputs(opts Options, base fs.FS) *urlInputs {
+	u := &urlInputs{
+		FS:      base,
+		outDir:  opts.OutDir,
+		exts:    splitExts(opts.Ext),
+		urls:    map[string]string{},
+		client:  opts.HTTPClient,
+		retries: opts.URLRetries,
+		logger:  opts.logger(),
+		infos:   map[string]fs.FileInfo{},
+	}
+	if u.client == nil {
+		u.client = http.DefaultClient
+	}
+	u.s3 = newS3Client(opts, u.client)
+	u.gcs = newGCSClient(opts)
+	u.azure = newAzureClient(opts)
+	u.sftp = newSFTPClients(opts)
+	u.webdav = newWebDAVClient(opts)
+	for _, raw := range opts.URLs {
+		base := "download"
+		if parsed, err := url.Parse(raw); err == nil {
+			if b := path.Base(parsed.Path); b != "/" && b != "." {
+				base = b
+			}
+		}
+		u.names = append(u.names, u.add(base, raw))
+	}
+	return u
+}
+
+// add names raw after the slash separated rel inside OutDir, numbering it
+// when the name is taken
+func (u *urlInputs) add(rel string, raw string) string {
+	u.urlsMu.Lock()
+	defer u.urlsMu.Unlock()
+	dir, base := path.Split(rel)
+	name := filepath.Join(u.outDir, filepath.FromSlash(rel))
+	// The number goes before the extension, which tells the format
+	ext := matchExt(base, u.exts)
+	if ext == "" {
+		ext = filepath.Ext(base)
+	}
+	for i := 1; u.urls[name] != ""; i++ {
+		name = filepath.Join(u.outDir, filepath.FromSlash(dir), fmt.Sprintf("%s(%d)%s", base[:len(base)-len(ext)], i, ext))
+	}
+	u.urls[name] = raw
+	return name
+}
+
+func (u *urlInputs) url(name string) (string, bool) {
+	u.urlsMu.RLock()
+	defer u.urlsMu.RUnlock()
+	raw, ok := u.urls[name]
+	return raw, ok
+}
+
+// list returns the objects of the bucket dir, like s3://bucket/prefix,
+// selected by opts, each named inside OutDir after its key past the last
+// slash of prefix. A listing that fails is logged, like the directories that
+// can't be read.
+func (u *urlInputs) list(dir string, opts walkOptions) []foundInput {
+	parsed, err := url.Parse(dir)
+	if err != nil {
+		opts.logger.Printf("unable to list %s: %v", dir, err)
+		return nil
+	}
+	prefix := strings.TrimPrefix(parsed.Path, "/")
+	var objects []bucketObject
+	switch parsed.Scheme {
+	case "sftp", "webdav", "webdavs":
+		// A directory, its files are named after their path inside it
+		if prefix = strings.TrimSuffix(prefix, "/"); prefix != "" {
+			prefix += "/"
+		}
+		if parsed.Scheme == "sftp" {
+			objects, err = u.listSFTP(parsed)
+		} else {
+			objects, err = u.listWebDAV(parsed)
+		}
+	case "gs":
+		objects, err = u.listGCS(parsed.Host, prefix)
+	case "az":
+		objects, err = u.listAzure(parsed.Host, prefix)
+	default:
+		objects, err = u.listS3(parsed.Host, prefix)
+	}
+	if err != nil {
+		opts.logger.Printf("unable to list %s: %v", dir, err)
+		return nil
+	}
+
+	// All of them first, so the markers of RequireMarker are found
+	var listed []foundInput
+	for _, object := range objects {
+		rel := strings.TrimPrefix(object.Key, prefix[:strings.LastIndex(prefix, "/")+1])
+		if strings.HasSuffix(rel, "/") {
+			// The placeholder of a folder
+			continue
+		}
+		if !fs.ValidPath(rel) {
+			opts.logger.Printf("skipping %s://%s/%s, its key isn't a valid path", parsed.Scheme, parsed.Host, object.Key)
+			continue
+		}
+		name := u.add(rel, (&url.URL{Scheme: parsed.Scheme, User: parsed.User, Host: parsed.Host, Path: "/" + object.Key}).String())
+		info := urlInfo{name: path.Base(rel), size: object.Size, modTime: object.ModTime}
+		u.mu.Lock()
+		u.infos[name] = info
+		u.mu.Unlock()
+		listed = append(listed, foundInput{name, info})
+	}
+
+	var inputs []foundInput
+	for _, input := range listed {
+		if _, ok, _ := opts.selects(u, input.path, fs.FileInfoToDirEntry(input.info)); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// close ends the sftp sessions once the run is done
+func (u *urlInputs) close() {
+	u.sftp.close()
+}
+
+// found returns the URL inputs with their info, the ones that can't be
+// reached with an empty one so reading them fails the run
+func (u *urlInputs) found() []foundInput {
+	inputs := make([]foundInput, len(u.names))
+	for i, name := range u.names {
+		info, err := u.Stat(name)
+		if err != nil {
+			info = urlInfo{name: filepath.Base(name)}
+		}
+		inputs[i] = foundInput{name, info}
+	}
+	return inputs
+}
+
+func (u *urlInputs) Open(name string) (fs.File, error) {
+	raw, ok := u.url(name)
+	if !ok {
+		return u.FS.Open(name)
+	}
+	if strings.HasPrefix(raw, "sftp://") {
+		return &sftpFile{inputs: u, name: name, url: raw}, nil
+	}
+	return &urlFile{inputs: u, name: name, url: raw}, nil
+}
+
+// Stat asks for the first byte of the URLs rather than their headers, the
+// presigned links are only signed for GET
+func (u *urlInputs) Stat(name string) (fs.FileInfo, error) {
+	raw, ok := u.url(name)
+	if !ok {
+		return fs.Stat(u.FS, name)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if info, ok := u.infos[name]; ok {
+		return info, nil
+	}
+	if strings.HasPrefix(raw, "sftp://") {
+		info, err := u.statSFTP(name, raw)
+		if err == nil {
+			u.infos[name] = info
+		}
+		return info, err
+	}
+
+	resp, err := u.get(raw, "bytes=0-0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %w", raw, err)
+	}
+	resp.Body.Close()
+	info := urlInfo{name: filepath.Base(name), size: resp.ContentLength}
+	if resp.StatusCode == http.StatusPartialContent {
+		// bytes 0-0/size
+		_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		info.size, _ = strconv.ParseInt(total, 10, 64)
+	}
+	if info.size < 0 {
+		info.size = 0
+	}
+	info.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	u.infos[name] = info
+	return info, nil
+}
+
+// get requests raw, trying again up to retries times, waiting longer each
+// time, when it can't be reached or answers with a server error. The
+// response is a 200, a 207 for the listing of a WebDAV directory or, with
+// byteRange, a 206.
+func (u *urlInputs) get(raw string, byteRange string) (*http.Response, error) {
+	var err error
+	for attempt := 0; attempt <= u.retries; attempt++ {
+		if attempt > 0 {
+			wait := time.Duration(1<<(attempt-1)) * time.Second
+			u.logger.Printf("unable to download %s, trying again in %v: %v", raw, wait, err)
+			time.Sleep(wait)
+		}
+
+		var req *http.Request
+		if req, err = u.request(raw); err != nil {
+			return nil, err
+		}
+		if byteRange != "" {
+			req.Header.Set("Range", byteRange)
+		}
+		var resp *http.Response
+		if resp, err = u.client.Do(req); err != nil {
+			continue
+		}
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusMultiStatus || (byteRange != "" && resp.StatusCode == http.StatusPartialContent) {
+			return resp, nil
+		}
+		err = responseError(resp)
+		resp.Body.Close()
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// request builds the GET of raw, with the credentials of the buckets
+func (u *urlInputs) request(raw string) (*http.Request, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed.Scheme {
+	case "s3":
+		return u.s3.request(parsed)
+	case "gs":
+		return u.gcs.request(parsed)
+	case "az":
+		return u.azure.request(parsed)
+	case "webdav", "webdavs":
+		return u.webdav.request(parsed)
+	}
+	return http.NewRequest(http.MethodGet, raw, nil)
+}
+
+// responseError tells why a request failed, with the message of the XML
+// errors of S3 and Azure or of the JSON ones of GCS
+func responseError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var xmlBody struct {
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(data, &xmlBody) == nil && xmlBody.Code != "" {
+		return fmt.Errorf("%s: %s: %s", resp.Status, xmlBody.Code, xmlBody.Message)
+	}
+	var jsonBody struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &jsonBody) == nil && jsonBody.Error.Message != "" {
+		return fmt.Errorf("%s: %s", resp.Status, jsonBody.Error.Message)
+	}
+	return errors.New(resp.Status)
+}
+
+// urlFile downloads a URL as it is read. When the connection breaks it asks
+// for the rest, if the server takes ranges.
+type urlFile struct {
+	inputs   *urlInputs
+	name     string
+	url      string
+	body     io.ReadCloser
+	read     int64
+	failures int
+}
+
+func (f *urlFile) Read(p []byte) (int, error) {
+	for {
+		if f.body == nil {
+			byteRange := ""
+			if f.read > 0 {
+				byteRange = fmt.Sprintf("bytes=%d-", f.read)
+			}
+			resp, err := f.inputs.get(f.url, byteRange)
+			if err != nil {
+				return 0, fmt.Errorf("unable to download %s: %w", f.url, err)
+			}
+			if f.read > 0 && resp.StatusCode != http.StatusPartialContent {
+				resp.Body.Close()
+				return 0, fmt.Errorf("unable to resume the download of %s at %d bytes, the server doesn't take ranges", f.url, f.read)
+			}
+			f.body = resp.Body
+		}
+
+		n, err := f.body.Read(p)
+		f.read += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		f.body.Close()
+		f.body = nil
+		if f.failures++; f.failures > f.inputs.retries {
+			return n, fmt.Errorf("unable to download %s: %w", f.url, err)
+		}
+		f.inputs.logger.Printf("the download of %s broke at %d bytes, resuming it: %v", f.url, f.read, err)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (f *urlFile) Stat() (fs.FileInfo, error) { return f.inputs.Stat(f.name) }
+
+func (f *urlFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
+
+type urlInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i urlInfo) Name() string       { return i.name }
+func (i urlInfo) Size() int64        { return i.size }
+func (i urlInfo) Mode() fs.FileMode  { return 0444 }
+func (i urlInfo) ModTime() time.Time { return i.modTime }
+func (i urlInfo) IsDir() bool        { return false }
+func (i urlInfo) Sys() any           { return nil }
@@ -0,0 +1,299 @@
+package catzip
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// Validate checks the options on their own, before anything is read or
+// written. Run, List and Plan call it, so a bad combination fails up front
+// instead of halfway through a run.
+func (o *Options) Validate() error {
+	if o.Ext == "" && !o.Detect {
+		return errors.New("Ext is empty, expected .gz, .zst, .bz2, .xz, .lz4, .br, .sz, .zip, .7z, .rar, .iso, .tar, .tar.gz, .tgz, .tar.xz, .txz, .tar.zst, .tzst, .tar.bz2, .tbz2, .tar.lz4, .cpio, .cpio.gz, .deb or .rpm")
+	}
+	if o.CatFileName == "" && o.CatWriter == nil {
+		return errors.New("CatFileName is empty")
+	}
+	if _, ok := o.InputFS.(*readerFS); ok && o.OnProgress != nil {
+		return errors.New("OnProgress can't be used with a ReaderFS, it reads the inputs through first")
+	}
+	if o.CatWriter != nil && (o.DirectIO || o.PreallocateCat || o.CheckpointPath != "" || o.SortKey > 0 || len(o.PostCommand) > 0) {
+		return errors.New("CatWriter can't be used with DirectIO, PreallocateCat, CheckpointPath, SortKey or PostCommand, they need CatFileName")
+	}
+
+	if o.Workers < 0 {
+		return fmt.Errorf("Workers is %d, expected a positive number or 0 to adjust it during the run", o.Workers)
+	}
+	if o.MaxWorkers < 0 {
+		return fmt.Errorf("MaxWorkers is %d, expected a positive number or 0 for the default", o.MaxWorkers)
+	}
+	if o.WriteWorkers < 1 {
+		return fmt.Errorf("WriteWorkers is %d, expected a positive number", o.WriteWorkers)
+	}
+	if o.FilesQueue < 0 || o.EntriesQueue < 0 {
+		return fmt.Errorf("FilesQueue and EntriesQueue can't be negative, got %d and %d", o.FilesQueue, o.EntriesQueue)
+	}
+	if o.ChunksQueue < 1 {
+		return fmt.Errorf("ChunksQueue is %d, expected a positive number", o.ChunksQueue)
+	}
+	if o.ChunkSize < 0 {
+		return fmt.Errorf("ChunkSize is %d, expected a positive number or 0 for the default", o.ChunkSize)
+	}
+
+	if o.WalkWorkers < 0 {
+		return fmt.Errorf("WalkWorkers is %d, expected a positive number or 0 for a single one", o.WalkWorkers)
+	}
+	if o.streams() {
+		switch {
+		case o.MergeTrees != "":
+			return errors.New("MergeTrees needs the inputs upfront, it can't be used with Stream or WalkWorkers")
+		case o.LargestFirst:
+			return errors.New("LargestFirst needs the inputs upfront, it can't be used with Stream or WalkWorkers")
+		case o.OnProgress != nil:
+			return errors.New("OnProgress needs the inputs upfront, it can't be used with Stream or WalkWorkers")
+		case o.PreallocateCat:
+			return errors.New("PreallocateCat needs the inputs upfront, it can't be used with Stream or WalkWorkers")
+		case o.CheckpointPath != "":
+			return errors.New("CheckpointPath needs the inputs upfront, it can't be used with Stream or WalkWorkers")
+		}
+	}
+	if o.MaxDepth < 0 {
+		return fmt.Errorf("MaxDepth is %d, expected a positive number or 0 to not extract nested archives", o.MaxDepth)
+	}
+	if o.SplitEntrySize < 0 {
+		return fmt.Errorf("SplitEntrySize is %d, expected a positive number or 0 to not split", o.SplitEntrySize)
+	}
+	if o.RecompressAbove < 0 {
+		return fmt.Errorf("RecompressAbove is %d, expected a positive number or 0 to not recompress", o.RecompressAbove)
+	}
+
+	if _, err := parseHash(o.Hash); err != nil {
+		return fmt.Errorf("Hash: %w, expected one of %v or %q", err, HashAlgorithms(), HashOff)
+	}
+	switch o.Signature {
+	case "", SignatureSimHash, SignatureMinHash:
+	default:
+		return fmt.Errorf("Signature is %q, expected %s, %s or empty", o.Signature, SignatureSimHash, SignatureMinHash)
+	}
+	if len(o.FormatRoutes) > 0 {
+		if !o.Classify {
+			return errors.New("FormatRoutes requires Classify")
+		}
+		if o.AttestationPath != "" {
+			return errors.New("AttestationPath only covers the cat file, FormatRoutes can't be set")
+		}
+		formats := map[string]bool{}
+		for _, format := range Formats() {
+			formats[format] = true
+		}
+		names := map[string]bool{o.CatFileName: true}
+		for format, name := range o.FormatRoutes {
+			if !formats[format] {
+				return fmt.Errorf("FormatRoutes has unknown format %q, expected one of %v", format, Formats())
+			}
+			if !validRouteName(name) || names[name] {
+				return fmt.Errorf("FormatRoutes sends %s to %q, expected a file name not used by the cat file or another route", format, name)
+			}
+			names[name] = true
+		}
+	}
+	for format, handler := range o.Handlers {
+		switch {
+		case format != "zip" && format != "gz" && format != "tar":
+			return fmt.Errorf("Handlers has unknown format %q, expected one of %v", format, handlerFormats)
+		case handler.NameEncoding != "" && format != "zip":
+			return fmt.Errorf("Handlers[%q].NameEncoding only applies to zip", format)
+		case handler.NameEncoding != "" && handler.NameEncoding != "cp437" && handler.NameEncoding != "latin1":
+			return fmt.Errorf("Handlers[%q].NameEncoding is %q, expected cp437, latin1 or \"\"", format, handler.NameEncoding)
+		case handler.UseFName && format != "gz":
+			return fmt.Errorf("Handlers[%q].UseFName only applies to gz", format)
+		case handler.Xattrs && format != "tar":
+			return fmt.Errorf("Handlers[%q].Xattrs only applies to tar", format)
+		}
+	}
+	if o.StableFor < 0 {
+		return fmt.Errorf("StableFor is %v, it can't be negative", o.StableFor)
+	}
+	if o.OnlyNewerThanState && o.StatePath == "" {
+		return errors.New("OnlyNewerThanState requires StatePath")
+	}
+	if o.RequireMarker != "" && o.RequireMarker == o.WriteMarker {
+		return fmt.Errorf("RequireMarker and WriteMarker are both %q, every processed input would mark itself as ready", o.RequireMarker)
+	}
+
+	if o.AttestationPath != "" {
+		switch {
+		case o.ManifestPath == "":
+			return errors.New("AttestationPath requires ManifestPath")
+		case o.SigningKey == nil:
+			return errors.New("AttestationPath requires SigningKey")
+		case o.Hash != "sha256":
+			return fmt.Errorf("AttestationPath requires the sha256 Hash, got %q", o.Hash)
+		}
+	}
+	switch o.MergeTrees {
+	case "", MergeNewest, MergeOldest, MergeFirst, MergeLast:
+	default:
+		return fmt.Errorf("MergeTrees is %q, expected one of %s, %s, %s or %s", o.MergeTrees, MergeNewest, MergeOldest, MergeFirst, MergeLast)
+	}
+	if o.Image && o.Ext != ".tar" {
+		return fmt.Errorf("Image reads docker save and OCI layout tarballs, Ext is %s instead of .tar", o.Ext)
+	}
+	if o.MergeTrees != "" && o.Detect {
+		return errors.New("MergeTrees reads the central directories of zip files, it can't be used with Detect")
+	}
+	for _, kind := range o.kinds() {
+		if command := kind.requiredCommand(); command != "" {
+			if _, err := exec.LookPath(command); err != nil {
+				return fmt.Errorf("Ext is %s, its inputs are decompressed by the %s command: %w", o.Ext, command, err)
+			}
+		}
+		if o.MergeTrees != "" && (kind.tar() || kind.cpio() || kind == kind7z || kind == kindRar || kind == kindIso || kind == kindImage) {
+			return fmt.Errorf("MergeTrees reads the central directories of zip files, Ext is %s", o.Ext)
+		}
+	}
+	if o.ConflictReportPath != "" && o.MergeTrees == "" {
+		return errors.New("ConflictReportPath requires MergeTrees")
+	}
+	if o.Tombstones != "" {
+		switch {
+		case o.MergeTrees != MergeLast:
+			return fmt.Errorf("Tombstones requires the %s MergeTrees, got %q", MergeLast, o.MergeTrees)
+		case o.LargestFirst:
+			return errors.New("Tombstones applies the inputs in order, LargestFirst can't be set")
+		}
+	}
+	if o.CheckpointPath != "" {
+		switch {
+		case o.CheckpointInterval < 0:
+			return fmt.Errorf("CheckpointInterval is %v, it can't be negative", o.CheckpointInterval)
+		case o.DirectIO:
+			return errors.New("DirectIO buffers the cat file, its checkpoint would be ahead of it, CheckpointPath can't be set")
+		case o.AttestationPath != "":
+			return errors.New("AttestationPath hashes the cat file as it is written, a resumed run can't, CheckpointPath can't be set")
+		}
+	}
+	if o.SortKey != 0 || o.SortNumeric {
+		switch {
+		case o.SortKey < 0:
+			return fmt.Errorf("SortKey is %d, expected a column from 1, or 0", o.SortKey)
+		case o.SortKey == 0:
+			return errors.New("SortNumeric requires SortKey")
+		case o.SortMemory < 0:
+			return fmt.Errorf("SortMemory is %d, it can't be negative", o.SortMemory)
+		case len(o.Sinks) > 0:
+			return errors.New("the sinks get the cat file as it is written, before it is sorted, Sinks can't be set with SortKey")
+		case o.AttestationPath != "":
+			return errors.New("AttestationPath hashes the cat file as it is written, SortKey can't be set")
+		case o.CheckpointPath != "":
+			return errors.New("a resumed run appends to the cat file, CheckpointPath can't be set with SortKey")
+		}
+	}
+	switch o.Escape {
+	case "", EscapeBase64, EscapeHex, EscapeC:
+	default:
+		return fmt.Errorf("Escape is %q, expected one of %v", o.Escape, EscapeModes())
+	}
+	if o.MaxLineBytes < 0 {
+		return fmt.Errorf("MaxLineBytes is %d, expected a positive number or 0 for no limit", o.MaxLineBytes)
+	}
+	switch o.LongLines {
+	case "", LongLinesTruncate, LongLinesWrap:
+	default:
+		return fmt.Errorf("LongLines is %q, expected %s or %s", o.LongLines, LongLinesTruncate, LongLinesWrap)
+	}
+	for _, column := range o.Annotate {
+		switch column {
+		case AnnotateArchive, AnnotateEntry, AnnotateLine:
+		case AnnotateCatLine:
+			if o.CheckpointPath != "" {
+				return fmt.Errorf("a resumed run doesn't know the lines already in the cat file, CheckpointPath can't be set with the %s Annotate column", AnnotateCatLine)
+			}
+		default:
+			return fmt.Errorf("Annotate has unknown column %q, expected one of %v", column, AnnotateColumns())
+		}
+	}
+	if o.PolicyScript != "" {
+		if len(o.PolicyCommand) > 0 {
+			return errors.New("PolicyScript and PolicyCommand can't both be set")
+		}
+		if _, err := compilePolicyScript(o.PolicyScript); err != nil {
+			return err
+		}
+	}
+	if o.QuarantineDir != "" && len(o.ScanCommand) == 0 {
+		return errors.New("QuarantineDir requires ScanCommand")
+	}
+
+	for _, raw := range o.URLs {
+		u, err := url.Parse(raw)
+		known := false
+		for _, scheme := range urlSchemes {
+			known = known || err == nil && u.Scheme == scheme
+		}
+		if !known || u.Host == "" {
+			return fmt.Errorf("URLs has %q, expected a URL with one of the schemes %v", raw, urlSchemes)
+		}
+		if isBucketDir(raw) && strings.Trim(u.Path, "/") == "" {
+			return fmt.Errorf("URLs has %q, expected %s://bucket/key, Dir lists buckets", raw, u.Scheme)
+		}
+		if err := urlPasswordError(u); err != nil {
+			return fmt.Errorf("URLs has %w", err)
+		}
+	}
+	if isBucketDir(o.Dir) {
+		u, err := url.Parse(o.Dir)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("Dir is %q, expected a bucket like s3://bucket/prefix", o.Dir)
+		}
+		if err := urlPasswordError(u); err != nil {
+			return fmt.Errorf("Dir has %w", err)
+		}
+	}
+	for _, endpoint := range []struct{ name, value string }{{"S3Endpoint", o.S3Endpoint}, {"GCSEndpoint", o.GCSEndpoint}, {"AzureEndpoint", o.AzureEndpoint}} {
+		if u, err := url.Parse(endpoint.value); endpoint.value != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+			return fmt.Errorf("%s is %q, expected an http or https URL", endpoint.name, endpoint.value)
+		}
+	}
+	if o.URLRetries < 0 {
+		return fmt.Errorf("URLRetries is %d, expected a positive number or 0", o.URLRetries)
+	}
+
+	// Options that only make sense on the OS filesystem
+	if o.InputFS != nil || len(o.URLs) > 0 || isBucketDir(o.Dir) {
+		switch {
+		case o.WriteMarker != "":
+			return errors.New("WriteMarker requires inputs read from the OS, InputFS or URLs is set or Dir is a bucket")
+		case o.Mmap:
+			return errors.New("Mmap requires inputs read from the OS, InputFS or URLs is set or Dir is a bucket")
+		}
+	}
+	if o.FS != nil && o.FS != OS {
+		switch {
+		case o.DirectIO:
+			return errors.New("DirectIO requires writing to the OS filesystem, FS is set")
+		case o.Preallocate || o.PreallocateCat:
+			return errors.New("Preallocate and PreallocateCat require writing to the OS filesystem, FS is set")
+		}
+	}
+	return nil
+}
+
+// urlPasswordError tells how to log in instead of the password of u, which
+// would be logged with it
+func urlPasswordError(u *url.URL) error {
+	if _, ok := u.User.Password(); !ok {
+		return nil
+	}
+	switch u.Scheme {
+	case "sftp":
+		return fmt.Errorf("a password for %s, expected the keys or the agent of ssh to log in", u.Host)
+	case "webdav", "webdavs":
+		return fmt.Errorf("a password for %s, expected CATZIP_WEBDAV_PASSWORD or NetrcFile to log in", u.Host)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package catzip
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// walkOptions select which files under the input directory are processed
+type walkOptions struct {
+	exts          []string // Of Options.Ext
+	passthrough   map[string]bool
+	requireMarker string
+	stableFor     time.Duration
+	// Leave out the files modified before this, zero disables it
+	onlyNewerThan time.Time
+	// Of Options.IgnoreFile and Options.DirConfigFile
+	ignoreFile    string
+	dirConfigFile string
+	// Checks the dir configs against the options, nil takes them as they are
+	checkConfig func(*dirConfig) error
+	// Where the dir configs of the inputs selected are kept, nil leaves them
+	configs *inputConfigs
+	logger  *log.Logger
+}
+
+// dirRules are what the walk carries from a directory to the ones under it,
+// the rules of its ignore file and of the ones of its parents, and its dir
+// config merged with theirs
+type dirRules struct {
+	ignores ignoreRules
+	config  *dirConfig
+}
+
+// enterDir reads the ignore file and the dir config of dir. A bad dir config
+// is an error, the directory is left out rather than read without it.
+func (opts walkOptions) enterDir(fsys fs.FS, dir string, parent dirRules) (dirRules, error) {
+	rules := dirRules{ignores: opts.readIgnore(fsys, dir, parent.ignores), config: parent.config}
+	if opts.dirConfigFile == "" {
+		return rules, nil
+	}
+	data, name, err := readDirFile(fsys, dir, opts.dirConfigFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return rules, nil
+	}
+	var config *dirConfig
+	if err == nil {
+		config, err = parseDirConfig(data)
+	}
+	if err == nil && opts.checkConfig != nil {
+		err = opts.checkConfig(config)
+	}
+	if err != nil {
+		return rules, fmt.Errorf("unable to read %s: %w", name, err)
+	}
+	rules.config = parent.config.merge(config)
+	return rules, nil
+}
+
+// selectsUnder is selects for the file d at path, in a directory with rules
+func (opts walkOptions) selectsUnder(rules dirRules, fsys fs.FS, path string, d fs.DirEntry) (fs.FileInfo, bool, error) {
+	if d.Name() == opts.ignoreFile || d.Name() == opts.dirConfigFile || strings.HasPrefix(d.Name(), nestedSpoolPrefix) || rules.ignores.ignores(path, false) {
+		return nil, false, nil
+	}
+	if rules.config != nil && rules.config.exts != nil {
+		opts.exts = rules.config.exts
+	}
+	info, ok, err := opts.selects(fsys, path, d)
+	if ok {
+		opts.configs.set(path, rules.config)
+	}
+	return info, ok, err
+}
+
+// walkInputs finds the input files under dir, or the objects of an s3://
+// one, returning them in walk order along with their file info, then the URL
+// inputs
+func walkInputs(fsys fs.FS, dir string, opts walkOptions) ([]string, map[string]fs.FileInfo) {
+	filesInDir := []string{}
+	fileInfos := map[string]fs.FileInfo{}
+
+	urls, remote := fsys.(*urlInputs)
+	if remote && isBucketDir(dir) {
+		for _, input := range urls.list(dir, opts) {
+			filesInDir = append(filesInDir, input.path)
+			fileInfos[input.path] = input.info
+		}
+	} else if dir != "" {
+		// The rules of the directories walked
+		dirs := map[string]dirRules{}
+		walkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rules := dirs[filepath.Dir(path)]
+			if d.IsDir() {
+				if path != dir && rules.ignores.ignores(path, true) {
+					return fs.SkipDir
+				}
+				if dirs[filepath.Clean(path)], err = opts.enterDir(fsys, path, rules); err != nil {
+					opts.logger.Printf("%v, %s is left out", err, path)
+					return fs.SkipDir
+				}
+				return nil
+			}
+
+			info, ok, err := opts.selectsUnder(rules, fsys, path, d)
+			if err != nil {
+				return err
+			}
+			if ok {
+				filesInDir = append(filesInDir, path)
+				fileInfos[path] = info
+			}
+			return nil
+		})
+	}
+
+	// Not filtered, they were asked for
+	if remote {
+		for _, input := range urls.found() {
+			filesInDir = append(filesInDir, input.path)
+			fileInfos[input.path] = input.info
+		}
+	}
+	return filesInDir, fileInfos
+}
+
+// selects tells whether the file d at path is an input file, with its info
+func (opts walkOptions) selects(fsys fs.FS, path string, d fs.DirEntry) (fs.FileInfo, bool, error) {
+	ext := matchExt(d.Name(), opts.exts)
+	if len(opts.exts) > 0 && ext == "" && !opts.passthrough[filepath.Ext(d.Name())] {
+		return nil, false, nil
+	}
+	if kindOf(ext) == kindRar && laterRarVolume(d.Name()) {
+		// Read with the first volume of its set
+		return nil, false, nil
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if opts.requireMarker != "" {
+		if _, err := fs.Stat(fsys, path+opts.requireMarker); err != nil {
+			opts.logger.Printf("skipping %s, its %s marker is missing", path, opts.requireMarker)
+			return nil, false, nil
+		}
+	}
+
+	// The directories are walked all the same, adding a file to 2024/10/
+	// doesn't change the time of 2024/. The ones of the same time as the
+	// cutoff are kept, they may have landed after it in the same second and
+	// the state tells the ones already read.
+	if !opts.onlyNewerThan.IsZero() && info.ModTime().Before(opts.onlyNewerThan) {
+		return nil, false, nil
+	}
+
+	if age := time.Since(info.ModTime()); age < opts.stableFor {
+		opts.logger.Printf("skipping %s, it was modified %v ago and may still be being written", path, age.Round(time.Second))
+		return nil, false, nil
+	}
+	return info, true, nil
+}
+
+// foundInput is an input file found by walkConcurrently
+type foundInput struct {
+	path string
+	info fs.FileInfo
+}
+
+// walkConcurrently sends the input files under dir, or the objects of an
+// s3:// one, to found as it finds them, reading up to workers directories at
+// a time, then the URL inputs,
+// and closes found. The
+// directories it can't read are logged and left out. It stops early once
+// stop is closed.
+func walkConcurrently(fsys fs.FS, dir string, opts walkOptions, workers int, found chan<- foundInput, stop <-chan struct{}) {
+	join := path.Join
+	readDir := func(name string) ([]fs.DirEntry, error) { return fs.ReadDir(fsys, name) }
+	if isOSInputs(fsys) {
+		join = filepath.Join
+		readDir = os.ReadDir
+	}
+
+	var wg sync.WaitGroup
+	// The walking goroutine is one of the workers
+	slots := make(chan struct{}, workers-1)
+	var walk func(dir string, parent dirRules)
+	walk = func(dir string, parent dirRules) {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		rules, err := opts.enterDir(fsys, dir, parent)
+		if err != nil {
+			opts.logger.Printf("%v, %s is left out", err, dir)
+			return
+		}
+		entries, err := readDir(dir)
+		if err != nil {
+			opts.logger.Printf("unable to read directory %s: %v", dir, err)
+		}
+		for _, d := range entries {
+			p := join(dir, d.Name())
+			if d.IsDir() {
+				if rules.ignores.ignores(p, true) {
+					continue
+				}
+				select {
+				case slots <- struct{}{}:
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						walk(p, rules)
+						<-slots
+					}()
+				default:
+					walk(p, rules)
+				}
+				continue
+			}
+			info, ok, err := opts.selectsUnder(rules, fsys, p, d)
+			if err != nil {
+				opts.logger.Printf("unable to read %s: %v", p, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			select {
+			case found <- foundInput{p, info}:
+			case <-stop:
+				return
+			}
+		}
+	}
+	urls, remote := fsys.(*urlInputs)
+	if remote && isBucketDir(dir) {
+		for _, input := range urls.list(dir, opts) {
+			select {
+			case found <- input:
+			case <-stop:
+			}
+		}
+	} else if dir != "" {
+		walk(dir, dirRules{})
+	}
+	wg.Wait()
+	if remote {
+		for _, input := range urls.found() {
+			select {
+			case found <- input:
+			case <-stop:
+			}
+		}
+	}
+	close(found)
+}
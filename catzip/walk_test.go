@@ -0,0 +1,79 @@
+package catzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeGz(t *testing.T, name, content string, modTime time.Time) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(content))
+	w.Close()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The files landing deep in a year/month tree are found, though the times
+// of the directories above them don't change
+func TestOnlyNewerThanStateNested(t *testing.T) {
+	for _, walkWorkers := range []int{0, 4} {
+		dir := t.TempDir()
+		in := filepath.Join(dir, "in")
+		old := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+		writeGz(t, filepath.Join(in, "2024", "09", "a.log.gz"), "a\n", old)
+
+		opts := DefaultOptions()
+		opts.Dir = in
+		opts.OutDir = filepath.Join(dir, "out")
+		opts.StatePath = filepath.Join(dir, "state.json")
+		opts.OnlyNewerThanState = true
+		opts.WalkWorkers = walkWorkers
+		opts.Logger = log.New(io.Discard, "", 0)
+		if err := os.MkdirAll(opts.OutDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if summary, err := Run(opts); err != nil || summary.Files != 1 {
+			t.Fatalf("first run: %v, %+v", err, summary)
+		}
+
+		// As old as the newest input of the state, and newer
+		writeGz(t, filepath.Join(in, "2024", "09", "same.log.gz"), "same\n", old)
+		writeGz(t, filepath.Join(in, "2024", "10", "b.log.gz"), "b\n", old.Add(time.Hour))
+		writeGz(t, filepath.Join(in, "2023", "12", "older.log.gz"), "older\n", old.Add(-time.Hour))
+		for _, d := range []string{"2023/12", "2023", "2024/10", "2024/09", "2024"} {
+			if err := os.Chtimes(filepath.Join(in, d), old.Add(-2*time.Hour), old.Add(-2*time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		summary, err := Run(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Files != 2 {
+			t.Errorf("walk workers %d: %d files processed, expected same.log.gz and b.log.gz", walkWorkers, summary.Files)
+		}
+		cat, err := os.ReadFile(filepath.Join(opts.OutDir, opts.CatFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(cat, []byte("b\n")) || !bytes.Contains(cat, []byte("same\n")) || bytes.Contains(cat, []byte("older\n")) {
+			t.Errorf("walk workers %d: cat file is %q", walkWorkers, cat)
+		}
+	}
+}
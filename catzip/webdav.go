@@ -0,0 +1,227 @@
+package catzip
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The properties of the listings of the webdav:// and webdavs:// inputs
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`
+
+// webdavClient builds the requests of the webdav:// and webdavs:// inputs,
+// to http and https servers like the ones of Nextcloud, with the login of
+// the environment or of the netrc file
+type webdavClient struct {
+	netrcFile string
+
+	once   sync.Once
+	logins []netrcLogin
+	err    error
+}
+
+func newWebDAVClient(opts Options) *webdavClient {
+	c := &webdavClient{netrcFile: opts.NetrcFile}
+	if c.netrcFile == "" {
+		c.netrcFile = os.Getenv("NETRC")
+	}
+	if c.netrcFile == "" {
+		home, _ := os.UserHomeDir()
+		c.netrcFile = filepath.Join(home, ".netrc")
+	}
+	return c
+}
+
+// request builds the GET of the webdav://host/path URL u or, when it ends
+// with a slash, the PROPFIND listing that directory
+func (c *webdavClient) request(u *url.URL) (*http.Request, error) {
+	target := &url.URL{Scheme: "https", Host: u.Host, Path: u.Path, RawPath: u.RawPath}
+	if u.Scheme == "webdav" {
+		target.Scheme = "http"
+	}
+	var req *http.Request
+	var err error
+	if strings.HasSuffix(u.Path, "/") {
+		req, err = http.NewRequest("PROPFIND", target.String(), strings.NewReader(webdavPropfind))
+		if err == nil {
+			req.Header.Set("Depth", "1")
+			req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+		}
+	} else {
+		req, err = http.NewRequest(http.MethodGet, target.String(), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, password, err := c.login(u)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	return req, nil
+}
+
+// login is the one of CATZIP_WEBDAV_USER and CATZIP_WEBDAV_PASSWORD, else
+// the one the netrc file has for the host, for the user of u if it has one
+func (c *webdavClient) login(u *url.URL) (string, string, error) {
+	user := u.User.Username()
+	if envUser := os.Getenv("CATZIP_WEBDAV_USER"); envUser != "" && (user == "" || user == envUser) {
+		return envUser, os.Getenv("CATZIP_WEBDAV_PASSWORD"), nil
+	}
+
+	c.once.Do(func() { c.logins, c.err = readNetrc(c.netrcFile) })
+	if c.err != nil {
+		return "", "", fmt.Errorf("unable to read %s: %w", c.netrcFile, c.err)
+	}
+	for _, machine := range []string{u.Hostname(), ""} {
+		for _, login := range c.logins {
+			if login.machine == machine && (user == "" || login.login == user) {
+				return login.login, login.password, nil
+			}
+		}
+	}
+	return user, "", nil
+}
+
+// netrcLogin is an entry of a netrc file, machine is "" for the default one
+type netrcLogin struct {
+	machine  string
+	login    string
+	password string
+}
+
+// readNetrc reads the logins of a netrc file, the one missing is empty
+func readNetrc(name string) ([]netrcLogin, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var logins []netrcLogin
+	var login *netrcLogin
+	scanner := bufio.NewScanner(f)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// A macro lasts until a blank line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			value := ""
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				logins = append(logins, netrcLogin{machine: value})
+				login = &logins[len(logins)-1]
+				i++
+			case "default":
+				logins = append(logins, netrcLogin{})
+				login = &logins[len(logins)-1]
+			case "login", "password", "account":
+				if login != nil && fields[i] == "login" {
+					login.login = value
+				}
+				if login != nil && fields[i] == "password" {
+					login.password = value
+				}
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	return logins, scanner.Err()
+}
+
+// listWebDAV lists the files under the directory of the webdav://host/dir
+// URL target one directory at a time, since servers often refuse Depth
+// infinity, keyed by their path like the objects of a bucket, in name order
+func (u *urlInputs) listWebDAV(target *url.URL) ([]bucketObject, error) {
+	var objects []bucketObject
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		raw := (&url.URL{Scheme: target.Scheme, User: target.User, Host: target.Host, Path: dir}).String()
+		resp, err := u.get(raw, "")
+		if err != nil {
+			return err
+		}
+		var listing struct {
+			Responses []struct {
+				Href      string `xml:"href"`
+				Propstats []struct {
+					Prop struct {
+						ResourceType struct {
+							Collection *struct{} `xml:"collection"`
+						} `xml:"resourcetype"`
+						ContentLength int64  `xml:"getcontentlength"`
+						LastModified  string `xml:"getlastmodified"`
+					} `xml:"prop"`
+					Status string `xml:"status"`
+				} `xml:"propstat"`
+			} `xml:"response"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&listing)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("unable to read the listing of %s: %w", raw, err)
+		}
+
+		for _, response := range listing.Responses {
+			href, err := url.Parse(response.Href)
+			if err != nil {
+				continue
+			}
+			// Cleaned, the directory itself doesn't start with dir/
+			p := path.Clean(href.Path)
+			if !strings.HasPrefix(p, dir) {
+				continue
+			}
+			object := bucketObject{Key: strings.TrimPrefix(p, "/")}
+			collection := false
+			for _, propstat := range response.Propstats {
+				if strings.Contains(propstat.Status, " 200 ") {
+					prop := propstat.Prop
+					collection = prop.ResourceType.Collection != nil
+					object.Size = prop.ContentLength
+					object.ModTime, _ = http.ParseTime(prop.LastModified)
+				}
+			}
+			if !collection {
+				objects = append(objects, object)
+			} else if err := walk(p + "/"); err != nil {
+				u.logger.Printf("unable to list %s: %v", p, err)
+			}
+		}
+		return nil
+	}
+	if err := walk(strings.TrimSuffix(path.Clean("/"+target.Path), "/") + "/"); err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
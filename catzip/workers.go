@@ -0,0 +1,223 @@
+package catzip
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type workerPool struct {
+	handle func(string)
+	jobs   chan string
+	wg     sync.WaitGroup
+	// Bytes processed so far, used to measure throughput
+	progress *atomic.Int64
+
+	mu     sync.Mutex
+	active int
+	target int
+	peak   int
+	max    int
+	// When the busy workers started their file, or the last sample, and the
+	// time the others were busy since the last sample
+	nextID  int
+	started map[int]time.Time
+	busy    time.Duration
+}
+
+// sendFiles returns a closed channel with files
+func sendFiles(files []string) <-chan string {
+	c := make(chan string, len(files))
+	for _, f := range files {
+		c <- f
+	}
+	close(c)
+	return c
+}
+
+// runWorkers calls handle for every file of files until it is closed, using
+// the given number of workers. When workers is 0 the pool starts with a single
+// worker and is resized during the run based on the progress throughput. Up
+// to queueDepth files wait for a free worker. It returns the final and the
+// peak number of workers.
+func runWorkers(files <-chan string, workers int, maxWorkers int, queueDepth int, progress *atomic.Int64, handle func(string)) (int, int) {
+	auto := workers == 0
+	if auto {
+		workers = 1
+	}
+	if maxWorkers > 0 && workers > maxWorkers {
+		workers = maxWorkers
+	}
+
+	p := &workerPool{
+		handle:   handle,
+		jobs:     make(chan string, queueDepth),
+		progress: progress,
+		target:   workers,
+		max:      maxWorkers,
+		started:  map[int]time.Time{},
+	}
+	if p.max <= 0 {
+		p.max = runtime.NumCPU() * 2
+	}
+
+	p.mu.Lock()
+	p.spawn()
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	tuned := make(chan struct{})
+	if auto {
+		go func() {
+			defer close(tuned)
+			p.autoTune(time.Second, done)
+		}()
+	} else {
+		close(tuned)
+	}
+
+	for f := range files {
+		p.jobs <- f
+	}
+	// Stopped first, so no worker is spawned once jobs is closed
+	close(done)
+	<-tuned
+	close(p.jobs)
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.target, p.peak
+}
+
+// spawn starts workers until the pool reaches its target, p.mu must be held
+func (p *workerPool) spawn() {
+	for p.active < p.target {
+		p.active++
+		p.nextID++
+		p.wg.Add(1)
+		go p.work(p.nextID)
+	}
+	if p.active > p.peak {
+		p.peak = p.active
+	}
+}
+
+func (p *workerPool) work(id int) {
+	defer p.wg.Done()
+	for f := range p.jobs {
+		p.mu.Lock()
+		p.started[id] = time.Now()
+		p.mu.Unlock()
+
+		p.handle(f)
+
+		p.mu.Lock()
+		p.busy += time.Since(p.started[id])
+		delete(p.started, id)
+		// Leave when the pool has been scaled down
+		if p.active > p.target {
+			p.active--
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+	}
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+}
+
+// resize sets the target number of workers, it returns false when target
+// is out of bounds
+func (p *workerPool) resize(target int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if target < 1 || target > p.max {
+		return false
+	}
+	p.target = target
+	p.spawn()
+	return true
+}
+
+// sample returns the time the workers were busy since the last sample and
+// the number of workers
+func (p *workerPool) sample() (time.Duration, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	busy := p.busy
+	for id, started := range p.started {
+		busy += now.Sub(started)
+		p.started[id] = now
+	}
+	p.busy = 0
+	return busy, p.active
+}
+
+// autoTune is a simple hill climber, it keeps adding (or removing) workers
+// while that pays off and reverses direction once it doesn't. Every worker
+// added has to bring at least half the throughput each worker had, less
+// than that usually means the disk, not the CPU, is the bottleneck. The
+// part of the time the workers are busy that isn't spent on the CPU is
+// their I/O wait: with little of it, the pool doesn't grow past the number
+// of CPUs, the workers would only take turns on them.
+func (p *workerPool) autoTune(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	direction := 1
+	last := p.progress.Load()
+	lastCPU, measured := processCPUTime()
+	lastRate, lastPerWorker := 0.0, 0.0
+	lastActive := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		current := p.progress.Load()
+		rate := float64(current-last) / interval.Seconds()
+		last = current
+		busy, active := p.sample()
+		perWorker := rate / float64(active)
+		ioWait := -1.0
+		if cpu, ok := processCPUTime(); ok && measured {
+			if busy > 0 {
+				ioWait = 1 - float64(cpu-lastCPU)/float64(busy)
+				if ioWait < 0 {
+					ioWait = 0
+				}
+			}
+			lastCPU = cpu
+		}
+
+		hold := false
+		switch {
+		case lastActive > 0 && active > lastActive && rate-lastRate < lastPerWorker/2*float64(active-lastActive):
+			// The new workers didn't pay for themselves
+			direction = -1
+		case lastActive > 0 && active < lastActive && rate < lastRate*0.95:
+			// The removed workers were needed
+			direction = 1
+		case direction > 0 && ioWait >= 0 && ioWait < 0.2 && active >= runtime.NumCPU():
+			// Bound by the CPUs, checked again at the next sample
+			hold = true
+		}
+		lastRate, lastPerWorker, lastActive = rate, perWorker, active
+
+		if hold {
+			continue
+		}
+		p.mu.Lock()
+		target := p.target
+		p.mu.Unlock()
+		if !p.resize(target + direction) {
+			direction = -direction
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package catzip
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// XXH3 64-bit with the default secret and seed 0, a fast non-cryptographic
+// hash for spotting accidental duplicates
+
+const (
+	xxhPrime32_1 = 0x9E3779B1
+	xxhPrime32_2 = 0x85EBCA77
+	xxhPrime32_3 = 0xC2B2AE3D
+	xxhPrime64_1 = 0x9E3779B185EBCA87
+	xxhPrime64_2 = 0xC2B2AE3D27D4EB4F
+	xxhPrime64_3 = 0x165667B19E3779F9
+	xxhPrime64_4 = 0x85EBCA77C2B2AE63
+	xxhPrime64_5 = 0x27D4EB2F165667C5
+	xxhPrimeMx1  = 0x165667919E3779F9
+	xxhPrimeMx2  = 0x9FB21C651E98DF25
+
+	xxh3StripeLen       = 64
+	xxh3StripesPerBlock = (len(xxh3Secret) - xxh3StripeLen) / 8
+	xxh3MidSizeMax      = 240
+)
+
+var xxh3Secret = [192]byte{
+	0xb8, 0xfe, 0x6c, 0x39, 0x23, 0xa4, 0x4b, 0xbe, 0x7c, 0x01, 0x81, 0x2c, 0xf7, 0x21, 0xad, 0x1c,
+	0xde, 0xd4, 0x6d, 0xe9, 0x83, 0x90, 0x97, 0xdb, 0x72, 0x40, 0xa4, 0xa4, 0xb7, 0xb3, 0x67, 0x1f,
+	0xcb, 0x79, 0xe6, 0x4e, 0xcc, 0xc0, 0xe5, 0x78, 0x82, 0x5a, 0xd0, 0x7d, 0xcc, 0xff, 0x72, 0x21,
+	0xb8, 0x08, 0x46, 0x74, 0xf7, 0x43, 0x24, 0x8e, 0xe0, 0x35, 0x90, 0xe6, 0x81, 0x3a, 0x26, 0x4c,
+	0x3c, 0x28, 0x52, 0xbb, 0x91, 0xc3, 0x00, 0xcb, 0x88, 0xd0, 0x65, 0x8b, 0x1b, 0x53, 0x2e, 0xa3,
+	0x71, 0x64, 0x48, 0x97, 0xa2, 0x0d, 0xf9, 0x4e, 0x38, 0x19, 0xef, 0x46, 0xa9, 0xde, 0xac, 0xd8,
+	0xa8, 0xfa, 0x76, 0x3f, 0xe3, 0x9c, 0x34, 0x3f, 0xf9, 0xdc, 0xbb, 0xc7, 0xc7, 0x0b, 0x4f, 0x1d,
+	0x8a, 0x51, 0xe0, 0x4b, 0xcd, 0xb4, 0x59, 0x31, 0xc8, 0x9f, 0x7e, 0xc9, 0xd9, 0x78, 0x73, 0x64,
+	0xea, 0xc5, 0xac, 0x83, 0x34, 0xd3, 0xeb, 0xc3, 0xc5, 0x81, 0xa0, 0xff, 0xfa, 0x13, 0x63, 0xeb,
+	0x17, 0x0d, 0xdd, 0x51, 0xb7, 0xf0, 0xda, 0x49, 0xd3, 0x16, 0x55, 0x26, 0x29, 0xd4, 0x68, 0x9e,
+	0x2b, 0x16, 0xbe, 0x58, 0x7d, 0x47, 0xa1, 0xfc, 0x8f, 0xf8, 0xb8, 0xd1, 0x7a, 0xd0, 0x31, 0xce,
+	0x45, 0xcb, 0x3a, 0x8f, 0x95, 0x16, 0x04, 0x28, 0xaf, 0xd7, 0xfb, 0xca, 0xbb, 0x4b, 0x40, 0x7e,
+}
+
+func xxhRead64(b []byte) uint64 { return binary.LittleEndian.Uint64(b) }
+func xxhRead32(b []byte) uint32 { return binary.LittleEndian.Uint32(b) }
+
+func xxhMulFold64(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func xxh64Avalanche(h uint64) uint64 {
+	h ^= h >> 33
+	h *= xxhPrime64_2
+	h ^= h >> 29
+	h *= xxhPrime64_3
+	h ^= h >> 32
+	return h
+}
+
+func xxh3Avalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= xxhPrimeMx1
+	h ^= h >> 32
+	return h
+}
+
+func xxh3Rrmxmx(h uint64, length uint64) uint64 {
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= xxhPrimeMx2
+	h ^= (h >> 35) + length
+	h *= xxhPrimeMx2
+	h ^= h >> 28
+	return h
+}
+
+func xxh3Mix16(input []byte, secret []byte) uint64 {
+	return xxhMulFold64(xxhRead64(input)^xxhRead64(secret), xxhRead64(input[8:])^xxhRead64(secret[8:]))
+}
+
+// xxh3Short hashes inputs of up to xxh3MidSizeMax bytes
+func xxh3Short(input []byte) uint64 {
+	secret := xxh3Secret[:]
+	length := uint64(len(input))
+
+	switch {
+	case len(input) == 0:
+		return xxh64Avalanche(xxhRead64(secret[56:]) ^ xxhRead64(secret[64:]))
+
+	case len(input) <= 3:
+		combined := uint32(input[0])<<16 | uint32(input[len(input)>>1])<<24 | uint32(input[len(input)-1]) | uint32(len(input))<<8
+		bitflip := uint64(xxhRead32(secret) ^ xxhRead32(secret[4:]))
+		return xxh64Avalanche(uint64(combined) ^ bitflip)
+
+	case len(input) <= 8:
+		input64 := uint64(xxhRead32(input[len(input)-4:])) + uint64(xxhRead32(input))<<32
+		bitflip := xxhRead64(secret[8:]) ^ xxhRead64(secret[16:])
+		return xxh3Rrmxmx(input64^bitflip, length)
+
+	case len(input) <= 16:
+		lo := xxhRead64(input) ^ (xxhRead64(secret[24:]) ^ xxhRead64(secret[32:]))
+		hi := xxhRead64(input[len(input)-8:]) ^ (xxhRead64(secret[40:]) ^ xxhRead64(secret[48:]))
+		acc := length + bits.ReverseBytes64(lo) + hi + xxhMulFold64(lo, hi)
+		return xxh3Avalanche(acc)
+
+	case len(input) <= 128:
+		acc := length * xxhPrime64_1
+		if len(input) > 32 {
+			if len(input) > 64 {
+				if len(input) > 96 {
+					acc += xxh3Mix16(input[48:], secret[96:])
+					acc += xxh3Mix16(input[len(input)-64:], secret[112:])
+				}
+				acc += xxh3Mix16(input[32:], secret[64:])
+				acc += xxh3Mix16(input[len(input)-48:], secret[80:])
+			}
+			acc += xxh3Mix16(input[16:], secret[32:])
+			acc += xxh3Mix16(input[len(input)-32:], secret[48:])
+		}
+		acc += xxh3Mix16(input, secret)
+		acc += xxh3Mix16(input[len(input)-16:], secret[16:])
+		return xxh3Avalanche(acc)
+
+	default:
+		acc := length * xxhPrime64_1
+		rounds := len(input) / 16
+		for i := 0; i < 8; i++ {
+			acc += xxh3Mix16(input[16*i:], secret[16*i:])
+		}
+		acc = xxh3Avalanche(acc)
+		for i := 8; i < rounds; i++ {
+			acc += xxh3Mix16(input[16*i:], secret[16*(i-8)+3:])
+		}
+		acc += xxh3Mix16(input[len(input)-16:], secret[136-17:])
+		return xxh3Avalanche(acc)
+	}
+}
+
+func xxh3Accumulate512(acc *[8]uint64, stripe []byte, secret []byte) {
+	for i := 0; i < 8; i++ {
+		value := xxhRead64(stripe[8*i:])
+		key := value ^ xxhRead64(secret[8*i:])
+		acc[i^1] += value
+		acc[i] += (key & 0xFFFFFFFF) * (key >> 32)
+	}
+}
+
+func xxh3Scramble(acc *[8]uint64, secret []byte) {
+	for i := 0; i < 8; i++ {
+		a := acc[i]
+		a ^= a >> 47
+		a ^= xxhRead64(secret[8*i:])
+		a *= xxhPrime32_1
+		acc[i] = a
+	}
+}
+
+// xxh3 implements hash.Hash64, long inputs are consumed one stripe at a
+// time so memory use doesn't depend on the input size
+type xxh3 struct {
+	acc     [8]uint64
+	stripes int // Stripes consumed in the current block
+	total   uint64
+	buf     []byte // Bytes not consumed yet
+	last    [xxh3StripeLen]byte
+}
+
+func newXXH3() hash.Hash64 {
+	h := &xxh3{}
+	h.Reset()
+	return h
+}
+
+func (h *xxh3) Reset() {
+	h.acc = [8]uint64{xxhPrime32_3, xxhPrime64_1, xxhPrime64_2, xxhPrime64_3, xxhPrime64_4, xxhPrime32_2, xxhPrime64_5, xxhPrime32_1}
+	h.stripes = 0
+	h.total = 0
+	h.buf = h.buf[:0]
+}
+
+func (h *xxh3) Size() int      { return 8 }
+func (h *xxh3) BlockSize() int { return xxh3StripeLen }
+
+func (h *xxh3) Write(p []byte) (int, error) {
+	h.total += uint64(len(p))
+	h.buf = append(h.buf, p...)
+	if h.total <= xxh3MidSizeMax {
+		return len(p), nil
+	}
+
+	// A stripe is only consumed once more input follows it, the final stripe
+	// is handled by Sum64
+	consumed := 0
+	for len(h.buf)-consumed > xxh3StripeLen {
+		stripe := h.buf[consumed : consumed+xxh3StripeLen]
+		xxh3Accumulate512(&h.acc, stripe, xxh3Secret[8*h.stripes:])
+		h.stripes++
+		if h.stripes == xxh3StripesPerBlock {
+			xxh3Scramble(&h.acc, xxh3Secret[len(xxh3Secret)-xxh3StripeLen:])
+			h.stripes = 0
+		}
+		copy(h.last[:], stripe)
+		consumed += xxh3StripeLen
+	}
+	h.buf = append(h.buf[:0], h.buf[consumed:]...)
+	return len(p), nil
+}
+
+func (h *xxh3) Sum64() uint64 {
+	if h.total <= xxh3MidSizeMax {
+		return xxh3Short(h.buf)
+	}
+
+	// The final stripe is the last 64 bytes of the input, overlapping the
+	// previously consumed stripe when fewer are pending
+	var stripe [xxh3StripeLen]byte
+	copy(stripe[:], h.last[len(h.buf):])
+	copy(stripe[xxh3StripeLen-len(h.buf):], h.buf)
+
+	acc := h.acc
+	xxh3Accumulate512(&acc, stripe[:], xxh3Secret[len(xxh3Secret)-xxh3StripeLen-7:])
+
+	result := h.total * xxhPrime64_1
+	for i := 0; i < 4; i++ {
+		result += xxhMulFold64(acc[2*i]^xxhRead64(xxh3Secret[11+16*i:]), acc[2*i+1]^xxhRead64(xxh3Secret[11+16*i+8:]))
+	}
+	return xxh3Avalanche(result)
+}
+
+func (h *xxh3) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.Sum64())
+}
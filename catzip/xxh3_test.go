@@ -0,0 +1,77 @@
+package catzip
+
+import "testing"
+
+// XXH3_64bits of xxh3Input(n), from the reference implementation. The
+// lengths cover each branch: empty, 1-3, 4-8, 9-16, 17-128, 129-240 bytes,
+// then the stripes, a block and the last partial stripe.
+var xxh3Vectors = []struct {
+	n   int
+	sum uint64
+}{
+	{0, 0x2d06800538d394c2},
+	{1, 0xc44bdff4074eecdb},
+	{3, 0xc3489259e968ad9e},
+	{4, 0xd3d60c1519014e89},
+	{8, 0xb88dee77f6bf6980},
+	{9, 0x03688dcad730d826},
+	{16, 0x9da23836adf2be1e},
+	{17, 0xf34c3c9cf5a112d1},
+	{128, 0x65f3c2c00fa93185},
+	{129, 0x28065c6ec25f5b25},
+	{240, 0x4917a75c0ef8eed7},
+	{241, 0x541b19226f0052e8},
+	{1024, 0x71bee625238addb4},
+	{1025, 0xd9b414f4e1bbf7ad},
+	{2245, 0x9d622a57dd280e8f},
+	{100000, 0xb25cea78018497ff},
+}
+
+func xxh3Input(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*7 + i>>8)
+	}
+	return b
+}
+
+func TestXXH3(t *testing.T) {
+	for _, v := range xxh3Vectors {
+		input := xxh3Input(v.n)
+		h := newXXH3()
+		h.Write(input)
+		if sum := h.Sum64(); sum != v.sum {
+			t.Errorf("%d bytes: %#016x, expected %#016x", v.n, sum, v.sum)
+		}
+
+		// Written in pieces straddling the stripes and the blocks
+		for _, piece := range []int{1, 63, 64, 65, 1000} {
+			h.Reset()
+			for rest := input; len(rest) > 0; {
+				n := piece
+				if n > len(rest) {
+					n = len(rest)
+				}
+				h.Write(rest[:n])
+				rest = rest[n:]
+			}
+			if sum := h.Sum64(); sum != v.sum {
+				t.Errorf("%d bytes in pieces of %d: %#016x, expected %#016x", v.n, piece, sum, v.sum)
+			}
+		}
+	}
+}
+
+// Sum64 doesn't change the state, more can be written after
+func TestXXH3SumThenWrite(t *testing.T) {
+	input := xxh3Input(100000)
+	h := newXXH3()
+	h.Write(input[:1025])
+	if sum := h.Sum64(); sum != 0xd9b414f4e1bbf7ad {
+		t.Errorf("first 1025 bytes: %#016x", sum)
+	}
+	h.Write(input[1025:])
+	if sum := h.Sum64(); sum != 0xb25cea78018497ff {
+		t.Errorf("all the bytes: %#016x", sum)
+	}
+}
@@ -0,0 +1,363 @@
+package catzip
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+)
+
+// A reader for xz files, following the .xz file format 1.1: a stream
+// header, blocks of LZMA2 data, filtered with BCJ x86 or not, each followed
+// by the check of its content, then an index of the blocks and a stream
+// footer. Concatenated streams, and the null padding between them, are read
+// through.
+
+const (
+	xzMagic       = "\xfd7zXZ\x00"
+	xzFooterMagic = "YZ"
+
+	xzCheckCRC32  = 0x01
+	xzCheckCRC64  = 0x04
+	xzCheckSHA256 = 0x0A
+
+	xzFilterX86   = 0x04
+	xzFilterLZMA2 = 0x21
+)
+
+var errXzCorrupt = errors.New("xz: corrupt stream")
+
+// xzCheckSizes are the sizes of the checks by type, the ones without a
+// hash here are skipped
+var xzCheckSizes = [16]int{0, 4, 4, 4, 8, 8, 8, 16, 16, 16, 32, 32, 32, 64, 64, 64}
+
+var xzCRC64Table = crc64.MakeTable(crc64.ECMA)
+
+type xzReader struct {
+	r       *bufio.Reader
+	streams int // Stream headers read
+	// In a stream, between its header and its index
+	inStream bool
+	flags    []byte
+	check    byte
+	records  []xzRecord // The blocks of the stream, for its index
+
+	// The current block, nil between blocks
+	block        io.Reader
+	compressed   *xzCounter
+	hash         hash.Hash
+	headerSize   int64
+	uncompressed int64
+	// The sizes the block header gives, -1 when it doesn't
+	headerCompressed, headerUncompressed int64
+	err                                  error
+}
+
+// xzRecord is a block as the index lists it
+type xzRecord struct {
+	unpadded, uncompressed int64
+}
+
+func newXzReader(r io.Reader) *xzReader {
+	return &xzReader{r: bufio.NewReader(r)}
+}
+
+func (z *xzReader) Read(p []byte) (int, error) {
+	for z.err == nil {
+		if z.block == nil {
+			z.err = z.next()
+			continue
+		}
+		n, err := z.block.Read(p)
+		if z.hash != nil {
+			z.hash.Write(p[:n])
+		}
+		z.uncompressed += int64(n)
+		if err == io.EOF {
+			err = z.endBlock()
+		}
+		if err != nil {
+			z.err = err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, z.err
+}
+
+// next reads up to the header of the next block, through the index and the
+// stream footer of the stream it ends and the header of the next stream. It
+// returns io.EOF after the last stream.
+func (z *xzReader) next() error {
+	if !z.inStream {
+		return z.readStreamHeader()
+	}
+	size, err := z.r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("xz: %w", unexpectedEOF(err))
+	}
+	if size == 0 {
+		return z.readIndex()
+	}
+	return z.readBlockHeader(size)
+}
+
+func (z *xzReader) readStreamHeader() error {
+	header := make([]byte, 12)
+	for {
+		// Null padding in multiples of 4 bytes can follow a stream
+		_, err := io.ReadFull(z.r, header[:4])
+		if err == io.EOF && z.streams > 0 {
+			return io.EOF
+		}
+		if err != nil {
+			return fmt.Errorf("xz: %w", unexpectedEOF(err))
+		}
+		if z.streams == 0 || binary.LittleEndian.Uint32(header) != 0 {
+			break
+		}
+	}
+	if _, err := io.ReadFull(z.r, header[4:]); err != nil {
+		return fmt.Errorf("xz: %w", unexpectedEOF(err))
+	}
+	if string(header[:6]) != xzMagic {
+		if z.streams == 0 {
+			return errors.New("xz: not an xz file")
+		}
+		return errXzCorrupt
+	}
+	if header[6] != 0 || header[7]&0xF0 != 0 || crc32.ChecksumIEEE(header[6:8]) != binary.LittleEndian.Uint32(header[8:]) {
+		return errXzCorrupt
+	}
+	z.flags = append(z.flags[:0], header[6:8]...)
+	z.check = header[7]
+	z.inStream = true
+	z.streams++
+	return nil
+}
+
+// readBlockHeader reads the header of a block, of size (size+1)*4, and sets
+// up the filters it lists
+func (z *xzReader) readBlockHeader(size byte) error {
+	header := make([]byte, (int(size)+1)*4)
+	header[0] = size
+	if _, err := io.ReadFull(z.r, header[1:]); err != nil {
+		return fmt.Errorf("xz: %w", unexpectedEOF(err))
+	}
+	crcAt := len(header) - 4
+	if crc32.ChecksumIEEE(header[:crcAt]) != binary.LittleEndian.Uint32(header[crcAt:]) {
+		return errXzCorrupt
+	}
+	flags := header[1]
+	if flags&0x3C != 0 {
+		return errXzCorrupt
+	}
+	fields := header[2:crcAt]
+	vli := func() (int64, bool) {
+		v, n := binary.Uvarint(fields)
+		if n <= 0 || v > 1<<63-1 {
+			return 0, false
+		}
+		fields = fields[n:]
+		return int64(v), true
+	}
+
+	z.headerCompressed, z.headerUncompressed = -1, -1
+	var ok bool
+	if flags&0x40 != 0 {
+		if z.headerCompressed, ok = vli(); !ok {
+			return errXzCorrupt
+		}
+	}
+	if flags&0x80 != 0 {
+		if z.headerUncompressed, ok = vli(); !ok {
+			return errXzCorrupt
+		}
+	}
+
+	// LZMA2 comes last, its output goes through the filters before it in
+	// reverse order
+	filters := int(flags&0x03) + 1
+	var dictSize uint32
+	x86 := false
+	for i := 0; i < filters; i++ {
+		id, ok := vli()
+		if !ok {
+			return errXzCorrupt
+		}
+		propsSize, ok := vli()
+		if !ok || propsSize > int64(len(fields)) {
+			return errXzCorrupt
+		}
+		props := fields[:propsSize]
+		fields = fields[propsSize:]
+		last := i == filters-1
+		switch {
+		case id == xzFilterLZMA2 && last && len(props) == 1:
+			var err error
+			if dictSize, err = lzma2DictSize(props[0]); err != nil {
+				return err
+			}
+		case id == xzFilterX86 && !last && !x86 && (len(props) == 0 || len(props) == 4 && binary.LittleEndian.Uint32(props) == 0):
+			x86 = true
+		case id == xzFilterLZMA2 || id == xzFilterX86:
+			return errXzCorrupt
+		default:
+			return fmt.Errorf("xz: unsupported filter %#x", id)
+		}
+	}
+	if !bytes.Equal(fields, make([]byte, len(fields))) {
+		return errXzCorrupt
+	}
+
+	z.compressed = &xzCounter{r: z.r}
+	z.block = newLZMA2Reader(z.compressed, dictSize, z.headerUncompressed)
+	if x86 {
+		z.block = newBCJReader(z.block)
+	}
+	z.headerSize = int64(len(header))
+	z.uncompressed = 0
+	switch z.check {
+	case xzCheckCRC32:
+		z.hash = crc32.NewIEEE()
+	case xzCheckCRC64:
+		z.hash = crc64.New(xzCRC64Table)
+	case xzCheckSHA256:
+		z.hash = sha256.New()
+	default:
+		z.hash = nil
+	}
+	return nil
+}
+
+// endBlock checks the sizes of the block read through, reads its padding
+// and its check
+func (z *xzReader) endBlock() error {
+	compressed := z.compressed.n
+	if z.headerCompressed >= 0 && compressed != z.headerCompressed || z.headerUncompressed >= 0 && z.uncompressed != z.headerUncompressed {
+		return errXzCorrupt
+	}
+	for i := z.headerSize + compressed; i%4 != 0; i++ {
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("xz: %w", unexpectedEOF(err))
+		}
+		if b != 0 {
+			return errXzCorrupt
+		}
+	}
+	check := make([]byte, xzCheckSizes[z.check])
+	if _, err := io.ReadFull(z.r, check); err != nil {
+		return fmt.Errorf("xz: %w", unexpectedEOF(err))
+	}
+	if z.hash != nil {
+		sum := z.hash.Sum(nil)
+		if z.check != xzCheckSHA256 {
+			// The CRCs are stored little endian
+			for i, j := 0, len(sum)-1; i < j; i, j = i+1, j-1 {
+				sum[i], sum[j] = sum[j], sum[i]
+			}
+		}
+		if !bytes.Equal(sum, check) {
+			return errors.New("xz: checksum error")
+		}
+	}
+	z.records = append(z.records, xzRecord{z.headerSize + compressed + int64(len(check)), z.uncompressed})
+	z.block = nil
+	return nil
+}
+
+// readIndex reads the index, its indicator read, and the stream footer. The
+// index must list the blocks read.
+func (z *xzReader) readIndex() error {
+	index := &xzCounter{r: z.r, crc: crc32.NewIEEE()}
+	index.crc.Write([]byte{0})
+	count, err := binary.ReadUvarint(index)
+	if err != nil {
+		return fmt.Errorf("xz: %w", unexpectedEOF(err))
+	}
+	if count != uint64(len(z.records)) {
+		return errXzCorrupt
+	}
+	for _, record := range z.records {
+		unpadded, err := binary.ReadUvarint(index)
+		if err != nil {
+			return fmt.Errorf("xz: %w", unexpectedEOF(err))
+		}
+		uncompressed, err := binary.ReadUvarint(index)
+		if err != nil {
+			return fmt.Errorf("xz: %w", unexpectedEOF(err))
+		}
+		if unpadded != uint64(record.unpadded) || uncompressed != uint64(record.uncompressed) {
+			return errXzCorrupt
+		}
+	}
+	for (1+index.n)%4 != 0 {
+		b, err := index.ReadByte()
+		if err != nil {
+			return fmt.Errorf("xz: %w", unexpectedEOF(err))
+		}
+		if b != 0 {
+			return errXzCorrupt
+		}
+	}
+	indexSize := 1 + index.n + 4
+
+	// The CRC32 of the index, then the footer: its CRC32, the size of the
+	// index, the stream flags and the magic bytes
+	tail := make([]byte, 4+12)
+	if _, err := io.ReadFull(z.r, tail); err != nil {
+		return fmt.Errorf("xz: %w", unexpectedEOF(err))
+	}
+	footer := tail[4:]
+	switch {
+	case binary.LittleEndian.Uint32(tail) != index.crc.Sum32(),
+		binary.LittleEndian.Uint32(footer) != crc32.ChecksumIEEE(footer[4:10]),
+		(int64(binary.LittleEndian.Uint32(footer[4:]))+1)*4 != indexSize,
+		!bytes.Equal(footer[8:10], z.flags),
+		string(footer[10:]) != xzFooterMagic:
+		return errXzCorrupt
+	}
+	z.inStream = false
+	z.records = z.records[:0]
+	return nil
+}
+
+// xzCounter counts the bytes read from r, adding them to crc when it isn't
+// nil
+type xzCounter struct {
+	r   *bufio.Reader
+	n   int64
+	crc hash.Hash32
+}
+
+func (c *xzCounter) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+		if c.crc != nil {
+			c.crc.Write([]byte{b})
+		}
+	}
+	return b, err
+}
+
+// Read is only there for newLZMA2Reader, which reads a byte at a time
+func (c *xzCounter) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b, err := c.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p[0] = b
+	return 1, nil
+}
@@ -0,0 +1,119 @@
+package catzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+)
+
+// The vectors under testdata are from the xz command: lines.xz with its
+// defaults, a CRC64 check, code.bcj.xz with --x86 --lzma2 and a SHA-256
+// check, lines.blocks.xz with -T2 --block-size=8KiB, blocks giving their
+// sizes, and a CRC32 check, and lines.none.xz without check.
+
+func decodeXz(data []byte, limit int64) ([]byte, error) {
+	return readLimited(newXzReader(bytes.NewReader(data)), limit)
+}
+
+func TestXz(t *testing.T) {
+	lines, code := readTestdata(t, "lines.xz"), readTestdata(t, "code.bcj.xz")
+	// Streams padded with nulls, in 4 bytes multiples, can follow each other
+	padded := append(append(append([]byte{}, lines...), make([]byte, 8)...), code...)
+	for _, v := range []struct {
+		name    string
+		data    []byte
+		content []byte
+	}{
+		{"lines.xz", lines, testLines(40000)},
+		{"code.bcj.xz", code, testCode(20000)},
+		{"lines.blocks.xz", readTestdata(t, "lines.blocks.xz"), testLines(40000)},
+		{"lines.none.xz", readTestdata(t, "lines.none.xz"), testLines(5000)},
+		{"concatenated", padded, append(testLines(40000), testCode(20000)...)},
+	} {
+		out, err := decodeXz(v.data, int64(len(v.content)))
+		if err != nil {
+			t.Errorf("%s: %v", v.name, err)
+		} else if !bytes.Equal(out, v.content) {
+			t.Errorf("%s: decoded %d bytes, not the content", v.name, len(out))
+		}
+
+		out, err = io.ReadAll(iotest.OneByteReader(newXzReader(iotest.OneByteReader(bytes.NewReader(v.data)))))
+		if err != nil || !bytes.Equal(out, v.content) {
+			t.Errorf("%s byte by byte: %d bytes, %v", v.name, len(out), err)
+		}
+	}
+
+	// Padding that isn't a multiple of 4, and something else than a stream
+	for name, data := range map[string][]byte{
+		"odd padding": append(append([]byte{}, lines...), 0, 0),
+		"garbage":     append(append([]byte{}, lines...), "garbage!!!!!"...),
+		"not xz":      []byte("not an xz file"),
+		"empty":       {},
+	} {
+		if _, err := decodeXz(data, 1<<20); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if size := xzSizeHint(fstest.MapFS{"a.xz": {Data: readTestdata(t, "lines.blocks.xz")}}, "a.xz"); size != 40000 {
+		t.Errorf("size hint %d", size)
+	}
+}
+
+// The checks of the content are verified
+func TestXzCheck(t *testing.T) {
+	for _, name := range []string{"lines.xz", "code.bcj.xz", "lines.blocks.xz"} {
+		data := readTestdata(t, name)
+		// The last byte of the check of the last block, right before the
+		// index whose size the footer gives
+		footer := data[len(data)-12:]
+		index := len(data) - 12 - int(binary.LittleEndian.Uint32(footer[4:])+1)*4
+		corrupt := append([]byte{}, data...)
+		corrupt[index-1] ^= 1
+		_, err := decodeXz(corrupt, 1<<20)
+		if err == nil || err.Error() != "xz: checksum error" {
+			t.Errorf("%s: decoded with %v", name, err)
+		}
+	}
+}
+
+func TestXzCorrupt(t *testing.T) {
+	for _, name := range []string{"lines.xz", "code.bcj.xz", "lines.blocks.xz", "lines.none.xz"} {
+		checkCorrupt(t, readTestdata(t, name), func(data []byte) error {
+			_, err := decodeXz(data, 80000)
+			return err
+		})
+	}
+}
+
+func TestXzRun(t *testing.T) {
+	lines := readTestdata(t, "lines.xz")
+	// An entry of method 95, the xz file as it is
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, _ := w.CreateRaw(&zip.FileHeader{
+		Name:               "lines.log",
+		Method:             zipMethodXz,
+		CRC32:              crc32.ChecksumIEEE(testLines(40000)),
+		CompressedSize64:   uint64(len(lines)),
+		UncompressedSize64: 40000,
+	})
+	f.Write(lines)
+	w.Close()
+
+	// The .xz file is extracted next to it
+	for _, v := range []struct{ ext, output string }{{".xz", "lines"}, {".zip", "/out/lines.log"}} {
+		data := lines
+		if v.ext == ".zip" {
+			data = buf.Bytes()
+		}
+		out := runInMemory(t, v.ext, fstest.MapFS{"lines" + v.ext: {Data: data}})
+		if data, err := out.ReadFile(v.output); err != nil || !bytes.Equal(data, testLines(40000)) {
+			t.Errorf("%s: extracted %d bytes, %v", v.ext, len(data), err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package catzip
+
+import (
+	"archive/zip"
+	"compress/bzip2"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// Compression methods archive/zip doesn't know about
+const (
+	zipMethodBzip2 = 12
+	zipMethodLZMA  = 14
+	zipMethodZstd  = 93
+	zipMethodXz    = 95
+)
+
+// The methods decompressed by a command, which has to be in PATH, the
+// entries are skipped without it
+var zipMethodCommands = map[uint16]string{
+	zipMethodZstd: "zstd",
+}
+
+// missingMethodCommand is why an entry of method can't be read, "" when it
+// isn't decompressed by a command or the command is in PATH
+func missingMethodCommand(method uint16) string {
+	name, ok := zipMethodCommands[method]
+	if !ok {
+		return ""
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Sprintf("%s compressed and the %s command isn't available", name, name)
+	}
+	return ""
+}
+
+// registerDecompressors adds the extra methods to reader, LZMA is registered
+// per entry by registerEntryDecompressor since it needs the entry size.
+// Zstd is decompressed by the zstd command.
+func registerDecompressors(reader *zip.Reader) {
+	reader.RegisterDecompressor(zipMethodBzip2, func(r io.Reader) io.ReadCloser {
+		return io.NopCloser(bzip2.NewReader(r))
+	})
+	reader.RegisterDecompressor(zipMethodXz, func(r io.Reader) io.ReadCloser {
+		return io.NopCloser(newXzReader(r))
+	})
+	for method, name := range zipMethodCommands {
+		name := name
+		reader.RegisterDecompressor(method, func(r io.Reader) io.ReadCloser {
+			return newCommandReader(r, name, "-d", "-c")
+		})
+	}
+}
+
+// registerEntryDecompressor must be called before opening f, entries of the
+// same reader have to be opened one at a time
+func registerEntryDecompressor(reader *zip.Reader, f *zip.File) {
+	if f.Method != zipMethodLZMA {
+		return
+	}
+
+	// Without the end marker flag the stream stops at the uncompressed size
+	size := int64(f.UncompressedSize64)
+	if f.Flags&0x2 != 0 {
+		size = -1
+	}
+	reader.RegisterDecompressor(zipMethodLZMA, func(r io.Reader) io.ReadCloser {
+		lzmaReader, err := newZipLZMAReader(r, size)
+		if err != nil {
+			return errReadCloser{err}
+		}
+		return io.NopCloser(lzmaReader)
+	})
+}
+
+// newZipLZMAReader skips the LZMA SDK version and reads the properties that
+// precede the stream in zip entries
+func newZipLZMAReader(r io.Reader, size int64) (*lzmaReader, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	propsSize := binary.LittleEndian.Uint16(header[2:])
+	if propsSize < 5 {
+		return nil, fmt.Errorf("lzma: invalid properties size %d", propsSize)
+	}
+	props := make([]byte, propsSize)
+	if _, err := io.ReadFull(r, props); err != nil {
+		return nil, err
+	}
+	return newLZMAReader(r, props[0], binary.LittleEndian.Uint32(props[1:]), size)
+}
+
+type errReadCloser struct {
+	err error
+}
+
+func (e errReadCloser) Read(p []byte) (int, error) { return 0, e.err }
+func (e errReadCloser) Close() error               { return nil }
+
+// commandReader pipes its input through an external decompressor, used for
+// formats without a decoder in the standard library
+type commandReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+func newCommandReader(r io.Reader, name string, args ...string) io.ReadCloser {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return errReadCloser{fmt.Errorf("decompressing requires the %s command, install it or add it to PATH: %w", name, err)}
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errReadCloser{err}
+	}
+	if err = cmd.Start(); err != nil {
+		return errReadCloser{err}
+	}
+	return &commandReader{cmd: cmd, stdout: stdout}
+}
+
+func (c *commandReader) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if err == io.EOF {
+		// Surface the exit status of the decompressor
+		if waitErr := c.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// wait waits for the command once, returning its error every time
+func (c *commandReader) wait() error {
+	c.waitOnce.Do(func() {
+		if err := c.cmd.Wait(); err != nil {
+			c.waitErr = fmt.Errorf("%s: %w", c.cmd.Path, err)
+		}
+	})
+	return c.waitErr
+}
+
+func (c *commandReader) Close() error {
+	c.stdout.Close()
+	return c.wait()
+}
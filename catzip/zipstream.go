@@ -0,0 +1,460 @@
+package catzip
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// zipStream reads the entries of a zip file in order from their local
+// headers, for the inputs that can't be read at random offsets to find the
+// central directory
+type zipStream struct {
+	r   *bufio.Reader
+	cur *zipStreamEntry
+}
+
+type zipStreamEntry struct {
+	zip.FileHeader
+	zip64 bool      // The data descriptor has 8 bytes sizes
+	raw   io.Reader // Compressed data, up to the data descriptor
+	open  *zipStreamReader
+	done  bool // Read through, the data descriptor included
+}
+
+// The entries whose end is only found by decompressing them
+func (e *zipStreamEntry) hasDescriptor() bool { return e.Flags&0x8 != 0 }
+
+func newZipStream(r io.Reader) *zipStream {
+	return &zipStream{r: bufio.NewReader(r)}
+}
+
+// next reads the local header of the next entry, past what is left of the
+// previous one. It returns io.EOF at the central directory.
+func (s *zipStream) next() (*zipStreamEntry, error) {
+	if s.cur != nil && !s.cur.done {
+		if err := s.cur.skip(); err != nil {
+			return nil, err
+		}
+	}
+	s.cur = nil
+
+	var signature [4]byte
+	if _, err := io.ReadFull(s.r, signature[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if binary.LittleEndian.Uint32(signature[:]) == 0x08074b50 {
+		// Spanning marker of single segment split archives
+		if _, err := io.ReadFull(s.r, signature[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	switch binary.LittleEndian.Uint32(signature[:]) {
+	case 0x04034b50:
+	case 0x02014b50, 0x06054b50, 0x06064b50:
+		return nil, io.EOF
+	default:
+		return nil, zip.ErrFormat
+	}
+
+	header := make([]byte, 26)
+	if _, err := io.ReadFull(s.r, header); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	entry := &zipStreamEntry{}
+	entry.Flags = binary.LittleEndian.Uint16(header[2:])
+	entry.Method = binary.LittleEndian.Uint16(header[4:])
+	entry.Modified = dosTime(binary.LittleEndian.Uint16(header[8:]), binary.LittleEndian.Uint16(header[6:]))
+	entry.CRC32 = binary.LittleEndian.Uint32(header[10:])
+	entry.CompressedSize64 = uint64(binary.LittleEndian.Uint32(header[14:]))
+	entry.UncompressedSize64 = uint64(binary.LittleEndian.Uint32(header[18:]))
+	nameAndExtra := make([]byte, int(binary.LittleEndian.Uint16(header[22:]))+int(binary.LittleEndian.Uint16(header[24:])))
+	if _, err := io.ReadFull(s.r, nameAndExtra); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	nameLen := binary.LittleEndian.Uint16(header[22:])
+	entry.Name = string(nameAndExtra[:nameLen])
+	entry.Extra = nameAndExtra[nameLen:]
+	entry.NonUTF8 = entry.Flags&0x800 == 0
+	entry.readExtra()
+
+	entry.raw = s.r
+	if !entry.hasDescriptor() {
+		entry.raw = io.LimitReader(s.r, int64(entry.CompressedSize64))
+	}
+	s.cur = entry
+	return entry, nil
+}
+
+// readExtra reads the zip64 sizes and the modification time of the extra
+// fields
+func (e *zipStreamEntry) readExtra() {
+	for extra := e.Extra; len(extra) >= 4; {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			return
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		switch id {
+		case 0x0001:
+			e.zip64 = true
+			if e.UncompressedSize64 == 0xffffffff && len(field) >= 8 {
+				e.UncompressedSize64 = binary.LittleEndian.Uint64(field)
+				field = field[8:]
+			}
+			if e.CompressedSize64 == 0xffffffff && len(field) >= 8 {
+				e.CompressedSize64 = binary.LittleEndian.Uint64(field)
+			}
+		case 0x5455:
+			// Extended timestamp, the modification time comes first
+			if len(field) >= 5 && field[0]&0x1 != 0 {
+				e.Modified = time.Unix(int64(int32(binary.LittleEndian.Uint32(field[1:]))), 0).UTC()
+			}
+		}
+	}
+}
+
+// dosTime is the time of the local header, in UTC like archive/zip does
+// without an extended timestamp
+func dosTime(date, t uint16) time.Time {
+	return time.Date(int(date>>9)+1980, time.Month(date>>5&0xf), int(date&0x1f), int(t>>11), int(t>>5&0x3f), int(t&0x1f)*2, 0, time.UTC)
+}
+
+// isDir tells whether the entry is a directory, the local headers have no
+// mode
+func (e *zipStreamEntry) isDir() bool {
+	return len(e.Name) > 0 && e.Name[len(e.Name)-1] == '/'
+}
+
+func (e *zipStreamEntry) mode() fs.FileMode {
+	if e.isDir() {
+		return fs.ModeDir | 0777
+	}
+	return 0666
+}
+
+// unsupportedReason is why the entry is skipped, "" when it can be read.
+// Its end can only be found when its size is known or it is deflated or
+// stored.
+func (e *zipStreamEntry) unsupportedReason() string {
+	switch {
+	case e.Flags&0x1 != 0:
+		return "encrypted"
+	case e.hasDescriptor() && e.Method != zip.Deflate && e.Method != zip.Store:
+		return fmt.Sprintf("compression method %d without its size in the local header", e.Method)
+	}
+	switch e.Method {
+	case zip.Store, zip.Deflate, zipMethodBzip2, zipMethodLZMA, zipMethodXz:
+		return ""
+	case zipMethodZstd:
+		return missingMethodCommand(e.Method)
+	}
+	return fmt.Sprintf("unsupported compression method %d", e.Method)
+}
+
+// skip reads past the entry, decompressing it when that is the only way
+// to find its end
+func (e *zipStreamEntry) skip() error {
+	if !e.hasDescriptor() {
+		_, err := io.Copy(io.Discard, e.raw)
+		e.done = err == nil
+		return unexpectedEOF(err)
+	}
+	if e.unsupportedReason() != "" {
+		return fmt.Errorf("%s: the end of the entry can't be found without its size", e.Name)
+	}
+	reader := e.open
+	if reader == nil {
+		var err error
+		if reader, err = e.openReader(); err != nil {
+			return err
+		}
+	}
+	_, err := io.Copy(io.Discard, reader)
+	return err
+}
+
+// openReader opens the content of the entry, it can only be read once and
+// before the next one
+func (e *zipStreamEntry) openReader() (*zipStreamReader, error) {
+	if e.open != nil {
+		return nil, errors.New("zip entry already read")
+	}
+	var rc io.ReadCloser
+	switch e.Method {
+	case zip.Store:
+		rc = io.NopCloser(e.raw)
+		if e.hasDescriptor() {
+			rc = io.NopCloser(&storedReader{r: e.raw.(*bufio.Reader), zip64: e.zip64})
+		}
+	case zip.Deflate:
+		// Without a size e.raw is the buffered stream, which flate reads a
+		// byte at a time, so it stops right at the end of the deflated data
+		rc = flate.NewReader(e.raw)
+	case zipMethodBzip2:
+		rc = io.NopCloser(bzip2.NewReader(e.raw))
+	case zipMethodXz:
+		rc = io.NopCloser(newXzReader(e.raw))
+	case zipMethodZstd:
+		rc = newCommandReader(e.raw, zipMethodCommands[e.Method], "-d", "-c")
+	case zipMethodLZMA:
+		size := int64(e.UncompressedSize64)
+		if e.Flags&0x2 != 0 {
+			size = -1
+		}
+		lzmaReader, err := newZipLZMAReader(e.raw, size)
+		if err != nil {
+			return nil, err
+		}
+		rc = io.NopCloser(lzmaReader)
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+	e.open = &zipStreamReader{entry: e, rc: rc, hash: crc32.NewIEEE()}
+	return e.open, nil
+}
+
+// zipStreamReader checks the CRC-32 and the size of the content of a
+// streamed entry, read from its data descriptor when it has one
+type zipStreamReader struct {
+	entry *zipStreamEntry
+	rc    io.ReadCloser
+	hash  hash.Hash32
+	n     uint64
+	err   error
+}
+
+func (z *zipStreamReader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n, err := z.rc.Read(p)
+	z.hash.Write(p[:n])
+	z.n += uint64(n)
+	if err == io.EOF {
+		err = z.finish()
+	}
+	if err != nil {
+		z.err = err
+	}
+	return n, err
+}
+
+func (z *zipStreamReader) finish() error {
+	e := z.entry
+	if e.hasDescriptor() {
+		if err := e.readDescriptor(); err != nil {
+			return err
+		}
+	} else if _, err := io.Copy(io.Discard, e.raw); err != nil {
+		return unexpectedEOF(err)
+	}
+	e.done = true
+	if z.n != e.UncompressedSize64 {
+		return fmt.Errorf("%s: %w, %d bytes instead of %d", e.Name, zip.ErrFormat, z.n, e.UncompressedSize64)
+	}
+	if z.hash.Sum32() != e.CRC32 {
+		return fmt.Errorf("%s: %w", e.Name, zip.ErrChecksum)
+	}
+	return io.EOF
+}
+
+func (z *zipStreamReader) Close() error {
+	return z.rc.Close()
+}
+
+// readDescriptor reads the CRC-32 and the sizes that follow the entry data,
+// with or without their signature
+func (e *zipStreamEntry) readDescriptor() error {
+	crc := make([]byte, 4)
+	if _, err := io.ReadFull(e.raw, crc); err != nil {
+		return unexpectedEOF(err)
+	}
+	if binary.LittleEndian.Uint32(crc) == 0x08074b50 {
+		if _, err := io.ReadFull(e.raw, crc); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	e.CRC32 = binary.LittleEndian.Uint32(crc)
+
+	sizes := make([]byte, 8)
+	if e.zip64 {
+		sizes = make([]byte, 16)
+	}
+	if _, err := io.ReadFull(e.raw, sizes); err != nil {
+		return unexpectedEOF(err)
+	}
+	if e.zip64 {
+		e.CompressedSize64 = binary.LittleEndian.Uint64(sizes)
+		e.UncompressedSize64 = binary.LittleEndian.Uint64(sizes[8:])
+	} else {
+		e.CompressedSize64 = uint64(binary.LittleEndian.Uint32(sizes))
+		e.UncompressedSize64 = uint64(binary.LittleEndian.Uint32(sizes[4:]))
+	}
+	return nil
+}
+
+// storedReader reads a stored entry up to its data descriptor, the first
+// one with its signature and the size read so far
+type storedReader struct {
+	r     *bufio.Reader
+	zip64 bool
+	n     int64
+	done  bool
+}
+
+func (s *storedReader) Read(p []byte) (int, error) {
+	if s.done {
+		return 0, io.EOF
+	}
+	descriptorLen := 16
+	if s.zip64 {
+		descriptorLen = 24
+	}
+	ahead := len(p)
+	if ahead > s.r.Size()-descriptorLen {
+		ahead = s.r.Size() - descriptorLen
+	}
+	buf, _ := s.r.Peek(ahead + descriptorLen)
+	if len(buf) < descriptorLen {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	// Where a whole descriptor fits
+	end := len(buf) - descriptorLen + 1
+	for i := 0; i < end; {
+		at := bytes.Index(buf[i:end+3], []byte("PK\x07\x08"))
+		if at < 0 {
+			break
+		}
+		i += at
+		size := int64(binary.LittleEndian.Uint32(buf[i+8:]))
+		if s.zip64 {
+			size = int64(binary.LittleEndian.Uint64(buf[i+8:]))
+		}
+		if size == s.n+int64(i) {
+			end = i
+			s.done = true
+			break
+		}
+		i++
+	}
+	n := copy(p, buf[:end])
+	s.r.Discard(n)
+	s.n += int64(n)
+	return n, nil
+}
+
+// isStream tells whether the input file name can only be read in order,
+// like a named pipe or the files of an fs.FS without ReadAt
+func isStream(fsys fs.FS, name string) bool {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return false
+	}
+	if info.Mode()&(fs.ModeNamedPipe|fs.ModeSocket|fs.ModeCharDevice) != 0 {
+		return true
+	}
+	if isOSInputs(fsys) {
+		return false
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	_, ok := file.(io.ReaderAt)
+	return !ok
+}
+
+// handleZipStream extracts the zip file f that can only be read in order,
+// its entries aren't counted upfront
+func (r *run) handleZipStream(f string) error {
+	file, err := r.in.Open(f)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	destination, err := filepath.Abs(r.opts.OutDir)
+	if err != nil {
+		return fmt.Errorf("unable to find absolute path for dir %s: %w", r.opts.OutDir, err)
+	}
+
+	stream := newZipStream(file)
+	archive := manifestArchive{Path: f}
+	for i := 0; ; i++ {
+		header, err := stream.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read %s stream: %w", r.opts.inputExt(f), err)
+		}
+
+		entry := &EntryInfo{
+			Archive:        f,
+			Name:           header.Name,
+			Size:           header.UncompressedSize64,
+			CompressedSize: header.CompressedSize64,
+			Mode:           header.mode(),
+			ModTime:        header.Modified,
+			CRC32:          header.CRC32,
+			Entry:          i + 1,
+			index:          i,
+		}
+		if reason := header.unsupportedReason(); reason != "" {
+			r.skipEntry(entry, reason)
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+		if header.NonUTF8 {
+			header.Name = decodeName(header.Name, r.opts.Handlers["zip"].NameEncoding)
+			entry.Name = header.Name
+		}
+
+		name := r.renamed(header.Name)
+		if name == "" {
+			r.skipEntry(entry, "renamed to an empty name")
+			archive.Entries = append(archive.Entries, entry)
+			continue
+		}
+		if !header.isDir() {
+			var ok bool
+			if name, ok, err = r.applyPolicy(entry, name); err != nil {
+				return err
+			}
+			if !ok {
+				archive.Entries = append(archive.Entries, entry)
+				continue
+			}
+		}
+
+		size := int64(header.UncompressedSize64)
+		if header.hasDescriptor() {
+			size = -1
+		}
+		open := func() (io.ReadCloser, error) { return header.openReader() }
+		if err := r.extractEntry(name, entry, destination, header.isDir(), size, open); err != nil {
+			return fmt.Errorf("unable to unzip file inside archive: %w", err)
+		}
+		if entry.Output != "" {
+			archive.Entries = append(archive.Entries, entry)
+		}
+	}
+	r.manifest.add(archive)
+	return nil
+}
@@ -0,0 +1,233 @@
+package catzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
+)
+
+// stream.zip under testdata is from zip - lines.log code.bin writing to a
+// pipe: deflated entries with data descriptors.
+
+var zipStreamModTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+type zipStreamFile struct {
+	name    string
+	content []byte
+}
+
+func zipStreamVector() []zipStreamFile {
+	return []zipStreamFile{{"lines.log", testLines(140000)}, {"code.bin", testCode(60000)}}
+}
+
+// zipStreamWritten returns an archive of archive/zip, with its files: a
+// deflated one, a stored one holding a false data descriptor, both with
+// descriptors, a stored one without, a directory and an empty file
+func zipStreamWritten() ([]byte, []zipStreamFile) {
+	fake := []byte("before PK\x07\x08 with a wrong size\x00\x00\x00\x00\x00\x00\x00\x00 after")
+	files := []zipStreamFile{
+		{"lines.log", testLines(40000)},
+		{"fake.bin", fake},
+		{"raw.txt", []byte("raw\n")},
+		{"dir/", nil},
+		{"empty", nil},
+	}
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		header := &zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: zipStreamModTime}
+		switch f.name {
+		case "fake.bin":
+			header.Method = zip.Store
+		case "raw.txt":
+			header.Method = zip.Store
+			header.CRC32 = crc32.ChecksumIEEE(f.content)
+			header.CompressedSize64 = uint64(len(f.content))
+			header.UncompressedSize64 = uint64(len(f.content))
+			raw, _ := w.CreateRaw(header)
+			raw.Write(f.content)
+			continue
+		}
+		fw, _ := w.CreateHeader(header)
+		fw.Write(f.content)
+	}
+	w.Close()
+	return buf.Bytes(), files
+}
+
+// zipStreamStored64 returns an entry stored with a zip64 data descriptor
+// after a spanning marker, then the signature of a central directory
+func zipStreamStored64(content []byte) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 0x08074b50)
+	b = binary.LittleEndian.AppendUint32(b, 0x04034b50)
+	b = append(b, 45, 0, 0x08, 0, 0, 0, 0, 0, 0x21, 0)
+	b = append(b, make([]byte, 4)...)
+	b = binary.LittleEndian.AppendUint32(b, 0xffffffff)
+	b = binary.LittleEndian.AppendUint32(b, 0xffffffff)
+	b = append(b, 7, 0, 20, 0)
+	b = append(b, "big.bin"...)
+	b = append(b, 0x01, 0, 16, 0)
+	b = append(b, make([]byte, 16)...)
+	b = append(b, content...)
+	b = binary.LittleEndian.AppendUint32(b, 0x08074b50)
+	b = binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(content))
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(content)))
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(content)))
+	return binary.LittleEndian.AppendUint32(b, 0x02014b50)
+}
+
+// readZipStream reads the entries of the zip file in data, the content of
+// those where read is set
+func readZipStream(r io.Reader, read func(i int) bool) ([]zipStreamFile, []*zipStreamEntry, error) {
+	stream := newZipStream(r)
+	var files []zipStreamFile
+	var entries []*zipStreamEntry
+	for i := 0; ; i++ {
+		entry, err := stream.next()
+		if err == io.EOF {
+			return files, entries, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, entry)
+		var content []byte
+		if read(i) {
+			if reason := entry.unsupportedReason(); reason != "" {
+				return nil, nil, errors.New(reason)
+			}
+			rc, err := entry.openReader()
+			if err != nil {
+				return nil, nil, err
+			}
+			if content, err = readLimited(rc, 1<<20); err != nil {
+				return nil, nil, err
+			}
+		}
+		files = append(files, zipStreamFile{entry.Name, content})
+	}
+}
+
+func checkZipStreamFiles(t *testing.T, what string, files, expected []zipStreamFile, read func(i int) bool) {
+	t.Helper()
+	if len(files) != len(expected) {
+		t.Fatalf("%s: %d entries, expected %d", what, len(files), len(expected))
+	}
+	for i, f := range files {
+		if f.name != expected[i].name {
+			t.Errorf("%s: entry %d is %s, expected %s", what, i, f.name, expected[i].name)
+		}
+		if read(i) && !bytes.Equal(f.content, expected[i].content) {
+			t.Errorf("%s: %s has %d bytes, not its content", what, f.name, len(f.content))
+		}
+	}
+}
+
+func TestZipStream(t *testing.T) {
+	written, writtenFiles := zipStreamWritten()
+	big := testCode(70000)
+	for _, v := range []struct {
+		name     string
+		data     []byte
+		expected []zipStreamFile
+	}{
+		{"stream.zip", readTestdata(t, "stream.zip"), zipStreamVector()},
+		{"archive/zip", written, writtenFiles},
+		{"zip64 stored", zipStreamStored64(big), []zipStreamFile{{"big.bin", big}}},
+	} {
+		// Read, or skipped as the stream goes
+		for _, read := range []func(int) bool{
+			func(int) bool { return true },
+			func(i int) bool { return i%2 == 1 },
+		} {
+			files, entries, err := readZipStream(bytes.NewReader(v.data), read)
+			if err != nil {
+				t.Fatalf("%s: %v", v.name, err)
+			}
+			checkZipStreamFiles(t, v.name, files, v.expected, read)
+			for _, entry := range entries {
+				// CreateRaw writes no time, big.bin has the DOS epoch
+				dated := entry.Name != "raw.txt" && entry.Name != "big.bin"
+				if dated && !entry.Modified.Equal(zipStreamModTime) {
+					t.Errorf("%s: %s modified %v", v.name, entry.Name, entry.Modified)
+				}
+				if entry.isDir() != (entry.Name == "dir/") {
+					t.Errorf("%s: %s is a directory: %v", v.name, entry.Name, entry.isDir())
+				}
+			}
+		}
+
+		all := func(int) bool { return true }
+		files, _, err := readZipStream(iotest.OneByteReader(bytes.NewReader(v.data)), all)
+		if err != nil {
+			t.Fatalf("%s byte by byte: %v", v.name, err)
+		}
+		checkZipStreamFiles(t, v.name+" byte by byte", files, v.expected, all)
+	}
+}
+
+// The CRC-32 of the data descriptor is checked
+func TestZipStreamChecksum(t *testing.T) {
+	data := zipStreamStored64([]byte("some content\n"))
+	data[len(data)-24] ^= 1
+	if _, _, err := readZipStream(bytes.NewReader(data), func(int) bool { return true }); !errors.Is(err, zip.ErrChecksum) {
+		t.Errorf("read with %v", err)
+	}
+}
+
+// errZipStreamShorter stands for a zip file cut in its central directory,
+// which the stream stops at
+var errZipStreamShorter = errors.New("cut in the central directory")
+
+func TestZipStreamCorrupt(t *testing.T) {
+	written, _ := zipStreamWritten()
+	for _, data := range [][]byte{readTestdata(t, "stream.zip"), written, zipStreamStored64(testCode(3000))} {
+		directory := int(binary.LittleEndian.Uint32(data[len(data)-6:]))
+		if binary.LittleEndian.Uint32(data[len(data)-4:]) == 0x02014b50 {
+			directory = len(data) - 4
+		}
+		checkCorrupt(t, data, func(cut []byte) error {
+			_, _, err := readZipStream(bytes.NewReader(cut), func(int) bool { return true })
+			if err == nil && len(cut) >= directory+4 {
+				return errZipStreamShorter
+			}
+			return err
+		})
+	}
+}
+
+// streamFS hides the ReadAt of the files of a MapFS, so they are read as
+// streams
+type streamFS struct{ fstest.MapFS }
+
+func (s streamFS) Open(name string) (fs.File, error) {
+	f, err := s.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && !info.IsDir() {
+		return struct{ fs.File }{f}, nil
+	}
+	return f, nil
+}
+
+func TestZipStreamRun(t *testing.T) {
+	inputs := streamFS{fstest.MapFS{"stream.zip": {Data: readTestdata(t, "stream.zip")}}}
+	if !isStream(inputs, "stream.zip") {
+		t.Fatal("not read as a stream")
+	}
+	out := runInMemory(t, ".zip", inputs)
+	for _, f := range zipStreamVector() {
+		if data, err := out.ReadFile("/out/" + f.name); err != nil || !bytes.Equal(data, f.content) {
+			t.Errorf("extracted %s: %d bytes, %v", f.name, len(data), err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+//go:build chaos && !js && !wasip1
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// Failure injection for the integration tests of the pipelines running
+// cat-zip, the flags only exist in binaries built with -tags chaos
+
+var failAfterEntries = flag.Int("fail-after-n-entries", 0, "Fail every write once this many entries are written or skipped, 0 is off")
+var ioErrorRate = flag.Float64("inject-io-error-rate", 0, "Fail this fraction of the writes, renames and removals in -outdir, from 0 to 1")
+var chaosSeed = flag.Int64("chaos-seed", 1, "Seed of -inject-io-error-rate, a run with the same seed and one worker fails the same operation")
+
+var errInjected = errors.New("injected I/O error")
+
+// applyChaos wraps the outputs of opts in a file system failing as the flags
+// ask, it is called last so it sees the final FS and OnEntry
+func applyChaos(opts *catzip.Options) {
+	if *ioErrorRate < 0 || *ioErrorRate > 1 {
+		log.Fatalf("invalid -inject-io-error-rate %v, expected 0 to 1", *ioErrorRate)
+	}
+	if *failAfterEntries <= 0 && *ioErrorRate == 0 {
+		return
+	}
+
+	c := &chaosFS{fs: opts.FS, rate: *ioErrorRate, rand: rand.New(rand.NewSource(*chaosSeed))}
+	if c.fs == nil {
+		c.fs = catzip.OS
+	}
+	opts.FS = c
+
+	if limit := int64(*failAfterEntries); limit > 0 {
+		var entries int64
+		onEntry := opts.OnEntry
+		opts.OnEntry = func(entry catzip.EntryInfo) {
+			if atomic.AddInt64(&entries, 1) == limit {
+				c.broken.Store(true)
+			}
+			if onEntry != nil {
+				onEntry(entry)
+			}
+		}
+	}
+}
+
+// chaosFS fails the operations of fs at random, or all of them once broken
+type chaosFS struct {
+	fs     catzip.WriteFS
+	rate   float64
+	broken atomic.Bool
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// fail returns the injected error of op on name, if it fails
+func (c *chaosFS) fail(op, name string) error {
+	if c.broken.Load() {
+		return &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("%w after %d entries", errInjected, *failAfterEntries)}
+	}
+	if c.rate <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	failed := c.rand.Float64() < c.rate
+	c.mu.Unlock()
+	if failed {
+		return &fs.PathError{Op: op, Path: name, Err: errInjected}
+	}
+	return nil
+}
+
+func (c *chaosFS) OpenFile(name string, flag int, perm fs.FileMode) (catzip.WriteFile, error) {
+	if err := c.fail("open", name); err != nil {
+		return nil, err
+	}
+	f, err := c.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosFile{WriteFile: f, c: c, name: name}, nil
+}
+
+func (c *chaosFS) Open(name string) (fs.File, error) {
+	return c.fs.Open(name)
+}
+
+func (c *chaosFS) MkdirAll(path string, perm fs.FileMode) error {
+	if err := c.fail("mkdir", path); err != nil {
+		return err
+	}
+	return c.fs.MkdirAll(path, perm)
+}
+
+func (c *chaosFS) Remove(name string) error {
+	if err := c.fail("remove", name); err != nil {
+		return err
+	}
+	return c.fs.Remove(name)
+}
+
+func (c *chaosFS) Rename(oldpath, newpath string) error {
+	if err := c.fail("rename", oldpath); err != nil {
+		return err
+	}
+	return c.fs.Rename(oldpath, newpath)
+}
+
+func (c *chaosFS) Truncate(name string, size int64) error {
+	if err := c.fail("truncate", name); err != nil {
+		return err
+	}
+	return c.fs.Truncate(name, size)
+}
+
+type chaosFile struct {
+	catzip.WriteFile
+	c    *chaosFS
+	name string
+}
+
+func (f *chaosFile) Write(p []byte) (int, error) {
+	if err := f.c.fail("write", f.name); err != nil {
+		return 0, err
+	}
+	return f.WriteFile.Write(p)
+}
+
+// Sync keeps the checkpoints synced
+func (f *chaosFile) Sync() error {
+	if syncer, ok := f.WriteFile.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
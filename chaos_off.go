@@ -0,0 +1,8 @@
+//go:build !chaos && !js && !wasip1
+
+package main
+
+import "github.com/guilycst/cat-zip.git/catzip"
+
+// applyChaos injects failures in binaries built with -tags chaos
+func applyChaos(opts *catzip.Options) {}
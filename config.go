@@ -0,0 +1,28 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"os"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// runConfig is the -config file of a run
+type runConfig struct {
+	// Sections of the zip, gz and tar handlers
+	Handlers map[string]catzip.HandlerOptions
+}
+
+// loadConfig reads the -config file, JSON or YAML, see catzip.ParseHandlers
+func loadConfig(path string) (*runConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	handlers, err := catzip.ParseHandlers(data)
+	if err != nil {
+		return nil, err
+	}
+	return &runConfig{Handlers: handlers}, nil
+}
@@ -0,0 +1,56 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/guilycst/cat-zip.git/internal/fixtures"
+)
+
+// runConformance processes a built-in corpus of tricky archives on the
+// filesystem of -workdir and prints whether each came out as expected
+func runConformance(args []string) {
+	flags := flag.NewFlagSet("conformance", flag.ExitOnError)
+	var workdir = flags.String("workdir", "", "Directory the cases are extracted under, e.g. on the NFS share or the Windows disk to check. Empty is the OS temporary directory")
+	var keep = flags.Bool("keep", false, "Keep the inputs and outputs of the cases instead of removing them")
+	flags.Parse(args)
+
+	dir, err := os.MkdirTemp(*workdir, "cat-zip-conformance")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *keep {
+		log.Printf("cases kept in %s", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+
+	failed := 0
+	cases := fixtures.ConformanceCases()
+	for _, c := range cases {
+		problems, err := fixtures.Conform(dir, c)
+		if err != nil {
+			log.Fatalf("Unable to write the inputs of %s: %v", c.Name, err)
+		}
+		if len(problems) == 0 {
+			fmt.Printf("PASS %s\n", c.Name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL %s\n", c.Name)
+		for _, p := range problems {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+	fmt.Printf("Conformance: %d passed, %d failed\n", len(cases)-failed, failed)
+	if failed > 0 {
+		if !*keep {
+			os.RemoveAll(dir)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,108 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+type featuresReport struct {
+	catzip.Features
+	Build buildReport `json:"build"`
+}
+
+// buildReport describes the binary, from the build info Go embeds
+type buildReport struct {
+	Version  string   `json:"version"` // (devel) when built from a checkout
+	Revision string   `json:"revision,omitempty"`
+	Time     string   `json:"time,omitempty"`
+	Modified bool     `json:"modified,omitempty"`
+	Go       string   `json:"go"`
+	OS       string   `json:"os"`
+	Arch     string   `json:"arch"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// runFeatures prints the formats, methods and options this binary supports
+func runFeatures(args []string) {
+	flags := flag.NewFlagSet("features", flag.ExitOnError)
+	var asJSON = flags.Bool("json", false, "Print them as JSON")
+	flags.Parse(args)
+
+	report := featuresReport{Features: catzip.SupportedFeatures(), Build: readBuildReport()}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	b := report.Build
+	fmt.Printf("cat-zip %s %s, %s %s/%s", b.Version, b.Revision, b.Go, b.OS, b.Arch)
+	if len(b.Tags) > 0 {
+		fmt.Printf(", tags %s", strings.Join(b.Tags, ","))
+	}
+	fmt.Println()
+	fmt.Printf("inputs: %s\n", formatCodecs(report.Inputs))
+	fmt.Printf("zip methods: %s\n", formatCodecs(report.ZipMethods))
+	fmt.Printf("7z methods: %s\n", formatCodecs(report.SevenZipMethods))
+	fmt.Printf("hashes: %s\n", strings.Join(report.Hashes, ", "))
+	fmt.Printf("signatures: %s\n", strings.Join(report.Signatures, ", "))
+	fmt.Printf("formats: %s\n", strings.Join(report.Formats, ", "))
+	fmt.Printf("encodings: %s\n", strings.Join(report.Encodings, ", "))
+	fmt.Printf("annotate columns: %s\n", strings.Join(report.AnnotateColumns, ", "))
+	fmt.Printf("escape modes: %s\n", strings.Join(report.EscapeModes, ", "))
+	fmt.Printf("profiles: %s\n", strings.Join(report.Profiles, ", "))
+	fmt.Printf("sinks: %s to %s\n", strings.Join(report.SinkOutputs, ", "), strings.Join(report.SinkKinds, ", "))
+	fmt.Printf("credentials: %s\n", strings.Join(report.Credentials, ", "))
+	fmt.Printf("url schemes: %s\n", strings.Join(report.URLSchemes, ", "))
+}
+
+// formatCodecs reads like ".zip, .zst (zstd), .br (brotli, missing)"
+func formatCodecs(codecs []catzip.Codec) string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Name
+		switch {
+		case c.Command != "" && !c.Available:
+			names[i] += " (" + c.Command + ", missing)"
+		case c.Command != "":
+			names[i] += " (" + c.Command + ")"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func readBuildReport() buildReport {
+	b := buildReport{Version: "unknown", Go: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Version = info.Main.Version
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			b.Revision = setting.Value
+		case "vcs.time":
+			b.Time = setting.Value
+		case "vcs.modified":
+			b.Modified = setting.Value == "true"
+		case "-tags":
+			b.Tags = strings.Split(setting.Value, ",")
+		}
+	}
+	return b
+}
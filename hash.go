@@ -0,0 +1,69 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// runHash writes a checksum file of everything under the output directory of
+// previous runs, or checks the files against it with -check
+func runHash(args []string) {
+	flags := flag.NewFlagSet("hash", flag.ExitOnError)
+	var outdir = flags.String("outdir", ".", "Directory of the extracted files")
+	var algo = flags.String("algo", "sha256", "Hash algorithm: crc32c, sha256 or xxh3")
+	var workers = flags.Int("workers", 1, "Number of files hashed concurrently")
+	var sumsPath = flags.String("sums", "", "Checksum file, SHA256SUMS or the one of -algo in -outdir when empty")
+	var check = flags.Bool("check", false, "Check the files listed in the checksum file instead of writing it")
+	flags.Parse(args)
+
+	if *sumsPath == "" {
+		*sumsPath = filepath.Join(*outdir, catzip.SumsFileName(*algo))
+	}
+
+	if *check {
+		f, err := os.Open(*sumsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sums, err := catzip.ReadSums(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Unable to read %s: %v", *sumsPath, err)
+		}
+		drifts, err := catzip.CheckSums(*outdir, *algo, sums, *workers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, d := range drifts {
+			fmt.Printf("! %s: %s\n", d.Path, d.Problem)
+		}
+		fmt.Printf("Hash: %d files checked, %d drifted\n", len(sums), len(drifts))
+		if len(drifts) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	sums, err := catzip.SumFiles(*outdir, *algo, *workers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Create(*sumsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = catzip.WriteSums(f, sums); err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Hash: %d files written to %s\n", len(sums), *sumsPath)
+}
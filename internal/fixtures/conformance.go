@@ -0,0 +1,184 @@
+package fixtures
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// ConformanceCase is a tricky input processed on the OS filesystem, with the
+// files it must come out as
+type ConformanceCase struct {
+	Name     string
+	Ext      string
+	MaxDepth int
+	Files    map[string][]byte
+	// In cat file order, each followed by a newline there
+	Want []WantFile
+}
+
+// WantFile is an extracted file, its path relative to the output directory
+type WantFile struct {
+	Path string
+	Data []byte
+}
+
+// ConformanceCases builds the corpus of the conformance subcommand
+func ConformanceCases() []ConformanceCase {
+	deepDir := ""
+	for i := 0; i < 40; i++ {
+		deepDir += fmt.Sprintf("level-%02d/", i)
+	}
+	longName := strings.Repeat("n", 251) + ".txt"
+
+	return []ConformanceCase{
+		{Name: "unicode-names", Ext: ".zip", Files: map[string][]byte{
+			"unicode.zip": zipArchive(
+				zipEntry{name: "ünïcødé-名前.txt", data: []byte("latin and kanji\n")},
+				zipEntry{name: "日本語/ファイル.txt", data: []byte("directory\n")},
+				zipEntry{name: "emoji-🙂.txt", data: []byte("emoji\n")},
+				zipEntry{name: "Ωmega/α.txt", data: []byte("greek\n")},
+			),
+		}, Want: []WantFile{
+			{"ünïcødé-名前.txt", []byte("latin and kanji\n")},
+			{"日本語/ファイル.txt", []byte("directory\n")},
+			{"emoji-🙂.txt", []byte("emoji\n")},
+			{"Ωmega/α.txt", []byte("greek\n")},
+		}},
+		{Name: "empty-entries", Ext: ".zip", Files: map[string][]byte{
+			"empty.zip": zipArchive(
+				zipEntry{name: "empty.txt"},
+				zipEntry{name: "dir/also-empty"},
+				zipEntry{name: "after.txt", data: []byte("after\n")},
+			),
+		}, Want: []WantFile{
+			{"empty.txt", nil},
+			{"dir/also-empty", nil},
+			{"after.txt", []byte("after\n")},
+		}},
+		{Name: "duplicate-names", Ext: ".zip", Files: map[string][]byte{
+			"a.zip": zipArchive(
+				zipEntry{name: "same.txt", data: []byte("a first\n")},
+				zipEntry{name: "same.txt", data: []byte("a second\n")},
+			),
+			"b.zip": zipArchive(zipEntry{name: "same.txt", data: []byte("b\n")}),
+		}, Want: []WantFile{
+			{"same.txt", []byte("a first\n")},
+			{"same(1).txt", []byte("a second\n")},
+			{"same(2).txt", []byte("b\n")},
+		}},
+		// Clash on case insensitive filesystems, like the defaults of
+		// Windows and macOS
+		{Name: "case-names", Ext: ".zip", Files: map[string][]byte{
+			"case.zip": zipArchive(
+				zipEntry{name: "Readme.txt", data: []byte("mixed case\n")},
+				zipEntry{name: "README.txt", data: []byte("upper case\n")},
+			),
+		}, Want: []WantFile{
+			{"Readme.txt", []byte("mixed case\n")},
+			{"README.txt", []byte("upper case\n")},
+		}},
+		// Past the 260 characters of MAX_PATH on Windows
+		{Name: "deep-paths", Ext: ".zip", Files: map[string][]byte{
+			"deep.zip": zipArchive(zipEntry{name: deepDir + "bottom.txt", data: []byte("bottom\n")}),
+		}, Want: []WantFile{
+			{deepDir + "bottom.txt", []byte("bottom\n")},
+		}},
+		// The longest name most filesystems take, 255 bytes
+		{Name: "long-name", Ext: ".zip", Files: map[string][]byte{
+			"long.zip": zipArchive(zipEntry{name: longName, data: []byte("long\n")}),
+		}, Want: []WantFile{
+			{longName, []byte("long\n")},
+		}},
+		{Name: "deep-nesting", Ext: ".zip", MaxDepth: 3, Files: map[string][]byte{
+			"l1.zip": zipArchive(
+				zipEntry{name: "l2.zip", data: zipArchive(
+					zipEntry{name: "l3.zip", data: zipArchive(zipEntry{name: "core.txt", data: []byte("core\n")})},
+					zipEntry{name: "two.txt", data: []byte("two\n")},
+				)},
+				zipEntry{name: "one.txt", data: []byte("one\n")},
+			),
+		}, Want: []WantFile{
+			{"l2/l3/core.txt", []byte("core\n")},
+			{"l2/two.txt", []byte("two\n")},
+			{"one.txt", []byte("one\n")},
+		}},
+		{Name: "zip64", Ext: ".zip", Files: map[string][]byte{
+			"big.zip": zip64Archive("big.txt", []byte("stored with zip64 extra fields\n")),
+		}, Want: []WantFile{
+			{"big.txt", []byte("stored with zip64 extra fields\n")},
+		}},
+	}
+}
+
+// Conform runs c under dir on the OS filesystem, returning what came out
+// wrong. The error is for the inputs that couldn't be written.
+func Conform(dir string, c ConformanceCase) ([]string, error) {
+	inDir := filepath.Join(dir, c.Name, "in")
+	outDir := filepath.Join(dir, c.Name, "out")
+	for _, d := range []string{inDir, outDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range sortedNames(c.Files) {
+		if err := os.WriteFile(filepath.Join(inDir, name), c.Files[name], 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := catzip.DefaultOptions()
+	opts.Dir = inDir
+	opts.OutDir = outDir
+	opts.Ext = c.Ext
+	opts.MaxDepth = c.MaxDepth
+	opts.Logger = log.New(io.Discard, "", 0)
+	if _, err := catzip.Run(opts); err != nil {
+		return []string{fmt.Sprintf("the run failed: %v", err)}, nil
+	}
+
+	var problems []string
+	var cat []byte
+	wanted := map[string]bool{opts.CatFileName: true}
+	for _, want := range c.Want {
+		wanted[want.Path] = true
+		cat = append(append(cat, want.Data...), '\n')
+		got, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(want.Path)))
+		switch {
+		case err != nil:
+			problems = append(problems, err.Error())
+		case !bytes.Equal(got, want.Data):
+			problems = append(problems, fmt.Sprintf("%s is %q, expected %q", want.Path, got, want.Data))
+		}
+	}
+
+	got, err := os.ReadFile(filepath.Join(outDir, opts.CatFileName))
+	switch {
+	case err != nil:
+		problems = append(problems, err.Error())
+	case !bytes.Equal(got, cat):
+		problems = append(problems, fmt.Sprintf("the cat file is %q, expected %q", got, cat))
+	}
+
+	err = filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(outDir, path)
+		if err == nil && !wanted[filepath.ToSlash(rel)] {
+			problems = append(problems, fmt.Sprintf("unexpected file %s", filepath.ToSlash(rel)))
+		}
+		return err
+	})
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems, nil
+}
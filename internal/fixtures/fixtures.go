@@ -0,0 +1,213 @@
+// Package fixtures generates the archives under testdata/fixtures and checks
+// what catzip makes of them against the files under testdata/golden
+package fixtures
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Case is a set of input files processed together by one run
+type Case struct {
+	Name  string
+	Ext   string
+	Files map[string][]byte
+}
+
+// Fixed so the generated archives don't change between runs
+var modTime = time.Date(2023, 2, 2, 22, 50, 0, 0, time.UTC)
+
+// Cases builds every fixture in memory
+func Cases() []Case {
+	return []Case{
+		{Name: "zip64", Ext: ".zip", Files: map[string][]byte{
+			"big.zip": zip64Archive("big.txt", []byte("stored with zip64 extra fields\n")),
+		}},
+		{Name: "encrypted", Ext: ".zip", Files: map[string][]byte{
+			"secret.zip": zipArchive(
+				zipEntry{name: "public.txt", data: []byte("readable\n")},
+				zipEntry{name: "private.txt", data: []byte("not really encrypted\n"), flags: 0x1},
+			),
+		}},
+		{Name: "nested", Ext: ".zip", Files: map[string][]byte{
+			"outer.zip": zipArchive(
+				zipEntry{name: "readme.txt", data: []byte("outer\n")},
+				zipEntry{name: "inner.zip", data: zipArchive(zipEntry{name: "inner.txt", data: []byte("inner\n")})},
+			),
+		}},
+		{Name: "weird-names", Ext: ".zip", Files: map[string][]byte{
+			"names.zip": zipArchive(
+				zipEntry{name: "with space.txt", data: []byte("space\n")},
+				zipEntry{name: "ünïcødé-名前.txt", data: []byte("unicode\n")},
+				zipEntry{name: "dir/", data: nil},
+				zipEntry{name: "dir/sub/deep.txt", data: []byte("deep\n")},
+				zipEntry{name: ".hidden", data: []byte("hidden\n")},
+				zipEntry{name: "no-extension", data: []byte("bare\n")},
+			),
+		}},
+		{Name: "zip-slip", Ext: ".zip", Files: map[string][]byte{
+			"slip.zip": zipArchive(zipEntry{name: "../escaped.txt", data: []byte("outside\n")}),
+		}},
+		{Name: "collisions", Ext: ".zip", Files: map[string][]byte{
+			"a.zip": zipArchive(
+				zipEntry{name: "same.txt", data: []byte("a first\n")},
+				zipEntry{name: "same.txt", data: []byte("a second\n")},
+			),
+			"b.zip": zipArchive(zipEntry{name: "same.txt", data: []byte("b\n")}),
+		}},
+		{Name: "deflate", Ext: ".zip", Files: map[string][]byte{
+			"deflated.zip": zipArchive(
+				zipEntry{name: "repeated.txt", data: bytes.Repeat([]byte("deflate me\n"), 50), method: zip.Deflate},
+				zipEntry{name: "short.txt", data: []byte("x"), method: zip.Deflate},
+			),
+		}},
+		{Name: "gz", Ext: ".gz", Files: map[string][]byte{
+			"one.log.gz": gzipFile("one.log", []byte("first line\nsecond line\n")),
+			"two.log.gz": gzipFile("two.log", []byte("no trailing newline")),
+			"empty.gz":   gzipFile("empty", nil),
+		}},
+		{Name: "zip-corrupt-tail", Ext: ".zip", Files: map[string][]byte{
+			"truncated.zip": truncate(zipArchive(zipEntry{name: "lost.txt", data: []byte("never read\n")}), 10),
+		}},
+		{Name: "gz-corrupt-tail", Ext: ".gz", Files: map[string][]byte{
+			"truncated.log.gz": truncate(gzipFile("truncated.log", bytes.Repeat([]byte("lost "), 100)), 6),
+		}},
+	}
+}
+
+// Generate writes every case to dir/<case name>
+func Generate(dir string) error {
+	for _, c := range Cases() {
+		caseDir := filepath.Join(dir, c.Name)
+		if err := os.RemoveAll(caseDir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(caseDir, 0755); err != nil {
+			return err
+		}
+		for _, name := range sortedNames(c.Files) {
+			if err := os.WriteFile(filepath.Join(caseDir, name), c.Files[name], 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type zipEntry struct {
+	name   string
+	data   []byte
+	flags  uint16
+	method uint16 // Store when unset
+}
+
+func zipArchive(entries ...zipEntry) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: e.method, Modified: modTime}
+		if e.flags != 0 {
+			// CreateRaw keeps the flags, the content is stored as is
+			header.Flags = e.flags
+			header.CRC32 = crc32.ChecksumIEEE(e.data)
+			header.CompressedSize64 = uint64(len(e.data))
+			header.UncompressedSize64 = uint64(len(e.data))
+			f, err := w.CreateRaw(header)
+			if err != nil {
+				panic(err)
+			}
+			f.Write(e.data)
+			continue
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			panic(err)
+		}
+		f.Write(e.data)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// zip64Archive builds a single stored entry using the zip64 extensions,
+// archive/zip only writes them for entries over 4GiB
+func zip64Archive(name string, data []byte) []byte {
+	var buf bytes.Buffer
+	le := func(v any) { binary.Write(&buf, binary.LittleEndian, v) }
+	crc := crc32.ChecksumIEEE(data)
+	dosTime := uint16(modTime.Hour()<<11 | modTime.Minute()<<5 | modTime.Second()/2)
+	dosDate := uint16((modTime.Year()-1980)<<9 | int(modTime.Month())<<5 | modTime.Day())
+
+	// Local file header
+	le(uint32(0x04034b50))
+	le([]uint16{45, 0, uint16(zip.Store), dosTime, dosDate})
+	le([]uint32{crc, 0xffffffff, 0xffffffff})
+	le([]uint16{uint16(len(name)), 20})
+	buf.WriteString(name)
+	le([]uint16{0x0001, 16})
+	le([]uint64{uint64(len(data)), uint64(len(data))})
+	buf.Write(data)
+
+	// Central directory
+	centralOffset := buf.Len()
+	le(uint32(0x02014b50))
+	le([]uint16{45, 45, 0, uint16(zip.Store), dosTime, dosDate})
+	le([]uint32{crc, 0xffffffff, 0xffffffff})
+	le([]uint16{uint16(len(name)), 28, 0, 0, 0})
+	le([]uint32{0, 0xffffffff})
+	buf.WriteString(name)
+	le([]uint16{0x0001, 24})
+	le([]uint64{uint64(len(data)), uint64(len(data)), 0})
+	centralSize := buf.Len() - centralOffset
+
+	// Zip64 end of central directory record and locator
+	zip64End := buf.Len()
+	le(uint32(0x06064b50))
+	le(uint64(44))
+	le([]uint16{45, 45})
+	le([]uint32{0, 0})
+	le([]uint64{1, 1, uint64(centralSize), uint64(centralOffset)})
+	le(uint32(0x07064b50))
+	le(uint32(0))
+	le(uint64(zip64End))
+	le(uint32(1))
+
+	// End of central directory, pointing to the zip64 record
+	le(uint32(0x06054b50))
+	le([]uint16{0, 0, 0xffff, 0xffff})
+	le([]uint32{0xffffffff, 0xffffffff})
+	le(uint16(0))
+	return buf.Bytes()
+}
+
+func gzipFile(name string, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Name = name
+	w.ModTime = modTime
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func truncate(data []byte, n int) []byte {
+	return data[:len(data)-n]
+}
@@ -0,0 +1,124 @@
+package fixtures
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing/fstest"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// MaxFuzzOutput bounds what a single fuzz input may write, a small input
+// expanding past it counts as a failure
+const MaxFuzzOutput = 64 << 20
+
+// MaxFuzzAlloc bounds what a single fuzz input may allocate, the outputs in
+// memory included
+const MaxFuzzAlloc = 4 * MaxFuzzOutput
+
+const fuzzOutDir = "/out"
+
+// Fuzz processes data as an input file with the extension ext. Malformed
+// inputs are expected to fail the run, Fuzz only returns an error when the
+// run wrote outside the output directory, more than MaxFuzzOutput bytes or
+// allocated more than MaxFuzzAlloc bytes. A panic isn't recovered, the
+// decode and write workers run on their own goroutines, so it crashes the
+// fuzzer as it should.
+func Fuzz(data []byte, ext string) error {
+	inputName := "input" + ext
+	out := &limitFS{MemFS: catzip.NewMemFS(), limit: MaxFuzzOutput}
+
+	opts := catzip.DefaultOptions()
+	opts.Ext = ext
+	opts.InputFS = fstest.MapFS{inputName: {Data: data}}
+	opts.OutDir = fuzzOutDir
+	opts.FS = out
+	opts.Logger = log.New(io.Discard, "", 0)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := catzip.Run(opts)
+	runtime.ReadMemStats(&after)
+
+	if out.written.Load() > out.limit {
+		return fmt.Errorf("wrote more than %d bytes", out.limit)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > MaxFuzzAlloc {
+		return fmt.Errorf("allocated %d bytes, more than %d", alloc, uint64(MaxFuzzAlloc))
+	}
+	for _, name := range out.Names() {
+		// gz inputs are extracted next to the input
+		if !strings.HasPrefix(name, fuzzOutDir+"/") && name != strings.TrimSuffix(inputName, ".gz") {
+			return fmt.Errorf("wrote %s outside %s (run error: %v)", name, fuzzOutDir, err)
+		}
+	}
+	return nil
+}
+
+// limitFS fails the writes once more than limit bytes were written
+type limitFS struct {
+	*catzip.MemFS
+	limit   int64
+	written atomic.Int64
+}
+
+func (l *limitFS) OpenFile(name string, flag int, perm fs.FileMode) (catzip.WriteFile, error) {
+	f, err := l.MemFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &limitFile{WriteFile: f, fs: l}, nil
+}
+
+type limitFile struct {
+	catzip.WriteFile
+	fs *limitFS
+}
+
+func (f *limitFile) Write(p []byte) (int, error) {
+	if f.fs.written.Add(int64(len(p))) > f.fs.limit {
+		return 0, io.ErrShortWrite
+	}
+	return f.WriteFile.Write(p)
+}
+
+// Seeds returns the fixtures with the extension ext, to start mutating
+// from. There are no tar fixtures, the .tar seeds hold the files of the
+// cases instead.
+func Seeds(ext string) [][]byte {
+	var seeds [][]byte
+	for _, c := range Cases() {
+		if ext == ".tar" {
+			seeds = append(seeds, tarSeed(c.Files))
+			continue
+		}
+		for _, name := range sortedNames(c.Files) {
+			if filepath.Ext(name) == ext {
+				seeds = append(seeds, c.Files[name])
+			}
+		}
+	}
+	if ext == ".tar" {
+		seeds = append(seeds, tarSeed(map[string][]byte{"../escape.txt": []byte("outside\n")}))
+	}
+	return seeds
+}
+
+func tarSeed(files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, name := range sortedNames(files) {
+		w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: modTime})
+		w.Write(files[name])
+	}
+	w.Close()
+	return buf.Bytes()
+}
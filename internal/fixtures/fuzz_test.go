@@ -0,0 +1,20 @@
+package fixtures
+
+import "testing"
+
+// go test ./internal/fixtures -run '^$' -fuzz FuzzZip fuzzes the zip
+// handler, the inputs failing are saved under testdata/fuzz
+func fuzzExt(f *testing.F, ext string) {
+	for _, seed := range Seeds(ext) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := Fuzz(data, ext); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzZip(f *testing.F)  { fuzzExt(f, ".zip") }
+func FuzzGzip(f *testing.F) { fuzzExt(f, ".gz") }
+func FuzzTar(f *testing.F)  { fuzzExt(f, ".tar") }
@@ -0,0 +1,86 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// Result is what a run made of a case, the cat file is compared on its own
+type Result struct {
+	Error   string             `json:"error,omitempty"`
+	Entries []catzip.EntryInfo `json:"entries"`
+	Outputs []string           `json:"outputs"` // Every file written
+}
+
+// Process runs catzip over the case in dir, writing to memory
+func Process(dir string, ext string) ([]byte, *Result, error) {
+	out := catzip.NewMemFS()
+	result := &Result{Entries: []catzip.EntryInfo{}}
+
+	opts := catzip.DefaultOptions()
+	opts.Ext = ext
+	opts.InputFS = os.DirFS(dir)
+	// Absolute so the output paths don't depend on the working directory
+	opts.OutDir = "/out"
+	opts.FS = out
+	opts.OnEntry = func(entry catzip.EntryInfo) {
+		entry.ModTime = entry.ModTime.UTC()
+		result.Entries = append(result.Entries, entry)
+	}
+
+	if _, err := catzip.Run(opts); err != nil {
+		result.Error = err.Error()
+	}
+	result.Outputs = out.Names()
+
+	cat, err := out.ReadFile(filepath.Join(opts.OutDir, opts.CatFileName))
+	if err != nil && result.Error == "" {
+		return nil, nil, err
+	}
+	return cat, result, nil
+}
+
+// checkCase processes the case c of testdata/fixtures and compares it with
+// testdata/golden, returning the golden files that differ. With update set
+// they are rewritten instead.
+func checkCase(testdata string, c Case, update bool) ([]string, error) {
+	goldenDir := filepath.Join(testdata, "golden")
+	cat, result, err := Process(filepath.Join(testdata, "fixtures", c.Name), c.Ext)
+	if err != nil {
+		return nil, err
+	}
+	resultJSON, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	resultJSON = append(resultJSON, '\n')
+
+	var mismatches []string
+	golden := map[string][]byte{
+		filepath.Join(goldenDir, c.Name+".cat"):  cat,
+		filepath.Join(goldenDir, c.Name+".json"): resultJSON,
+	}
+	for path, got := range golden {
+		if update {
+			if err := os.MkdirAll(goldenDir, 0755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(path, got, 0644); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(got, want) {
+			mismatches = append(mismatches, path)
+		}
+	}
+	return mismatches, nil
+}
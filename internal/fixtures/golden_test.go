@@ -0,0 +1,47 @@
+package fixtures
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files with the current outputs")
+var generate = flag.Bool("generate", false, "Regenerate the fixture archives before checking")
+
+// Of the module, the fixtures are shared with the conformance cases
+const testdata = "../../testdata"
+
+// TestGolden compares the outputs of every case with the golden files,
+//
+//	go test ./internal/fixtures -run Golden -update     rewrites them
+//	go test ./internal/fixtures -run Golden -generate   regenerates the fixtures first
+func TestGolden(t *testing.T) {
+	if *generate {
+		if err := Generate(filepath.Join(testdata, "fixtures")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// catzip logs every file it writes
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, c := range Cases() {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			mismatches, err := checkCase(testdata, c, *update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(mismatches)
+			for _, m := range mismatches {
+				t.Errorf("differs from golden: %s, go test -update rewrites it", m)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// Hidden subcommand the write helper runs as
+const writeHelperCommand = "__write-helper"
+
+// Given instead of the directory to the helper once it is confined to it
+const writeHelperConfined = "-confined"
+
+// Where the directory the helper writes to is in its root
+const writeHelperOut = "/out"
+
+// Written by the helper once confined, before serving
+const writeHelperReady = 'R'
+
+// runWriteHelper confines itself to the directory in args and serves the
+// writes of the parent process over stdin and stdout
+func runWriteHelper(args []string) {
+	// The parent reports what the helper logs
+	log.SetFlags(0)
+	if len(args) != 1 {
+		log.Fatalf("%s expects the directory to confine to", writeHelperCommand)
+	}
+	if err := confineWriteHelper(args[0]); err != nil {
+		log.Fatalf("unable to confine to %s: %v", args[0], err)
+	}
+	if _, err := os.Stdout.Write([]byte{writeHelperReady}); err != nil {
+		log.Fatal(err)
+	}
+	if err := catzip.ServeFS(catzip.OS, os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// isolatedFS sends the writes to the helper, translating the paths to the
+// helper's root
+type isolatedFS struct {
+	root   string
+	remote *catzip.RemoteFS
+}
+
+func (i *isolatedFS) path(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(i.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the isolated directory %s", name, i.root)
+	}
+	return path.Join(writeHelperOut, filepath.ToSlash(rel)), nil
+}
+
+func (i *isolatedFS) OpenFile(name string, flag int, perm fs.FileMode) (catzip.WriteFile, error) {
+	p, err := i.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return i.remote.OpenFile(p, flag, perm)
+}
+
+func (i *isolatedFS) Open(name string) (fs.File, error) {
+	p, err := i.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return i.remote.Open(p)
+}
+
+func (i *isolatedFS) MkdirAll(path string, perm fs.FileMode) error {
+	p, err := i.path(path)
+	if err != nil {
+		return err
+	}
+	return i.remote.MkdirAll(p, perm)
+}
+
+func (i *isolatedFS) Remove(name string) error {
+	p, err := i.path(name)
+	if err != nil {
+		return err
+	}
+	return i.remote.Remove(p)
+}
+
+func (i *isolatedFS) Rename(oldpath, newpath string) error {
+	oldp, err := i.path(oldpath)
+	if err != nil {
+		return err
+	}
+	newp, err := i.path(newpath)
+	if err != nil {
+		return err
+	}
+	return i.remote.Rename(oldp, newp)
+}
+
+func (i *isolatedFS) Truncate(name string, size int64) error {
+	p, err := i.path(name)
+	if err != nil {
+		return err
+	}
+	return i.remote.Truncate(p, size)
+}
+
+// startWriteHelper starts the helper confined to root, the returned function
+// stops it once the run is over
+func startWriteHelper(root string) (catzip.WriteFS, func() error, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = os.MkdirAll(root, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	cmd, err := writeHelperCmd(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Small, the helper only logs why it exits
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("unable to start the write helper: %w", err)
+	}
+	helperErr := func(err error) error {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("write helper: %s", msg)
+		}
+		return fmt.Errorf("write helper: %w", err)
+	}
+
+	// Only EOF when it exits before it is confined
+	ready := make([]byte, 1)
+	if _, err := io.ReadFull(stdout, ready); err != nil || ready[0] != writeHelperReady {
+		stdin.Close()
+		if waitErr := cmd.Wait(); waitErr != nil {
+			err = waitErr
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected %q instead of ready", ready)
+		}
+		return nil, nil, helperErr(err)
+	}
+
+	stop := func() error {
+		stdin.Close()
+		if err := cmd.Wait(); err != nil {
+			return helperErr(err)
+		}
+		return nil
+	}
+	return &isolatedFS{root: root, remote: catzip.NewRemoteFS(stdout, stdin)}, stop, nil
+}
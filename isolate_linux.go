@@ -0,0 +1,247 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// writeHelperCmd runs this executable as the write helper, in a mount
+// namespace of its own. Unless already root it gets a user namespace where
+// it is root, so it can mount and chroot.
+func writeHelperCmd(root string) (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(executable, writeHelperCommand, root)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL, Cloneflags: syscall.CLONE_NEWNS}
+	if uid, gid := os.Geteuid(), os.Getegid(); uid != 0 {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+	}
+	return cmd, nil
+}
+
+const (
+	linuxCapabilityVersion3 = 0x20080522
+	prCapbsetDrop           = 24
+	prGetNoNewPrivs         = 39
+	capLastCap              = 40
+	stRelatime              = 0x1000 // ST_RELATIME, of statfs
+)
+
+// confineWriteHelper chroots to root and drops every capability, so the
+// helper can't get out of the chroot.
+//
+// The chroot applies to the whole process, but no_new_privs and the
+// capabilities are per thread and the runtime has started others already,
+// AllThreadsSyscall being refused when cgo is linked in. So this thread
+// drops them and execs the executable again, with writeHelperConfined for
+// root: the new process starts without capabilities on its only thread,
+// and confineWriteHelper checks it and returns nil there.
+//
+// The exec happens inside the new root, so it is a tmpfs mounted over root
+// in the mount namespace of the helper, with root bound at writeHelperOut
+// and the executable, its interpreter and libraries bound read-only where
+// they are.
+func confineWriteHelper(root string) error {
+	if root == writeHelperConfined {
+		return checkWriteHelperConfined()
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	files, err := mappedFiles()
+	if err != nil {
+		return err
+	}
+	// Opened by its path in the new root, which may be a link to a mapped file
+	if interp, err := interpreter(executable); err != nil {
+		return err
+	} else if interp != "" {
+		files = append(files, interp)
+	}
+	var libraryPath []string
+	for _, file := range files {
+		libraryPath = append(libraryPath, filepath.Dir(file))
+	}
+
+	out, err := os.Open(root)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// Not seen outside the namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("unable to make the mounts private: %w", err)
+	}
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+		return fmt.Errorf("unable to mount the root: %w", err)
+	}
+	if err := bindInto(root, fmt.Sprintf("/proc/self/fd/%d", out.Fd()), writeHelperOut, false); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := bindInto(root, file, file, true); err != nil {
+			return err
+		}
+	}
+
+	if err := syscall.Chroot(root); err != nil {
+		return err
+	}
+	if err := syscall.Chdir(writeHelperOut); err != nil {
+		return err
+	}
+
+	// Never unlocked, the thread is replaced by the exec or the process exits
+	runtime.LockOSThread()
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return errno
+	}
+	for c := 0; c <= capLastCap; c++ {
+		// EINVAL for capabilities this kernel doesn't know
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapbsetDrop, uintptr(c), 0); errno != 0 && errno != syscall.EINVAL {
+			return errno
+		}
+	}
+	// Empty effective, permitted and inheritable sets, with the bounding set
+	// empty the exec gives none back even to root
+	header := capHeader{version: linuxCapabilityVersion3}
+	var data capData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data)), 0); errno != 0 {
+		return errno
+	}
+
+	// The cache of the loader isn't in the root, the libraries are found there
+	env := append(os.Environ(), "LD_LIBRARY_PATH="+strings.Join(libraryPath, ":"))
+	err = syscall.Exec(executable, []string{os.Args[0], writeHelperCommand, writeHelperConfined}, env)
+	return fmt.Errorf("unable to exec the confined helper: %w", err)
+}
+
+// bindInto binds source at target beneath root, creating what it is mounted
+// on in root. readOnly keeps the flags of the mount of source, a user
+// namespace can't clear them.
+func bindInto(root, source, target string, readOnly bool) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	target = filepath.Join(root, target)
+	if info.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+		err = os.WriteFile(target, nil, 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("unable to bind %s: %w", source, err)
+	}
+	if !readOnly {
+		return nil
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(source, &fs); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY) |
+		uintptr(fs.Flags)&(syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC|syscall.MS_NOATIME|syscall.MS_NODIRATIME)
+	if fs.Flags&stRelatime != 0 {
+		flags |= syscall.MS_RELATIME
+	}
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("unable to make %s read-only: %w", source, err)
+	}
+	return nil
+}
+
+// mappedFiles lists the files mapped by the process, the executable and the
+// libraries it was linked with
+func mappedFiles() ([]string, error) {
+	maps, err := os.Open("/proc/self/maps")
+	if err != nil {
+		return nil, err
+	}
+	defer maps.Close()
+
+	var files []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(maps)
+	for scanner.Scan() {
+		// address perms offset dev inode path
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 || !strings.HasPrefix(fields[5], "/") || seen[fields[5]] {
+			continue
+		}
+		if inode, _ := strconv.Atoi(fields[4]); inode == 0 {
+			continue
+		}
+		seen[fields[5]] = true
+		files = append(files, fields[5])
+	}
+	return files, scanner.Err()
+}
+
+// interpreter returns the loader executable asks for, empty when it is
+// statically linked
+func interpreter(executable string) (string, error) {
+	f, err := elf.Open(executable)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			data := make([]byte, prog.Filesz)
+			if _, err := prog.ReadAt(data, 0); err != nil {
+				return "", err
+			}
+			return strings.TrimRight(string(data), "\x00"), nil
+		}
+	}
+	return "", nil
+}
+
+// checkWriteHelperConfined makes sure the exec'd helper has no capability
+// and can't get any
+func checkWriteHelperConfined() error {
+	noNewPrivs, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prGetNoNewPrivs, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	header := capHeader{version: linuxCapabilityVersion3}
+	var data capData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data)), 0); errno != 0 {
+		return errno
+	}
+	if noNewPrivs != 1 || data != (capData{}) {
+		return errors.New("the helper still has privileges")
+	}
+	return nil
+}
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData [2]struct{ effective, permitted, inheritable uint32 }
@@ -0,0 +1,16 @@
+//go:build !linux && !js && !wasip1
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func writeHelperCmd(root string) (*exec.Cmd, error) {
+	return nil, errors.New("-isolate-writes is only supported on Linux")
+}
+
+func confineWriteHelper(root string) error {
+	return errors.New("the write helper is only supported on Linux")
+}
@@ -1,26 +1,161 @@
+//go:build !js && !wasip1
+
 package main
 
 import (
-	"archive/zip"
-	"compress/gzip"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/guilycst/cat-zip.git/catzip"
 )
 
-var unzipedFiles map[string]uint = make(map[string]uint)
-var catFile *os.File
+// Subcommands, the default command extracts and concatenates
+var subcommands = map[string]func(args []string){
+	"conformance":      runConformance,
+	"features":         runFeatures,
+	"hash":             runHash,
+	"plan":             runPlan,
+	"recheck":          runRecheck,
+	"serve":            runServe,
+	"verify":           runVerify,
+	writeHelperCommand: runWriteHelper,
+}
 
 func main() {
-	var dir = flag.String("dir", ".", "Directory where the input zip files are placed")
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			subcommand(os.Args[2:])
+			return
+		}
+	}
+
+	defaults := catzip.DefaultOptions()
+	var dir = flag.String("dir", ".", "Directory where the input zip files are placed, s3://bucket/prefix, gs://bucket/prefix or az://container/prefix lists the objects of an S3 or GCS bucket or of an Azure container with keys starting with prefix, downloaded as they are read and named in -outdir after their key past the last / of prefix, sftp://user@host/dir (or sftp://user@host/~/dir in the home directory) and webdavs://host/dir (webdav:// for http) the files under a remote directory, named after their path inside it, - reads a single input from stdin as the first -ext, or as -detect tells")
+	var urls []string
+	flag.Func("url", "Also download and read this http, https, s3://bucket/key, gs://bucket/key, az://container/blob, sftp://user@host/path or webdavs://host/path URL, as it is read, named in -outdir after the end of its path, which tells its format like the extension of a file. Only the URLs are read when -dir isn't set. Repeatable", func(u string) error {
+		urls = append(urls, u)
+		return nil
+	})
+	var urlFile = flag.String("url-file", "", "File with more -url, one per line, blank lines and # comments left out")
+	var urlRetries = flag.Int("url-retries", defaults.URLRetries, "Times a failed download is tried again, resuming where it broke when the server takes ranges")
+	var s3Endpoint = flag.String("s3-endpoint", "", "Endpoint of the s3:// inputs, e.g. http://localhost:9000 for MinIO, reached with path style requests. Empty is AWS_ENDPOINT_URL_S3, AWS_ENDPOINT_URL or AWS")
+	var s3Region = flag.String("s3-region", "", "Region the s3:// requests are signed for, empty is the one each bucket tells, AWS_REGION or us-east-1")
+	var gcsEndpoint = flag.String("gcs-endpoint", "", "Endpoint of the gs:// inputs, e.g. the one of fake-gcs-server. Empty is STORAGE_EMULATOR_HOST or GCS")
+	var azureAccount = flag.String("azure-account", "", "Storage account of the az:// inputs, empty is AZURE_STORAGE_ACCOUNT")
+	var azureEndpoint = flag.String("azure-endpoint", "", "Endpoint of the az:// inputs, e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite. Empty is the one of -azure-account")
+	var sshCommand = flag.String("ssh-command", "", "Command the sftp:// inputs are reached with, given the -p port, -l user, -s host sftp arguments of ssh. Empty is ssh -o BatchMode=yes, with your keys, agent and known hosts")
+	var netrcFile = flag.String("netrc-file", "", "File with the logins of the webdav:// and webdavs:// inputs, in the format of .netrc. Empty is NETRC or ~/.netrc, CATZIP_WEBDAV_USER and CATZIP_WEBDAV_PASSWORD come first")
 	var outdir = flag.String("outdir", ".", "Directory where the output unziped files will be placed")
-	var ext = flag.String("ext", ".gz", "Filter input files by extension: .zip and .gz")
-	var outdirCatFileName = flag.String("outfile", "unknown_blob", "Concatenated file containing all of the unziped files content")
+	var ext = flag.String("ext", ".gz", "Filter input files by extension, several separated by commas reading each file as the one it has (e.g. .gz,.zip,.zst): .zip, .7z, .rar, .iso (ISO 9660, with Rock Ridge or Joliet names), .gz, .zst, .bz2, .xz, .lz4, .br, .sz, .tar, .tar.gz, .tgz, .tar.xz, .txz, .tar.zst, .tzst, .tar.bz2, .tbz2, .tar.lz4, .cpio and .cpio.gz (newc and odc), .deb and .rpm (their installed files). The tarballs a single compressed extension selects, like the .tar.gz files of .gz, are untarred. Split .zip files are read from their .z01, .z02 and on parts next to them. .zip files that can't be seeked, like named pipes, are read in order from their local headers. .gz, .zst, .bz2, .xz, .lz4, .br and .sz (snappy framed) files are extracted next to them, .zst ones with the zstd command and .br ones with the brotli command. The compressed files of .rar archives are extracted with the unrar command, and the next volumes of .part1.rar sets are read with it")
+	var maxDepth = flag.Int("max-depth", 0, "Extract the .zip, .gz, .tar.gz and .tgz entries of the input files too, up to this many archives deep, into a directory named after them (a .gz one next to it) instead of writing them as they are")
+	var detect = flag.Bool("detect", false, "Read each input file as the format its magic bytes tell instead of the one of -ext, which only filters them, or the one of -ext when they match none. -ext \"\" selects every file")
+	var image = flag.Bool("image", false, "The .tar inputs are docker save or OCI layout tarballs, extract the root filesystem of their images, the layers applied in order with their whiteouts, instead of their members")
+	var configPath = flag.String("config", "", "JSON or YAML file with a handlers section per format: zip (name_encoding, cp437 or latin1 for the names not flagged as UTF-8), gz (use_fname, naming the extracted file after the gzip header) and tar (xattrs, recording the extended attributes of the members in -manifest). There is no zip password, encrypted entries are skipped")
+	var outdirCatFileName = flag.String("outfile", "unknown_blob", "Concatenated file containing all of the unziped files content, - writes it to stdout")
+	var stdin = flag.Bool("stdin", false, "Same as -dir -")
+	var stdout = flag.Bool("stdout", false, "Same as -outfile -")
+	var workersFlag = flag.String("workers", "1", "Number of input files decompressed concurrently, or \"auto\" to adjust it during the run")
+	var writeWorkers = flag.Int("write-workers", 1, "Number of extracted files written concurrently, more than 1 doesn't keep the cat file in input order")
+	var filesQueue = flag.Int("files-queue", defaults.FilesQueue, "Input files queued for the decode workers")
+	var entriesQueue = flag.Int("entries-queue", defaults.EntriesQueue, "Decoded entries queued for the write workers")
+	var chunksQueue = flag.Int("chunks-queue", defaults.ChunksQueue, "Buffers of 256KiB queued per entry being written")
+	var splitEntrySize = flag.String("split-entry-size", "0", "Split extracted files larger than this into name.part000, name.part001... listed in -manifest, e.g. 4GB for FAT32 disks. KB, MB, GB and TB are powers of 1000, KiB, MiB, GiB and TiB of 1024")
+	var recompressThreshold = flag.String("recompress-threshold", "0", "Gzip extracted files larger than this to name.gz once they are in -outfile, e.g. 10MB, keeping the smaller ones plain. KB, MB... like -split-entry-size")
+	var hashFlag = flag.String("hash", defaults.Hash, "Hash of each extracted file: crc32c, sha256, xxh3 or off")
+	var classify = flag.Bool("classify", false, "Record the format of each extracted file in -manifest: "+strings.Join(catzip.Formats(), ", "))
+	var routeFormats = flag.String("route-formats", "", "Comma separated format=file pairs concatenating the files of a format to another file in -outdir instead of -outfile, e.g. json=json_blob,binary=binary_blob. Implies -classify")
+	var detectText = flag.Bool("detect-text", false, "Record the line terminators (lf, crlf, cr or mixed) and the encoding of each extracted file in -manifest: "+strings.Join(catzip.Encodings(), ", "))
+	var annotate = flag.String("annotate", "", "Comma separated columns prefixing each line of -outfile, tab separated, to trace it back to its source: "+strings.Join(catzip.AnnotateColumns(), ", ")+", e.g. archive,entry,lineno")
+	var escape = flag.String("escape", "", "Append the binary entries to -outfile as a single line escaped as "+strings.Join(catzip.EscapeModes(), ", ")+", keeping it line oriented. The text ones are appended as they are")
+	var maxLineBytes = flag.String("max-line-bytes", "0", "Longest line appended to -outfile, e.g. 1MiB, the longer ones are cut as -long-lines tells and counted in -manifest and the report. KB, MB... like -split-entry-size, 0 is no limit")
+	var longLines = flag.String("long-lines", catzip.LongLinesTruncate, "What -max-line-bytes does with the longer lines: truncate or wrap")
+	var stripANSI = flag.Bool("strip-ansi", false, "Remove the ANSI escape sequences, like the colors of console logs, from what is appended to -outfile. The extracted files keep them")
+	var signature = flag.String("signature", "", "Record a similarity signature of the lines of each extracted file in -manifest, simhash or minhash, to cluster near-duplicates. Digits are ignored")
+	var passthroughExt = flag.String("passthrough-ext", "", "Comma separated extensions of plain files copied and concatenated as they are, e.g. .log,.txt")
+	var stream = flag.Bool("stream", false, "Process the input files as the walk of -dir finds them instead of once it is done, so the first outputs of huge trees come out in seconds")
+	var walkWorkers = flag.Int("walk-workers", 0, "Directories of -dir read concurrently, in no particular order, implies -stream. For huge trees on network filesystems, 0 is a single one")
+	var largestFirst = flag.Bool("schedule-largest-first", false, "Process the largest input files first, so a big archive doesn't run alone at the end")
+	var stableFor = flag.Duration("stable-for", 0, "Skip input files modified less than this long ago, they may still be being written (e.g. 30s)")
+	var ignoreFile = flag.String("ignore-file", defaults.IgnoreFile, "Name of the ignore files of the input directories, whose glob patterns leave out the files and directories under them like the ones of .gitignore (# comments, ! negations, trailing / for directories only, ** for any number of directories). Empty reads none")
+	var dirConfigFile = flag.String("dir-config-file", defaults.DirConfigFile, "Name of the YAML config files of the input directories, overriding for the inputs under them the route of their entries (route, a file in -outdir instead of -outfile), -route-formats (format_routes), -filter-cmd (filter_command) and narrowing -ext (ext), on top of the ones of their parents. A directory with a bad one is left out. Empty reads none")
+	var requireMarker = flag.String("require-marker", "", "Only process input files that have a companion marker file with this suffix, e.g. .done")
+	var writeMarker = flag.String("write-marker", "", "Create a marker file with this suffix next to each processed input file, e.g. .processed")
+	var statePath = flag.String("state", "", "State file remembering processed input files, unchanged ones are skipped on the next runs")
+	var stateIndex = flag.Bool("state-index", false, "Keep -state as a compact index with a bloom filter instead of JSON, so skipping the processed inputs stays fast with millions of them. A JSON state file is converted")
+	var onlyNewer = flag.Bool("only-newer-than-state", false, "Skip input files modified before the newest input in -state without hashing them, for append-only landing directories")
+	var checkpointPath = flag.String("checkpoint", "", "Save the progress of the run to this file, so a crashed or stopped run resumes after the entries it already appended when run again. -outfile is truncated back to it so each entry is in it once. Removed once the run completes")
+	var checkpointEvery = flag.Duration("checkpoint-every", 10*time.Second, "Time between the saves of -checkpoint, 0 saves it after every entry")
+	var useMmap = flag.Bool("mmap", false, "Memory-map local zip files instead of reading them, faster for very large archives")
+	var preallocate = flag.Bool("preallocate", false, "Preallocate extracted files using the sizes in the archive headers")
+	var preallocateCat = flag.Bool("preallocate-cat", false, "Preallocate the concatenated file using the estimated total size")
+	var directIO = flag.Bool("direct-io", false, "Write the concatenated file bypassing the page cache (Linux only)")
+	var attestPath = flag.String("attest", "", "Write a signed attestation of the manifest, the cat file and the inputs, requires -manifest, -sign-key and -hash sha256")
+	var signKey = flag.String("sign-key", "", "PKCS #8 private key (Ed25519, ECDSA or RSA, PEM or DER) signing -attest")
+	var auditPath = flag.String("audit", "", "Append a JSON line for every file or directory the run creates, renames or deletes, with the archive and entry behind it")
+	var script = flag.String("script", "", "Starlark file deciding what to do with each entry, e.g. policy.star. It defines decide(entry), entry having the attributes archive, name, nested, layer, size, compressed_size, mode, is_dir, mtime, crc32, comment, format, xattrs, entry and entries, and returns None to keep the defaults or a dict like {\"skip\": False, \"reason\": \"\", \"rename\": \"\", \"route\": \"other_blob\", \"transform\": [\"gzip -c\"]}")
+	var scriptCmd = flag.String("script-cmd", "", "Executable, split on spaces, deciding what to do with each entry instead of -script, e.g. \"python3 policy.py\". It gets one JSON entry per line on stdin and answers each with a JSON line like the dicts of -script, {} keeps the defaults")
+	var filterCmd = flag.String("filter-cmd", "", "Command, split on spaces, each extracted entry is piped through from stdin to stdout before it is written, e.g. \"grep -v DEBUG\"")
+	var wasmFilter = flag.String("wasm-filter", "", "WASI module each extracted entry is piped through from stdin to stdout, run with -wasm-runtime")
+	var wasmRuntime = flag.String("wasm-runtime", "wasmtime run", "Command, split on spaces, running -wasm-filter with the module path appended, e.g. \"wasmer run\" or \"wazero run\"")
+	var scanCmd = flag.String("scan-cmd", "", "Command, split on spaces, each extracted entry is streamed to on stdin, e.g. \"clamdscan -\". It must exit 0 for clean content and 1 for malicious content, anything else stops the run")
+	var quarantineDir = flag.String("quarantine-dir", "", "Move the entries flagged by -scan-cmd here instead of removing them")
+	var renameRules []catzip.RenameRule
+	flag.Func("rename", "Rewrite entry names before computing output paths with a sed-style rule, e.g. 's/old-prefix\\//new\\//' or 's/[^a-z0-9.\\/]/_/g'. An empty name skips the entry, gz and plain inputs only have their base name rewritten. Repeatable, applied in order", func(expr string) error {
+		rule, err := catzip.ParseRenameRule(expr)
+		if err != nil {
+			return err
+		}
+		renameRules = append(renameRules, rule)
+		return nil
+	})
+	var mergeTrees = flag.Bool("merge-trees", false, "Merge the trees of the zip files into one, keeping a single entry for each path instead of numbering the repeated ones")
+	var mergeKeep = flag.String("merge-keep", catzip.MergeNewest, "Entry kept by -merge-trees when several zip files have one for the same path: newest, oldest, first or last")
+	var conflictReport = flag.String("conflict-report", "", "Write the paths with different content in several zip files as JSON, with -merge-trees")
+	var overlay = flag.Bool("overlay", false, "Apply the zip files in input order as a full backup followed by its increments, honoring the -tombstones entries. Same as -merge-trees -merge-keep last")
+	var tombstones = flag.String("tombstones", "deleted.list", "Entry of the zip files listing the paths deleted by that archive, one per line, with -overlay. Deleted files extracted by previous runs are removed from -outdir")
+	var sinkSpecs []string
+	flag.Func("sink", "Also send the cat file or the entries as JSON lines somewhere, as policy:what:kind:target with policy required or best-effort, what cat or events and kind file or cmd (split on spaces, fed on stdin), e.g. \"required:cat:cmd:aws s3 cp - s3://bucket/blob\". A post-command run once the sink is complete can follow a |, {} being the file of file sinks. Repeatable", func(spec string) error {
+		sinkSpecs = append(sinkSpecs, spec)
+		return nil
+	})
+	var tee = flag.Bool("tee", false, "Also stream -outfile to stdout as it is written, e.g. to pipe it into grep. The run goes on when the reader goes away")
+	var credentials = flag.String("credentials", "", "Where the credentials of remote inputs come from, a comma separated chain of env (AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or CATZIP_TOKEN), shared or shared:profile (~/.aws/credentials and ~/.aws/config), web-identity (AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN, as with IRSA), metadata (ECS, EKS Pod Identity or EC2), gce-metadata and default, ending with exec:command (an external helper printing credential_process JSON). Empty is default, all but exec in that order")
+	var proxy = flag.String("proxy", "", "Proxy of the remote inputs and the credential providers, an http, https or socks5 URL. Empty uses HTTPS_PROXY, HTTP_PROXY and NO_PROXY")
+	var caCert = flag.String("ca-cert", "", "PEM file of certificates trusted on top of the system ones by the remote inputs and the credential providers, e.g. a private CA")
+	var clientCert = flag.String("client-cert", "", "PEM certificate sent to the servers asking for one, with -client-key")
+	var clientKey = flag.String("client-key", "", "PEM key of -client-cert")
+	var insecureSkipVerify = flag.Bool("insecure-skip-verify", false, "Accept any server certificate, for tests against self-signed ones")
+	var sortKey = flag.Int("sort-key", 0, "Sort the CSV records of -outfile by this column, from 1, once it is complete. Blank lines are dropped, and the offsets in -manifest are the ones before sorting")
+	var sortNumeric = flag.Bool("numeric", false, "Compare the -sort-key column as numbers, after the records where it isn't one like the header rows")
+	var sortMemory = flag.String("sort-memory", "64MiB", "Memory for the records sorted at once by -sort-key, the rest is spilled to sorted runs in -sort-tmpdir and merged")
+	var sortTempDir = flag.String("sort-tmpdir", "", "Where -sort-key spills its sorted runs, empty is the OS temporary directory")
+	var tmpDir = flag.String("tmpdir", "", "Temporary directory of the run and of the commands it runs, in place of $TMPDIR: -sort-key spills there unless -sort-tmpdir is set")
+	var postCmd = flag.String("post-cmd", "", "Command, split on spaces, run once the concatenated file is complete with {} replaced with its path, e.g. \"gzip -f {}\"")
+	var profile = flag.String("profile", "", "Preset for the kind of host, overriding the flags it covers: "+strings.Join(catzip.Profiles(), ", "))
+	var sandboxed = flag.Bool("sandbox", false, "Confine the process with Landlock (Linux only) so it can't write outside -outdir, -quarantine-dir, -tmpdir, -sort-tmpdir (with -sort-key), the directories of -state, -manifest, -checkpoint, -attest, -audit, -conflict-report and of gz inputs, and the files of -sink, -tree-json and -report-out")
+	var isolateWrites = flag.Bool("isolate-writes", false, "Write the outputs from a helper process chrooted to -outdir, in a user namespace when not root (Linux only). gz inputs must be under -outdir")
+	var lowPriority = flag.Bool("low-priority", false, "Run with the lowest CPU and I/O priority (background mode on Windows), also limits -workers and -write-workers to 1")
+	var manifestPath = flag.String("manifest", "", "Write a JSON manifest of the extracted files, including archive and entry comments")
+	var reportTemplate = flag.String("report-template", "", "Go text/template file rendering the summary and the entries at the end of the run, with .Summary and .Entries and the json and join functions")
+	var reportOut = flag.String("report-out", "", "Write -report-template here instead of stdout")
+	var showComposition = flag.Bool("composition", false, "Log a histogram of the sizes of the entries and their count by format, as -classify tells, or by extension at the end of the run")
+	var progress = flag.Bool("progress", false, "Log the entries done per archive and in total, at most once a second. The totals come from the zip central directories, read upfront")
+	var treeJSON = flag.String("tree-json", "", "Write the directory tree of each input file to this JSON file, - for stdout, instead of extracting them. Names, sizes and modification times come from the headers")
+	var entryName = flag.String("entry", "", "Write the entry with this name, from the first input file that has one, to stdout instead of extracting the input files. With -range, only a byte window of it")
+	var entryRange = flag.String("range", "", "Bytes of -entry to write, from the first offset to before the second, e.g. 1000-2000, 1GB-1.1GB or 5MiB- for the rest. Stored zip entries are seeked, the others decompressed up to the window")
+	var list = flag.Bool("list", false, "List the entries of the input files instead of extracting them")
+	var printComments = flag.Bool("print-comments", false, "Print archive and entry comments in -list mode")
 	var help = flag.Bool("help", false, "Show help")
 	flag.Parse()
 
@@ -29,191 +164,575 @@ func main() {
 		os.Exit(0)
 	}
 
-	filesInDir := []string{}
-	filepath.WalkDir(*dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	if *tmpDir != "" {
+		// For os.TempDir and the commands
+		for _, name := range []string{"TMPDIR", "TMP", "TEMP"} {
+			os.Setenv(name, *tmpDir)
 		}
+	}
 
-		if d.IsDir() {
-			return nil
-		}
+	workers, err := parseWorkers(*workersFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	splitSize, err := parseSize(*splitEntrySize)
+	if err != nil {
+		log.Fatalf("invalid -split-entry-size: %v", err)
+	}
+	recompressSize, err := parseSize(*recompressThreshold)
+	if err != nil {
+		log.Fatalf("invalid -recompress-threshold: %v", err)
+	}
+	maxLineSize, err := parseSize(*maxLineBytes)
+	if err != nil {
+		log.Fatalf("invalid -max-line-bytes: %v", err)
+	}
+	sortMemorySize, err := parseSize(*sortMemory)
+	if err != nil {
+		log.Fatalf("invalid -sort-memory: %v", err)
+	}
+	if *entryRange != "" && *entryName == "" {
+		log.Fatal("-range requires -entry")
+	}
+	rangeStart, rangeEnd, err := parseRange(*entryRange)
+	if err != nil {
+		log.Fatalf("invalid -range: %v", err)
+	}
 
-		if filepath.Ext(d.Name()) == *ext {
-			filesInDir = append(filesInDir, path)
-		}
+	opts := catzip.Options{
+		Dir:                *dir,
+		OutDir:             *outdir,
+		Ext:                *ext,
+		Detect:             *detect,
+		Image:              *image,
+		MaxDepth:           *maxDepth,
+		CatFileName:        *outdirCatFileName,
+		PassthroughExt:     strings.Split(*passthroughExt, ","),
+		Rename:             renameRules,
+		ConflictReportPath: *conflictReport,
+		Workers:            workers,
+		WriteWorkers:       *writeWorkers,
+		FilesQueue:         *filesQueue,
+		EntriesQueue:       *entriesQueue,
+		ChunksQueue:        *chunksQueue,
+		LargestFirst:       *largestFirst,
+		Stream:             *stream,
+		WalkWorkers:        *walkWorkers,
+		SplitEntrySize:     splitSize,
+		RecompressAbove:    recompressSize,
+		Hash:               *hashFlag,
+		Signature:          *signature,
+		Classify:           *classify,
+		DetectText:         *detectText,
+		StripANSI:          *stripANSI,
+		Escape:             *escape,
+		MaxLineBytes:       maxLineSize,
+		LongLines:          *longLines,
+		StableFor:          *stableFor,
+		RequireMarker:      *requireMarker,
+		IgnoreFile:         *ignoreFile,
+		DirConfigFile:      *dirConfigFile,
+		WriteMarker:        *writeMarker,
+		StatePath:          *statePath,
+		StateIndex:         *stateIndex,
+		OnlyNewerThanState: *onlyNewer,
+		ManifestPath:       *manifestPath,
+		CheckpointPath:     *checkpointPath,
+		CheckpointInterval: *checkpointEvery,
+		Mmap:               *useMmap,
+		Preallocate:        *preallocate,
+		PreallocateCat:     *preallocateCat,
+		DirectIO:           *directIO,
+		FilterCommand:      strings.Fields(*filterCmd),
+		PolicyCommand:      strings.Fields(*scriptCmd),
+		ScanCommand:        strings.Fields(*scanCmd),
+		QuarantineDir:      *quarantineDir,
+		AttestationPath:    *attestPath,
+		AuditPath:          *auditPath,
+		SortKey:            *sortKey,
+		SortNumeric:        *sortNumeric,
+		SortMemory:         sortMemorySize,
+		SortTempDir:        *sortTempDir,
+		PostCommand:        strings.Fields(*postCmd),
+	}
 
-		return nil
-	})
+	if *urlFile != "" {
+		more, err := readURLFile(*urlFile)
+		if err != nil {
+			log.Fatalf("Unable to read -url-file: %v", err)
+		}
+		urls = append(urls, more...)
+	}
+	opts.URLRetries = *urlRetries
+	opts.S3Endpoint = *s3Endpoint
+	opts.S3Region = *s3Region
+	opts.GCSEndpoint = *gcsEndpoint
+	opts.AzureAccount = *azureAccount
+	opts.AzureEndpoint = *azureEndpoint
+	opts.SSHCommand = strings.Fields(*sshCommand)
+	opts.NetrcFile = *netrcFile
+	if len(urls) > 0 {
+		opts.URLs = urls
+		dirSet := false
+		flag.Visit(func(f *flag.Flag) { dirSet = dirSet || f.Name == "dir" })
+		if !dirSet {
+			opts.Dir = ""
+		}
+	}
 
-	catFilePath := filepath.Join(*outdir, *outdirCatFileName)
-	catFile, _ = os.OpenFile(catFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	defer catFile.Close()
+	if *stdin || opts.Dir == "-" {
+		// Named after the format it is read as, inside -outdir where the
+		// single compressed inputs are extracted next to it
+		opts.Dir = filepath.Join(opts.OutDir, "stdin"+strings.TrimSpace(strings.Split(opts.Ext, ",")[0]))
+		opts.InputFS = catzip.ReaderFS(opts.Dir, os.Stdin)
+	}
+	if *stdout || opts.CatFileName == "-" {
+		if *tee {
+			log.Fatal("-tee can't be used with -outfile -, it is on stdout already")
+		}
+		if *reportTemplate != "" && *reportOut == "" {
+			log.Fatal("-report-template requires -report-out with -outfile -")
+		}
+		opts.CatWriter = os.Stdout
+	}
 
-	switch filepath.Ext(*ext) {
-	case ".gz":
-		handleGz(filesInDir, ext, outdir)
-		break
-	default:
-		handleZip(filesInDir, ext, outdir)
-		break
+	if *wasmFilter != "" {
+		if len(opts.FilterCommand) > 0 {
+			log.Fatal("-wasm-filter and -filter-cmd can't be used together")
+		}
+		opts.FilterCommand = append(strings.Fields(*wasmRuntime), *wasmFilter)
 	}
 
-}
+	if *annotate != "" {
+		opts.Annotate = strings.Split(*annotate, ",")
+	}
 
-func handleGz(filesInDir []string, ext *string, outdir *string) {
-	for _, gzFilename := range filesInDir {
+	if *routeFormats != "" {
+		opts.Classify = true
+		opts.FormatRoutes = map[string]string{}
+		for _, route := range strings.Split(*routeFormats, ",") {
+			format, name, ok := strings.Cut(route, "=")
+			if !ok {
+				log.Fatalf("invalid -route-formats entry %q, expected format=file", route)
+			}
+			opts.FormatRoutes[strings.TrimSpace(format)] = strings.TrimSpace(name)
+		}
+	}
 
-		newFilename := strings.TrimSuffix(gzFilename, ".gz")
-		newFilename = autoRenameRepeatedFiles(newFilename)
+	if *mergeTrees {
+		opts.MergeTrees = *mergeKeep
+	}
+	if *overlay {
+		opts.MergeTrees = catzip.MergeLast
+		opts.Tombstones = *tombstones
+	}
 
-		writer, err := os.Create(newFilename)
+	if *configPath != "" {
+		config, err := loadConfig(*configPath)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("Unable to load %s: %v", *configPath, err)
 		}
-		defer writer.Close()
+		opts.Handlers = config.Handlers
+	}
 
-		err = copyFileGz(gzFilename, newFilename, writer)
+	if *script != "" {
+		src, err := os.ReadFile(*script)
 		if err != nil {
 			log.Fatal(err)
 		}
+		opts.PolicyScript = string(src)
+	}
 
-		err = copyFileGz(gzFilename, newFilename, catFile)
+	if *signKey != "" {
+		keyData, err := os.ReadFile(*signKey)
 		if err != nil {
 			log.Fatal(err)
 		}
-		catFile.WriteString("\n")
+		if opts.SigningKey, err = catzip.ParseSigningKey(keyData); err != nil {
+			log.Fatalf("Unable to parse %s: %v", *signKey, err)
+		}
 	}
-}
-
-func copyFileGz(gzFilename string, newFilename string, writer io.WriteCloser) error {
 
-	gzFile, err := os.Open(gzFilename)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if *profile != "" {
+		if err := opts.ApplyProfile(*profile); err != nil {
+			log.Fatal(err)
+		}
 	}
-	defer gzFile.Close()
 
-	reader, err := gzip.NewReader(gzFile)
-	if err != nil {
-		return err
+	if *lowPriority {
+		if err := lowerPriority(); err != nil {
+			log.Print(catzip.LogFields("event", "warning", "msg", "unable to lower the process priority", "err", err))
+		}
+		opts.MaxWorkers = 1
+		opts.WriteWorkers = 1
 	}
-	defer reader.Close()
 
-	if err = ioCopy(newFilename, writer, reader); err != nil {
-		return err
+	if *proxy != "" || *caCert != "" || *clientCert != "" || *clientKey != "" || *insecureSkipVerify {
+		httpConfig := catzip.HTTPConfig{
+			Proxy:              *proxy,
+			CACertFile:         *caCert,
+			ClientCertFile:     *clientCert,
+			ClientKeyFile:      *clientKey,
+			InsecureSkipVerify: *insecureSkipVerify,
+		}
+		if opts.HTTPClient, err = httpConfig.Client(); err != nil {
+			log.Fatal(err)
+		}
 	}
-
-	return nil
-}
-
-func handleZip(filesInDir []string, ext *string, outdir *string) {
-	for _, f := range filesInDir {
-		reader, err := zip.OpenReader(f)
-		if err != nil {
-			log.Fatalf("Unable to read %s file ", *ext)
+	if *credentials != "" {
+		if opts.Credentials, err = catzip.ParseCredentials(*credentials, opts.HTTPClient); err != nil {
+			log.Fatal(err)
 		}
-		defer reader.Close()
+	}
 
-		destination, err := filepath.Abs(*outdir)
-		if err != nil {
-			log.Fatalf("Unable to find absolute path for dir %s ", *outdir)
+	// Not when the run only lists or extracts
+	attachSinks := !*list && *treeJSON == "" && *entryName == ""
+	if *sandboxed {
+		for _, dir := range []string{opts.OutDir, opts.QuarantineDir, *tmpDir} {
+			if dir == "" {
+				continue
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				log.Fatal(err)
+			}
+		}
+		// The sandboxed process opens the sinks, -tree-json and -report-out again
+		var files []string
+		if attachSinks {
+			files = writableFiles(sinkSpecs, "", *reportOut)
+		} else {
+			files = writableFiles(nil, *treeJSON, "")
+		}
+		for _, file := range files {
+			f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0666)
+			if err != nil {
+				log.Fatal(err)
+			}
+			f.Close()
 		}
+		dirs := writableDirs(opts)
+		if *tmpDir != "" {
+			dirs = append(dirs, *tmpDir)
+		}
+		if err := sandbox(dirs, files); err != nil {
+			log.Fatalf("Unable to sandbox the process: %v", err)
+		}
+	}
 
-		for _, f := range reader.File {
-			err := unzipFile(f, destination)
+	if attachSinks {
+		for _, spec := range sinkSpecs {
+			sink, err := catzip.ParseSink(spec)
 			if err != nil {
-				log.Fatal("Unable to to unzip file inside archive: ", err)
+				log.Fatal(err)
 			}
+			opts.Sinks = append(opts.Sinks, sink)
+		}
+		if *tee {
+			// Writing to a closed pipe fails the sink instead of killing the
+			// process
+			signal.Ignore(syscall.SIGPIPE)
+			stdout := &catzip.CatSink{W: nopWriteCloser{os.Stdout}}
+			opts.Sinks = append(opts.Sinks, catzip.AttachedSink{Name: "cat to stdout", Sink: stdout, Policy: catzip.SinkBestEffort})
 		}
 	}
-}
 
-func autoRenameRepeatedFiles(filePath string) string {
-	counter, repeated := unzipedFiles[filePath]
-	if repeated {
-		dir := filepath.Dir(filePath)
-		ext := filepath.Ext(filePath)
-		fileName := filepath.Base(filePath)
-		fileName = fileName[:len(fileName)-len(ext)]
-		fileName = fmt.Sprintf("%s(%d)%s", fileName, counter, ext)
-		filePath = filepath.Join(dir, fileName)
-	}
-	return filePath
-}
+	var treeOut *os.File
+	if *treeJSON == "-" {
+		treeOut = os.Stdout
+	} else if *treeJSON != "" {
+		if treeOut, err = os.Create(*treeJSON); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-func unzipFile(f *zip.File, destination string) error {
-	//Check if file paths are not vulnerable to Zip Slip
-	filePath := filepath.Join(destination, f.Name)
-	if !strings.HasPrefix(filePath, filepath.Clean(destination)+string(os.PathSeparator)) {
-		return fmt.Errorf("invalid file path: %s", filePath)
+	if *list {
+		if err := catzip.List(os.Stdout, opts, *printComments); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// Not needed but will create directory tree
-	if f.FileInfo().IsDir() {
-		if err := os.MkdirAll(filePath, os.ModePerm); err != nil {
-			return err
+	if treeOut != nil {
+		trees, err := catzip.Tree(opts)
+		if err != nil {
+			log.Fatal(err)
 		}
-		return nil
+		data, err := json.MarshalIndent(trees, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err = treeOut.Write(append(data, '\n')); err == nil {
+			err = treeOut.Close()
+		}
+		if err != nil {
+			log.Fatalf("Unable to write -tree-json: %v", err)
+		}
+		return
 	}
 
-	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
-		return err
+	if *entryName != "" {
+		if err := catzip.ExtractRange(os.Stdout, opts, *entryName, rangeStart, rangeEnd); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var report *template.Template
+	var reportEntries []catzip.EntryInfo
+	if *reportTemplate != "" {
+		if report, err = parseReportTemplate(*reportTemplate); err != nil {
+			log.Fatalf("Unable to parse -report-template: %v", err)
+		}
+		opts.OnEntry = func(entry catzip.EntryInfo) {
+			reportEntries = append(reportEntries, entry)
+		}
+	}
+	if *progress {
+		var last time.Time
+		opts.OnProgress = func(p catzip.Progress) {
+			if p.Done < p.Total && time.Since(last) < time.Second {
+				return
+			}
+			last = time.Now()
+			log.Print(formatProgress(p))
+		}
 	}
 
-	// The ziped files migh have files with the same name, solving that
-	counter, repeated := unzipedFiles[filePath]
-	if repeated {
-		dir := filepath.Dir(filePath)
-		ext := filepath.Ext(filePath)
-		fileName := filepath.Base(filePath)
-		fileName = fileName[:len(fileName)-len(ext)]
-		fileName = fmt.Sprintf("%s(%d)%s", fileName, counter, ext)
-		filePath = filepath.Join(dir, fileName)
+	var stopWriteHelper func() error
+	if *isolateWrites {
+		if opts.FS, stopWriteHelper, err = startWriteHelper(opts.OutDir); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	// 6. Create a destination file for unzipped content
-	destinationFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	applyChaos(&opts)
+	summary, err := catzip.Run(opts)
 	if err != nil {
-		return err
+		log.Fatal(err)
+	}
+	if stopWriteHelper != nil {
+		if err := stopWriteHelper(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if report != nil {
+		if err := writeReport(report, *reportOut, reportData{Summary: summary, Entries: reportEntries}); err != nil {
+			log.Fatalf("Unable to write the report: %v", err)
+		}
 	}
+	if summary.LongLines > 0 {
+		log.Print(catzip.LogFields("event", "long_lines", "lines", summary.LongLines, "mode", *longLines))
+	}
+	if len(summary.Conflicts) > 0 {
+		// Paths with different content in several zip files
+		log.Print(catzip.LogFields("event", "conflicts", "paths", len(summary.Conflicts)))
+	}
+	for name, err := range summary.SinkErrors {
+		log.Print(catzip.LogFields("event", "sink_dropped", "sink", name, "err", err))
+	}
+	if *showComposition {
+		reportComposition(summary)
+	}
+
+	fields := []interface{}{"event", "done", "files", summary.Files, "bytes", summary.Bytes,
+		"duration", summary.Duration.Round(time.Millisecond), "workers", summary.Workers}
+	if opts.Workers == 0 {
+		// Auto-tuned
+		fields = append(fields, "peak_workers", summary.PeakWorkers)
+	}
+	log.Print(catzip.LogFields(fields...))
+}
 
-	defer destinationFile.Close()
+const autoWorkers = "auto"
 
-	if err = copyToFile(f, destinationFile); err != nil {
-		return err
+// parseWorkers parses the -workers flag, "auto" is 0
+func parseWorkers(value string) (int, error) {
+	if value == autoWorkers {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid -workers value %q, expected a positive number or %q", value, autoWorkers)
 	}
+	return n, nil
+}
+
+var sizeUnits = map[string]int64{
+	"": 1, "B": 1,
+	"KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12,
+	"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40,
+}
 
-	//Apend to cat
-	if err = copyToFile(f, catFile); err != nil {
-		return err
+// parseSize parses a number of bytes with an optional unit, e.g. 4GB
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	i := strings.IndexFunc(value, func(c rune) bool { return (c < '0' || c > '9') && c != '.' })
+	if i < 0 {
+		i = len(value)
+	}
+	unit, ok := sizeUnits[strings.TrimSpace(value[i:])]
+	n, err := strconv.ParseFloat(value[:i], 64)
+	if !ok || err != nil || n < 0 {
+		return 0, fmt.Errorf("%q isn't a size, expected e.g. 4GB or 512MiB", value)
 	}
-	catFile.WriteString("\n")
+	return int64(n * float64(unit)), nil
+}
 
-	unzipedFiles[filePath] += 1
-	return nil
+// parseRange parses a byte range of -range like 1000-2000, the end is -1
+// when it is left out
+func parseRange(value string) (int64, int64, error) {
+	if value == "" {
+		return 0, -1, nil
+	}
+	i := strings.Index(value, "-")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("%q isn't a range, expected e.g. 1000-2000", value)
+	}
+	start, err := parseSize(value[:i])
+	if err != nil {
+		return 0, 0, err
+	}
+	end := int64(-1)
+	if strings.TrimSpace(value[i+1:]) != "" {
+		if end, err = parseSize(value[i+1:]); err != nil {
+			return 0, 0, err
+		}
+		if end < start {
+			return 0, 0, fmt.Errorf("%q ends before it starts", value)
+		}
+	}
+	return start, end, nil
 }
 
-func copyToFile(f *zip.File, destinationFile *os.File) error {
-	zippedFile, err := f.Open()
+// readURLFile reads the URLs of -url-file
+func readURLFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var urls []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	return urls, nil
+}
+
+// writableFiles lists the files outside of the writable directories a run
+// writes to, the file sinks, -tree-json and -report-out
+func writableFiles(sinkSpecs []string, treeJSON, reportOut string) []string {
+	var files []string
+	if treeJSON != "" && treeJSON != "-" {
+		files = append(files, treeJSON)
+	}
+	if reportOut != "" {
+		files = append(files, reportOut)
+	}
+	for _, spec := range sinkSpecs {
+		if file := catzip.SinkFile(spec); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// writableDirs lists the directories a run writes to
+func writableDirs(opts catzip.Options) []string {
+	dirs := []string{opts.OutDir}
+	// gz, zst, bz2, xz, lz4, br and sz inputs are extracted next to them, and markers are written there.
+	// stdin, the URLs and the objects of buckets are named in OutDir.
+	if opts.InputFS == nil && opts.Dir != "" && !strings.Contains(opts.Dir, "://") && (opts.ExtractsInPlace() || opts.WriteMarker != "") {
+		dirs = append(dirs, opts.Dir)
 	}
-	defer zippedFile.Close()
+	if opts.StatePath != "" {
+		dirs = append(dirs, filepath.Dir(opts.StatePath))
+	}
+	if opts.ManifestPath != "" {
+		dirs = append(dirs, filepath.Dir(opts.ManifestPath))
+	}
+	if opts.CheckpointPath != "" {
+		dirs = append(dirs, filepath.Dir(opts.CheckpointPath))
+	}
+	if opts.QuarantineDir != "" {
+		dirs = append(dirs, opts.QuarantineDir)
+	}
+	if opts.SortKey > 0 {
+		if opts.SortTempDir != "" {
+			dirs = append(dirs, opts.SortTempDir)
+		} else {
+			dirs = append(dirs, os.TempDir())
+		}
+	}
+	if opts.AttestationPath != "" {
+		dirs = append(dirs, filepath.Dir(opts.AttestationPath))
+	}
+	if opts.AuditPath != "" {
+		dirs = append(dirs, filepath.Dir(opts.AuditPath))
+	}
+	if opts.ConflictReportPath != "" {
+		dirs = append(dirs, filepath.Dir(opts.ConflictReportPath))
+	}
+	return dirs
+}
 
-	if err = ioCopy(destinationFile.Name(), destinationFile, zippedFile); err != nil {
-		return err
+// reportComposition logs the entries by size, with a bar scaled to the
+// largest bucket, and by type
+func reportComposition(summary *catzip.Summary) {
+	if summary.Entries == 0 {
+		return
 	}
+	largest := 0
+	for _, b := range summary.Sizes {
+		if b.Entries > largest {
+			largest = b.Entries
+		}
+	}
+	log.Printf("entries by size:")
+	lower := "0B"
+	for _, b := range summary.Sizes {
+		bounds := lower + "-" + sizeLabel(b.Below)
+		switch {
+		case b.Below == 1:
+			bounds = "empty"
+		case b.Below == 0:
+			bounds = lower + " and more"
+		}
+		log.Printf("  %-16s %6d %-40s %d bytes", bounds, b.Entries, strings.Repeat("#", (b.Entries*40+largest-1)/largest), b.Bytes)
+		lower = sizeLabel(b.Below)
+	}
+	log.Printf("entries by type:")
+	for _, t := range summary.Types {
+		name := t.Type
+		if name == "" {
+			name = "(no extension)"
+		}
+		log.Printf("  %-16s %6d %d bytes", name, t.Entries, t.Bytes)
+	}
+}
 
-	log.Printf("output file at %v", destinationFile.Name())
-	return nil
+// sizeLabel reads like 16KiB, n being a power of 1024 times a whole number
+func sizeLabel(n uint64) string {
+	for _, unit := range []string{"GiB", "MiB", "KiB"} {
+		if size := uint64(sizeUnits[unit]); n >= size && n%size == 0 {
+			return strconv.FormatUint(n/size, 10) + unit
+		}
+	}
+	return strconv.FormatUint(n, 10) + "B"
 }
 
-func ioCopy(filename string, writer io.Writer, reader io.ReadCloser) error {
-	if _, err := io.Copy(writer, reader); err != nil {
-		return err
+// formatProgress reads like "in/a.zip: entry 1234/98765, 1300/200000 in total",
+// tar members aren't counted upfront
+func formatProgress(p catzip.Progress) string {
+	entry := strconv.Itoa(p.Entry)
+	if p.Entries > 0 {
+		entry += "/" + strconv.Itoa(p.Entries)
 	}
-	log.Printf("output file at %v", filename)
-	return nil
+	return catzip.LogFields("event", "progress", "archive", p.Archive, "entry", entry, "done", p.Done, "total", p.Total)
 }
+
+// nopWriteCloser keeps stdout open once the sink writing to it is closed
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,51 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// runPlan compares the input files with the state file and prints what a
+// run would do with them, without processing anything
+func runPlan(args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	var dir = flags.String("dir", ".", "Directory where the input zip files are placed")
+	var ext = flags.String("ext", ".gz", "Filter input files by extension: .zip and .gz")
+	var passthroughExt = flags.String("passthrough-ext", "", "Comma separated extensions of plain files copied and concatenated as they are")
+	var statePath = flags.String("state", "", "State file of the previous runs")
+	var requireMarker = flags.String("require-marker", "", "Only consider input files that have a companion marker file with this suffix")
+	var stableFor = flags.Duration("stable-for", 0, "Leave out input files modified less than this long ago")
+	var onlyNewer = flags.Bool("only-newer-than-state", false, "Leave out input files and directories not modified since the newest input in -state")
+	flags.Parse(args)
+
+	if *statePath == "" {
+		log.Fatal("plan requires -state")
+	}
+
+	opts := catzip.DefaultOptions()
+	opts.Dir = *dir
+	opts.Ext = *ext
+	opts.PassthroughExt = strings.Split(*passthroughExt, ",")
+	opts.StatePath = *statePath
+	opts.RequireMarker = *requireMarker
+	opts.StableFor = *stableFor
+	opts.OnlyNewerThanState = *onlyNewer
+
+	plan, err := catzip.Plan(opts)
+	if err != nil {
+		log.Fatalf("Unable to read state file %s: %v", *statePath, err)
+	}
+	for _, f := range plan.Added {
+		fmt.Printf("+ %s\n", f)
+	}
+	for _, f := range plan.Changed {
+		fmt.Printf("~ %s\n", f)
+	}
+	fmt.Printf("Plan: %d new, %d changed, %d unchanged (skipped)\n", len(plan.Added), len(plan.Changed), plan.Unchanged)
+}
@@ -0,0 +1,11 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "syscall"
+
+// lowerPriority sets the lowest CPU niceness for the current process, there is
+// no portable I/O priority on these systems so the nice value has to be enough
+func lowerPriority() error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, 20)
+}
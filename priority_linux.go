@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// lowerPriority sets the lowest CPU niceness and the idle I/O scheduling class
+// for the current process, the same as running it under `nice -n 19 ionice -c3`.
+// On Linux both only apply to the thread they are given, so they are set for
+// every thread of /proc/self/task until no new one shows up, the threads the
+// runtime starts later inherit them from the ones that start them.
+func lowerPriority() error {
+	// Not to be moved to a thread started meanwhile
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	lowered := map[int]bool{}
+	for {
+		tasks, err := os.ReadDir("/proc/self/task")
+		if err != nil {
+			return err
+		}
+		more := false
+		for _, task := range tasks {
+			tid, err := strconv.Atoi(task.Name())
+			if err != nil || lowered[tid] {
+				continue
+			}
+			if err := lowerThreadPriority(tid); err != nil && err != syscall.ESRCH {
+				return err
+			}
+			lowered[tid] = true
+			more = true
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// lowerThreadPriority lowers the priorities of the thread tid, ESRCH when it
+// has exited
+func lowerThreadPriority(tid int) error {
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, 19); err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !windows && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !js && !wasip1
+
+package main
+
+import "errors"
+
+func lowerPriority() error {
+	return errors.New("process priority can't be changed on this platform")
+}
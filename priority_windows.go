@@ -0,0 +1,22 @@
+package main
+
+import "syscall"
+
+const processModeBackgroundBegin = 0x00100000
+
+var procSetPriorityClass = syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+
+// lowerPriority switches the current process to background processing mode,
+// which lowers both its CPU and its I/O (and memory) priority
+func lowerPriority() error {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+
+	r, _, err := procSetPriorityClass.Call(uintptr(process), processModeBackgroundBegin)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// runRecheck confirms the outputs of a previous run still match its manifest
+func runRecheck(args []string) {
+	flags := flag.NewFlagSet("recheck", flag.ExitOnError)
+	var manifestPath = flags.String("manifest", "", "Manifest of the run, written with -hash")
+	var catPath = flags.String("cat", "", "Concatenated file, when it moved since the run")
+	flags.Parse(args)
+
+	if *manifestPath == "" {
+		log.Fatal("recheck requires -manifest")
+	}
+
+	checked, drifts, err := catzip.Recheck(*manifestPath, *catPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, d := range drifts {
+		fmt.Printf("! %s (%s in %s): %s\n", d.Path, d.Name, d.Archive, d.Problem)
+	}
+	fmt.Printf("Recheck: %d entries checked, %d drifted\n", checked, len(drifts))
+	if len(drifts) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,50 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// reportData is what -report-template is executed with
+type reportData struct {
+	Summary *catzip.Summary
+	Entries []catzip.EntryInfo // Written and skipped, in the order they completed
+}
+
+var reportFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
+
+func parseReportTemplate(path string) (*template.Template, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(path).Funcs(reportFuncs).Parse(string(text))
+}
+
+// writeReport renders the report to path, or stdout when path is empty
+func writeReport(tmpl *template.Template, path string, data reportData) error {
+	if path == "" {
+		return tmpl.Execute(os.Stdout, data)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = tmpl.Execute(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
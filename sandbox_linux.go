@@ -0,0 +1,169 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock, the syscall numbers are the same on every architecture
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	prSetNoNewPrivs = 38
+	oPath           = 0x200000 // O_PATH, missing from syscall
+)
+
+// Write side filesystem rights, reading and executing stay unrestricted
+const (
+	landlockAccessFsWriteFile  = 1 << 1
+	landlockAccessFsRemoveDir  = 1 << 4
+	landlockAccessFsRemoveFile = 1 << 5
+	landlockAccessFsMakeChar   = 1 << 6
+	landlockAccessFsMakeDir    = 1 << 7
+	landlockAccessFsMakeReg    = 1 << 8
+	landlockAccessFsMakeSock   = 1 << 9
+	landlockAccessFsMakeFifo   = 1 << 10
+	landlockAccessFsMakeBlock  = 1 << 11
+	landlockAccessFsMakeSym    = 1 << 12
+	landlockAccessFsRefer      = 1 << 13 // ABI 2
+	landlockAccessFsTruncate   = 1 << 14 // ABI 3
+)
+
+// sandboxEnv is set for the process sandbox execs, which runs confined, to
+// the descriptor of the Landlock ruleset it inherits
+const sandboxEnv = "CATZIP_SANDBOXED"
+
+// sandbox confines the process with Landlock so that it can only create,
+// modify or remove files beneath writableDirs, and write to writableFiles
+// which have to exist.
+//
+// no_new_privs and Landlock only apply to the thread that sets them, and
+// the runtime has started others already, AllThreadsSyscall being refused
+// when cgo is linked in. So this thread sets them and execs the program
+// again with sandboxEnv: the new process starts confined on its only
+// thread, the ones it starts inherit it, and sandbox returns nil there once
+// checkSandboxed finds the ruleset. A sandboxEnv that doesn't check out, say
+// inherited from another process, is ignored and the process confines
+// itself.
+func sandbox(writableDirs, writableFiles []string) error {
+	if value, ok := os.LookupEnv(sandboxEnv); ok {
+		os.Unsetenv(sandboxEnv)
+		if checkSandboxed(value) {
+			return nil
+		}
+	}
+
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return fmt.Errorf("landlock is not available: %w", errno)
+	}
+
+	var access uint64 = landlockAccessFsWriteFile | landlockAccessFsRemoveDir | landlockAccessFsRemoveFile |
+		landlockAccessFsMakeChar | landlockAccessFsMakeDir | landlockAccessFsMakeReg | landlockAccessFsMakeSock |
+		landlockAccessFsMakeFifo | landlockAccessFsMakeBlock | landlockAccessFsMakeSym
+	var fileAccess uint64 = landlockAccessFsWriteFile
+	if abi >= 2 {
+		access |= landlockAccessFsRefer
+	}
+	if abi >= 3 {
+		access |= landlockAccessFsTruncate
+		fileAccess |= landlockAccessFsTruncate
+	}
+
+	// struct landlock_ruleset_attr, only handled_access_fs
+	rulesetAttr := access
+	rulesetFd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&rulesetAttr)), unsafe.Sizeof(rulesetAttr), 0)
+	if errno != 0 {
+		return fmt.Errorf("unable to create landlock ruleset: %w", errno)
+	}
+	// Kept open by the exec, it tells the new process it is confined
+	defer syscall.Close(int(rulesetFd))
+
+	for _, dir := range writableDirs {
+		if err := addLandlockRule(rulesetFd, dir, syscall.O_DIRECTORY, access); err != nil {
+			return err
+		}
+	}
+	// Only the file rights are allowed on a file
+	for _, file := range writableFiles {
+		if err := addLandlockRule(rulesetFd, file, 0, fileAccess); err != nil {
+			return err
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if _, _, errno = syscall.Syscall(syscall.SYS_FCNTL, rulesetFd, syscall.F_SETFD, 0); errno != 0 {
+		return fmt.Errorf("unable to pass the landlock ruleset on: %w", errno)
+	}
+	env := append(os.Environ(), sandboxEnv+"="+strconv.Itoa(int(rulesetFd)))
+
+	// Never unlocked, the thread is replaced by the exec or the process exits
+	runtime.LockOSThread()
+	if _, _, errno = syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("unable to set no_new_privs: %w", errno)
+	}
+	if _, _, errno = syscall.RawSyscall(sysLandlockRestrictSelf, rulesetFd, 0, 0); errno != 0 {
+		return fmt.Errorf("unable to enforce the landlock ruleset: %w", errno)
+	}
+	err = syscall.Exec(exe, os.Args, env)
+	return fmt.Errorf("unable to start the sandboxed process: %w", err)
+}
+
+// addLandlockRule allows access beneath path
+func addLandlockRule(rulesetFd uintptr, path string, flags int, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC|flags, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open %s for the sandbox: %w", path, err)
+	}
+	defer syscall.Close(fd)
+
+	rule := landlockPathBeneathAttr{allowedAccess: access, parentFd: int32(fd)}
+	_, _, errno := syscall.Syscall6(sysLandlockAddRule, rulesetFd, landlockRulePathBeneath, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("unable to allow writes to %s: %w", path, errno)
+	}
+	return nil
+}
+
+// checkSandboxed tells whether value, of sandboxEnv, is the descriptor of
+// the Landlock ruleset sandbox passed on, with no_new_privs set. Landlock
+// can't be queried, but nothing else has this descriptor open: sandbox
+// opens it without close-on-exec for the exec only, and it is closed here
+// so the commands the process runs don't get it.
+func checkSandboxed(value string) bool {
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 0 {
+		return false
+	}
+	link, err := os.Readlink("/proc/self/fd/" + value)
+	if err != nil || link != "anon_inode:[landlock-ruleset]" {
+		return false
+	}
+	syscall.Close(fd)
+
+	status, err := os.ReadFile("/proc/self/status")
+	return err == nil && strings.Contains(string(status), "\nNoNewPrivs:\t1\n")
+}
+
+// The kernel struct is packed to 12 bytes, the padding Go adds after
+// parentFd isn't read
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !js && !wasip1
+
+package main
+
+import "errors"
+
+func sandbox(writableDirs, writableFiles []string) error {
+	return errors.New("-sandbox is only supported on Linux")
+}
@@ -0,0 +1,584 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// serveConfig is the -config file of the serve subcommand
+type serveConfig struct {
+	Tenants []*tenant `json:"tenants"`
+}
+
+// tenant is a namespace of the daemon, its jobs only read under InputRoot and
+// only write under OutDir
+type tenant struct {
+	Name  string `json:"name"`
+	Token string `json:"token"` // API token, sent as a bearer token
+	// Jobs name a directory under it
+	InputRoot string `json:"input_root"`
+	OutDir    string `json:"outdir"`
+	// Bytes OutDir can hold, 0 is no limit
+	QuotaBytes int64 `json:"quota_bytes"`
+	// Defaults of the jobs
+	Ext  string `json:"ext"`
+	Hash string `json:"hash"`
+	// Routing, as -route-formats and -sink
+	FormatRoutes map[string]string `json:"format_routes"`
+	Sinks        []string          `json:"sinks"`
+	// Where the credentials of the urls of the jobs come from, as
+	// -credentials. Jobs can only have urls when it is set, the daemon's own
+	// credentials don't serve the tenants.
+	Credentials string `json:"credentials"`
+	// Jobs run at the same time, 0 is 1. Each writes in a directory of
+	// OutDir named after its id, but the jobs extracting next to their
+	// inputs never run together.
+	MaxJobs int `json:"max_jobs"`
+	// Bytes written per second by all the jobs, 0 is no limit
+	BytesPerSecond int64 `json:"bytes_per_second"`
+
+	tokenSum    [sha256.Size]byte // Of Token
+	credentials catzip.CredentialProvider
+
+	// Guarded by server.mu
+	running int
+	inPlace bool      // A running job extracts next to its inputs
+	fs      *tenantFS // Shared by the running jobs
+}
+
+// jobRequest is the body of POST /jobs
+type jobRequest struct {
+	Dir string `json:"dir"` // Under the tenant input root
+	// Also read, with the tenant credentials. Only they are when dir is
+	// empty.
+	URLs    []string `json:"urls"`
+	Ext     string   `json:"ext"`
+	OutFile string   `json:"outfile"`
+	// Queued jobs with a higher priority start first, whatever the tenant
+	Priority int `json:"priority"`
+	// Count the entries of the inputs upfront for the job total, which
+	// reads the zip central directories and the tar files one more time
+	Progress bool `json:"progress"`
+}
+
+type job struct {
+	ID       string     `json:"id"`
+	Dir      string     `json:"dir"`
+	OutDir   string     `json:"outdir"` // Under the tenant outdir
+	Priority int        `json:"priority"`
+	Status   string     `json:"status"` // queued, running, done or failed
+	Error    string     `json:"error,omitempty"`
+	Files    int        `json:"files"`
+	Bytes    int64      `json:"bytes"`
+	Skipped  int        `json:"skipped"`
+	Done     int        `json:"done"`  // Entries so far
+	Total    int        `json:"total"` // Entries of the inputs, with progress only
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	tenant *tenant
+	opts   catzip.Options
+}
+
+type server struct {
+	tenants   []*tenant
+	maxJobs   int
+	retention time.Duration
+	mu        sync.Mutex      // Guards everything below, the jobs and the tenants state
+	jobs      map[string]*job // Until retention has passed once finished
+	queue     []*job
+	running   int
+	nextID    atomic.Int64
+}
+
+// runServe runs cat-zip as a daemon extracting the jobs submitted over HTTP
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	var listen = flags.String("listen", "127.0.0.1:8080", "Address to listen on")
+	var configPath = flags.String("config", "", "JSON file with the tenants: name, token, input_root, outdir, quota_bytes, ext, hash, format_routes, sinks, credentials, max_jobs and bytes_per_second")
+	var maxJobs = flags.Int("max-jobs", 2, "Jobs run at the same time across the tenants, the queued ones start by priority")
+	var retention = flags.Duration("job-retention", time.Hour, "How long finished jobs can still be looked up, 0 keeps them")
+	flags.Parse(args)
+	if *maxJobs < 1 {
+		log.Fatal("-max-jobs must be at least 1")
+	}
+	if *retention < 0 {
+		log.Fatal("-job-retention can't be negative")
+	}
+
+	if *configPath == "" {
+		log.Fatal("serve requires -config")
+	}
+	s, err := loadServer(*configPath)
+	if err != nil {
+		log.Fatalf("Unable to load %s: %v", *configPath, err)
+	}
+	s.maxJobs = *maxJobs
+	s.retention = *retention
+
+	http.HandleFunc("/jobs", s.handleJobs)
+	http.HandleFunc("/jobs/", s.handleJob)
+	log.Print(catzip.LogFields("event", "serving", "tenants", len(s.tenants), "listen", *listen))
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
+
+func loadServer(path string) (*server, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config serveConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	outDirs := map[string]string{}
+	for _, t := range config.Tenants {
+		switch {
+		case t.Name == "" || names[t.Name]:
+			return nil, fmt.Errorf("tenant names must be unique and not empty, got %q", t.Name)
+		case len(t.Token) < 16:
+			return nil, fmt.Errorf("tenant %s: the token must have at least 16 characters", t.Name)
+		case t.InputRoot == "" || t.OutDir == "":
+			return nil, fmt.Errorf("tenant %s: input_root and outdir are required", t.Name)
+		}
+		names[t.Name] = true
+		if t.MaxJobs == 0 {
+			t.MaxJobs = 1
+		}
+		t.tokenSum = sha256.Sum256([]byte(t.Token))
+		if t.Credentials != "" {
+			if t.credentials, err = catzip.ParseCredentials(t.Credentials, nil); err != nil {
+				return nil, fmt.Errorf("tenant %s: %w", t.Name, err)
+			}
+		}
+		if err = os.MkdirAll(t.OutDir, 0755); err != nil {
+			return nil, err
+		}
+		// Without links, the containment checks compare the real paths
+		if t.InputRoot, err = realPath(t.InputRoot); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+		if t.OutDir, err = realPath(t.OutDir); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+		// Isolation relies on the tenants not sharing outputs
+		for dir, other := range outDirs {
+			if within(dir, t.OutDir) || within(t.OutDir, dir) {
+				return nil, fmt.Errorf("tenants %s and %s have overlapping outdirs", other, t.Name)
+			}
+		}
+		outDirs[t.OutDir] = t.Name
+	}
+	s := &server{tenants: config.Tenants, jobs: map[string]*job{}}
+
+	// The ids go on from the job directories of the previous runs
+	for dir := range outDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if id, err := strconv.ParseInt(entry.Name(), 10, 64); err == nil && id > s.nextID.Load() {
+				s.nextID.Store(id)
+			}
+		}
+	}
+	return s, nil
+}
+
+// realPath returns the absolute path of name with the links resolved, name
+// has to exist
+func realPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// within tells whether path is dir or under it, both absolute and clean
+func within(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// authenticate returns the tenant of the request token, writing the error
+// response when there is none
+func (s *server) authenticate(w http.ResponseWriter, req *http.Request) *tenant {
+	if header := req.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		// The sums have the same length whatever the tokens, and every
+		// tenant is compared, so the time tells nothing about them
+		sum := sha256.Sum256([]byte(strings.TrimPrefix(header, "Bearer ")))
+		var found *tenant
+		for _, t := range s.tenants {
+			if subtle.ConstantTimeCompare(sum[:], t.tokenSum[:]) == 1 {
+				found = t
+			}
+		}
+		if found != nil {
+			return found
+		}
+	}
+	http.Error(w, "unknown or missing bearer token", http.StatusUnauthorized)
+	return nil
+}
+
+// handleJobs lists the jobs of the tenant on GET and submits one on POST
+func (s *server) handleJobs(w http.ResponseWriter, req *http.Request) {
+	t := s.authenticate(w, req)
+	if t == nil {
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		jobs := []job{}
+		for _, j := range s.jobs {
+			if j.tenant == t {
+				jobs = append(jobs, *j)
+			}
+		}
+		s.mu.Unlock()
+		sort.Slice(jobs, func(a, b int) bool { return jobs[a].Created.Before(jobs[b].Created) })
+		writeJSON(w, http.StatusOK, jobs)
+
+	case http.MethodPost:
+		var jr jobRequest
+		if err := json.NewDecoder(req.Body).Decode(&jr); err != nil {
+			http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts, err := t.jobOptions(jr)
+		if err != nil {
+			http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		id := strconv.FormatInt(s.nextID.Add(1), 10)
+		// The jobs of the tenant don't see the files of the others
+		opts.OutDir = filepath.Join(t.OutDir, id)
+		// The jobs of the tenants run at the same time, their lines are told apart
+		opts.Logger = log.New(log.Writer(), catzip.LogFields("tenant", t.Name, "job", id)+" ", log.Flags()|log.Lmsgprefix)
+		j := &job{
+			ID:       id,
+			Dir:      jr.Dir,
+			OutDir:   id,
+			Priority: jr.Priority,
+			Status:   "queued",
+			Created:  time.Now(),
+			tenant:   t,
+			opts:     opts,
+		}
+		if jr.Progress {
+			j.opts.OnProgress = func(p catzip.Progress) {
+				s.mu.Lock()
+				j.Done, j.Total = p.Done, p.Total
+				s.mu.Unlock()
+			}
+		} else {
+			j.opts.OnEntry = func(catzip.EntryInfo) {
+				s.mu.Lock()
+				j.Done++
+				s.mu.Unlock()
+			}
+		}
+		s.mu.Lock()
+		s.jobs[j.ID] = j
+		s.queue = append(s.queue, j)
+		s.dispatch()
+		view := *j
+		s.mu.Unlock()
+		writeJSON(w, http.StatusAccepted, view)
+
+	default:
+		http.Error(w, "expected GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleJob returns a job of the tenant, the jobs of other tenants don't exist
+func (s *server) handleJob(w http.ResponseWriter, req *http.Request) {
+	t := s.authenticate(w, req)
+	if t == nil {
+		return
+	}
+	if req.Method != http.MethodGet {
+		http.Error(w, "expected GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	j, ok := s.jobs[strings.TrimPrefix(req.URL.Path, "/jobs/")]
+	var view job
+	if ok {
+		view = *j
+	}
+	s.mu.Unlock()
+	if !ok || j.tenant != t {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// jobOptions checks a job of the tenant and returns its options, the sinks
+// are only opened when it runs
+func (t *tenant) jobOptions(jr jobRequest) (catzip.Options, error) {
+	opts := catzip.DefaultOptions()
+	if filepath.IsAbs(jr.Dir) {
+		return opts, fmt.Errorf("dir %q isn't under the input root", jr.Dir)
+	}
+	if len(jr.URLs) > 0 {
+		if t.credentials == nil {
+			return opts, errors.New("urls require the credentials of the tenant")
+		}
+		opts.URLs = jr.URLs
+		opts.Credentials = t.credentials
+	}
+	if jr.Dir != "" || len(jr.URLs) == 0 {
+		// A link under the input root can point out of it
+		dir, err := filepath.EvalSymlinks(filepath.Join(t.InputRoot, jr.Dir))
+		if err != nil {
+			// Without the path of the input root
+			var pathErr *fs.PathError
+			if errors.As(err, &pathErr) {
+				err = pathErr.Err
+			}
+			return opts, fmt.Errorf("dir %q: %w", jr.Dir, err)
+		}
+		if !within(t.InputRoot, dir) {
+			return opts, fmt.Errorf("dir %q isn't under the input root", jr.Dir)
+		}
+		opts.Dir = dir
+	}
+	// So are the links to files, the archives are opened by the workers
+	opts.InputRoot = t.InputRoot
+	// Named after the job once it has an id
+	opts.OutDir = t.OutDir
+
+	for _, ext := range []string{t.Ext, jr.Ext} {
+		if ext != "" {
+			opts.Ext = ext
+		}
+	}
+	// gz, zst, bz2, xz, lz4, br and sz inputs are extracted next to them
+	if opts.ExtractsInPlace() && opts.Dir != "" && !within(t.OutDir, opts.Dir) {
+		return opts, errors.New("gz, zst, bz2, xz, lz4, br and sz inputs are extracted next to them, the tenant input root must be under its outdir")
+	}
+	if jr.OutFile != "" {
+		if filepath.Base(jr.OutFile) != jr.OutFile || jr.OutFile == "." || jr.OutFile == ".." {
+			return opts, fmt.Errorf("outfile %q isn't a file name", jr.OutFile)
+		}
+		opts.CatFileName = jr.OutFile
+	}
+	if t.Hash != "" {
+		opts.Hash = t.Hash
+	}
+	if len(t.FormatRoutes) > 0 {
+		opts.Classify = true
+		opts.FormatRoutes = t.FormatRoutes
+	}
+	return opts, opts.Validate()
+}
+
+// dispatch starts the queued jobs while there are free slots, highest
+// priority first and in submission order on ties, skipping the jobs of tenants
+// at their limit. It is called with s.mu held.
+func (s *server) dispatch() {
+	sort.SliceStable(s.queue, func(a, b int) bool { return s.queue[a].Priority > s.queue[b].Priority })
+	for i := 0; i < len(s.queue) && s.running < s.maxJobs; {
+		j := s.queue[i]
+		t := j.tenant
+		if t.running >= t.MaxJobs || t.inPlace && j.opts.ExtractsInPlace() {
+			i++
+			continue
+		}
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+
+		if t.running == 0 {
+			// Measured again between jobs, the tenant may have cleaned up
+			fsys, err := t.newFS()
+			if err != nil {
+				s.finish(j, nil, err)
+				continue
+			}
+			t.fs = fsys
+		}
+		s.running++
+		t.running++
+		t.inPlace = t.inPlace || j.opts.ExtractsInPlace()
+		j.Status = "running"
+		go s.run(j, t.fs)
+	}
+}
+
+func (s *server) run(j *job, fsys *tenantFS) {
+	summary, err := j.tenant.runJob(j.opts, fsys)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := j.tenant
+	s.running--
+	t.running--
+	if j.opts.ExtractsInPlace() {
+		t.inPlace = false
+	}
+	s.finish(j, summary, err)
+	s.dispatch()
+}
+
+// finish records the outcome of a job, with s.mu held
+func (s *server) finish(j *job, summary *catzip.Summary, err error) {
+	finished := time.Now()
+	j.Finished = &finished
+	if err != nil {
+		j.Status = "failed"
+		j.Error = err.Error()
+	} else {
+		j.Status = "done"
+		j.Files = summary.Files
+		j.Bytes = summary.Bytes
+		j.Skipped = len(summary.Skipped)
+	}
+	log.Print(catzip.LogFields("event", "job", "tenant", j.tenant.Name, "job", j.ID, "status", j.Status))
+	if s.retention > 0 {
+		time.AfterFunc(s.retention, func() {
+			s.mu.Lock()
+			delete(s.jobs, j.ID)
+			s.mu.Unlock()
+		})
+	}
+}
+
+func (t *tenant) runJob(opts catzip.Options, fsys *tenantFS) (*catzip.Summary, error) {
+	if err := os.Mkdir(opts.OutDir, 0755); err != nil {
+		return nil, err
+	}
+	for _, spec := range t.Sinks {
+		sink, err := catzip.ParseSink(spec)
+		if err != nil {
+			return nil, err
+		}
+		opts.Sinks = append(opts.Sinks, sink)
+	}
+	if fsys != nil {
+		opts.FS = fsys
+	}
+	return catzip.Run(opts)
+}
+
+// newFS returns the filesystem enforcing the tenant quota and rate, nil when
+// it has neither
+func (t *tenant) newFS() (*tenantFS, error) {
+	if t.QuotaBytes == 0 && t.BytesPerSecond == 0 {
+		return nil, nil
+	}
+	fsys := &tenantFS{WriteFS: catzip.OS, quota: t.QuotaBytes > 0}
+	if t.QuotaBytes > 0 {
+		used, err := diskUsage(t.OutDir)
+		if err != nil {
+			return nil, err
+		}
+		fsys.left.Store(t.QuotaBytes - used)
+	}
+	if t.BytesPerSecond > 0 {
+		fsys.rate = &rateLimiter{bytesPerSecond: float64(t.BytesPerSecond)}
+	}
+	return fsys, nil
+}
+
+func diskUsage(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err == nil {
+			total += info.Size()
+		}
+		return err
+	})
+	return total, err
+}
+
+var errQuotaExceeded = errors.New("tenant quota exceeded")
+
+// tenantFS fails the writes once the tenant quota is used up and spaces
+// them out to the tenant rate
+type tenantFS struct {
+	catzip.WriteFS
+	quota bool
+	left  atomic.Int64
+	rate  *rateLimiter
+}
+
+func (t *tenantFS) OpenFile(name string, flag int, perm fs.FileMode) (catzip.WriteFile, error) {
+	f, err := t.WriteFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &tenantFile{WriteFile: f, fs: t}, nil
+}
+
+type tenantFile struct {
+	catzip.WriteFile
+	fs *tenantFS
+}
+
+func (f *tenantFile) Write(p []byte) (int, error) {
+	if f.fs.quota && f.fs.left.Add(-int64(len(p))) < 0 {
+		return 0, errQuotaExceeded
+	}
+	if f.fs.rate != nil {
+		f.fs.rate.wait(len(p))
+	}
+	return f.WriteFile.Write(p)
+}
+
+// rateLimiter gives each write a slot after the previous ones so the writes
+// average bytesPerSecond, an idle limiter doesn't save up
+type rateLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond float64
+	next           time.Time // Start of the next free slot
+}
+
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	start := l.next
+	l.next = l.next.Add(time.Duration(float64(n) / l.bytesPerSecond * float64(time.Second)))
+	l.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
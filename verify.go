@@ -0,0 +1,69 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/guilycst/cat-zip.git/catzip"
+)
+
+// runVerify checks an attestation written by -attest against the manifest
+// and the cat file
+func runVerify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	var attestationPath = flags.String("attestation", "", "Attestation file written by -attest")
+	var manifestPath = flags.String("manifest", "", "Manifest the attestation was made for")
+	var catPath = flags.String("cat", "", "Concatenated file the attestation was made for, not checked when empty")
+	var publicKey = flags.String("public-key", "", "PEM public key the attestation must be signed with, when empty the embedded key is only reported")
+	flags.Parse(args)
+
+	if *attestationPath == "" || *manifestPath == "" {
+		log.Fatal("verify requires -attestation and -manifest")
+	}
+
+	data, err := os.ReadFile(*attestationPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	attestation, err := catzip.VerifyAttestation(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *publicKey != "" {
+		trusted, err := os.ReadFile(*publicKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = attestation.SignedBy(trusted); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	manifestData, err := os.ReadFile(*manifestPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cat io.Reader
+	if *catPath != "" {
+		catFile, err := os.Open(*catPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer catFile.Close()
+		cat = catFile
+	}
+	if err = attestation.Check(manifestData, cat); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("OK: %d entries from %d inputs, signed with %s at %v\n",
+		attestation.Statement.Entries, len(attestation.Statement.Inputs), attestation.Algorithm, attestation.Statement.CreatedAt)
+	if *publicKey == "" {
+		fmt.Println("The signing key wasn't checked, pass -public-key to require it")
+	}
+}